@@ -0,0 +1,202 @@
+package vmodem
+
+import "io"
+
+// Controller is a lock-free front end to a Modem. Every operation is sent over a
+// channel to a goroutine that runs it with the modem lock held, so callers never
+// manage the mutex and there is a single variant of each method.
+//
+// Hooks and callbacks configured on the modem run with the lock held and must keep
+// using the *Modem they receive: calling the Controller from them deadlocks.
+type Controller struct {
+	m    *Modem
+	ops  chan func()
+	done chan struct{}
+}
+
+// NewController starts a controller for m. It runs until Close or Stop is called.
+func NewController(m *Modem) *Controller {
+	c := &Controller{
+		m:    m,
+		ops:  make(chan func()),
+		done: make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *Controller) run() {
+	for {
+		select {
+		case op := <-c.ops:
+			c.m.Lock()
+			op()
+			c.m.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Do runs f with the modem lock held, for operations without a Controller method.
+// Returns ErrControllerStopped if the controller has been stopped.
+func (c *Controller) Do(f func(m *Modem)) error {
+	result := make(chan struct{})
+	op := func() {
+		f(c.m)
+		close(result)
+	}
+	select {
+	case c.ops <- op:
+	case <-c.done:
+		return ErrControllerStopped
+	}
+	<-result
+	return nil
+}
+
+// Stop ends the controller without closing the modem. Later calls fail with
+// ErrControllerStopped or return zero values.
+func (c *Controller) Stop() {
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+}
+
+// Modem returns the controlled modem.
+func (c *Controller) Modem() *Modem {
+	return c.m
+}
+
+// Status returns the modem status, or StatusClosed if the controller is stopped.
+func (c *Controller) Status() ModemStatus {
+	st := StatusClosed
+	c.Do(func(m *Modem) { st = m.status() })
+	return st
+}
+
+// CanTransition reports whether the modem may move to status now. See Modem.CanTransition.
+func (c *Controller) CanTransition(status ModemStatus) bool {
+	ok := false
+	c.Do(func(m *Modem) { ok = m.checkTransition(status) == nil })
+	return ok
+}
+
+// ProcessAtCommand processes an AT command line (without the AT prefix) and returns
+// its result code, or RetCodeError if the controller is stopped.
+func (c *Controller) ProcessAtCommand(cmd string) RetCode {
+	r := RetCodeError
+	c.Do(func(m *Modem) { r = m.processAtCommand(cmd) })
+	return r
+}
+
+// Dial originates a call. See Modem.Dial.
+func (c *Controller) Dial(number string) (*CallHandle, error) {
+	var call *CallHandle
+	err := c.doErr(func(m *Modem) (err error) {
+		call, err = m.apiDial(number)
+		return err
+	})
+	return call, err
+}
+
+// Answer accepts a ringing call. See Modem.Answer.
+func (c *Controller) Answer() (*CallHandle, error) {
+	var call *CallHandle
+	err := c.doErr(func(m *Modem) (err error) {
+		call, err = m.answer(CauseAPI)
+		return err
+	})
+	return call, err
+}
+
+// ActiveCall returns the handle of the current call, or nil if there is none.
+func (c *Controller) ActiveCall() *CallHandle {
+	var call *CallHandle
+	c.Do(func(m *Modem) { call = m.activeCall() })
+	return call
+}
+
+// Hangup returns the modem to idle. See Modem.Hangup.
+func (c *Controller) Hangup() error {
+	return c.doErr(func(m *Modem) error { return m.hangup() })
+}
+
+// IncomingCall offers an incoming call to the modem. See Modem.IncomingCallInfo.
+func (c *Controller) IncomingCall(conn io.ReadWriteCloser, info CallInfo) error {
+	return c.doErr(func(m *Modem) error { return m.incomingCall(conn, info) })
+}
+
+// CallInfo returns the caller information of the current incoming call.
+func (c *Controller) CallInfo() CallInfo {
+	var info CallInfo
+	c.Do(func(m *Modem) { info = m.getCallInfo() })
+	return info
+}
+
+// SendUnsolicited writes an unsolicited result code. See Modem.SendUnsolicited.
+func (c *Controller) SendUnsolicited(code string) error {
+	return c.doErr(func(m *Modem) error { return m.sendUnsolicited(code) })
+}
+
+// TtyWriteStr writes a string to the TTY.
+func (c *Controller) TtyWriteStr(s string) error {
+	return c.Do(func(m *Modem) { m.ttyWriteStr(s) })
+}
+
+// SReg returns the value of S-register reg.
+func (c *Controller) SReg(reg byte) byte {
+	var v byte
+	c.Do(func(m *Modem) { v = m.sReg(reg) })
+	return v
+}
+
+// SetSReg sets S-register reg to value.
+func (c *Controller) SetSReg(reg byte, value byte) error {
+	return c.Do(func(m *Modem) { m.setSReg(reg, value) })
+}
+
+// Metrics returns a snapshot of the modem metrics, or nil if the controller is stopped.
+func (c *Controller) Metrics() *Metrics {
+	var mt *Metrics
+	c.Do(func(m *Modem) { mt = m.Metrics() })
+	return mt
+}
+
+// CallRecords returns the detail records of the last finished calls, or nil if
+// the controller is stopped.
+func (c *Controller) CallRecords() []CallRecord {
+	var recs []CallRecord
+	c.Do(func(m *Modem) { recs = m.callRecords() })
+	return recs
+}
+
+// Subscribe returns a channel of state changes. See Modem.Subscribe.
+func (c *Controller) Subscribe() (int, <-chan StateChange, error) {
+	var id int
+	var ch <-chan StateChange
+	err := c.Do(func(m *Modem) { id, ch = m.subscribe() })
+	return id, ch, err
+}
+
+// Unsubscribe stops the delivery of state changes. See Modem.Unsubscribe.
+func (c *Controller) Unsubscribe(id int) error {
+	return c.Do(func(m *Modem) { m.unsubscribe(id) })
+}
+
+// Close closes the modem and stops the controller.
+func (c *Controller) Close() error {
+	err := c.doErr(func(m *Modem) error { return m.close() })
+	c.Stop()
+	return err
+}
+
+func (c *Controller) doErr(f func(m *Modem) error) error {
+	var err error
+	if doErr := c.Do(func(m *Modem) { err = f(m) }); doErr != nil {
+		return doErr
+	}
+	return err
+}
@@ -0,0 +1,226 @@
+// Package atparse parses AT command lines into individual commands.
+//
+// It is the parser used by vmodem, usable on its own to test command lines or
+// to build other AT command interpreters. Parsing has no side effects and never
+// panics, whatever the input.
+//
+// A command line is the text after the "AT" prefix. It holds a chain of basic
+// commands (a letter, or a letter prefixed by '&', '%' or '\', followed by an
+// optional number, '?' or '=' and a number) and extended commands ('+' or '#'
+// and a name of letters and digits, with an optional '?', "=?" or '=' and a
+// value), which are separated from the next command by ';'. A dial command (D
+// and the dial string) ends the line:
+//
+//	E0V1S0=2&F%C0\N3+VCID=1;+CMEE=2;&C1DT5551234
+package atparse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Command is a single command of a command line.
+type Command struct {
+	// Name is the command name in upper case: "E", "&F", "+VCID", "D"
+	Name string
+	// Number is the numeric parameter of a basic command ("0" in E0, "7" in S7=30)
+	Number string
+	// Assign reports whether the command sets a value with '='
+	Assign bool
+	// Query reports whether the command reads a value with '?'
+	Query bool
+	// Test reports whether the command asks for its supported values with "=?"
+	Test bool
+	// Value is the text assigned with '=', or the dial string of D
+	Value string
+	// Params are the comma-separated parameters of Value, with the quotes of
+	// string parameters removed. The dial string of D is a single parameter.
+	Params []string
+	// Raw is the command as it appeared in the command line
+	Raw string
+}
+
+// IsExtended reports whether c is an extended ('+' or '#') command.
+func (c Command) IsExtended() bool {
+	return len(c.Name) > 0 && (c.Name[0] == '+' || c.Name[0] == '#')
+}
+
+// SyntaxError reports a malformed command line.
+type SyntaxError struct {
+	// Line is the command line being parsed
+	Line string
+	// Offset is the position of the offending byte in Line
+	Offset int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("atparse: syntax error at offset %d in %q", e.Offset, e.Line)
+}
+
+// Parse splits a command line (without the AT prefix) into commands.
+// On a syntax error it returns the commands parsed before the malformed one
+// together with a *SyntaxError, so they can still be executed in order as a
+// modem would. Commands may be separated by ';', which is required after an
+// extended command for the line to go on: text after an extended command not
+// followed by ';', or after a dial command, is ignored.
+func Parse(line string) ([]Command, error) {
+	var cmds []Command
+	p := 0
+	for p < len(line) {
+		if line[p] == ';' {
+			p++
+			continue
+		}
+		c, next, err := parseCommand(line, p)
+		if err != nil {
+			return cmds, err
+		}
+		cmds = append(cmds, c)
+		p = next
+		if c.Name == "D" || (c.IsExtended() && (p == len(line) || line[p] != ';')) {
+			break
+		}
+	}
+	return cmds, nil
+}
+
+// parseCommand parses the command starting at p and returns it with the offset
+// of the next command.
+func parseCommand(line string, p int) (Command, int, error) {
+	name := ""
+	c := Command{}
+	long := false
+	quoted := false
+	start := p
+	fail := func(offset int) (Command, int, error) {
+		return Command{}, p, &SyntaxError{Line: line, Offset: offset}
+	}
+	for ; p < len(line); p++ {
+		b := line[p]
+		if b == '?' && !quoted {
+			if name == "" {
+				return fail(p)
+			}
+			if c.Assign && c.Value == "" && !isDial(name) {
+				c.Assign = false
+				c.Test = true
+			} else {
+				c.Query = true
+			}
+			p++
+			break
+		}
+		if c.Assign {
+			if !long && !isDigit(b) { // Basic commands only accept numbers
+				break
+			}
+			if !isDial(name) {
+				if b == '"' {
+					quoted = !quoted
+				} else if b == ';' && !quoted {
+					break
+				}
+			}
+			c.Value += string(b)
+			continue
+		}
+		if b == '+' || b == '#' {
+			if name == "" {
+				long = true
+				name += string(b)
+				continue
+			}
+			if !long && !isPrefix(name[len(name)-1]) {
+				break // An extended command after a basic one
+			}
+			return fail(p)
+		}
+		if b == '=' {
+			if name == "" {
+				return fail(p)
+			}
+			c.Assign = true
+			continue
+		}
+		if long {
+			if b == ';' {
+				break
+			}
+			if !isLetter(b) && (len(name) == 1 || !isDigit(b)) {
+				return fail(p)
+			}
+			name += string(b)
+			continue
+		}
+		if name == "" || (len(name) == 1 && isPrefix(name[0])) {
+			if isPrefix(b) && name == "" && p+1 < len(line) {
+				name += string(b)
+				continue
+			}
+			if !isLetter(b) {
+				return fail(p)
+			}
+			name += string(b)
+			if isDial(name) {
+				long = true
+				c.Assign = true
+			}
+			continue
+		}
+		if !isDigit(b) {
+			break
+		}
+		c.Number += string(b)
+	}
+	if long && len(name) == 1 && !isDial(name) {
+		return fail(start) // Extended command without a name
+	}
+	c.Name = strings.ToUpper(name)
+	c.Raw = line[start:p]
+	if c.Value != "" {
+		if isDial(name) {
+			c.Params = []string{c.Value}
+		} else {
+			c.Params = splitParams(c.Value)
+		}
+	}
+	return c, p, nil
+}
+
+// splitParams splits a parameter list at the commas outside double quotes and
+// removes the quotes.
+func splitParams(s string) []string {
+	var params []string
+	var param strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == '"':
+			quoted = !quoted
+		case b == ',' && !quoted:
+			params = append(params, param.String())
+			param.Reset()
+		default:
+			param.WriteByte(b)
+		}
+	}
+	return append(params, param.String())
+}
+
+func isDial(name string) bool {
+	return name == "d" || name == "D"
+}
+
+// isPrefix reports whether b starts a prefixed basic command: &F, %C0 (Rockwell)
+// or \N3 (USR).
+func isPrefix(b byte) bool {
+	return b == '&' || b == '%' || b == '\\'
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z')
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
@@ -0,0 +1,136 @@
+package atparse
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Test parsing of valid and malformed command lines
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []Command
+		errAt    int // Offset of the syntax error, -1 if none
+	}{
+		{"", nil, -1},
+		{"E0", []Command{{Name: "E", Number: "0"}}, -1},
+		{"e1v0q", []Command{{Name: "E", Number: "1"}, {Name: "V", Number: "0"}, {Name: "Q"}}, -1},
+		{"S0=2S7?", []Command{{Name: "S", Number: "0", Assign: true, Value: "2"}, {Name: "S", Number: "7", Query: true}}, -1},
+		{"&F&c1", []Command{{Name: "&F"}, {Name: "&C", Number: "1"}}, -1},
+		{"%C0", []Command{{Name: "%C", Number: "0"}}, -1},
+		{"&K3\\n3%c1", []Command{{Name: "&K", Number: "3"}, {Name: "\\N", Number: "3"}, {Name: "%C", Number: "1"}}, -1},
+		{"\\", nil, 0},
+		{"&%C", nil, 1},
+		{"DT555-1234", []Command{{Name: "D", Assign: true, Value: "T555-1234"}}, -1},
+		{"E0dexample.com:23", []Command{{Name: "E", Number: "0"}, {Name: "D", Assign: true, Value: "example.com:23"}}, -1},
+		{"+VCID=1E0", []Command{{Name: "+VCID", Assign: true, Value: "1E0"}}, -1},
+		{"+vcid?E0", []Command{{Name: "+VCID", Query: true}}, -1},
+		{"#CID=1", []Command{{Name: "#CID", Assign: true, Value: "1"}}, -1},
+		{"E0?", []Command{{Name: "E", Number: "0", Query: true}}, -1},
+		{"E0!", []Command{{Name: "E", Number: "0"}}, 2},
+		{"?", nil, 0},
+		{"=1", nil, 0},
+		{"&", nil, 0},
+		{"E+", []Command{{Name: "E"}}, 1},
+		{"E0+VCID=1", []Command{{Name: "E", Number: "0"}, {Name: "+VCID", Assign: true, Value: "1"}}, -1},
+		{"+CMD1;+CMD2;&F", []Command{{Name: "+CMD1"}, {Name: "+CMD2"}, {Name: "&F"}}, -1},
+		{"+CMEE=2;E0;", []Command{{Name: "+CMEE", Assign: true, Value: "2"}, {Name: "E", Number: "0"}}, -1},
+		{"+X=\"a;b\";V1", []Command{{Name: "+X", Assign: true, Value: "\"a;b\""}, {Name: "V", Number: "1"}}, -1},
+		{"+VCID?;#CID=?", []Command{{Name: "+VCID", Query: true}, {Name: "#CID", Test: true}}, -1},
+		{";;E1", []Command{{Name: "E", Number: "1"}}, -1},
+		{"D555;E0", []Command{{Name: "D", Assign: true, Value: "555;E0"}}, -1},
+		{"+VCID=1;+;E0", []Command{{Name: "+VCID", Assign: true, Value: "1"}}, 8},
+		{"+1CSQ", nil, 1},
+		{"+CSQ!", nil, 4},
+		{"E1V", []Command{{Name: "E", Number: "1"}, {Name: "V"}}, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			cmds, err := Parse(tt.line)
+			// Raw and Params are checked by TestParse_Params
+			for i := range cmds {
+				cmds[i].Raw = ""
+				cmds[i].Params = nil
+			}
+			if !reflect.DeepEqual(cmds, tt.expected) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.line, cmds, tt.expected)
+			}
+			var se *SyntaxError
+			switch {
+			case tt.errAt < 0 && err != nil:
+				t.Errorf("Parse(%q) error = %v, want nil", tt.line, err)
+			case tt.errAt >= 0 && !errors.As(err, &se):
+				t.Errorf("Parse(%q) error = %v, want a *SyntaxError", tt.line, err)
+			case tt.errAt >= 0 && se.Offset != tt.errAt:
+				t.Errorf("Parse(%q) error offset = %d, want %d", tt.line, se.Offset, tt.errAt)
+			}
+		})
+	}
+}
+
+// Test test commands, raw text and parameter splitting
+func TestParse_Params(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected Command
+	}{
+		{"S7=30", Command{Name: "S", Number: "7", Assign: true, Value: "30", Params: []string{"30"}, Raw: "S7=30"}},
+		{"+CMGS=?", Command{Name: "+CMGS", Test: true, Raw: "+CMGS=?"}},
+		{"s0=?", Command{Name: "S", Number: "0", Test: true, Raw: "s0=?"}},
+		{"+CPBW=1,\"555,1234\",129", Command{Name: "+CPBW", Assign: true, Value: "1,\"555,1234\",129",
+			Params: []string{"1", "555,1234", "129"}, Raw: "+CPBW=1,\"555,1234\",129"}},
+		{"+X=,", Command{Name: "+X", Assign: true, Value: ",", Params: []string{"", ""}, Raw: "+X=,"}},
+		{"DT555,123", Command{Name: "D", Assign: true, Value: "T555,123", Params: []string{"T555,123"}, Raw: "DT555,123"}},
+		{"D?", Command{Name: "D", Assign: true, Query: true, Raw: "D?"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			cmds, err := Parse(tt.line)
+			if err != nil || len(cmds) != 1 || !reflect.DeepEqual(cmds[0], tt.expected) {
+				t.Errorf("Parse(%q) = %+v, %v, want %+v", tt.line, cmds, err, tt.expected)
+			}
+		})
+	}
+}
+
+// Fuzz the parser: it must not panic and must report offsets within the line
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{"", "E0V1", "S0=2S7?", "&F%C0", "DT555", "+VCID=1", "E0!", "&", "?=", "+A;+B=\";\";E1"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		cmds, err := Parse(line)
+		var se *SyntaxError
+		if err != nil {
+			if !errors.As(err, &se) || se.Offset < 0 || se.Offset >= len(line) {
+				t.Fatalf("Parse(%q) error = %v", line, err)
+			}
+		}
+		p := 0
+		for i, c := range cmds {
+			for p < len(line) && line[p] == ';' {
+				p++
+			}
+			if !strings.HasPrefix(line[p:], c.Raw) {
+				t.Fatalf("Parse(%q) raw command %d %q is not at offset %d", line, i, c.Raw, p)
+			}
+			p += len(c.Raw)
+			if c.Name == "" {
+				t.Fatalf("Parse(%q) command %d has no name", line, i)
+			}
+			if i == len(cmds)-1 {
+				break
+			}
+			if c.Name == "D" {
+				t.Fatalf("Parse(%q) dial command %d is not the last one", line, i)
+			}
+			if c.IsExtended() && (p == len(line) || line[p] != ';') {
+				t.Fatalf("Parse(%q) extended command %d is not followed by ';'", line, i)
+			}
+		}
+	})
+}
@@ -0,0 +1,24 @@
+package atparse_test
+
+import (
+	"fmt"
+
+	"github.com/jaracil/vmodem/atparse"
+)
+
+// Split a typical init string into commands.
+func ExampleParse() {
+	cmds, err := atparse.Parse("E0V1S0=2+VCID=1")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, c := range cmds {
+		fmt.Printf("%s %q assign=%v value=%q\n", c.Name, c.Number, c.Assign, c.Value)
+	}
+	// Output:
+	// E "0" assign=false value=""
+	// V "1" assign=false value=""
+	// S "0" assign=true value="2"
+	// +VCID "" assign=true value="1"
+}
@@ -0,0 +1,92 @@
+package vmodem
+
+import (
+	"context"
+	"time"
+)
+
+// CallHandle represents a single call, from the moment it is dialed or starts
+// ringing until it is hung up. It is safe for concurrent use and does not
+// require the modem lock.
+type CallHandle struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	incoming bool
+	number   string
+	info     CallInfo
+	start    time.Time
+	connect  time.Time
+	pump     *pump
+}
+
+func newCall(incoming bool, number string, info CallInfo) *CallHandle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CallHandle{
+		ctx:      ctx,
+		cancel:   cancel,
+		incoming: incoming,
+		number:   number,
+		info:     info,
+		start:    time.Now(),
+	}
+}
+
+// Context returns a context that is cancelled when the call ends.
+func (c *CallHandle) Context() context.Context {
+	return c.ctx
+}
+
+// Done returns a channel that is closed when the call ends.
+func (c *CallHandle) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Incoming reports whether the call was received (true) or dialed (false).
+func (c *CallHandle) Incoming() bool {
+	return c.incoming
+}
+
+// Number returns the dialed number of an outgoing call, or the caller number of an incoming one.
+func (c *CallHandle) Number() string {
+	return c.number
+}
+
+// Info returns the caller information of an incoming call.
+func (c *CallHandle) Info() CallInfo {
+	return c.info
+}
+
+// StartTime returns when the call was dialed or started ringing.
+func (c *CallHandle) StartTime() time.Time {
+	return c.start
+}
+
+func (m *Modem) activeCall() *CallHandle {
+	return m.call
+}
+
+// ActiveCall returns the handle of the call being dialed, ringing or connected,
+// or nil if the modem is idle or closed.
+// The modem lock must be held before calling this method.
+// Use ActiveCallSync for automatic lock management.
+func (m *Modem) ActiveCall() *CallHandle {
+	m.checkLock()
+	return m.activeCall()
+}
+
+// ActiveCallSync returns the handle of the current call with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) ActiveCallSync() *CallHandle {
+	m.Lock()
+	defer m.Unlock()
+	return m.activeCall()
+}
+
+// endCall records the current call and cancels its context.
+func (m *Modem) endCall(cause TransitionCause) {
+	if m.call != nil {
+		m.recordCall(cause)
+		m.call.cancel()
+		m.call = nil
+	}
+}
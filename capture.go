@@ -0,0 +1,231 @@
+package vmodem
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CaptureFormat selects how a Capture writes the call data it records.
+type CaptureFormat int
+
+const (
+	// CaptureAnnotated writes a line of text per chunk of call data: the time, the
+	// modem Id, > for data sent to the remote side or < for data received, and the
+	// data as a quoted Go string. The start and end of each call are noted with the
+	// caller and the cause of the hangup.
+	CaptureAnnotated CaptureFormat = iota
+	// CaptureRaw writes a binary record per chunk of call data, in the format read
+	// by ReadCaptureRecord, for tools that replay or analyze calls.
+	CaptureRaw
+)
+
+// String returns a human-readable string representation of the capture format.
+func (f CaptureFormat) String() string {
+	switch f {
+	case CaptureAnnotated:
+		return "Annotated"
+	case CaptureRaw:
+		return "Raw"
+	default:
+		return "Unknown"
+	}
+}
+
+// CaptureRecord is a chunk of call data recorded by a Capture.
+type CaptureRecord struct {
+	// Time is when the data was sent or received
+	Time time.Time
+	// Modem is the identifier of the modem of the call
+	Modem string
+	// Dir is FilterToLine for data sent to the remote side, FilterToTTY for data received
+	Dir FilterDirection
+	// Data is the call data as it went through the connection
+	Data []byte
+}
+
+// Capture records both directions of the calls of one or more modems to a writer,
+// with timestamps, to debug protocol issues. Data is recorded as it goes through
+// the connection: after the filters and the line noise when sent, and before them
+// when received. A Capture is safe for concurrent use; the first write error stops
+// it.
+type Capture struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format CaptureFormat
+	err    error
+	tee    []*Capture
+}
+
+// NewCapture returns a Capture writing to w in the given format.
+func NewCapture(w io.Writer, format CaptureFormat) *Capture {
+	return &Capture{w: w, format: format}
+}
+
+// TeeCapture returns a Capture recording to each of captures in its own format,
+// so that a modem can record its calls to several destinations.
+func TeeCapture(captures ...*Capture) *Capture {
+	return &Capture{tee: captures}
+}
+
+// Err returns the write error that stopped the capture, if any. The error of
+// a Capture returned by TeeCapture is the first of its captures.
+func (c *Capture) Err() error {
+	for _, t := range c.tee {
+		if err := t.Err(); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Record writes rec to the capture.
+func (c *Capture) Record(rec CaptureRecord) error {
+	if c.tee != nil {
+		var err error
+		for _, t := range c.tee {
+			if e := t.Record(rec); err == nil {
+				err = e
+			}
+		}
+		return err
+	}
+	var b []byte
+	if c.format == CaptureRaw {
+		b = appendRawRecord(nil, rec)
+	} else {
+		dir := '>'
+		if rec.Dir == FilterToTTY {
+			dir = '<'
+		}
+		b = fmt.Appendf(nil, "%s %s %c %s\n", rec.Time.Format(time.RFC3339Nano), rec.Modem, dir, strconv.Quote(string(rec.Data)))
+	}
+	return c.write(b)
+}
+
+// note writes an annotation about the call of the modem id, skipped in raw format.
+func (c *Capture) note(id, format string, args ...any) {
+	for _, t := range c.tee {
+		t.note(id, format, args...)
+	}
+	if c.tee != nil || c.format == CaptureRaw {
+		return
+	}
+	c.write(fmt.Appendf(nil, "%s %s # %s\n", time.Now().Format(time.RFC3339Nano), id, fmt.Sprintf(format, args...)))
+}
+
+func (c *Capture) write(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	_, c.err = c.w.Write(b)
+	return c.err
+}
+
+// Raw capture records are, in big endian: the time in nanoseconds since the Unix
+// epoch (8 bytes), the direction (1 byte, 0 sent and 1 received), the length of
+// the modem Id (1 byte) and the Id, and the length of the data (4 bytes) and the
+// data.
+
+func appendRawRecord(b []byte, rec CaptureRecord) []byte {
+	id := rec.Modem[:min(len(rec.Modem), 255)]
+	b = binary.BigEndian.AppendUint64(b, uint64(rec.Time.UnixNano()))
+	b = append(b, byte(rec.Dir), byte(len(id)))
+	b = append(b, id...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(rec.Data)))
+	return append(b, rec.Data...)
+}
+
+// ReadCaptureRecord reads the next record of a capture written in CaptureRaw
+// format. It returns io.EOF at the end of the capture, and io.ErrUnexpectedEOF
+// for a truncated record.
+func ReadCaptureRecord(r io.Reader) (CaptureRecord, error) {
+	var hdr [10]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return CaptureRecord{}, err
+	}
+	rec := CaptureRecord{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[:8]))),
+		Dir:  FilterDirection(hdr[8]),
+	}
+	id := make([]byte, hdr[9]+4)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return CaptureRecord{}, unexpectedEOF(err)
+	}
+	rec.Modem = string(id[:hdr[9]])
+	rec.Data = make([]byte, binary.BigEndian.Uint32(id[hdr[9]:]))
+	if _, err := io.ReadFull(r, rec.Data); err != nil {
+		return CaptureRecord{}, unexpectedEOF(err)
+	}
+	return rec, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// captureConn records the data of the connection of a call.
+type captureConn struct {
+	io.ReadWriteCloser
+	c  *Capture
+	id string
+}
+
+func (cc *captureConn) Read(b []byte) (int, error) {
+	n, err := cc.ReadWriteCloser.Read(b)
+	if n > 0 {
+		cc.c.Record(CaptureRecord{Time: time.Now(), Modem: cc.id, Dir: FilterToTTY, Data: b[:n]})
+	}
+	return n, err
+}
+
+func (cc *captureConn) Write(b []byte) (int, error) {
+	n, err := cc.ReadWriteCloser.Write(b)
+	if n > 0 {
+		cc.c.Record(CaptureRecord{Time: time.Now(), Modem: cc.id, Dir: FilterToLine, Data: b[:n]})
+	}
+	return n, err
+}
+
+// captureCall starts recording the call of p over conn, if the modem captures
+// calls, and returns the connection the pump uses.
+func (m *Modem) captureCall(p *pump, conn io.ReadWriteCloser) io.ReadWriteCloser {
+	if m.capture == nil {
+		return conn
+	}
+	p.capture = m.capture
+	if c := m.call; c.incoming {
+		p.capture.note(m.id, "incoming call number=%q name=%q source=%q", c.info.Number, c.info.Name, c.info.Source)
+	} else {
+		p.capture.note(m.id, "outgoing call number=%q", c.number)
+	}
+	return &captureConn{ReadWriteCloser: conn, c: p.capture, id: m.id}
+}
+
+// SetCapture records the calls started afterwards to c, or stops recording them
+// if c is nil. A call in progress keeps the capture it started with.
+// The modem lock must be held before calling this method.
+// Use SetCaptureSync for automatic lock management.
+func (m *Modem) SetCapture(c *Capture) {
+	m.checkLock()
+	m.capture = c
+}
+
+// SetCaptureSync sets the capture of the next calls with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetCaptureSync(c *Capture) {
+	m.Lock()
+	defer m.Unlock()
+	m.capture = c
+}
@@ -0,0 +1,128 @@
+package vmodem
+
+import "time"
+
+// maxCallRecords is the number of call detail records kept by a modem.
+const maxCallRecords = 100
+
+// CallRecord is the call detail record of a finished call, for accounting.
+type CallRecord struct {
+	// Modem is the identifier of the modem that handled the call
+	Modem string
+	// Incoming reports whether the call was received (true) or dialed (false)
+	Incoming bool
+	// Number is the dialed number of an outgoing call, or the caller number of an incoming one
+	Number string
+	// Info is the caller information of an incoming call
+	Info CallInfo
+	// StartTime is when the call was dialed or started ringing
+	StartTime time.Time
+	// ConnectTime is when the call was connected (zero if it never was)
+	ConnectTime time.Time
+	// EndTime is when the call ended
+	EndTime time.Time
+	// TxBytes is the number of bytes sent to the remote side
+	TxBytes int
+	// RxBytes is the number of bytes received from the remote side
+	RxBytes int
+	// StallTime is the connected time without data flowing in either direction,
+	// counting only gaps longer than one second
+	StallTime time.Duration
+	// PacingTime is the time data waited for the emulated line speed, in both directions
+	PacingTime time.Duration
+	// CorruptBytes is the number of bytes flipped or inserted by line noise
+	CorruptBytes int
+	// DroppedBytes is the number of bytes discarded because a buffer was full
+	DroppedBytes int
+	// Cause is the cause of the transition that ended the call
+	Cause TransitionCause
+}
+
+// Connected reports whether the call was ever connected.
+func (r CallRecord) Connected() bool {
+	return !r.ConnectTime.IsZero()
+}
+
+// Duration returns the connected time of the call, zero if it never connected.
+func (r CallRecord) Duration() time.Duration {
+	if !r.Connected() {
+		return 0
+	}
+	return r.EndTime.Sub(r.ConnectTime)
+}
+
+// Throughput returns the effective throughput of the call in bytes per second
+// for each direction, excluding the stall time.
+func (r CallRecord) Throughput() (txBps float64, rxBps float64) {
+	active := r.Duration() - r.StallTime
+	if active <= 0 {
+		return 0, 0
+	}
+	return float64(r.TxBytes) / active.Seconds(), float64(r.RxBytes) / active.Seconds()
+}
+
+// RetransmitBytes returns the retransmission-equivalent volume of the call: the
+// bytes damaged by line noise or dropped, which an error-correcting link would
+// have to send again.
+func (r CallRecord) RetransmitBytes() int {
+	return r.CorruptBytes + r.DroppedBytes
+}
+
+// CallRecordType is called with the detail record of every call when it ends.
+// The modem lock is held when the callback is called.
+type CallRecordType func(m *Modem, rec CallRecord)
+
+// recordCall builds the detail record of the current call, keeps it and
+// delivers it to the CDR callback.
+func (m *Modem) recordCall(cause TransitionCause) {
+	c := m.call
+	rec := CallRecord{
+		Modem:       m.id,
+		Incoming:    c.incoming,
+		Number:      c.number,
+		Info:        c.info,
+		StartTime:   c.start,
+		ConnectTime: c.connect,
+		EndTime:     time.Now(),
+		Cause:       cause,
+	}
+	if rec.Connected() {
+		rec.TxBytes = m.metrics.CallTxBytes
+		rec.RxBytes = m.metrics.CallRxBytes
+		rec.StallTime = m.metrics.CallStallTime
+		rec.PacingTime = m.metrics.CallPacingTime
+		rec.CorruptBytes = m.metrics.CallCorruptBytes
+		rec.DroppedBytes = m.metrics.CallDroppedBytes
+	}
+	if len(m.records) == maxCallRecords {
+		m.records = append(m.records[:0], m.records[1:]...)
+	}
+	m.records = append(m.records, rec)
+	if c.pump != nil && c.pump.capture != nil {
+		c.pump.capture.note(m.id, "call ended cause=%s tx=%d rx=%d retransmit=%d", cause, rec.TxBytes, rec.RxBytes, rec.RetransmitBytes())
+	}
+	if m.callRecord != nil {
+		m.callRecord(m, rec)
+	}
+}
+
+func (m *Modem) callRecords() []CallRecord {
+	return append([]CallRecord(nil), m.records...)
+}
+
+// CallRecords returns the detail records of the last finished calls, oldest first.
+// Up to 100 records are kept.
+// The modem lock must be held before calling this method.
+// Use CallRecordsSync for automatic lock management.
+func (m *Modem) CallRecords() []CallRecord {
+	m.checkLock()
+	return m.callRecords()
+}
+
+// CallRecordsSync returns the detail records of the last finished calls with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) CallRecordsSync() []CallRecord {
+	m.Lock()
+	defer m.Unlock()
+	return m.callRecords()
+}
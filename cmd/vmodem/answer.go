@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// Dial-in sessions: with --answer-exec, answering a call runs a program, such
+// as /bin/login or a BBS door, on the TTY of the modem, as getty does on the
+// line of a dial-in modem. The program is hung up when the call ends, and the
+// call when the program exits.
+
+// answerInit is run before the init commands of modems running a program on
+// answer: dial-in modems answer on the first ring and keep quiet, so the
+// program does not read the result codes as input.
+const answerInit = "E0Q1S0=1"
+
+// answerHangupGrace is how long a hung up program has to exit before it is
+// killed.
+const answerHangupGrace = 5 * time.Second
+
+// answerDrainMax bounds the wait for the last output of an exited program to
+// reach the call.
+const answerDrainMax = 2 * time.Second
+
+// answerSession is the program run for the call answered by a modem.
+type answerSession struct {
+	mu    sync.Mutex
+	proc  *os.Process
+	ended bool
+}
+
+var answerSessions = struct {
+	sync.Mutex
+	byModem map[*vm.Modem]*answerSession
+}{byModem: make(map[*vm.Modem]*answerSession)}
+
+// answerCommand returns the command line of the program run on answer by the
+// modem id, if any.
+func answerCommand(id string) string {
+	for _, spec := range specs {
+		if spec.Name == id && spec.AnswerExec != "" {
+			return spec.AnswerExec
+		}
+	}
+	return options.AnswerExec
+}
+
+// answerTransition starts the program of a modem answering a call, and hangs
+// it up when the call ends. It is called with the modem locked.
+func answerTransition(m *vm.Modem, oldStatus, newStatus vm.ModemStatus) {
+	switch {
+	case oldStatus == vm.StatusRinging && newStatus == vm.StatusConnected:
+		cmdLine := answerCommand(m.Id())
+		if cmdLine == "" {
+			return
+		}
+		s := &answerSession{}
+		answerSessions.Lock()
+		answerSessions.byModem[m] = s
+		answerSessions.Unlock()
+		go s.run(m, cmdLine)
+	case newStatus == vm.StatusIdle || newStatus == vm.StatusClosed:
+		answerSessions.Lock()
+		s := answerSessions.byModem[m]
+		delete(answerSessions.byModem, m)
+		answerSessions.Unlock()
+		if s != nil {
+			s.hangup()
+		}
+	}
+}
+
+// run starts the program cmdLine, run by the shell, on the TTY of m and hangs
+// up the call when it exits. The program gets the caller in its environment.
+func (s *answerSession) run(m *vm.Modem, cmdLine string) {
+	log := modemLog(m.Id())
+	info := m.CallInfoSync()
+	cmd := shellCommand(cmdLine)
+	cmd.Env = append(os.Environ(), "VMODEM_MODEM="+m.Id(), "VMODEM_CALLER="+info.Number, "VMODEM_SOURCE="+info.Source)
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	err := startAnswerProgram(m, cmd)
+	if err == nil {
+		s.proc = cmd.Process
+	}
+	s.mu.Unlock()
+	if err != nil {
+		log.Error("Error running answer program", "cmd", cmdLine, "err", err)
+		m.HangupSync()
+		return
+	}
+	log.Info("Answer program started", "cmd", cmdLine, "pid", cmd.Process.Pid)
+
+	err = cmd.Wait()
+	log.Info("Answer program exited", "cmd", cmdLine, "err", err)
+	answerSessions.Lock()
+	current := answerSessions.byModem[m] == s
+	if current {
+		delete(answerSessions.byModem, m)
+	}
+	answerSessions.Unlock()
+	if current {
+		drainTTY(m)
+		m.HangupSync()
+	}
+}
+
+// drainTTY waits for the output still buffered by the TTY and the modem to
+// reach the call, until nothing more has been read from the TTY for the flush
+// delay of the call data, and a bit more, or answerDrainMax passes.
+func drainTTY(m *vm.Modem) {
+	idle := flushDelay() + 100*time.Millisecond
+	deadline := time.Now().Add(answerDrainMax)
+	last := m.MetricsSync().TtyRxBytes
+	for time.Now().Before(deadline) {
+		time.Sleep(idle)
+		n := m.MetricsSync().TtyRxBytes
+		if n == last {
+			return
+		}
+		last = n
+	}
+}
+
+// hangup ends the session: the program is hung up, and killed if it does not
+// exit in time.
+func (s *answerSession) hangup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+	if s.proc != nil {
+		p := s.proc
+		hangupProcess(p)
+		time.AfterFunc(answerHangupGrace, func() { p.Kill() })
+	}
+}
+
+// startAnswerProgram starts cmd on the TTY of m.
+func startAnswerProgram(m *vm.Modem, cmd *exec.Cmd) error {
+	var tty Device
+	bankMu.Lock()
+	for _, inst := range instances {
+		if inst != nil && inst.modem == m {
+			tty = inst.devs[0]
+		}
+	}
+	bankMu.Unlock()
+	if tty == nil {
+		return fmt.Errorf("modem not running")
+	}
+	return startOnTTY(cmd, tty.Name())
+}
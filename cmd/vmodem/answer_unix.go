@@ -0,0 +1,32 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand returns the command running cmdLine with the shell.
+func shellCommand(cmdLine string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", cmdLine)
+}
+
+// startOnTTY starts cmd in a session of its own with the TTY at path as its
+// controlling terminal and standard I/O, like getty does.
+func startOnTTY(cmd *exec.Cmd, path string) error {
+	tty, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	return cmd.Start()
+}
+
+// hangupProcess tells the session of p that its line hung up (SIGHUP).
+func hangupProcess(p *os.Process) {
+	syscall.Kill(-p.Pid, syscall.SIGHUP)
+}
@@ -0,0 +1,104 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// Test an answered call runs the program on the TTY, and its exit hangs up
+func TestAnswerExec(t *testing.T) {
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true,
+		AnswerExec: `printf login:; read user; echo hello $user from $VMODEM_CALLER`}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances = Options{}, nil, nil
+	}()
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	m := bank()[0]
+
+	host, line := net.Pipe()
+	defer host.Close()
+	if err := m.IncomingCallInfoSync(line, vm.CallInfo{Number: "5551234"}); err != nil {
+		t.Fatalf("IncomingCallInfoSync() error = %v", err)
+	}
+	r := bufio.NewReader(host)
+	host.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if prompt, err := r.ReadString(':'); err != nil || !strings.HasSuffix(prompt, "login:") {
+		t.Fatalf("prompt = %q, %v", prompt, err)
+	}
+	host.Write([]byte("bob\r"))
+	for {
+		s, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading greeting: %v", err)
+		}
+		if strings.Contains(s, "hello") {
+			if s != "hello bob from 5551234\r\n" {
+				t.Errorf("greeting = %q", s)
+			}
+			break
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for m.StatusSync() != vm.StatusIdle && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.StatusSync() != vm.StatusIdle {
+		t.Errorf("status = %v after the program exited, want idle", m.StatusSync())
+	}
+}
+
+// Test a call hung up sends SIGHUP to the program
+func TestAnswerExecHangup(t *testing.T) {
+	dir := t.TempDir()
+	hup := filepath.Join(dir, "hup")
+	options = Options{TtyPath: dir, Locale: "en", NoListen: true,
+		AnswerExec: "trap 'echo hup > " + hup + "; exit' HUP; echo ready; while :; do sleep 0.05; done"}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances = Options{}, nil, nil
+	}()
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	m := bank()[0]
+
+	host, line := net.Pipe()
+	defer host.Close()
+	if err := m.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	host.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if s, err := bufio.NewReader(host).ReadString('\n'); err != nil || s != "ready\r\n" {
+		t.Fatalf("program output = %q, %v", s, err)
+	}
+	if err := m.HangupSync(); err != nil {
+		t.Fatalf("HangupSync() error = %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, _ := os.ReadFile(hup); string(data) == "hup\n" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("program not hung up")
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// shellCommand returns the command running cmdLine with the shell.
+func shellCommand(cmdLine string) *exec.Cmd {
+	return exec.Command("cmd", "/C", cmdLine)
+}
+
+// startOnTTY starts cmd with the named pipe at path as its standard I/O.
+func startOnTTY(cmd *exec.Cmd, path string) error {
+	tty, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	return cmd.Start()
+}
+
+// hangupProcess ends p, since Windows has no hangup signal.
+func hangupProcess(p *os.Process) {
+	p.Kill()
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the YAML configuration file of the server. Every setting maps to a
+// command line option, which takes precedence over the file.
+type Config struct {
+	// TTY describes the TTYs the modems are exposed on
+	TTY TTYConfig `yaml:"tty"`
+	// Modem sets any other option by its long name, e.g. "line-speed: 2400"
+	Modem map[string]any `yaml:"modem"`
+	// Init lists the AT commands run on each modem before its TTY is exposed
+	Init []string `yaml:"init"`
+	// Dial configures outgoing calls
+	Dial DialConfig `yaml:"dial"`
+	// Listen configures incoming calls
+	Listen ListenConfig `yaml:"listen"`
+	// Logging configures diagnostics
+	Logging LoggingConfig `yaml:"logging"`
+	// Modems lists the modems of the bank, replacing tty.num
+	Modems []ModemSpec `yaml:"modems"`
+}
+
+// TTYConfig describes the TTYs the modems are exposed on.
+type TTYConfig struct {
+	Path        *string  `yaml:"path"`
+	Start       *int     `yaml:"start"`
+	Num         *int     `yaml:"num"`
+	Attach      []string `yaml:"attach"`
+	Link        []string `yaml:"link"`
+	Com         []string `yaml:"com"`
+	Device      []string `yaml:"device"`
+	RFC2217     []string `yaml:"rfc2217"`
+	Mode        *string  `yaml:"mode"`
+	Owner       *string  `yaml:"owner"`
+	Group       *string  `yaml:"group"`
+	Speed       *int     `yaml:"speed"`
+	FollowSpeed *bool    `yaml:"follow-speed"`
+}
+
+// DialConfig configures outgoing calls.
+type DialConfig struct {
+	Port           *string       `yaml:"port"`
+	Bind           *string       `yaml:"bind"`
+	Timeout        *int          `yaml:"timeout"`
+	ConnectTimeout *int          `yaml:"connect-timeout"`
+	Phonebook      *string       `yaml:"phonebook"`
+	Proxy          *string       `yaml:"proxy"`
+	ProxyRules     []ProxyConfig `yaml:"proxy-rules"`
+	Translate      []Translation `yaml:"translate"`
+	Routes         []RouteConfig `yaml:"routes"`
+}
+
+// RouteConfig routes the dial strings matching Match to Target, in the format
+// of the --route option.
+type RouteConfig struct {
+	Match  string `yaml:"match"`
+	Target string `yaml:"target"`
+	Bind   string `yaml:"bind"`
+}
+
+// ProxyConfig routes the calls to the destinations matching Host through the
+// proxy Proxy, in the format of the --proxy-rule option.
+type ProxyConfig struct {
+	Host  string `yaml:"host"`
+	Proxy string `yaml:"proxy"`
+}
+
+// Translation maps the phone numbers matching Number to the host Host, in the
+// format of the --translate option.
+type Translation struct {
+	Number string `yaml:"number"`
+	Host   string `yaml:"host"`
+	Bind   string `yaml:"bind"`
+}
+
+// ListenConfig configures incoming calls.
+type ListenConfig struct {
+	Enabled       *bool        `yaml:"enabled"`
+	Addr          *string      `yaml:"addr"`
+	Extra         []string     `yaml:"extra"`
+	WebSocket     []string     `yaml:"websocket"`
+	BusyStr       *string      `yaml:"busy-str"`
+	AnswerTimeout *int         `yaml:"answer-timeout"`
+	IdleTimeout   *int         `yaml:"idle-timeout"`
+	Pools         []PoolConfig `yaml:"pools"`
+}
+
+// PoolConfig is a hunt group of the modems Modems, in the format of the --pool
+// option.
+type PoolConfig struct {
+	Name   string   `yaml:"name"`
+	Modems []string `yaml:"modems"`
+	Addr   string   `yaml:"addr"`
+}
+
+// LoggingConfig configures diagnostics.
+type LoggingConfig struct {
+	Verbose       *int    `yaml:"verbose"`
+	Metrics       *string `yaml:"metrics"`
+	API           *string `yaml:"api"`
+	Console       *string `yaml:"console"`
+	Level         *string `yaml:"level"`
+	Format        *string `yaml:"format"`
+	File          *string `yaml:"file"`
+	MaxSize       *int    `yaml:"max-size"`
+	MaxBackups    *int    `yaml:"max-backups"`
+	Capture       *string `yaml:"capture"`
+	CaptureFormat *string `yaml:"capture-format"`
+	HexDump       *bool   `yaml:"hexdump"`
+}
+
+// configValue is the value of a command line option set by the config file.
+type configValue struct {
+	name   string
+	values []string
+}
+
+// parseConfig parses and strictly decodes a YAML config file.
+func parseConfig(data []byte) (*Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// values returns the command line options set by c.
+func (c *Config) values() []configValue {
+	var vals []configValue
+	set := func(name string, values ...string) {
+		vals = append(vals, configValue{name: name, values: values})
+	}
+	str := func(name string, v *string) {
+		if v != nil {
+			set(name, *v)
+		}
+	}
+	num := func(name string, v *int) {
+		if v != nil {
+			set(name, fmt.Sprint(*v))
+		}
+	}
+
+	str("tty", c.TTY.Path)
+	num("start", c.TTY.Start)
+	num("num", c.TTY.Num)
+	if len(c.TTY.Attach) > 0 {
+		set("attach", c.TTY.Attach...)
+	}
+	if len(c.TTY.Link) > 0 {
+		set("link", c.TTY.Link...)
+	}
+	if len(c.TTY.Com) > 0 {
+		set("com", c.TTY.Com...)
+	}
+	if len(c.TTY.Device) > 0 {
+		set("device", c.TTY.Device...)
+	}
+	if len(c.TTY.RFC2217) > 0 {
+		set("rfc2217", c.TTY.RFC2217...)
+	}
+	str("tty-mode", c.TTY.Mode)
+	str("tty-owner", c.TTY.Owner)
+	str("tty-group", c.TTY.Group)
+	num("tty-speed", c.TTY.Speed)
+	if c.TTY.FollowSpeed != nil && *c.TTY.FollowSpeed {
+		set("follow-tty-speed", "true")
+	}
+	if len(c.Init) > 0 {
+		set("init", c.Init...)
+	}
+	str("port", c.Dial.Port)
+	str("bind", c.Dial.Bind)
+	num("dial-timeout", c.Dial.Timeout)
+	num("connect-timeout", c.Dial.ConnectTimeout)
+	str("phonebook", c.Dial.Phonebook)
+	str("proxy", c.Dial.Proxy)
+	if len(c.Dial.ProxyRules) > 0 {
+		var rs []string
+		for _, r := range c.Dial.ProxyRules {
+			rs = append(rs, r.Host+"->"+r.Proxy)
+		}
+		set("proxy-rule", rs...)
+	}
+	if len(c.Dial.Translate) > 0 {
+		var ts []string
+		for _, t := range c.Dial.Translate {
+			s := t.Number + "->" + t.Host
+			if t.Bind != "" {
+				s += "->" + t.Bind
+			}
+			ts = append(ts, s)
+		}
+		set("translate", ts...)
+	}
+	if len(c.Dial.Routes) > 0 {
+		var rs []string
+		for _, r := range c.Dial.Routes {
+			s := r.Match + "->" + r.Target
+			if r.Bind != "" {
+				s += "->" + r.Bind
+			}
+			rs = append(rs, s)
+		}
+		set("route", rs...)
+	}
+	if c.Listen.Enabled != nil && !*c.Listen.Enabled {
+		set("nolisten", "true")
+	}
+	str("addr", c.Listen.Addr)
+	if len(c.Listen.Extra) > 0 {
+		set("listen", c.Listen.Extra...)
+	}
+	if len(c.Listen.WebSocket) > 0 {
+		set("ws-listen", c.Listen.WebSocket...)
+	}
+	str("busy-str", c.Listen.BusyStr)
+	num("answer-timeout", c.Listen.AnswerTimeout)
+	num("idle-timeout", c.Listen.IdleTimeout)
+	if len(c.Listen.Pools) > 0 {
+		var ps []string
+		for _, p := range c.Listen.Pools {
+			s := p.Name + "->" + strings.Join(p.Modems, ",")
+			if p.Addr != "" {
+				s += "->" + p.Addr
+			}
+			ps = append(ps, s)
+		}
+		set("pool", ps...)
+	}
+	if v := c.Logging.Verbose; v != nil {
+		verbose := make([]string, *v)
+		for i := range verbose {
+			verbose[i] = "true"
+		}
+		set("verbose", verbose...)
+	}
+	str("metrics", c.Logging.Metrics)
+	str("api", c.Logging.API)
+	str("console", c.Logging.Console)
+	str("log-level", c.Logging.Level)
+	str("log-format", c.Logging.Format)
+	str("log-file", c.Logging.File)
+	num("log-max-size", c.Logging.MaxSize)
+	num("log-max-backups", c.Logging.MaxBackups)
+	str("capture", c.Logging.Capture)
+	str("capture-format", c.Logging.CaptureFormat)
+	if c.Logging.HexDump != nil && *c.Logging.HexDump {
+		set("hexdump", "true")
+	}
+
+	names := make([]string, 0, len(c.Modem))
+	for name := range c.Modem {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch v := c.Modem[name].(type) {
+		case []any:
+			var s []string
+			for _, e := range v {
+				s = append(s, fmt.Sprint(e))
+			}
+			set(name, s...)
+		case bool:
+			if v {
+				set(name, "true")
+			}
+		default:
+			set(name, fmt.Sprint(v))
+		}
+	}
+	return vals
+}
+
+// applyConfig sets the options of the config file cfg on the options of p not
+// given on the command line.
+func applyConfig(p *flags.Parser, cfg *Config) error {
+	vals := cfg.values()
+	fromArgs := make(map[string]bool)
+	for _, v := range vals {
+		opt := p.FindOptionByLongName(v.name)
+		if opt == nil || v.name == "config" || v.name == "check-config" {
+			return fmt.Errorf("unknown option %q", v.name)
+		}
+		fromArgs[v.name] = opt.IsSet() && !opt.IsSetDefault()
+	}
+	for _, v := range vals {
+		if fromArgs[v.name] {
+			continue
+		}
+		opt := p.FindOptionByLongName(v.name)
+		for _, s := range v.values {
+			if err := opt.Set(&s); err != nil {
+				return fmt.Errorf("%s: %v", v.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadConfig reads the config file at path into the options of p.
+func loadConfig(p *flags.Parser, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	configModems = cfg.Modems
+	return applyConfig(p, cfg)
+}
+
+// configure validates the options and builds the translation, command and line
+// tables from them.
+func configure() error {
+	switch {
+	case options.NumTTYs < 1:
+		return fmt.Errorf("num must be at least 1")
+	case options.StartNum < 0:
+		return fmt.Errorf("start must not be negative")
+	case options.LineSpeed != 0 && (options.LineSpeed < 300 || options.LineSpeed > 56000):
+		return fmt.Errorf("line-speed must be 0 or between 300 and 56000")
+	case options.BitErrorRate < 0 || options.BitErrorRate > 1:
+		return fmt.Errorf("bit-error-rate must be between 0 and 1")
+	case options.GarbageRate < 0 || options.GarbageRate > 1:
+		return fmt.Errorf("garbage-rate must be between 0 and 1")
+	case options.GuardTime < 0 || options.GuardTime > 255:
+		return fmt.Errorf("guard-time must be between 0 and 255")
+	case options.ConnectTimeout < 0:
+		return fmt.Errorf("connect-timeout must not be negative")
+	case options.TTYSpeed < 0:
+		return fmt.Errorf("tty-speed must not be negative")
+	}
+	if err := checkLogging(); err != nil {
+		return err
+	}
+	for _, sreg := range options.SReg {
+		if _, _, err := parseSReg(sreg); err != nil {
+			return err
+		}
+	}
+	if !options.NoListen {
+		if err := checkListenAddr(options.ListenAddr); err != nil {
+			return fmt.Errorf("addr: %v", err)
+		}
+	}
+	for _, addr := range options.Listen {
+		if err := checkListenAddr(addr); err != nil {
+			return fmt.Errorf("listen: %v", err)
+		}
+	}
+	for _, addr := range options.WSListen {
+		if _, _, err := splitWSAddr(addr); err != nil {
+			return fmt.Errorf("ws-listen: %v", err)
+		}
+	}
+	if options.Metrics != "" {
+		if _, _, err := net.SplitHostPort(options.Metrics); err != nil {
+			return fmt.Errorf("metrics: %v", err)
+		}
+	}
+	if options.API != "" {
+		if _, _, err := net.SplitHostPort(options.API); err != nil {
+			return fmt.Errorf("api: %v", err)
+		}
+	}
+	if options.Console != "" && options.Console != consoleStdio {
+		if err := checkListenAddr(options.Console); err != nil {
+			return fmt.Errorf("console: %v", err)
+		}
+	}
+	for _, a := range options.Attach {
+		if _, _, _, err := parseAttach(a); err != nil {
+			return fmt.Errorf("attach %s: %v", a, err)
+		}
+	}
+	var err error
+	if specs, err = bankSpecs(); err != nil {
+		return err
+	}
+	if err := bankPools(); err != nil {
+		return err
+	}
+	if options.Stdio {
+		switch {
+		case len(specs) != 1:
+			return fmt.Errorf("stdio needs a single modem")
+		case specs[0].Device != "" || specs[0].Com != "" || specs[0].RFC2217 != "":
+			return fmt.Errorf("stdio replaces the TTY of the modem, it cannot have a device")
+		case options.Daemon:
+			return fmt.Errorf("stdio cannot run as a daemon")
+		}
+	}
+	if options.Console == consoleStdio && (options.Stdio || options.Daemon) {
+		return fmt.Errorf("console on the terminal needs it free, without stdio or daemon")
+	}
+	for _, spec := range specs {
+		if answerCommand(spec.Name) != "" && (options.Stdio || spec.Device != "" || spec.Com != "" || spec.RFC2217 != "") {
+			return fmt.Errorf("%s: answer-exec needs the TTY of the modem", spec.Name)
+		}
+		if spec.RFC2217 == "" {
+			continue
+		}
+		if spec.Device != "" || spec.Com != "" {
+			return fmt.Errorf("%s: rfc2217 replaces the TTY of the modem, it cannot have a device", spec.Name)
+		}
+		if _, _, err := net.SplitHostPort(spec.RFC2217); err != nil {
+			return fmt.Errorf("%s: rfc2217: %v", spec.Name, err)
+		}
+	}
+	if ttyAccess, err = parsePtyAccess(options.TTYMode, options.TTYOwner, options.TTYGroup); err != nil {
+		return err
+	}
+	return reloadTables()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+const testConfig = `
+tty:
+  path: /var/lib/vmodem
+  num: 4
+init: [E0, V1]
+dial:
+  port: "2323"
+  translate:
+    - number: '^555(\d{4})$'
+      host: 'bbs.example.com:%[1]s'
+      bind: tun0
+listen:
+  enabled: false
+  busy-str: BUSY
+logging:
+  verbose: 2
+  hexdump: true
+modem:
+  line-speed: 2400
+  locale: fr
+  monitor: true
+modems:
+  - name: bbs1
+    listen: 127.0.0.1:2323
+  - init: [S0=1]
+`
+
+// Test the config file sets the options not given on the command line
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vmodem.yaml")
+	if err := os.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	options = Options{}
+	defer func() { options, configModems, specs = Options{}, nil, nil }()
+	p := flags.NewParser(&options, flags.Default)
+	if _, err := p.ParseArgs([]string{"-n", "2"}); err != nil {
+		t.Fatalf("ParseArgs() error = %v", err)
+	}
+	if err := loadConfig(p, path); err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	if options.NumTTYs != 2 {
+		t.Errorf("NumTTYs = %d, want 2 from the command line", options.NumTTYs)
+	}
+	if options.TtyPath != "/var/lib/vmodem" || options.DefaultPort != "2323" || options.BusyStr != "BUSY" {
+		t.Errorf("TtyPath, DefaultPort, BusyStr = %q, %q, %q", options.TtyPath, options.DefaultPort, options.BusyStr)
+	}
+	if !slices.Equal(options.InitCmd, []string{"E0", "V1"}) {
+		t.Errorf("InitCmd = %q", options.InitCmd)
+	}
+	if want := []string{`^555(\d{4})$->bbs.example.com:%[1]s->tun0`}; !slices.Equal(options.Translate, want) {
+		t.Errorf("Translate = %q, want %q", options.Translate, want)
+	}
+	if !options.NoListen || len(options.Verbose) != 2 || !options.Monitor || !options.HexDump {
+		t.Errorf("NoListen, Verbose, Monitor, HexDump = %v, %d, %v, %v", options.NoListen, len(options.Verbose), options.Monitor, options.HexDump)
+	}
+	if options.LineSpeed != 2400 || options.Locale != "fr" {
+		t.Errorf("LineSpeed, Locale = %d, %q", options.LineSpeed, options.Locale)
+	}
+	if options.RingMax != 10 {
+		t.Errorf("RingMax = %d, want the default 10", options.RingMax)
+	}
+	if err := configure(); err != nil {
+		t.Errorf("configure() error = %v", err)
+	}
+	if len(specs) != 2 || specs[0].Listen != "127.0.0.1:2323" || specs[1].Name != "tty1" || specs[1].Init[0] != "S0=1" {
+		t.Errorf("specs = %+v", specs)
+	}
+	if host, bind := findHost("5551234"); host != "bbs.example.com:1234" || bind != "tun0" {
+		t.Errorf("findHost() = %q, %q", host, bind)
+	}
+}
+
+// Test invalid config files and options are rejected
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"unknown section", "modems: {}"},
+		{"unknown field", "tty: {size: 3}"},
+		{"unknown option", "modem: {speed: 2400}"},
+		{"invalid choice", "modem: {locale: de}"},
+		{"invalid number", "modem: {ring: many}"},
+		{"line speed", "modem: {line-speed: 100}"},
+		{"s-register", "modem: {s: ['0=300']}"},
+		{"echo", "modem: {echo: maybe}"},
+		{"translation", "dial: {translate: [{number: '(', host: x}]}"},
+		{"command", "modem: {command: ['^I9$->x->MAYBE']}"},
+		{"attach", "tty: {attach: [ttyS0]}"},
+		{"listen address", "listen: {addr: nowhere}"},
+		{"stdio bank", "tty: {num: 2}\nmodem: {stdio: true}"},
+		{"answer-exec on stdio", "modem: {stdio: true, answer-exec: /bin/login}"},
+		{"rfc2217 address", "tty: {rfc2217: [nowhere]}"},
+		{"pool modem", "listen: {pools: [{name: lines, modems: [tty9]}]}"},
+		{"rfc2217 with device", "tty: {rfc2217: [':7000'], device: [/dev/ttyS0]}"},
+		{"console address", "logging: {console: nowhere}"},
+		{"console on stdio", "modem: {stdio: true}\nlogging: {console: '-'}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options = Options{}
+			defer func() { options = Options{} }()
+			p := flags.NewParser(&options, flags.Default)
+			if _, err := p.ParseArgs(nil); err != nil {
+				t.Fatalf("ParseArgs() error = %v", err)
+			}
+			cfg, err := parseConfig([]byte(tt.config))
+			if err == nil {
+				err = applyConfig(p, cfg)
+			}
+			if err == nil {
+				err = configure()
+			}
+			if err == nil {
+				t.Errorf("config %q accepted", tt.config)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// consoleStdio is the --console address of the console on the terminal.
+const consoleStdio = "-"
+
+// consolePrompt is written before each command of a console session.
+const consolePrompt = "vmodem> "
+
+// consoleHelp is the answer of the help command.
+const consoleHelp = `Commands:
+  stat [modem]                                 Status of the modems, or the S-registers of one
+  ring <modem> [from <number>] [name <name>] [to <target>]
+                                               Incoming call, to target or to a line echoing the data
+  answer <modem>                               Answer the ringing call
+  drop <modem>                                 Hang up the call
+  sreg <modem> <reg>[=<value>]                 Show or set an S-register
+  pools                                        Occupancy of the pools
+  help                                         This help
+  quit                                         End the session
+Modems are given by name or by TTY number (1 for tty1).
+`
+
+// errConsoleQuit ends a console session.
+var errConsoleQuit = errors.New("quit")
+
+// enableConsole serves the operator console on addr: a unix: socket, host:port,
+// or - for the terminal.
+func enableConsole(addr string) {
+	if addr == consoleStdio {
+		go serveConsole(os.Stdin, os.Stdout)
+		return
+	}
+	l, err := listenCalls(addr)
+	if err != nil {
+		shutdown("Error starting console", "err", err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	go serveConsoles(l)
+}
+
+// serveConsoles serves console sessions on l until it is closed, then waits
+// for the sessions in progress to end.
+func serveConsoles(l net.Listener) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Error("Error accepting console sessions", "err", err)
+			}
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			logger.Info("Console session started", "addr", conn.RemoteAddr())
+			serveConsole(conn, conn)
+		}()
+	}
+}
+
+// serveConsole runs a console session reading commands from r and writing
+// their output to w, until quit or the end of r.
+func serveConsole(r io.Reader, w io.Writer) {
+	sc := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(w, consolePrompt)
+		if !sc.Scan() {
+			return
+		}
+		err := consoleCommand(w, strings.Fields(sc.Text()))
+		if errors.Is(err, errConsoleQuit) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+		}
+	}
+}
+
+// consoleCommand runs the console command args.
+func consoleCommand(w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	logger.Debug("Console command", "command", strings.Join(args, " "))
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "help", "?":
+		fmt.Fprint(w, consoleHelp)
+		return nil
+	case "quit", "exit":
+		return errConsoleQuit
+	case "pools":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		for _, p := range pools {
+			st := poolState(p)
+			fmt.Fprintf(tw, "%s\tidle %d\tbusy %d", st.Name, st.Idle, st.Busy)
+			if st.Addr != "" {
+				fmt.Fprintf(tw, "\t%s", st.Addr)
+			}
+			fmt.Fprintln(tw)
+		}
+		return tw.Flush()
+	case "stat":
+		if len(args) == 0 {
+			tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+			for _, m := range bank() {
+				st := modemState(m, false)
+				fmt.Fprintf(tw, "%s\t%s", st.Id, st.Status)
+				if st.Call != nil {
+					fmt.Fprintf(tw, "\t%s", caller(st.Call))
+				}
+				fmt.Fprintln(tw)
+			}
+			return tw.Flush()
+		}
+	}
+
+	if !slices.Contains([]string{"stat", "ring", "answer", "drop", "sreg"}, cmd) {
+		return fmt.Errorf("unknown command %s, try help", cmd)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s <modem>", cmd)
+	}
+	m := consoleModem(args[0])
+	if m == nil {
+		return fmt.Errorf("no modem %s", args[0])
+	}
+	args = args[1:]
+	switch cmd {
+	case "stat":
+		st := modemState(m, true)
+		fmt.Fprintln(w, strings.TrimSpace(st.Id+" "+st.Status+" "+caller(st.Call)))
+		regs := make([]int, 0, len(st.SRegs))
+		for reg := range st.SRegs {
+			n, _ := strconv.Atoi(reg)
+			regs = append(regs, n)
+		}
+		slices.Sort(regs)
+		for _, reg := range regs {
+			fmt.Fprintf(w, "S%d=%d\n", reg, st.SRegs[strconv.Itoa(reg)])
+		}
+	case "ring":
+		return consoleRing(w, m, args)
+	case "answer":
+		if _, err := m.AnswerSync(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s answered\n", m.Id())
+	case "drop":
+		if err := m.HangupSync(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s hung up\n", m.Id())
+	default: // sreg
+		if len(args) != 1 {
+			return errors.New("usage: sreg <modem> <reg>[=<value>]")
+		}
+		regStr, valStr, set := strings.Cut(strings.TrimPrefix(strings.ToUpper(args[0]), "S"), "=")
+		reg, err := strconv.ParseUint(regStr, 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid register %s", regStr)
+		}
+		if set {
+			val, err := strconv.ParseUint(valStr, 10, 8)
+			if err != nil {
+				return errors.New("value must be between 0 and 255")
+			}
+			m.SetSRegSync(byte(reg), byte(val))
+		}
+		fmt.Fprintf(w, "S%d=%d\n", reg, m.SRegSync(byte(reg)))
+	}
+	return nil
+}
+
+// consoleRing triggers an incoming call on m from the ring command arguments.
+func consoleRing(w io.Writer, m *vm.Modem, args []string) error {
+	var number, name, target string
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return fmt.Errorf("missing value of %s", args[0])
+		}
+		switch args[0] {
+		case "from":
+			number = args[1]
+		case "name":
+			name = args[1]
+		case "to":
+			target = args[1]
+		default:
+			return fmt.Errorf("unknown ring argument %s", args[0])
+		}
+		args = args[2:]
+	}
+	conn, source, err := ringConn(ctx, target, "console")
+	if err != nil {
+		return err
+	}
+	info := vm.CallInfo{Number: number, Name: name, Source: source}
+	if err := m.IncomingCallInfoSync(conn, info); err != nil {
+		conn.Close()
+		return err
+	}
+	fmt.Fprintf(w, "%s ringing\n", m.Id())
+	return nil
+}
+
+// consoleModem returns the running modem named id, or whose TTY number is id.
+func consoleModem(id string) *vm.Modem {
+	if m := findModem(id); m != nil {
+		return m
+	}
+	return findModem("tty" + id)
+}
+
+// caller describes the caller of the call c for the console.
+func caller(c *CallState) string {
+	if c == nil {
+		return ""
+	}
+	var parts []string
+	if c.Number != "" {
+		parts = append(parts, "from "+c.Number)
+	}
+	if c.Name != "" {
+		parts = append(parts, strconv.Quote(c.Name))
+	}
+	if c.Source != "" {
+		parts = append(parts, "("+c.Source+")")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// Test an operator drives a call scenario from the console
+func TestConsole(t *testing.T) {
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true}
+	specs = []ModemSpec{{Name: "tty0"}, {Name: "tty1"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances = Options{}, nil, nil
+	}()
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	m := bank()[1]
+
+	run := func(line string) string {
+		t.Helper()
+		var out strings.Builder
+		serveConsole(strings.NewReader(line+"\n"), &out)
+		return strings.TrimSuffix(strings.TrimPrefix(out.String(), consolePrompt), consolePrompt)
+	}
+	if out := run("ring 1 from 5551212 name Alice"); out != "tty1 ringing\n" {
+		t.Errorf("ring = %q", out)
+	}
+	if st := m.StatusSync(); st != vm.StatusRinging {
+		t.Errorf("status = %v after ring, want ringing", st)
+	}
+	if info := m.CallInfoSync(); info.Number != "5551212" || info.Name != "Alice" || info.Source != "console" {
+		t.Errorf("CallInfo() = %+v", info)
+	}
+	if out := run("stat"); !strings.Contains(out, "tty0  Idle") || !strings.Contains(out, `tty1  Ringing  from 5551212 "Alice" (console)`) {
+		t.Errorf("stat = %q", out)
+	}
+	if out := run("answer tty1"); out != "tty1 answered\n" || m.StatusSync() != vm.StatusConnected {
+		t.Errorf("answer = %q, status %v", out, m.StatusSync())
+	}
+	if out := run("drop 1"); out != "tty1 hung up\n" || m.StatusSync() != vm.StatusIdle {
+		t.Errorf("drop = %q, status %v", out, m.StatusSync())
+	}
+	if out := run("sreg 1 S0=2"); out != "S0=2\n" || m.SRegSync(0) != 2 {
+		t.Errorf("sreg = %q, S0 = %d", out, m.SRegSync(0))
+	}
+	if out := run("stat 1"); !strings.HasPrefix(out, "tty1 Idle\n") || !strings.Contains(out, "S0=2\n") {
+		t.Errorf("stat 1 = %q", out)
+	}
+	for _, bad := range []string{"frobnicate", "drop", "drop 9", "sreg 1 x", "sreg 1 0=300", "ring 1 from"} {
+		if out := run(bad); !strings.HasPrefix(out, "error: ") {
+			t.Errorf("%s = %q, want an error", bad, out)
+		}
+	}
+}
+
+// Test console sessions are served on a Unix socket
+func TestConsoleSocket(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "console.sock")
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances = Options{}, nil, nil
+	}()
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	l, err := listenCalls("unix:" + addr)
+	if err != nil {
+		t.Fatalf("listenCalls() error = %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		serveConsoles(l)
+		close(done)
+	}()
+	defer func() {
+		l.Close()
+		<-done
+	}()
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("stat\nquit\n"))
+	r := bufio.NewReader(conn)
+	var out strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		out.WriteString(line)
+		if err != nil {
+			break
+		}
+	}
+	if want := consolePrompt + "tty0  Idle\n" + consolePrompt; out.String() != want {
+		t.Errorf("session = %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// daemonEnv marks the background process started by daemonize.
+const daemonEnv = "VMODEM_DAEMON"
+
+// daemonize runs vmodem in the background: it starts a copy of the process in a
+// new session, detached from the terminal, and exits. In that copy it returns
+// and vmodem goes on.
+func daemonize() error {
+	if os.Getenv(daemonEnv) != "" {
+		os.Unsetenv(daemonEnv)
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = null, null, null
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	fmt.Printf("Vmodem started in the background, pid %d\n", cmd.Process.Pid)
+	os.Exit(0)
+	return nil
+}
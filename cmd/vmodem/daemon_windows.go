@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+// daemonize is not supported on Windows, where vmodem runs in the background
+// as a service instead.
+func daemonize() error {
+	return errors.New("daemon mode is not supported on Windows")
+}
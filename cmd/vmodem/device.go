@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.bug.st/serial"
+)
+
+// Device is the TTY side of a modem that applications open: a PTY on Unix, and
+// a named pipe or a com0com virtual COM port on Windows. A real serial device
+// can take its place, wired to the DTE.
+type Device interface {
+	io.ReadWriteCloser
+	// Name returns the name of the device
+	Name() string
+}
+
+// Flow control of serial devices.
+const (
+	flowNone    = "none"
+	flowRtsCts  = "rtscts"
+	flowXonXoff = "xonxoff"
+)
+
+// parseDevice parses a serial device in the format
+// device:speed,data_bits,parity,stop_bits,flow, where everything but the device
+// is optional and flow is none, rtscts or xonxoff.
+func parseDevice(cfgStr string) (string, *serial.Mode, string, error) {
+	path, params, _ := strings.Cut(cfgStr, ":")
+	if path == "" {
+		return "", nil, "", fmt.Errorf("invalid device string")
+	}
+	serialParams := []string{}
+	if params != "" {
+		serialParams = strings.Split(params, ",")
+	}
+	flow := flowNone
+	if len(serialParams) >= 5 {
+		flow = strings.ToLower(serialParams[4])
+		switch flow {
+		case flowNone, flowRtsCts, flowXonXoff:
+		default:
+			return "", nil, "", fmt.Errorf("invalid flow control")
+		}
+		serialParams = serialParams[:4]
+	}
+	mode, err := parseSerialMode(serialParams)
+	if err != nil {
+		return "", nil, "", err
+	}
+	return path, mode, flow, nil
+}
+
+// openSerialDevice opens the serial device described by cfgStr, in the
+// parseDevice format.
+func openSerialDevice(cfgStr string) (Device, error) {
+	path, mode, flow, err := parseDevice(cfgStr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := serial.Open(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := setFlowControl(path, flow); err != nil {
+		port.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &serialDevice{Port: port, name: path}, nil
+}
+
+// serialDevice is a serial port: a real device wired to the DTE, or one end of
+// a com0com virtual COM port pair whose other end applications open.
+type serialDevice struct {
+	serial.Port
+	name string
+}
+
+func (d *serialDevice) Name() string {
+	return d.name
+}
+
+// dteLines returns a function reading the DTR line of the DTE and the baud rate
+// it set on tty (0 if unknown), or nil if tty carries neither. Applications
+// drop DTR on a PTY by setting 0 baud, as stty 0 and hangup on close (HUPCL) do
+// on serial ports, and the DTR of a DTE wired as a null modem drives our DSR.
+func dteLines(tty Device) func() (bool, int, error) {
+	switch d := tty.(type) {
+	case *UnixPty:
+		return func() (bool, int, error) {
+			speed, err := ptySpeed(d)
+			return speed != 0, speed, err
+		}
+	case *serialDevice:
+		return func() (bool, int, error) {
+			bits, err := d.GetModemStatusBits()
+			if err != nil {
+				return false, 0, err
+			}
+			return bits.DSR, 0, nil
+		}
+	}
+	return nil
+}
+
+// stdioDevice is the TTY of the modem in --stdio mode: the standard input and
+// output of vmodem, connected to the DTE by inetd, sshd or ser2net.
+type stdioDevice struct {
+	in, out *os.File
+}
+
+func newStdioDevice() *stdioDevice {
+	return &stdioDevice{in: os.Stdin, out: os.Stdout}
+}
+
+func (d *stdioDevice) Read(b []byte) (int, error) {
+	return d.in.Read(b)
+}
+
+func (d *stdioDevice) Write(b []byte) (int, error) {
+	return d.out.Write(b)
+}
+
+func (d *stdioDevice) Close() error {
+	d.in.Close()
+	return d.out.Close()
+}
+
+func (d *stdioDevice) Name() string {
+	return "stdio"
+}
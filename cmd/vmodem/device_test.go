@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"go.bug.st/serial"
+)
+
+// Test parsing serial devices and their line settings
+func TestParseDevice(t *testing.T) {
+	path, mode, flow, err := parseDevice("/dev/ttyUSB0")
+	if err != nil || path != "/dev/ttyUSB0" || mode.BaudRate != 9600 || flow != flowNone {
+		t.Errorf("parseDevice() = %q, %+v, %q, %v", path, mode, flow, err)
+	}
+	path, mode, flow, err = parseDevice("/dev/ttyUSB0:19200,7,E,2,RTSCTS")
+	if err != nil || path != "/dev/ttyUSB0" || flow != flowRtsCts {
+		t.Fatalf("parseDevice() = %q, %+v, %q, %v", path, mode, flow, err)
+	}
+	want := serial.Mode{BaudRate: 19200, DataBits: 7, Parity: serial.EvenParity, StopBits: serial.TwoStopBits}
+	if *mode != want {
+		t.Errorf("mode = %+v, want %+v", *mode, want)
+	}
+	for _, bad := range []string{"", ":9600", "/dev/ttyUSB0:fast", "/dev/ttyUSB0:9600,8,X,1", "/dev/ttyUSB0:9600,8,N,1,dsr"} {
+		if _, _, _, err := parseDevice(bad); err == nil {
+			t.Errorf("parseDevice(%q) accepted", bad)
+		}
+	}
+}
+
+// Test devices are assigned to the modems in order and not shared
+func TestAddDevices(t *testing.T) {
+	defer func(o Options) { options = o }(options)
+	options.Device = []string{"/dev/ttyUSB0:57600", "/dev/ttyUSB1"}
+	s, err := addLinks(make([]ModemSpec, 3))
+	if err != nil {
+		t.Fatalf("addLinks() error = %v", err)
+	}
+	if s[0].Device != "/dev/ttyUSB0:57600" || s[1].Device != "/dev/ttyUSB1" || s[2].Device != "" {
+		t.Errorf("devices = %q, %q, %q", s[0].Device, s[1].Device, s[2].Device)
+	}
+	options.Device = []string{"/dev/ttyUSB0:57600", "/dev/ttyUSB0:9600"}
+	if _, err := addLinks(make([]ModemSpec, 2)); err == nil {
+		t.Error("addLinks() accepted a duplicate device")
+	}
+}
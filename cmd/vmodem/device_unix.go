@@ -0,0 +1,55 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// newDevice creates the PTY of the TTY of spec with the given suffix ("" for the
+// modem TTY, "-op" for the shared TTY, "-mon" for the monitor), in raw mode at
+// the --tty-speed baud rate, or opens the serial device of the modem, its RFC
+// 2217 server, or standard I/O in --stdio mode, in place of its TTY.
+func newDevice(spec ModemSpec, suffix string) (Device, error) {
+	if suffix == "" && options.Stdio {
+		return newStdioDevice(), nil
+	}
+	if suffix == "" && spec.Device != "" {
+		return openSerialDevice(spec.Device)
+	}
+	if suffix == "" && spec.RFC2217 != "" {
+		return newRFC2217Device(spec.Name, spec.RFC2217)
+	}
+	p, err := NewPty()
+	if err != nil {
+		return nil, err
+	}
+	// Answer programs get the TTY of a login line
+	if err := setupPty(p, answerCommand(spec.Name) == "", options.TTYSpeed); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("setting up %s: %v", p.Name(), err)
+	}
+	return p, nil
+}
+
+// exposeDevice makes dev available to applications once the modem is ready: it
+// sets the TTY mode and ownership and links it from the TTY path, and from the
+// stable link of the modem. It returns the path applications open. Serial
+// devices, RFC 2217 servers and standard I/O are left as they are.
+func exposeDevice(dev Device, spec ModemSpec, suffix string) (string, error) {
+	switch dev.(type) {
+	case *serialDevice, *rfc2217Device, *stdioDevice:
+		return dev.Name(), nil
+	}
+	if err := ttyAccess.apply(dev.Name()); err != nil {
+		return "", err
+	}
+	path := ttyPath(spec, suffix)
+	if err := symlinkTTY(dev, path); err != nil {
+		return "", err
+	}
+	if suffix == "" && spec.Link != "" {
+		if err := linkTTY(dev, spec.Link); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,134 @@
+//go:build windows
+
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/Microsoft/go-winio"
+	"go.bug.st/serial"
+)
+
+// pipePrefix is the prefix of the named pipes of the modems, followed by the
+// modem name, e.g. \\.\pipe\vmodem-tty0
+const pipePrefix = `\\.\pipe\vmodem-`
+
+// newDevice creates the device of the TTY of spec with the given suffix ("" for
+// the modem TTY, "-op" for the shared TTY, "-mon" for the monitor): the serial
+// device, RFC 2217 server or com0com port of the modem, if it has one, or a
+// named pipe.
+func newDevice(spec ModemSpec, suffix string) (Device, error) {
+	if suffix == "" && options.Stdio {
+		return newStdioDevice(), nil
+	}
+	if suffix == "" && spec.Device != "" {
+		return openSerialDevice(spec.Device)
+	}
+	if suffix == "" && spec.RFC2217 != "" {
+		return newRFC2217Device(spec.Name, spec.RFC2217)
+	}
+	if suffix == "" && spec.Com != "" {
+		port, err := serial.Open(spec.Com, &serial.Mode{BaudRate: 115200})
+		if err != nil {
+			return nil, err
+		}
+		return &serialDevice{Port: port, name: spec.Com}, nil
+	}
+	return newPipeDevice(pipePrefix + spec.Name + suffix)
+}
+
+// exposeDevice returns the name applications open: named pipes and COM ports
+// are available as soon as they are created.
+func exposeDevice(dev Device, spec ModemSpec, suffix string) (string, error) {
+	return dev.Name(), nil
+}
+
+// pipeDevice is a named pipe serving one client at a time, like a serial line.
+// When the client closes the pipe the next one is waited for, and output is
+// discarded while no client is connected.
+type pipeDevice struct {
+	name string
+	l    net.Listener
+	mu   sync.Mutex
+	conn net.Conn // Current client, nil if none
+}
+
+func newPipeDevice(name string) (*pipeDevice, error) {
+	l, err := winio.ListenPipe(name, &winio.PipeConfig{InputBufferSize: 4096, OutputBufferSize: 4096})
+	if err != nil {
+		return nil, err
+	}
+	return &pipeDevice{name: name, l: l}, nil
+}
+
+func (p *pipeDevice) Name() string {
+	return p.name
+}
+
+// client returns the connected client, waiting for one if there is none.
+func (p *pipeDevice) client() (net.Conn, error) {
+	p.mu.Lock()
+	c := p.conn
+	p.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	c, err := p.l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.conn = c
+	p.mu.Unlock()
+	return c, nil
+}
+
+// drop disconnects the client c.
+func (p *pipeDevice) drop(c net.Conn) {
+	p.mu.Lock()
+	if p.conn == c {
+		p.conn = nil
+	}
+	p.mu.Unlock()
+	c.Close()
+}
+
+func (p *pipeDevice) Read(b []byte) (int, error) {
+	for {
+		c, err := p.client()
+		if err != nil {
+			return 0, err
+		}
+		n, err := c.Read(b)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+		p.drop(c)
+	}
+}
+
+func (p *pipeDevice) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	c := p.conn
+	p.mu.Unlock()
+	if c == nil {
+		return len(b), nil
+	}
+	if _, err := c.Write(b); err != nil {
+		p.drop(c)
+	}
+	return len(b), nil
+}
+
+func (p *pipeDevice) Close() error {
+	err := p.l.Close()
+	p.mu.Lock()
+	c := p.conn
+	p.conn = nil
+	p.mu.Unlock()
+	if c != nil {
+		c.Close()
+	}
+	return err
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+const (
+	defaultFallbackDelay = 300 * time.Millisecond
+	defaultDnsTTL        = 60 * time.Second
+	defaultDnsNegTTL     = 5 * time.Second
+	defaultDnsMaxEntries = 256
+)
+
+var errNoAddresses = errors.New("no addresses for host")
+
+// DialerStats contains counters of the outgoing call dialer.
+type DialerStats struct {
+	// Dials is the number of dial attempts
+	Dials int `json:"dials"`
+	// Failures is the number of dial attempts that did not connect
+	Failures int `json:"failures"`
+	// Fallbacks is the number of calls connected over the secondary address family
+	Fallbacks int `json:"fallbacks"`
+	// CacheHits is the number of host lookups served from the DNS cache
+	CacheHits int `json:"cacheHits"`
+	// CacheMisses is the number of host lookups sent to the resolver
+	CacheMisses int `json:"cacheMisses"`
+}
+
+type dnsEntry struct {
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// Dialer connects outgoing calls over TCP. Host names are resolved through a
+// small positive/negative cache, and IPv6/IPv4 addresses are raced following
+// Happy Eyeballs (RFC 8305) so a broken address family does not stall a call.
+type Dialer struct {
+	sync.Mutex
+	// FallbackDelay is the delay before the next address is tried in parallel
+	FallbackDelay time.Duration
+	// DnsTTL is how long successful lookups are cached
+	DnsTTL time.Duration
+	// DnsNegTTL is how long failed lookups are cached
+	DnsNegTTL time.Duration
+	// Resolver is used for host lookups (default: net.DefaultResolver)
+	Resolver *net.Resolver
+	cache    map[string]dnsEntry
+	stats    DialerStats
+}
+
+type dialResult struct {
+	conn net.Conn
+	ip   net.IP
+	err  error
+}
+
+// NewDialer creates a dialer with default delays and cache lifetimes.
+func NewDialer() *Dialer {
+	return &Dialer{
+		FallbackDelay: defaultFallbackDelay,
+		DnsTTL:        defaultDnsTTL,
+		DnsNegTTL:     defaultDnsNegTTL,
+		Resolver:      net.DefaultResolver,
+		cache:         make(map[string]dnsEntry),
+	}
+}
+
+// Stats returns a copy of the dialer counters.
+func (d *Dialer) Stats() DialerStats {
+	d.Lock()
+	defer d.Unlock()
+	return d.stats
+}
+
+func (d *Dialer) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	d.Lock()
+	if e, ok := d.cache[host]; ok && time.Now().Before(e.expires) {
+		d.stats.CacheHits++
+		d.Unlock()
+		return e.ips, e.err
+	}
+	d.stats.CacheMisses++
+	d.Unlock()
+
+	var ips []net.IP
+	addrs, err := d.Resolver.LookupIPAddr(ctx, host)
+	for _, a := range addrs {
+		ips = append(ips, a.IP)
+	}
+	if err == nil && len(ips) == 0 {
+		err = errNoAddresses
+	}
+	if ctx.Err() != nil {
+		// Do not cache lookups aborted by the caller
+		return ips, err
+	}
+
+	ttl := d.DnsTTL
+	if err != nil {
+		ttl = d.DnsNegTTL
+	}
+	d.Lock()
+	if len(d.cache) >= defaultDnsMaxEntries {
+		d.evict()
+	}
+	d.cache[host] = dnsEntry{ips: ips, err: err, expires: time.Now().Add(ttl)}
+	d.Unlock()
+	return ips, err
+}
+
+// evict makes room in the cache, dropping expired entries first.
+// The dialer lock must be held.
+func (d *Dialer) evict() {
+	now := time.Now()
+	for host, e := range d.cache {
+		if now.After(e.expires) {
+			delete(d.cache, host)
+		}
+	}
+	for host := range d.cache {
+		if len(d.cache) < defaultDnsMaxEntries {
+			break
+		}
+		delete(d.cache, host)
+	}
+}
+
+// sortAddrs interleaves address families starting with the family of the
+// first address, as recommended by RFC 8305.
+func sortAddrs(ips []net.IP) []net.IP {
+	var primary, secondary []net.IP
+	for _, ip := range ips {
+		if len(primary) == 0 || (ip.To4() == nil) == (primary[0].To4() == nil) {
+			primary = append(primary, ip)
+		} else {
+			secondary = append(secondary, ip)
+		}
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(primary) || i < len(secondary); i++ {
+		if i < len(primary) {
+			sorted = append(sorted, primary[i])
+		}
+		if i < len(secondary) {
+			sorted = append(sorted, secondary[i])
+		}
+	}
+	return sorted
+}
+
+// DialContext connects to address (host:port), optionally from localAddr.
+func (d *Dialer) DialContext(ctx context.Context, address string, localAddr net.Addr) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	d.Lock()
+	d.stats.Dials++
+	d.Unlock()
+
+	ips, err := d.lookup(ctx, host)
+	if err == nil && localAddr != nil {
+		// A local address restricts the call to its own address family
+		localIP := localAddr.(*net.TCPAddr).IP
+		var filtered []net.IP
+		for _, ip := range ips {
+			if (ip.To4() == nil) == (localIP.To4() == nil) {
+				filtered = append(filtered, ip)
+			}
+		}
+		ips = filtered
+		if len(ips) == 0 {
+			err = errNoAddresses
+		}
+	}
+	if err != nil {
+		d.Lock()
+		d.stats.Failures++
+		d.Unlock()
+		return nil, err
+	}
+
+	conn, ip, err := d.race(ctx, sortAddrs(ips), port, localAddr)
+	d.Lock()
+	defer d.Unlock()
+	if err != nil {
+		d.stats.Failures++
+		return nil, err
+	}
+	if (ip.To4() == nil) != (ips[0].To4() == nil) {
+		d.stats.Fallbacks++
+	}
+	return conn, nil
+}
+
+// race dials ips in order, starting a new attempt every FallbackDelay or as
+// soon as the previous one fails, and returns the first established connection.
+func (d *Dialer) race(ctx context.Context, ips []net.IP, port string, localAddr net.Addr) (net.Conn, net.IP, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(ips))
+	dialer := &net.Dialer{LocalAddr: localAddr}
+	next := 0
+	pending := 0
+	start := func() {
+		ip := ips[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+			results <- dialResult{conn: conn, ip: ip, err: err}
+		}()
+	}
+
+	var lastErr error
+	start()
+	for pending > 0 {
+		timer := time.NewTimer(d.FallbackDelay)
+		select {
+		case r := <-results:
+			timer.Stop()
+			pending--
+			if r.err == nil {
+				// Close connections won by slower attempts
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.ip, nil
+			}
+			lastErr = r.err
+			if next < len(ips) {
+				start()
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+			}
+		}
+	}
+	return nil, nil, lastErr
+}
+
+// callError converts the error of a failed connect into the result of the
+// call: a refused connection is a busy number, and a connect that times out or
+// finds no route is a number that does not answer. Other errors end the call
+// with NO CARRIER.
+func callError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Errorf("%w: %v", vm.ErrRemoteBusy, err)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout(),
+		errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return fmt.Errorf("%w: %v", vm.ErrNoAnswer, err)
+	}
+	return err
+}
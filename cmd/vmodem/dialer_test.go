@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// Test address family interleaving
+func TestSortAddrs(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8::2"),
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("192.0.2.2"),
+	}
+	sorted := sortAddrs(ips)
+	expected := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}
+	for i, ip := range sorted {
+		if ip.String() != expected[i] {
+			t.Errorf("sortAddrs()[%d] = %v, want %v", i, ip, expected[i])
+		}
+	}
+}
+
+// Test fallback to the secondary address family
+func TestDialer_Fallback(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	d := NewDialer()
+	d.FallbackDelay = 50 * time.Millisecond
+	// Nothing listens on the IPv6 loopback port, so the IPv4 address must win
+	d.cache["dual.test"] = dnsEntry{
+		ips:     []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")},
+		expires: time.Now().Add(time.Minute),
+	}
+
+	conn, err := d.DialContext(context.Background(), net.JoinHostPort("dual.test", port), nil)
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	conn.Close()
+
+	stats := d.Stats()
+	if stats.Fallbacks != 1 {
+		t.Errorf("Fallbacks = %d, want 1", stats.Fallbacks)
+	}
+	if stats.CacheHits != 1 {
+		t.Errorf("CacheHits = %d, want 1", stats.CacheHits)
+	}
+}
+
+// Test negative DNS cache entries
+func TestDialer_NegativeCache(t *testing.T) {
+	d := NewDialer()
+	lookupErr := errors.New("lookup failed")
+	d.cache["broken.test"] = dnsEntry{
+		err:     lookupErr,
+		expires: time.Now().Add(time.Minute),
+	}
+
+	_, err := d.DialContext(context.Background(), "broken.test:23", nil)
+	if err != lookupErr {
+		t.Errorf("DialContext() error = %v, want %v", err, lookupErr)
+	}
+
+	stats := d.Stats()
+	if stats.Failures != 1 || stats.CacheHits != 1 || stats.CacheMisses != 0 {
+		t.Errorf("Stats() = %+v, want 1 failure served from cache", stats)
+	}
+}
+
+// Test connect errors are reported as BUSY and NO ANSWER
+func TestCallError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, err = NewDialer().DialContext(context.Background(), addr, nil)
+	if err == nil {
+		t.Skip("closed port accepted the connection")
+	}
+	if err := callError(err); !errors.Is(err, vm.ErrRemoteBusy) {
+		t.Errorf("refused connection = %v, want busy", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+	if err := callError(ctx.Err()); !errors.Is(err, vm.ErrNoAnswer) {
+		t.Errorf("connect timeout = %v, want no answer", err)
+	}
+	other := errors.New("no route")
+	if err := callError(other); err != other {
+		t.Errorf("callError(%v) = %v", other, err)
+	}
+}
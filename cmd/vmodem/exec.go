@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// execScheme is the prefix of the dial targets that run a local program.
+const execScheme = "exec://"
+
+// execArgs returns the program and arguments of the exec:// target, e.g.
+// exec:///usr/games/adventure or exec://telnet localhost 6400. The command
+// line is split at white space and run without a shell, so dial strings
+// expanded into it cannot run other commands.
+func execArgs(target string) ([]string, error) {
+	args := strings.Fields(strings.TrimPrefix(target, execScheme))
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no program in %s", target)
+	}
+	return args, nil
+}
+
+// dialExec runs the program of an exec:// target, with env added to its
+// environment, and returns it as the call: the call data goes to its standard
+// input and comes from its standard output. The call ends when the program
+// exits.
+func dialExec(target string, env ...string) (io.ReadWriteCloser, error) {
+	args, err := execArgs(target)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	return startProcess(cmd)
+}
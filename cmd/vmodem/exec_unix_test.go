@@ -0,0 +1,63 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDialExec(t *testing.T) {
+	// No shell expands the command line
+	conn, err := dialExec("exec://echo $VMODEM_NUMBER;id")
+	if err != nil {
+		t.Fatalf("dialExec() error = %v", err)
+	}
+	if out, err := io.ReadAll(conn); err != nil || string(out) != "$VMODEM_NUMBER;id\n" {
+		t.Errorf("program output = %q, %v", out, err)
+	}
+	conn.Close()
+
+	conn, err = dialExec("exec://printenv VMODEM_NUMBER", "VMODEM_NUMBER=5551234")
+	if err != nil {
+		t.Fatalf("dialExec() error = %v", err)
+	}
+	if out, err := io.ReadAll(conn); err != nil || string(out) != "5551234\n" {
+		t.Errorf("program environment = %q, %v", out, err)
+	}
+	conn.Close()
+
+	conn, err = dialExec("exec:///bin/cat")
+	if err != nil {
+		t.Fatalf("dialExec() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ATDT?"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ATDT?" {
+		t.Errorf("program output = %q, %v", buf, err)
+	}
+
+	// The call ends when the program exits
+	conn.(*procConn).stdin.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(buf)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("Read() after exit error = %v, want EOF", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("program exit not seen")
+	}
+
+	for _, bad := range []string{"exec://", "exec://  "} {
+		if _, _, err := parseTarget(bad); err == nil {
+			t.Errorf("parseTarget(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setFlowControl sets the flow control of the serial device at path in its
+// termios. The serial package leaves it disabled.
+func setFlowControl(path, flow string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Cflag &^= unix.CRTSCTS
+	t.Iflag &^= unix.IXON | unix.IXOFF | unix.IXANY
+	switch flow {
+	case flowRtsCts:
+		t.Cflag |= unix.CRTSCTS
+	case flowXonXoff:
+		t.Iflag |= unix.IXON | unix.IXOFF
+	}
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
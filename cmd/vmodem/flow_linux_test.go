@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// Test the flow control is set in the termios of the device
+func TestSetFlowControl(t *testing.T) {
+	pty, err := NewPty()
+	if err != nil {
+		t.Fatalf("NewPty() error = %v", err)
+	}
+	defer pty.Close()
+
+	if err := setFlowControl(pty.Name(), flowXonXoff); err != nil {
+		t.Fatalf("setFlowControl() error = %v", err)
+	}
+	tio, err := unix.IoctlGetTermios(int(pty.Slave().Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tio.Iflag&(unix.IXON|unix.IXOFF) != unix.IXON|unix.IXOFF {
+		t.Errorf("iflag = %#o, want IXON|IXOFF", tio.Iflag)
+	}
+	if err := setFlowControl(pty.Name(), flowNone); err != nil {
+		t.Fatalf("setFlowControl() error = %v", err)
+	}
+	tio, _ = unix.IoctlGetTermios(int(pty.Slave().Fd()), unix.TCGETS)
+	if tio.Iflag&(unix.IXON|unix.IXOFF) != 0 {
+		t.Errorf("iflag = %#o, want no IXON|IXOFF", tio.Iflag)
+	}
+}
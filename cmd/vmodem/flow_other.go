@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// setFlowControl sets the flow control of the serial device at path. Only
+// Linux supports flow control.
+func setFlowControl(path, flow string) error {
+	if flow != flowNone {
+		return fmt.Errorf("%s flow control not supported on this platform", flow)
+	}
+	return nil
+}
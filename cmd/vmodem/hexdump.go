@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// hexDumper prints a timestamped hex and ASCII dump of the TTY and line
+// traffic of the modems with --hexdump, to debug init strings and the command
+// parser. It is the io.Writer of a raw vm.Capture for the line traffic.
+type hexDumper struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// hexdump dumps the traffic of all the modems with --hexdump, nil without.
+var hexdump *hexDumper
+
+// setupHexDump dumps the traffic to the log file, or stderr, with --hexdump.
+// It must run after setupCapture, as the line traffic is dumped through the
+// capture of the calls.
+func setupHexDump() {
+	if !options.HexDump {
+		return
+	}
+	var w io.Writer = os.Stderr
+	if logFile != nil {
+		w = logFile
+	}
+	hexdump = &hexDumper{w: w}
+	line := vm.NewCapture(hexdump, vm.CaptureRaw)
+	if capture != nil {
+		capture = vm.TeeCapture(capture, line)
+	} else {
+		capture = line
+	}
+}
+
+// dump writes data of the modem id, 16 bytes per line like hexdump -C, each
+// line tagged with the time, the channel (tty or line), the direction as seen
+// by the modem (rx or tx) and the mode the data went through (cmd or data).
+func (d *hexDumper) dump(id, channel, dir, mode string, data []byte) {
+	now := time.Now().Format(time.StampMicro)
+	var b []byte
+	for off := 0; off < len(data); off += 16 {
+		chunk := data[off:min(off+16, len(data))]
+		b = fmt.Appendf(b, "%s %s %s %s %-4s %04x ", now, id, channel, dir, mode, off)
+		for i := range 16 {
+			if i == 8 {
+				b = append(b, ' ')
+			}
+			if i < len(chunk) {
+				b = fmt.Appendf(b, " %02x", chunk[i])
+			} else {
+				b = append(b, "   "...)
+			}
+		}
+		b = append(b, "  |"...)
+		for _, c := range chunk {
+			if c < ' ' || c > '~' {
+				c = '.'
+			}
+			b = append(b, c)
+		}
+		b = append(b, "|\n"...)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.w.Write(b)
+}
+
+// Write dumps the raw capture record b of the line traffic of a call.
+func (d *hexDumper) Write(b []byte) (int, error) {
+	rec, err := vm.ReadCaptureRecord(bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	dir := "tx"
+	if rec.Dir == vm.FilterToTTY {
+		dir = "rx"
+	}
+	d.dump(rec.Modem, "line", dir, "data", rec.Data)
+	return len(b), nil
+}
+
+// hexDumpTTY dumps the data of the TTY of a modem, in the mode of the modem
+// when it was read or written.
+type hexDumpTTY struct {
+	io.ReadWriteCloser
+	d  *hexDumper
+	id string
+	m  atomic.Pointer[vm.Modem]
+}
+
+// mode returns data while the modem is online in data mode, cmd otherwise.
+func (h *hexDumpTTY) mode() string {
+	if m := h.m.Load(); m != nil && m.StatusSync() == vm.StatusConnected {
+		return "data"
+	}
+	return "cmd"
+}
+
+func (h *hexDumpTTY) Read(b []byte) (int, error) {
+	n, err := h.ReadWriteCloser.Read(b)
+	if n > 0 {
+		h.d.dump(h.id, "tty", "rx", h.mode(), b[:n])
+	}
+	return n, err
+}
+
+func (h *hexDumpTTY) Write(b []byte) (int, error) {
+	mode := h.mode()
+	n, err := h.ReadWriteCloser.Write(b)
+	if n > 0 {
+		h.d.dump(h.id, "tty", "tx", mode, b[:n])
+	}
+	return n, err
+}
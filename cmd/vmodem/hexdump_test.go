@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// dumpBuffer is a buffer safe for concurrent use
+type dumpBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *dumpBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *dumpBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHexDumpFormat(t *testing.T) {
+	out := &dumpBuffer{}
+	d := &hexDumper{w: out}
+	d.dump("tty0", "tty", "rx", "cmd", []byte("ATZ\r0123456789abcdef"))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("dump = %q, want 2 lines", out.String())
+	}
+	want := []string{
+		" tty0 tty rx cmd  0000  41 54 5a 0d 30 31 32 33  34 35 36 37 38 39 61 62  |ATZ.0123456789ab|",
+		" tty0 tty rx cmd  0010  63 64 65 66                                       |cdef|",
+	}
+	for i, l := range lines {
+		if !strings.HasSuffix(l, want[i]) {
+			t.Errorf("line %d = %q, want suffix %q", i, l, want[i])
+		}
+	}
+}
+
+// Test the TTY traffic is dumped in the mode of the modem and the line
+// traffic through its capture
+func TestHexDumpModes(t *testing.T) {
+	out := &dumpBuffer{}
+	d := &hexDumper{w: out}
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	tty := &hexDumpTTY{ReadWriteCloser: dce, d: d, id: "tty0"}
+	m, err := vm.NewModem(&vm.ModemConfig{Id: "tty0", TTY: tty, Capture: vm.NewCapture(d, vm.CaptureRaw)})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer m.CloseSync()
+	tty.m.Store(m)
+	r := bufio.NewReader(dte)
+
+	dte.Write([]byte("ATE0\r"))
+	if s, err := r.ReadString('K'); err != nil || !strings.HasSuffix(s, "OK") {
+		t.Fatalf("ATE0 answer = %q, %v", s, err)
+	}
+	go io.Copy(io.Discard, r)
+	host, line := vm.NewLine()
+	defer host.Close()
+	if err := m.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := m.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	dte.Write([]byte("hi"))
+	if _, err := io.ReadFull(host, make([]byte, 2)); err != nil {
+		t.Fatalf("reading the call: %v", err)
+	}
+
+	dump := out.String()
+	for _, want := range []string{
+		" tty0 tty rx cmd  0000  41 54 45 30 0d",
+		" tty0 tty tx cmd  0000  0d 0a 4f 4b 0d 0a",
+		" tty0 tty rx data 0000  68 69 ",
+		" tty0 line tx data 0000  68 69 ",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump %q does not contain %q", dump, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// ptyAccess is the mode and ownership given to the TTY devices of the modems.
+type ptyAccess struct {
+	mode     os.FileMode // 0 to keep the mode of the PTY
+	uid, gid int         // -1 to keep the owner or group
+}
+
+var ttyAccess = ptyAccess{uid: -1, gid: -1}
+
+// parsePtyAccess parses the --tty-mode, --tty-owner and --tty-group options.
+// Owners and groups are names or numeric ids.
+func parsePtyAccess(mode, owner, group string) (ptyAccess, error) {
+	a := ptyAccess{uid: -1, gid: -1}
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0777 {
+			return a, fmt.Errorf("invalid tty-mode %q", mode)
+		}
+		a.mode = os.FileMode(m)
+	}
+	if owner != "" {
+		id, err := strconv.Atoi(owner)
+		if err != nil {
+			u, lerr := user.Lookup(owner)
+			if lerr != nil {
+				return a, fmt.Errorf("tty-owner: %v", lerr)
+			}
+			id, _ = strconv.Atoi(u.Uid)
+		}
+		a.uid = id
+	}
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, lerr := user.LookupGroup(group)
+			if lerr != nil {
+				return a, fmt.Errorf("tty-group: %v", lerr)
+			}
+			id, _ = strconv.Atoi(g.Gid)
+		}
+		a.gid = id
+	}
+	return a, nil
+}
+
+// apply sets the mode and ownership of the TTY device at path.
+func (a ptyAccess) apply(path string) error {
+	if a.mode != 0 {
+		if err := os.Chmod(path, a.mode); err != nil {
+			return err
+		}
+	}
+	if a.uid >= 0 || a.gid >= 0 {
+		return os.Chown(path, a.uid, a.gid)
+	}
+	return nil
+}
+
+// linkTTY creates the stable symlink path to the TTY tty, replacing a previous
+// symlink but never another kind of file, such as a real device.
+func linkTTY(tty Device, path string) error {
+	if err := removeLink(path); err != nil {
+		return err
+	}
+	return os.Symlink(tty.Name(), path)
+}
+
+// removeLink removes the symlink at path, if any. Other files are left alone
+// and reported as an error.
+func removeLink(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s exists and is not a symlink", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test the stable link replaces an old link but never another file
+func TestLinkTTY(t *testing.T) {
+	pty, err := NewPty()
+	if err != nil {
+		t.Fatalf("NewPty() error = %v", err)
+	}
+	defer pty.Close()
+
+	dir := t.TempDir()
+	link := filepath.Join(dir, "ttyVM0")
+	if err := os.Symlink("/dev/null", link); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkTTY(pty, link); err != nil {
+		t.Fatalf("linkTTY() error = %v", err)
+	}
+	if target, err := os.Readlink(link); err != nil || target != pty.Name() {
+		t.Errorf("link = %q, %v, want %q", target, err, pty.Name())
+	}
+	if err := removeLink(link); err != nil {
+		t.Errorf("removeLink() error = %v", err)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("link not removed: %v", err)
+	}
+
+	file := filepath.Join(dir, "ttyS0")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkTTY(pty, file); err == nil {
+		t.Error("linkTTY() replaced a regular file")
+	}
+	if err := removeLink(file); err == nil {
+		t.Error("removeLink() removed a regular file")
+	}
+}
+
+// Test the mode and ownership of the TTY devices
+func TestPtyAccess(t *testing.T) {
+	for _, bad := range [][3]string{{"rw", "", ""}, {"1777", "", ""}, {"", "no-such-user-vm", ""}, {"", "", "no-such-group-vm"}} {
+		if _, err := parsePtyAccess(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("parsePtyAccess(%q) accepted", bad)
+		}
+	}
+
+	a, err := parsePtyAccess("0620", "", "")
+	if err != nil || a.mode != 0620 || a.uid != -1 || a.gid != -1 {
+		t.Fatalf("parsePtyAccess() = %+v, %v", a, err)
+	}
+	pty, err := NewPty()
+	if err != nil {
+		t.Fatalf("NewPty() error = %v", err)
+	}
+	defer pty.Close()
+	a.uid, a.gid = os.Getuid(), os.Getgid()
+	if err := a.apply(pty.Name()); err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	fi, err := os.Stat(pty.Name())
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Mode().Perm() != 0620 {
+		t.Errorf("TTY mode = %v, want 0620", fi.Mode().Perm())
+	}
+}
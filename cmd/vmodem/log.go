@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// logger receives the diagnostics of the server and, through modemLog, of the
+// modems. It logs text to stderr until setupLogging applies the options.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// logFile is the log file, if logging to one.
+var logFile *rotatingFile
+
+// modemLog returns the logger of the modem id, which tags the records with it.
+func modemLog(id string) *slog.Logger {
+	return logger.With("modem", id)
+}
+
+// parseLogLevel parses --log-level. Without one the level is info, or debug
+// with --verbose.
+func parseLogLevel(s string) (slog.Level, error) {
+	if s == "" {
+		if len(options.Verbose) > 0 {
+			return slog.LevelDebug, nil
+		}
+		return slog.LevelInfo, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log-level %q", s)
+	}
+	return level, nil
+}
+
+// checkLogging validates the logging options.
+func checkLogging() error {
+	if _, err := parseLogLevel(options.LogLevel); err != nil {
+		return err
+	}
+	switch {
+	case options.LogFormat != "" && options.LogFormat != "text" && options.LogFormat != "json":
+		return fmt.Errorf("log-format must be text or json")
+	case options.LogMaxSize < 0 || options.LogMaxBackups < 0:
+		return fmt.Errorf("log-max-size and log-max-backups must not be negative")
+	}
+	return nil
+}
+
+// setupLogging creates the logger from the logging options.
+func setupLogging() error {
+	level, err := parseLogLevel(options.LogLevel)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stderr
+	if options.LogFile != "" {
+		f, err := openRotatingFile(options.LogFile, int64(options.LogMaxSize)<<20, options.LogMaxBackups)
+		if err != nil {
+			return err
+		}
+		logFile, w = f, f
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if options.LogFormat == "json" {
+		logger = slog.New(slog.NewJSONHandler(w, opts))
+	} else {
+		logger = slog.New(slog.NewTextHandler(w, opts))
+	}
+	return nil
+}
+
+// capture records the calls of all the modems with --capture or --hexdump,
+// nil without.
+var capture *vm.Capture
+
+// captureFile is the file of the capture, reopened on SIGHUP like the log.
+var captureFile *rotatingFile
+
+// setupCapture opens the capture file of --capture.
+func setupCapture() error {
+	if options.Capture == "" {
+		return nil
+	}
+	f, err := openRotatingFile(options.Capture, 0, 0)
+	if err != nil {
+		return err
+	}
+	format := vm.CaptureAnnotated
+	if options.CaptureFormat == "raw" {
+		format = vm.CaptureRaw
+	}
+	captureFile, capture = f, vm.NewCapture(f, format)
+	return nil
+}
+
+// rotatingFile is a log file that is rotated once it grows past maxSize bytes,
+// keeping maxBackups old files named path.1 (the newest), path.2, ...
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64 // 0 for no rotation
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(b)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, dropping the oldest, and starts a new file.
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	if r.maxBackups == 0 {
+		os.Remove(r.path)
+	} else {
+		for i := r.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		os.Rename(r.path, r.path+".1")
+	}
+	return r.open()
+}
+
+// Reopen opens the file at its path again, after an external tool such as
+// logrotate moved it.
+func (r *rotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.f.Close()
+	return r.open()
+}
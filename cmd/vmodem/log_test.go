@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	defer func() { options = Options{} }()
+	tests := []struct {
+		level   string
+		verbose int
+		want    slog.Level
+		ok      bool
+	}{
+		{"", 0, slog.LevelInfo, true},
+		{"", 1, slog.LevelDebug, true},
+		{"warn", 1, slog.LevelWarn, true},
+		{"ERROR", 0, slog.LevelError, true},
+		{"loud", 0, 0, false},
+	}
+	for _, tt := range tests {
+		options = Options{Verbose: make([]bool, tt.verbose)}
+		got, err := parseLogLevel(tt.level)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("parseLogLevel(%q, -v x%d) = %v, %v, want %v", tt.level, tt.verbose, got, err, tt.want)
+		}
+	}
+}
+
+func TestSetupLoggingJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vmodem.log")
+	saved := logger
+	defer func() {
+		logger, logFile, options = saved, nil, Options{}
+	}()
+	options = Options{LogLevel: "warn", LogFormat: "json", LogFile: path}
+	if err := setupLogging(); err != nil {
+		t.Fatalf("setupLogging() error = %v", err)
+	}
+	modemLog("tty0").Info("filtered")
+	modemLog("tty0").Warn("Watchdog connection timeout")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("log = %q, want one record", data)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("log record %q: %v", lines[0], err)
+	}
+	if rec["level"] != "WARN" || rec["modem"] != "tty0" || rec["msg"] != "Watchdog connection timeout" {
+		t.Errorf("log record = %v", rec)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vmodem.log")
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("openRotatingFile() error = %v", err)
+	}
+	defer r.f.Close()
+	for _, s := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(s)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		if data, _ := os.ReadFile(name); string(data) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("more backups than max-backups kept")
+	}
+
+	// Reopen follows a file moved away by logrotate
+	os.Rename(path, path+".old")
+	if err := r.Reopen(); err != nil {
+		t.Fatalf("Reopen() error = %v", err)
+	}
+	r.Write([]byte("fifth\n"))
+	if data, _ := os.ReadFile(path); string(data) != "fifth\n" {
+		t.Errorf("after Reopen() = %q", data)
+	}
+}
+
+// Test --capture records the calls of the modems to the capture file
+func TestCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.txt")
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true, Capture: path, CaptureFormat: "annotated"}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances, capture, captureFile = Options{}, nil, nil, nil, nil
+	}()
+	if err := setupCapture(); err != nil {
+		t.Fatalf("setupCapture() error = %v", err)
+	}
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	m := bank()[0]
+	host, line := vm.NewLine()
+	defer host.Close()
+	if err := m.IncomingCallInfoSync(line, vm.CallInfo{Number: "5551212"}); err != nil {
+		t.Fatalf("IncomingCallInfoSync() error = %v", err)
+	}
+	host.Write([]byte("hello\r"))
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b, _ := os.ReadFile(path)
+		if strings.Contains(string(b), `tty0 < "hello\r"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("capture = %q, want the data of the call", b)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.HangupSync()
+	b, _ := os.ReadFile(path)
+	if !strings.Contains(string(b), `tty0 # incoming call number="5551212"`) || !strings.Contains(string(b), "tty0 # call ended") {
+		t.Errorf("capture = %q, want the call noted", b)
+	}
+}
@@ -8,23 +8,31 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/jaracil/nagle"
 	vm "github.com/jaracil/vmodem"
 	"github.com/jessevdk/go-flags"
-	t "github.com/nayarsystems/iotrace"
 	"go.bug.st/serial"
 )
 
 type Options struct {
 	Verbose          []bool   `short:"v" long:"verbose" description:"Show verbose debug information"`
+	LogLevel         string   `long:"log-level" description:"Log level: debug, info, warn or error (default: info, debug with -v)"`
+	LogFormat        string   `long:"log-format" description:"Log format: text or json" default:"text"`
+	LogFile          string   `long:"log-file" description:"Log to this file instead of stderr"`
+	LogMaxSize       int      `long:"log-max-size" description:"Rotate the log file once it reaches this many megabytes (0 = never)" default:"0"`
+	LogMaxBackups    int      `long:"log-max-backups" description:"Rotated log files kept" default:"3"`
+	Capture          string   `long:"capture" description:"Record both directions of every call with timestamps to this file"`
+	CaptureFormat    string   `long:"capture-format" description:"Format of the capture file" choice:"annotated" choice:"raw" default:"annotated"`
+	HexDump          bool     `long:"hexdump" description:"Dump TTY and line traffic in hex and ASCII to the log, tagging command and data mode"`
 	ListenAddr       string   `short:"a" long:"addr" description:"Listen address" default:"0.0.0.0:2020"`
 	DefaultPort      string   `short:"p" long:"port" description:"Default port for outgoing calls" default:"2020"`
 	TtyPath          string   `short:"t" long:"tty" description:"path for TTYs creation" default:"/tmp/vmodem"`
@@ -32,19 +40,66 @@ type Options struct {
 	NumTTYs          int      `short:"n" long:"num" description:"Number of TTYs to create" default:"1"`
 	RingMax          int      `short:"r" long:"ring" description:"Max number of rings before hangup" default:"10"`
 	NoListen         bool     `short:"X" long:"nolisten" description:"Do not listen for incoming calls"`
+	Listen           []string `long:"listen" description:"Also listen for incoming calls on this address, e.g. :6400 or unix:/run/vmodem.sock (repeatable)"`
+	WSListen         []string `long:"ws-listen" description:"Accept incoming calls as WebSocket connections. Format: host:port[/path] (repeatable)"`
+	Pool             []string `long:"pool" description:"Hunt group answering the calls of its address, or of the numbers routed to pool:name, on its first idle modem. Format: name->modem,modem...[->address] (repeatable)"`
 	AnswerChar       string   `short:"S" long:"answer-char" description:"sends this character when the call is answered"`
-	NagleSize        int      `short:"N" long:"nagle-size" description:"size of the nagle buffer 0 = disabled" default:"1024"`
-	NagleTimeout     int      `short:"M" long:"nagle-timeout" description:"nagle timeout in milliseconds" default:"50"`
+	NagleSize        int      `short:"N" long:"nagle-size" description:"Coalesce call data into connection writes of up to this many bytes, 0 = no flush delay" default:"1024"`
+	NagleTimeout     int      `short:"M" long:"nagle-timeout" description:"Hold call data up to this many milliseconds to coalesce it" default:"50"`
 	GuardTime        int      `short:"G" long:"guard-time" description:"guard time in 50ms increments" default:"20"`
 	DisablePreGuard  bool     `short:"D" long:"disable-pre-guard" description:"disable pre-guard time for buggy implementations"`
 	DisablePostGuard bool     `short:"P" long:"disable-post-guard" description:"disable post-guard time for buggy implementations"`
 	Command          []string `short:"C" long:"command" description:"Command hook. Format: regexp->response->result"`
 	Line             []string `short:"L" long:"line" description:"Line hook. Format: regexp->response->result"`
-	Translate        []string `short:"T" long:"translate" description:"Translate phone number to host. Format: regexp->format"`
+	Translate        []string `short:"T" long:"translate" description:"Translate phone number to host. Format: regexp->format[->bind]"`
+	Route            []string `long:"route" description:"Route dial strings to a target, expanding $1... to the groups of the match. Format: regexp->target[->bind]"`
+	Bind             string   `short:"B" long:"bind" description:"Local address or interface for outgoing calls"`
 	Attach           []string `short:"A" long:"attach" description:"Attach two TTY's. Format: tty1:tty2:speed,data_bits,parity,stop_bits"`
 	Metrics          string   `short:"m" long:"metrics" description:"Enable metrics http server. Format: host:port"`
+	API              string   `long:"api" description:"Enable the HTTP control API. Format: host:port"`
+	Console          string   `long:"console" description:"Serve an operator console driving the modems on this address, e.g. unix:/run/vmodem-console.sock, or - for the terminal"`
+	Daemon           bool     `long:"daemon" description:"Run in the background, detached from the terminal"`
+	PidFile          string   `long:"pidfile" description:"Write the process id to this file"`
+	AnswerExec       string   `long:"answer-exec" description:"Run this program on the TTY of the modem for each answered call, e.g. /bin/login"`
+	Stdio            bool     `long:"stdio" description:"Use stdin and stdout as the TTY of a single modem, for inetd, sshd or ser2net"`
 	Watchdog         int      `short:"w" long:"watchdog" description:"Connection timeout in seconds (0 = disabled)" default:"0"`
+	BusyStr          string   `long:"busy-str" description:"Sends this string to incoming calls when all modems are busy"`
+	AnswerTimeout    int      `long:"answer-timeout" description:"Drop incoming calls not answered within this many seconds (0 = disabled)" default:"0"`
+	IdleTimeout      int      `long:"idle-timeout" description:"Drop incoming calls from the listeners whose caller sends nothing within this many seconds before the modem answers (0 = disabled)" default:"0"`
+	Phonebook        string   `long:"phonebook" description:"Phonebook file mapping numbers to hosts, reloaded when it changes"`
+	Proxy            string   `long:"proxy" description:"Route outgoing calls through a proxy. Format: socks5://[user:password@]host:port or http://[user:password@]host:port"`
+	ProxyRule        []string `long:"proxy-rule" description:"Route outgoing calls to destinations matching a regexp through a proxy, or directly. Format: regexp->proxy|direct"`
+	ConnectTimeout   int      `long:"connect-timeout" description:"Abandon TCP connects of outgoing calls not established within this many seconds with NO ANSWER (0 = no limit)" default:"30"`
+	DialTimeout      int      `long:"dial-timeout" description:"Abandon outgoing calls not connected within this many seconds (0 = S7 only)" default:"0"`
+	LineSpeed        int      `long:"line-speed" description:"Pace call data to this emulated line speed in bps, 300-56000 (0 = unlimited)" default:"0"`
+	Latency          int      `long:"latency" description:"Delay call data by this many milliseconds in each direction" default:"0"`
+	Jitter           int      `long:"jitter" description:"Vary the call data delay by up to this many milliseconds" default:"0"`
+	BitErrorRate     float64  `long:"bit-error-rate" description:"Probability of each bit of call data being flipped (0-1)" default:"0"`
+	GarbageRate      float64  `long:"garbage-rate" description:"Probability of a random byte being inserted after each byte of call data (0-1)" default:"0"`
+	CarrierLoss      int      `long:"carrier-loss" description:"Drop calls at random after this many seconds on average (0 = disabled)" default:"0"`
+	CharDelay        int      `long:"char-delay" description:"Delay between characters of command mode output in microseconds, for vintage terminals" default:"0"`
+	Locale           string   `long:"locale" description:"Language of verbose result codes" choice:"en" choice:"fr" default:"en"`
+	Shared           bool     `long:"shared" description:"Create a second TTY per modem (ttyN-op) sharing the line"`
+	SharedInput      string   `long:"shared-input" description:"Input arbitration between shared TTYs" choice:"merge" choice:"exclusive" choice:"operator" default:"merge"`
+	Monitor          bool     `long:"monitor" description:"Create a read-only TTY per modem (ttyN-mon) mirroring its traffic"`
+	CommandParity    string   `long:"command-parity" description:"Parity of command mode bytes" choice:"none" choice:"strip" choice:"even" choice:"odd" default:"none"`
+	Echo             string   `long:"echo" description:"Command echo of the modems, also restored by ATZ" choice:"on" choice:"off"`
+	Quiet            bool     `long:"quiet" description:"Suppress result codes, also after ATZ"`
+	NumericResults   bool     `long:"numeric-results" description:"Send numeric result codes instead of words, also after ATZ"`
+	SReg             []string `long:"s" description:"Initial value of an S-register, also restored by ATZ. Format: reg=value (repeatable)"`
+	ConnectStr       string   `long:"connect-str" description:"Result sent when a call connects, e.g. 'CONNECT 57600' (default: the CONNECT of the locale)"`
 	InitCmd          []string `short:"I" long:"init" description:"AT commands to initialize each modem (e.g., 'e0' for echo off, 'e0v1' for echo off and verbose)"`
+	Link             []string `long:"link" description:"Stable symlink to the TTY of each modem in order, e.g. /dev/ttyVM0"`
+	Device           []string `long:"device" description:"Real serial device used as the DTE of each modem in order instead of a TTY. Format: device:speed,data_bits,parity,stop_bits,flow"`
+	Com              []string `long:"com" description:"Windows: com0com port opened by each modem in order instead of a named pipe, e.g. COM10"`
+	RFC2217          []string `long:"rfc2217" description:"Serve the DTE side of each modem in order as an RFC 2217 (Telnet COM port control) server on host:port instead of a TTY"`
+	TTYSpeed         int      `long:"tty-speed" description:"Baud rate set on the TTYs of the modems, e.g. 9600 (0 = the system default)" default:"0"`
+	FollowTTYSpeed   bool     `long:"follow-tty-speed" description:"Pace the calls of each modem to the baud rate its DTE sets on the TTY, e.g. with stty"`
+	TTYMode          string   `long:"tty-mode" description:"Permissions of the TTY devices in octal, e.g. 0660"`
+	TTYOwner         string   `long:"tty-owner" description:"Owner of the TTY devices, name or uid"`
+	TTYGroup         string   `long:"tty-group" description:"Group of the TTY devices, name or gid"`
+	Config           string   `short:"c" long:"config" description:"YAML configuration file, overridden by command line options"`
+	CheckConfig      bool     `long:"check-config" description:"Validate the configuration and exit"`
 }
 
 type Command struct {
@@ -86,6 +141,29 @@ type MetricsResponse struct {
 	LastAtCmdMs int64 `json:"lastAtCmdMs"`
 	// LastConnMs is the time in milliseconds since the last connection (online)
 	LastConnMs int64 `json:"lastConnMs"`
+	// CallDurationMs is the duration in milliseconds of the current (or last) call
+	CallDurationMs int64 `json:"callDurationMs"`
+	// CallStallMs is the time in milliseconds without data flow during the current (or last) call
+	CallStallMs int64 `json:"callStallMs"`
+	// CallPacingMs is the time in milliseconds data of the current (or last) call waited for the line speed
+	CallPacingMs int64 `json:"callPacingMs"`
+	// CallRetransmitBytes is the number of bytes of the current (or last) call damaged by line noise
+	// or dropped, which an error-correcting link would send again
+	CallRetransmitBytes int `json:"callRetransmitBytes"`
+	// CallTxBps is the effective throughput towards the connection in bytes per second
+	CallTxBps float64 `json:"callTxBps"`
+	// CallRxBps is the effective throughput from the connection in bytes per second
+	CallRxBps float64 `json:"callRxBps"`
+	// TxRateBps is the rate towards the connection over the last five seconds in bytes per second
+	TxRateBps float64 `json:"txRateBps"`
+	// RxRateBps is the rate from the connection over the last five seconds in bytes per second
+	RxRateBps float64 `json:"rxRateBps"`
+	// LineQueueLen is the number of TTY input chunks waiting for the connection
+	LineQueueLen int `json:"lineQueueLen"`
+	// TtyBufferLen is the number of bytes waiting in the TTY buffer
+	TtyBufferLen int `json:"ttyBufferLen"`
+	// RetCodes is the number of result codes emitted, per code
+	RetCodes map[vm.RetCode]int `json:"retCodes"`
 }
 
 func NewCommand(reStr, format string, result vm.RetCode) (*Command, error) {
@@ -117,6 +195,7 @@ func NewLine(reStr, format string, result vm.RetCode) (*Line, error) {
 type NumToHost struct {
 	Format string
 	ReStr  string
+	Bind   string
 	re     *regexp.Regexp
 }
 
@@ -145,74 +224,231 @@ func (n *NumToHost) Match(num string) string {
 }
 
 var (
-	ctx        context.Context
-	cancel     context.CancelFunc
-	options    Options
-	modems     []*vm.Modem
-	attached1  []serial.Port
-	attached2  []serial.Port
-	listener   net.Listener
-	numToHosts []*NumToHost
-	commands   []*Command
-	lines      []*Line
-	tini       = time.Now()
+	ctx          context.Context
+	cancel       context.CancelFunc
+	options      Options
+	attached1    []serial.Port
+	attached2    []serial.Port
+	numToHosts   []*NumToHost
+	dialRoutes   []*Route
+	phonebook    *Phonebook
+	defaultProxy *Proxy
+	proxyRules   []*ProxyRule
+	commands     []*Command
+	lines        []*Line
+	dialer       = NewDialer()
+	tini         = time.Now()
+	exitStatus   atomic.Int32
 )
 
-func findHost(num string) string {
+// shutdown stops vmodem after a fatal error, logged with msg and args. The
+// process then exits with a failure status, so service managers restart it.
+func shutdown(msg string, args ...any) {
+	logger.Error(msg, args...)
+	exitStatus.Store(1)
+	cancel()
+}
+
+func findHost(num string) (string, string) {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	if phonebook != nil {
+		if host, bind := phonebook.Lookup(num); host != "" {
+			if bind != "" {
+				return host, bind
+			}
+			return host, options.Bind
+		}
+	}
+	for _, r := range dialRoutes {
+		if target := r.Match(num); target != "" {
+			if r.Bind != "" {
+				return target, r.Bind
+			}
+			return target, options.Bind
+		}
+	}
 	for _, n := range numToHosts {
 		host := n.Match(num)
 		if host != "" {
+			if n.Bind != "" {
+				return host, n.Bind
+			}
+			return host, options.Bind
+		}
+	}
+	return "", ""
+}
+
+// dialAddr returns the address dialed for host, adding the default port when
+// host has none.
+func dialAddr(host string) string {
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if port != "" {
 			return host
 		}
+		host = h
+	}
+	return net.JoinHostPort(host, options.DefaultPort)
+}
+
+// redactTarget returns target with the password of its user, if any, masked
+// for logging.
+func redactTarget(target string) string {
+	if u, err := url.Parse(target); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return target
+}
+
+// resolveBind converts a local address or interface name into the address
+// outgoing connections are bound to. An empty bind leaves the choice to the OS.
+func resolveBind(bind string) (net.Addr, error) {
+	if bind == "" {
+		return nil, nil
+	}
+	if iface, err := net.InterfaceByName(bind); err == nil {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		if addr := interfaceBindAddr(iface.Name, addrs); addr != nil {
+			return addr, nil
+		}
+		return nil, fmt.Errorf("interface %s has no addresses", bind)
+	}
+	if _, _, err := net.SplitHostPort(bind); err != nil {
+		bind = net.JoinHostPort(bind, "0")
 	}
-	return ""
+	return net.ResolveTCPAddr("tcp", bind)
 }
 
-func outGoingCall(m *vm.Modem, number string) (io.ReadWriteCloser, error) {
-	host := findHost(number)
-	if host != "" {
-		if !strings.Contains(host, ":") {
-			host = fmt.Sprintf("%s:%s", host, options.DefaultPort)
+// interfaceBindAddr picks the address of the interface name, among its
+// addresses addrs, outgoing connections are bound to: IPv4 first, then global
+// IPv6, and link-local addresses last, IPv6 ones scoped to the interface.
+func interfaceBindAddr(name string, addrs []net.Addr) *net.TCPAddr {
+	var best *net.TCPAddr
+	bestRank := 0
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
 		}
-		if len(options.Verbose) > 0 {
-			fmt.Printf("%s: Dialing %s -> %s\n", m.Id(), number, host)
+		addr := &net.TCPAddr{IP: ipNet.IP}
+		rank := 1
+		if ipNet.IP.To4() == nil {
+			rank = 2
 		}
-		conn, err := net.Dial("tcp", host)
+		if ipNet.IP.IsLinkLocalUnicast() {
+			rank += 2
+			if ipNet.IP.To4() == nil {
+				addr.Zone = name
+			}
+		}
+		if best == nil || rank < bestRank {
+			best, bestRank = addr, rank
+		}
+	}
+	return best
+}
+
+func outGoingCall(ctx context.Context, m *vm.Modem, number string) (io.ReadWriteCloser, error) {
+	log := modemLog(m.Id())
+	target, bind := findHost(number)
+	if target != "" {
+		host, tlsConfig, err := parseTarget(target)
 		if err != nil {
+			log.Error("Invalid dial target", "target", redactTarget(target), "err", err)
 			return nil, err
 		}
-		var connWrapp io.ReadWriteCloser
-		if options.NagleSize > 0 {
-			connWrapp = nagle.NewNagleWrapper(conn, options.NagleSize, time.Millisecond*time.Duration(options.NagleTimeout))
+		log.Debug("Dialing", "number", number, "target", redactTarget(target))
+		localAddr, err := resolveBind(bind)
+		if err != nil {
+			log.Error("Invalid bind address", "bind", bind, "err", err)
+			return nil, err
+		}
+		if options.ConnectTimeout > 0 {
+			var stop context.CancelFunc
+			ctx, stop = context.WithTimeout(ctx, time.Duration(options.ConnectTimeout)*time.Second)
+			defer stop()
+		}
+		if strings.HasPrefix(target, poolScheme) {
+			conn, err := dialPool(m, host)
+			if err != nil {
+				log.Debug("Pool call failed", "pool", host, "err", err)
+				return nil, err
+			}
+			return conn, nil
+		}
+		if strings.HasPrefix(target, execScheme) {
+			conn, err := dialExec(target, "VMODEM_MODEM="+m.Id(), "VMODEM_NUMBER="+number)
+			if err != nil {
+				log.Debug("Running program failed", "program", host, "err", err)
+				return nil, err
+			}
+			return conn, nil
+		}
+		if strings.HasPrefix(target, sshScheme) {
+			conn, err := dialSSH(ctx, target, localAddr)
+			if err != nil {
+				log.Debug("SSH session failed", "host", host, "err", err)
+				return nil, callError(err)
+			}
+			return conn, nil
+		}
+		var conn net.Conn
+		if _, ok := unixPath(target); ok {
+			conn, err = dialUnix(ctx, host)
 		} else {
-			connWrapp = conn
+			conn, err = dialCall(ctx, host, localAddr)
 		}
-		return connWrapp, nil
-	}
-	if len(options.Verbose) > 0 {
-		fmt.Printf("%s: Dialing %s -> no host found\n", m.Id(), number)
+		if err != nil {
+			log.Debug("Dialing failed", "host", host, "err", err)
+			return nil, callError(err)
+		}
+		if tlsConfig != nil {
+			if conn, err = startTLS(ctx, conn, tlsConfig); err != nil {
+				log.Debug("TLS handshake failed", "host", host, "err", err)
+				return nil, callError(err)
+			}
+		}
+		if strings.HasPrefix(target, telnetScheme) {
+			conn = newTelnetConn(conn)
+		}
+		if isWSTarget(target) {
+			if conn, err = wsHandshake(ctx, conn, target); err != nil {
+				log.Debug("WebSocket handshake failed", "target", target, "err", err)
+				return nil, callError(err)
+			}
+		}
+		return conn, nil
 	}
+	log.Debug("Dialing failed, no host found", "number", number)
 	return nil, vm.ErrNoCarrier
 }
 
-func commandHook(m *vm.Modem, cmdChar string, cmdNum string, cmdAssign bool, cmdQuery bool, cmdAssignVal string) vm.RetCode {
+func commandHook(m *vm.Modem, command vm.Command) vm.RetCode {
 	if len(options.Verbose) > 1 {
-		fmt.Printf("%s: Command with params: cmd:%s num:%s assign:%v query:%v val:%s\n", m.Id(), cmdChar, cmdNum, cmdAssign, cmdQuery, cmdAssignVal)
+		modemLog(m.Id()).Debug("Command hook", "cmd", command.Name, "num", command.Number, "assign", command.Assign,
+			"query", command.Query, "test", command.Test, "val", command.Value)
 	}
-	cmd := fmt.Sprintf("%s%s", cmdChar, cmdNum)
-	if cmdAssign {
+	cmd := fmt.Sprintf("%s%s", command.Name, command.Number)
+	if command.Assign || command.Test {
 		cmd += "="
 	}
-	if cmdQuery {
+	if command.Query || command.Test {
 		cmd += "?"
 	}
-	if cmdAssignVal != "" {
-		cmd += cmdAssignVal
+	if command.Value != "" {
+		cmd += command.Value
 	}
-	for _, c := range commands {
+	reloadMu.RLock()
+	cmds := commands
+	reloadMu.RUnlock()
+	for _, c := range cmds {
 		if c.re.MatchString(cmd) {
 			if c.Output != "" {
-				m.TtyWriteStr(fmt.Sprintf("\r\n%s\r\n", c.Output))
+				m.WriteInfoText(c.Output)
 			}
 			return c.Result
 		}
@@ -222,12 +458,15 @@ func commandHook(m *vm.Modem, cmdChar string, cmdNum string, cmdAssign bool, cmd
 
 func lineHook(m *vm.Modem, line string) vm.RetCode {
 	if len(options.Verbose) > 1 {
-		fmt.Printf("%s: Line hook: %s\n", m.Id(), line)
+		modemLog(m.Id()).Debug("Line hook", "line", line)
 	}
-	for _, l := range lines {
+	reloadMu.RLock()
+	hooks := lines
+	reloadMu.RUnlock()
+	for _, l := range hooks {
 		if l.re.MatchString(line) {
 			if l.Output != "" {
-				m.TtyWriteStr(fmt.Sprintf("\r\n%s\r\n", l.Output))
+				m.WriteInfoText(l.Output)
 			}
 			return l.Result
 		}
@@ -235,21 +474,28 @@ func lineHook(m *vm.Modem, line string) vm.RetCode {
 	return vm.RetCodeSkip
 }
 
-func statusTransition(m *vm.Modem, oldStatus vm.ModemStatus, newStatus vm.ModemStatus) {
-	if len(options.Verbose) > 0 {
-		fmt.Printf("%s: Status transition %v -> %v\n", m.Id(), oldStatus, newStatus)
-	}
+func busyCall(m *vm.Modem, conn io.ReadWriteCloser) {
+	modemLog(m.Id()).Debug("Incoming call rejected, modem busy")
 }
 
-func cleanTTYs() {
-	for i := 0; i < options.NumTTYs; i++ {
-		os.Remove(fmt.Sprintf("%s/tty%d", options.TtyPath, options.StartNum+i))
-	}
+func missedCall(m *vm.Modem, rings int) {
+	modemLog(m.Id()).Debug("Missed incoming call", "rings", rings)
 }
 
-func cleanModems() {
-	for i := 0; i < options.NumTTYs; i++ {
-		modems[i].CloseSync()
+func statusTransition(m *vm.Modem, oldStatus vm.ModemStatus, newStatus vm.ModemStatus) {
+	modemLog(m.Id()).Debug("Status transition", "from", oldStatus, "to", newStatus)
+	observeCallEnd(m, oldStatus, newStatus)
+	answerTransition(m, oldStatus, newStatus)
+}
+
+func cleanTTYs() {
+	for _, spec := range specs {
+		os.Remove(ttyPath(spec, ""))
+		os.Remove(ttyPath(spec, "-op"))
+		os.Remove(ttyPath(spec, "-mon"))
+		if spec.Link != "" {
+			removeLink(spec.Link)
+		}
 	}
 }
 
@@ -262,73 +508,43 @@ func cleanAttached() {
 	}
 }
 
-func listenTask() {
-	// TCP server
-	var err error
-	listener, err = net.Listen("tcp", options.ListenAddr)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating listener: %v\n", err)
-		cancel()
-		return
-	}
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			cancel()
-			break
-		}
-		var connWrapp io.ReadWriteCloser
-		if options.NagleSize > 0 {
-			connWrapp = nagle.NewNagleWrapper(conn, options.NagleSize, time.Millisecond*time.Duration(options.NagleTimeout))
-		} else {
-			connWrapp = conn
-		}
-		assigned := false
-		// Find a free modem
-		for i := 0; i < options.NumTTYs; i++ {
-			if err := modems[i].IncomingCallSync(connWrapp); err == nil {
-				assigned = true
-				break
-			}
-		}
-		if !assigned {
-			connWrapp.Close()
-			fmt.Fprintf(os.Stderr, "No free modems for incomming call\n")
-		}
-	}
-}
-
 func linkPorts(port1, port2 serial.Port) {
 	go func() {
 		io.Copy(port1, port2)
 		if ctx.Err() == nil {
-			fmt.Fprintf(os.Stderr, "Broken tty attach\n")
-			cancel()
+			shutdown("Broken tty attach")
 		}
 
 	}()
 	go func() {
 		io.Copy(port2, port1)
 		if ctx.Err() == nil {
-			fmt.Fprintf(os.Stderr, "Broken tty attach\n")
-			cancel()
+			shutdown("Broken tty attach")
 		}
 	}()
 }
 
-func attachTTY(cfgStr string) error {
-
+// parseAttach parses an attach string into the two serial ports and their mode.
+func parseAttach(cfgStr string) (string, string, *serial.Mode, error) {
 	params := strings.Split(cfgStr, ":")
 	if len(params) < 2 {
-		return fmt.Errorf("invalid attach string")
+		return "", "", nil, fmt.Errorf("invalid attach string")
 	}
 
-	serialPort1 := params[0]
-	serialPort2 := params[1]
 	serialParams := []string{}
 	if len(params) > 2 {
 		serialParams = strings.Split(params[2], ",")
 	}
+	mode, err := parseSerialMode(serialParams)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return params[0], params[1], mode, nil
+}
+
+// parseSerialMode parses the speed, data bits, parity and stop bits of a serial
+// line, in this order. Missing parameters default to 9600,8,N,1.
+func parseSerialMode(serialParams []string) (*serial.Mode, error) {
 	serialSpeed := 9600
 	serialDataBits := 8
 	serialParity := serial.NoParity
@@ -338,13 +554,13 @@ func attachTTY(cfgStr string) error {
 	if len(serialParams) >= 1 {
 		serialSpeed, err = strconv.Atoi(serialParams[0])
 		if err != nil {
-			return fmt.Errorf("invalid speed")
+			return nil, fmt.Errorf("invalid speed")
 		}
 	}
 	if len(serialParams) >= 2 {
 		serialDataBits, err = strconv.Atoi(serialParams[1])
 		if err != nil {
-			return fmt.Errorf("invalid data bits")
+			return nil, fmt.Errorf("invalid data bits")
 		}
 	}
 	if len(serialParams) >= 3 {
@@ -356,7 +572,7 @@ func attachTTY(cfgStr string) error {
 		case "O":
 			serialParity = serial.OddParity
 		default:
-			return fmt.Errorf("invalid parity")
+			return nil, fmt.Errorf("invalid parity")
 		}
 	}
 	if len(serialParams) >= 4 {
@@ -366,25 +582,28 @@ func attachTTY(cfgStr string) error {
 		case "2":
 			serialStopBits = serial.TwoStopBits
 		default:
-			return fmt.Errorf("invalid stop bits")
+			return nil, fmt.Errorf("invalid stop bits")
 		}
 	}
 
-	port1, err := serial.Open(serialPort1, &serial.Mode{
+	return &serial.Mode{
 		BaudRate: serialSpeed,
 		DataBits: serialDataBits,
 		Parity:   serialParity,
 		StopBits: serialStopBits,
-	})
+	}, nil
+}
+
+func attachTTY(cfgStr string) error {
+	serialPort1, serialPort2, mode, err := parseAttach(cfgStr)
+	if err != nil {
+		return err
+	}
+	port1, err := serial.Open(serialPort1, mode)
 	if err != nil {
 		return fmt.Errorf("error opening external serial port: %v", err)
 	}
-	port2, err := serial.Open(serialPort2, &serial.Mode{
-		BaudRate: serialSpeed,
-		DataBits: serialDataBits,
-		Parity:   serialParity,
-		StopBits: serialStopBits,
-	})
+	port2, err := serial.Open(serialPort2, mode)
 	if err != nil {
 		return fmt.Errorf("error opening local serial port: %v", err)
 	}
@@ -394,100 +613,117 @@ func attachTTY(cfgStr string) error {
 	return nil
 }
 
-func phoneTranslations() {
-	defaultNumToHost, err := NewNumToHost("\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,5})?", "%[1]s.%[2]s.%[3]s.%[4]s:%[5]s")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating default NumToHost: %v\n", err)
-		os.Exit(1)
-	}
-	numToHosts = append(numToHosts, defaultNumToHost)
-	defaultNumToHost, err = NewNumToHost("\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})", "%[1]s.%[2]s.%[3]s.%[4]s")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating default NumToHost: %v\n", err)
-		os.Exit(1)
-	}
-	numToHosts = append(numToHosts, defaultNumToHost)
-	defaultNumToHost, err = NewNumToHost("(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3}):(\\d{1,5})?", "%[1]s.%[2]s.%[3]s.%[4]s:%[5]s")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating default NumToHost: %v\n", err)
-		os.Exit(1)
+func phoneTranslations() error {
+	defaults := []struct{ re, format string }{
+		{"\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,5})?", "%[1]s.%[2]s.%[3]s.%[4]s:%[5]s"},
+		{"\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})", "%[1]s.%[2]s.%[3]s.%[4]s"},
+		{"(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3}):(\\d{1,5})?", "%[1]s.%[2]s.%[3]s.%[4]s:%[5]s"},
+		{"(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})", "%[1]s.%[2]s.%[3]s.%[4]s"},
 	}
-	numToHosts = append(numToHosts, defaultNumToHost)
-	defaultNumToHost, err = NewNumToHost("(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})", "%[1]s.%[2]s.%[3]s.%[4]s")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating default NumToHost: %v\n", err)
-		os.Exit(1)
+	numToHosts = nil
+	for _, d := range defaults {
+		defaultNumToHost, err := NewNumToHost(d.re, d.format)
+		if err != nil {
+			return fmt.Errorf("error creating default NumToHost: %v", err)
+		}
+		numToHosts = append(numToHosts, defaultNumToHost)
 	}
-	numToHosts = append(numToHosts, defaultNumToHost)
 	for _, t := range options.Translate {
 		parts := strings.Split(t, "->")
-		if len(parts) != 2 {
-			fmt.Fprintf(os.Stderr, "Invalid translation: %s\n", t)
-			os.Exit(1)
+		if len(parts) != 2 && len(parts) != 3 {
+			return fmt.Errorf("invalid translation: %s", t)
 		}
 		numToHost, err := NewNumToHost(parts[0], parts[1])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating NumToHost: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("error creating NumToHost: %v", err)
+		}
+		if len(parts) == 3 {
+			numToHost.Bind = parts[2]
 		}
 		numToHosts = append(numToHosts, numToHost)
 	}
+	// Unix domain sockets are dialed as they are, e.g. ATDTunix:/run/dosbox.sock
+	unixNumToHost, err := NewNumToHost(`^(unix:\S+)$`, "%[1]s")
+	if err != nil {
+		return fmt.Errorf("error creating Unix socket NumToHost: %v", err)
+	}
+	numToHosts = append(numToHosts, unixNumToHost)
+	// Pools of the bank are dialed as they are, e.g. ATDTpool:lines
+	poolNumToHost, err := NewNumToHost(`^(pool:[\w.-]+)$`, "%[1]s")
+	if err != nil {
+		return fmt.Errorf("error creating pool NumToHost: %v", err)
+	}
+	numToHosts = append(numToHosts, poolNumToHost)
+	// Any other target with a letter is dialed as a host name, e.g. ATDTbbs.example.com:23,
+	// ATDTtls://bbs.example.com:992, ATDTtelnet://bbs.example.com or ATDTssh://bob@shell.example.com
+	hostNumToHost, err := NewNumToHost(`^((?:(?:tls|tcp|telnet)://|ssh://(?:[\w.-]+@)?)?[0-9A-Za-z.-]*[A-Za-z][0-9A-Za-z.-]*)(?::(\d{1,5}))?$`, "%[1]s:%[2]s")
+	if err != nil {
+		return fmt.Errorf("error creating host name NumToHost: %v", err)
+	}
+	numToHosts = append(numToHosts, hostNumToHost)
+	return nil
 }
 
-func customCommands() {
+func customCommands() error {
+	commands = nil
 	for _, c := range options.Command {
 		parts := strings.Split(c, "->")
 		if len(parts) != 3 {
-			fmt.Fprintf(os.Stderr, "Invalid command: %s\n", c)
-			os.Exit(1)
+			return fmt.Errorf("invalid command: %s", c)
 		}
 		cmdRet := vm.CmdReturnFromString(parts[2])
 		if cmdRet == vm.RetCodeUnknown {
-			fmt.Fprintf(os.Stderr, "Invalid command return: %s\n", parts[2])
-			os.Exit(1)
+			return fmt.Errorf("invalid command return: %s", parts[2])
 		}
 		cmd, err := NewCommand(parts[0], parts[1], cmdRet)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating command: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("error creating command: %v", err)
 		}
 		commands = append(commands, cmd)
 	}
+	return nil
 }
 
-func customLines() {
+func customLines() error {
+	lines = nil
 	for _, l := range options.Line {
 		parts := strings.Split(l, "->")
 		if len(parts) != 3 {
-			fmt.Fprintf(os.Stderr, "Invalid line: %s\n", l)
-			os.Exit(1)
+			return fmt.Errorf("invalid line: %s", l)
 		}
 		lineRet := vm.CmdReturnFromString(parts[2])
 		if lineRet == vm.RetCodeUnknown {
-			fmt.Fprintf(os.Stderr, "Invalid line return: %s\n", parts[2])
-			os.Exit(1)
+			return fmt.Errorf("invalid line return: %s", parts[2])
 		}
 		line, err := NewLine(parts[0], parts[1], lineRet)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating line: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("error creating line: %v", err)
 		}
 		lines = append(lines, line)
 	}
+	return nil
+}
+
+// addMonitor makes mon a read-only TTY that mirrors everything the modem sends
+// to and receives from its DTE. Input typed on the monitor is discarded.
+func addMonitor(m *vm.Modem, mon Device) {
+	m.AddObserverSync(mon, true)
+	go io.Copy(io.Discard, mon)
 }
 
 type bytesHookFunc func([]byte)
 
-func newModemTraceHook(prefix string) bytesHookFunc {
+func newModemTraceHook(id, dir string) bytesHookFunc {
+	log := modemLog(id)
 	return func(data []byte) {
-		fmt.Printf("(%d) %s:\n%s\n", time.Since(tini).Milliseconds(), prefix, hex.Dump(data))
+		log.Debug("TTY data", "dir", dir, "uptimeMs", time.Since(tini).Milliseconds(), "hex", hex.EncodeToString(data))
 	}
 }
 
 func enableWatchdog(timeout int) {
 	go func() {
 		for ctx.Err() == nil {
-			for _, m := range modems {
+			for _, m := range bank() {
 				metrics := m.MetricsSync()
 				if metrics.Status != vm.StatusConnected {
 					continue
@@ -503,7 +739,7 @@ func enableWatchdog(timeout int) {
 				}
 				if rxElapsed > timeout || txElapsed > timeout {
 					m.SetStatusSync(vm.StatusIdle)
-					fmt.Fprintf(os.Stderr, "%s: Watchdog connection timeout\n", m.Id())
+					modemLog(m.Id()).Warn("Watchdog connection timeout")
 				}
 			}
 			time.Sleep(time.Second)
@@ -512,10 +748,15 @@ func enableWatchdog(timeout int) {
 }
 
 func enableMetrics(addr string) {
+	http.HandleFunc("/metrics", prometheusHandler)
+
 	http.HandleFunc("/proc", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{"uptime": time.Since(tini).String()})
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"uptime": time.Since(tini).String(),
+			"dialer": dialer.Stats(),
+		})
 	})
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -526,8 +767,9 @@ func enableMetrics(addr string) {
 			}
 			return val2
 		}
-		for _, m := range modems {
+		for _, m := range bank() {
 			metrics := m.MetricsSync()
+			txBps, rxBps := metrics.CallThroughput()
 			response := MetricsResponse{
 				ModemId:     m.Id(),
 				TtyTxBytes:  metrics.TtyTxBytes,
@@ -541,6 +783,18 @@ func enableMetrics(addr string) {
 				LastTtyTxMs: ternary(metrics.LastTtyTxTime.IsZero(), -1, int64(time.Since(metrics.LastTtyTxTime)/time.Millisecond)),
 				LastAtCmdMs: ternary(metrics.LastAtCmdTime.IsZero(), -1, int64(time.Since(metrics.LastAtCmdTime)/time.Millisecond)),
 				LastConnMs:  ternary(metrics.LastConnTime.IsZero(), -1, int64(time.Since(metrics.LastConnTime)/time.Millisecond)),
+
+				CallDurationMs:      int64(metrics.CallDuration() / time.Millisecond),
+				CallStallMs:         int64(metrics.CallStallTime / time.Millisecond),
+				CallPacingMs:        int64(metrics.CallPacingTime / time.Millisecond),
+				CallRetransmitBytes: metrics.CallRetransmitBytes(),
+				CallTxBps:           txBps,
+				CallRxBps:           rxBps,
+				TxRateBps:           metrics.TxRate,
+				RxRateBps:           metrics.RxRate,
+				LineQueueLen:        metrics.LineQueueLen,
+				TtyBufferLen:        metrics.TtyBufferLen,
+				RetCodes:            metrics.RetCodes,
 			}
 			metricsList = append(metricsList, response)
 		}
@@ -552,14 +806,140 @@ func enableMetrics(addr string) {
 	go func() {
 		err := http.ListenAndServe(addr, nil)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error starting metrics server: %v\n", err)
-			cancel()
+			shutdown("Error starting metrics server", "err", err)
 		}
 	}()
 
 }
 
+func arbitrationFromString(s string) vm.InputArbitration {
+	switch s {
+	case "exclusive":
+		return vm.ArbitrationExclusive
+	case "operator":
+		return vm.ArbitrationOperator
+	default:
+		return vm.ArbitrationMerge
+	}
+}
+
+// flushDelay returns how long call data is held to coalesce connection writes,
+// none when the nagle buffer is disabled.
+func flushDelay() time.Duration {
+	if options.NagleSize <= 0 {
+		return 0
+	}
+	return time.Duration(options.NagleTimeout) * time.Millisecond
+}
+
+func parityFromString(s string) vm.Parity {
+	switch s {
+	case "strip":
+		return vm.ParityStrip
+	case "even":
+		return vm.ParityEven
+	case "odd":
+		return vm.ParityOdd
+	default:
+		return vm.ParityNone
+	}
+}
+
+// parseSReg parses an S-register assignment of --s in the format reg=value.
+func parseSReg(s string) (reg, value byte, err error) {
+	r, v, ok := strings.Cut(s, "=")
+	n, err1 := strconv.ParseUint(strings.TrimSpace(r), 10, 8)
+	val, err2 := strconv.ParseUint(strings.TrimSpace(v), 10, 8)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid S-register %q, want reg=value from 0 to 255", s)
+	}
+	return byte(n), byte(val), nil
+}
+
+// initialSRegs returns the S-registers set with --s, checked by configure.
+func initialSRegs() map[byte]byte {
+	var sregs map[byte]byte
+	for _, s := range options.SReg {
+		if reg, value, err := parseSReg(s); err == nil {
+			if sregs == nil {
+				sregs = make(map[byte]byte)
+			}
+			sregs[reg] = value
+		}
+	}
+	return sregs
+}
+
+// setPersonality applies the echo and result code options to the new modem m,
+// before its init commands.
+func setPersonality(m *vm.Modem) {
+	m.Lock()
+	defer m.Unlock()
+	if options.Echo != "" {
+		m.SetEcho(options.Echo == "on")
+	}
+	if options.NumericResults {
+		m.SetVerbose(false)
+	}
+	if options.Quiet {
+		m.SetQuiet(true)
+	}
+}
+
+// modemConfig returns the configuration of the modem id over tty, and sharedTty
+// if the line is shared.
+func modemConfig(id string, tty, sharedTty io.ReadWriteCloser) *vm.ModemConfig {
+	sregs := initialSRegs()
+	guardTime := options.GuardTime
+	if _, ok := sregs[12]; ok {
+		// --s 12=... sets the guard time instead
+		guardTime = 0
+	}
+	return &vm.ModemConfig{
+		Id:                  id,
+		Dead:                modemDead,
+		Logger:              logger,
+		OutgoingCallContext: outGoingCall,
+		CommandHook:         commandHook,
+		LineHook:            lineHook,
+		StatusTransition:    statusTransition,
+		MissedCall:          missedCall,
+		BusyStr:             options.BusyStr,
+		BusyCall:            busyCall,
+		TTY:                 tty,
+		SharedTTY:           sharedTty,
+		SharedInput:         arbitrationFromString(options.SharedInput),
+		RingMax:             options.RingMax,
+		RingTimeout:         time.Duration(options.AnswerTimeout) * time.Second,
+		DialTimeout:         time.Duration(options.DialTimeout) * time.Second,
+		LineSpeed:           options.LineSpeed,
+		AnswerChar:          options.AnswerChar,
+		GuardTime:           guardTime,
+		SRegs:               sregs,
+		ConnectStr:          options.ConnectStr,
+		DisablePreGuard:     options.DisablePreGuard,
+		DisablePostGuard:    options.DisablePostGuard,
+		CommandParity:       parityFromString(options.CommandParity),
+		Locale:              vm.Locales[options.Locale],
+		PublishExpvar:       options.Metrics != "",
+		CharDelay:           time.Duration(options.CharDelay) * time.Microsecond,
+		WriteChunkSize:      options.NagleSize,
+		WriteFlushDelay:     flushDelay(),
+		Capture:             capture,
+		Impairment: vm.LineImpairment{
+			Latency:      time.Duration(options.Latency) * time.Millisecond,
+			Jitter:       time.Duration(options.Jitter) * time.Millisecond,
+			BitErrorRate: options.BitErrorRate,
+			GarbageRate:  options.GarbageRate,
+			CarrierLoss:  time.Duration(options.CarrierLoss) * time.Second,
+		},
+	}
+}
+
 func main() {
+	if sshAskpass() {
+		return
+	}
 	ctx, cancel = context.WithCancel(context.Background())
 
 	gfParser := flags.NewParser(&options, flags.Default)
@@ -567,10 +947,39 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
 		os.Exit(1)
 	}
+	if options.Config != "" {
+		if err := loadConfig(gfParser, options.Config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := configure(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if options.CheckConfig {
+		fmt.Println("Configuration OK")
+		return
+	}
 
+	if err := setupLogging(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening log: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setupCapture(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening capture: %v\n", err)
+		os.Exit(1)
+	}
+	setupHexDump()
+	if options.Daemon {
+		if err := daemonize(); err != nil {
+			logger.Error("Error starting daemon", "err", err)
+			os.Exit(1)
+		}
+	}
 	err := os.MkdirAll(options.TtyPath, 0755)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating TTY path: %v\n", err)
+		logger.Error("Error creating TTY path", "err", err)
 		os.Exit(1)
 	}
 
@@ -583,86 +992,43 @@ func main() {
 		cancel()
 	}()
 
-	phoneTranslations()
-	customCommands()
-	customLines()
-
-	for i := 0; i < options.NumTTYs; i++ {
-		tty, err := NewPty()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating tty: %v\n", err)
-			os.Exit(1)
-		}
-
-		id := fmt.Sprintf("tty%d", options.StartNum+i)
-		var rwc io.ReadWriteCloser
-		if len(options.Verbose) > 2 {
-			rwc = t.NewRWCTracer(tty, 16, time.Millisecond*time.Duration(options.NagleTimeout),
-				newModemTraceHook(fmt.Sprintf("%s-w", id)),
-				newModemTraceHook(fmt.Sprintf("%s-r", id)),
-			)
-		} else {
-			rwc = tty
-		}
-
-		m, err := vm.NewModem(&vm.ModemConfig{
-			Id:               id,
-			OutgoingCall:     outGoingCall,
-			CommandHook:      commandHook,
-			LineHook:         lineHook,
-			StatusTransition: statusTransition,
-			TTY:              rwc,
-			RingMax:          options.RingMax,
-			AnswerChar:       options.AnswerChar,
-			GuardTime:        options.GuardTime,
-			DisablePreGuard:  options.DisablePreGuard,
-			DisablePostGuard: options.DisablePostGuard,
-		})
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating modem: %v\n", err)
-			os.Exit(1)
-		}
-		
-		// Execute initialization commands before exposing the TTY
-		for _, initCmd := range options.InitCmd {
-			if len(options.Verbose) > 0 {
-				fmt.Printf("%s: Executing init command: AT%s\n", m.Id(), initCmd)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if logFile != nil {
+				if err := logFile.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "Error reopening log: %v\n", err)
+				}
+			}
+			if captureFile != nil {
+				if err := captureFile.Reopen(); err != nil {
+					logger.Error("Error reopening capture", "err", err)
+				}
 			}
-			
-			// Send the AT command
-			// The response will be written to the TTY but since it's not yet exposed
-			// via symlink, no external process will see it
-			result := m.ProcessAtCommandSync(initCmd)
-			
-			if len(options.Verbose) > 0 {
-				fmt.Printf("%s: Init command result: %v\n", m.Id(), result)
+			if err := reload(os.Args); err != nil {
+				logger.Error("Error reloading configuration", "err", err)
+				continue
 			}
-			
-			// Small delay to ensure the command is fully processed
-			time.Sleep(10 * time.Millisecond)
-		}
-		
-		modems = append(modems, m)
-		err = os.Symlink(tty.Name(), fmt.Sprintf("%s/tty%d", options.TtyPath, options.StartNum+i))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating symlink: %v\n", err)
-			os.Exit(1)
-		}
-		if len(options.Verbose) > 0 {
-			fmt.Printf("%s: Created and listen on %s/tty%d\n", m.Id(), options.TtyPath, options.StartNum+i)
+			logger.Info("Configuration reloaded")
 		}
+	}()
+
+	if err := startBank(); err != nil {
+		logger.Error("Error starting modems", "err", err)
+		os.Exit(1)
 	}
 
 	for _, attachStr := range options.Attach {
 		err := attachTTY(attachStr)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error attaching TTY: %v\n", err)
+			logger.Error("Error attaching TTY", "err", err)
 			os.Exit(1)
 		}
 	}
 
-	if !options.NoListen {
-		go listenTask()
+	if err := startListeners(); err != nil {
+		shutdown("Error creating listener", "err", err)
 	}
 
 	if options.Watchdog > 0 {
@@ -673,12 +1039,32 @@ func main() {
 		enableMetrics(options.Metrics)
 	}
 
-	fmt.Println("Vmodem started, press Ctrl+C to exit")
-	<-ctx.Done()
-	if listener != nil {
-		listener.Close()
+	if options.API != "" {
+		enableAPI(options.API)
+	}
+
+	if options.Console != "" {
+		enableConsole(options.Console)
+	}
+
+	startSystemdWatchdog()
+
+	if options.PidFile != "" {
+		if err := writePidFile(options.PidFile); err != nil {
+			shutdown("Error writing pidfile", "err", err)
+		}
 	}
+
+	logger.Info("Vmodem started, press Ctrl+C to exit", "modems", len(specs))
+	sdNotify(fmt.Sprintf("READY=1\nSTATUS=Serving %d modems", len(specs)))
+	<-ctx.Done()
+	sdNotify("STOPPING=1")
+	closeListeners()
 	cleanTTYs()
 	cleanAttached()
-	cleanModems()
+	stopBank()
+	if options.PidFile != "" {
+		os.Remove(options.PidFile)
+	}
+	os.Exit(int(exitStatus.Load()))
 }
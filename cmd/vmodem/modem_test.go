@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// Test phone number translation patterns
+func TestNumToHost_Match(t *testing.T) {
+	n, err := NewNumToHost("\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})", "%[1]s.%[2]s.%[3]s.%[4]s")
+	if err != nil {
+		t.Fatalf("NewNumToHost() error = %v", err)
+	}
+
+	if host := n.Match("*192*168*1*100"); host != "192.168.1.100" {
+		t.Errorf("Match() = %q, want %q", host, "192.168.1.100")
+	}
+
+	if host := n.Match("5551212"); host != "" {
+		t.Errorf("Match() = %q, want empty string", host)
+	}
+}
+
+// Test host names are dialed with the default port unless they have one
+func TestDialHostNames(t *testing.T) {
+	defer func() { options, numToHosts = Options{}, nil }()
+	options.DefaultPort = "2020"
+	options.Translate = []string{"^BBS$->bbs.example.com:23"}
+	if err := phoneTranslations(); err != nil {
+		t.Fatalf("phoneTranslations() error = %v", err)
+	}
+	for number, want := range map[string]string{
+		"BBS":                       "bbs.example.com:23",
+		"bbs.example.com:6400":      "bbs.example.com:6400",
+		"localhost":                 "localhost:2020",
+		"*10*0*0*1":                 "10.0.0.1:2020",
+		"10.0.0.1:23":               "10.0.0.1:23",
+		"tls://bbs.example.com:992": "bbs.example.com:992",
+		"5551212":                   "",
+	} {
+		host, _ := findHost(number)
+		if host != "" {
+			host, _, _ = parseTarget(host)
+		}
+		if host != want {
+			t.Errorf("%s dials %q, want %q", number, host, want)
+		}
+	}
+}
+
+// Test local bind address resolution
+func TestResolveBind(t *testing.T) {
+	addr, err := resolveBind("")
+	if err != nil || addr != nil {
+		t.Errorf("resolveBind(\"\") = %v, %v, want nil, nil", addr, err)
+	}
+
+	addr, err = resolveBind("127.0.0.1")
+	if err != nil {
+		t.Fatalf("resolveBind() error = %v", err)
+	}
+	if tcpAddr := addr.(*net.TCPAddr); !tcpAddr.IP.Equal(net.IPv4(127, 0, 0, 1)) || tcpAddr.Port != 0 {
+		t.Errorf("resolveBind() = %v, want 127.0.0.1:0", addr)
+	}
+
+	ipNet := func(s string) net.Addr {
+		ip, n, _ := net.ParseCIDR(s)
+		n.IP = ip
+		return n
+	}
+	for _, tt := range []struct {
+		addrs []net.Addr
+		want  string
+	}{
+		{[]net.Addr{ipNet("fe80::1/64"), ipNet("2001:db8::1/64"), ipNet("192.0.2.1/24")}, "192.0.2.1:0"},
+		{[]net.Addr{ipNet("fe80::1/64"), ipNet("169.254.0.1/16"), ipNet("2001:db8::1/64")}, "[2001:db8::1]:0"},
+		{[]net.Addr{ipNet("fe80::1/64")}, "[fe80::1%eth0]:0"},
+	} {
+		if addr := interfaceBindAddr("eth0", tt.addrs); addr == nil || addr.String() != tt.want {
+			t.Errorf("interfaceBindAddr(%v) = %v, want %s", tt.addrs, addr, tt.want)
+		}
+	}
+	if addr := interfaceBindAddr("eth0", nil); addr != nil {
+		t.Errorf("interfaceBindAddr() without addresses = %v, want nil", addr)
+	}
+
+	addr, err = resolveBind("lo")
+	if err != nil {
+		t.Skipf("loopback interface not available: %v", err)
+	}
+	if tcpAddr := addr.(*net.TCPAddr); !tcpAddr.IP.IsLoopback() {
+		t.Errorf("resolveBind(\"lo\") = %v, want loopback address", addr)
+	}
+}
+
+// Test the monitor TTY mirrors modem traffic
+func TestAddMonitor(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	go io.Copy(io.Discard, dte)
+	m, err := vm.NewModem(&vm.ModemConfig{Id: "tty0", TTY: dce})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer m.CloseSync()
+
+	mon, err := NewPty()
+	if err != nil {
+		t.Fatalf("NewPty() error = %v", err)
+	}
+	defer mon.Close()
+	addMonitor(m, mon)
+
+	m.TtyWriteStrSync("hello\n")
+	line, err := bufio.NewReader(mon.Slave()).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("monitor read %q, %v, want %q", line, err, "hello\n")
+	}
+}
+
+// Test the personality options set the initial state of the modems, kept by ATZ
+func TestPersonality(t *testing.T) {
+	options = Options{Locale: "en", GuardTime: 20, Echo: "off", NumericResults: true,
+		SReg: []string{"0=2", "12=40"}, ConnectStr: "CONNECT 57600"}
+	defer func() { options = Options{} }()
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	m, err := vm.NewModem(modemConfig("tty0", dce, nil))
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer m.CloseSync()
+	setPersonality(m)
+	if s0, s12 := m.SRegSync(0), m.SRegSync(12); s0 != 2 || s12 != 40 {
+		t.Errorf("S0, S12 = %d, %d, want 2, 40", s0, s12)
+	}
+
+	dte.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(dte)
+	for _, cmd := range []string{"AT\r", "ATZ\r"} {
+		dte.Write([]byte(cmd))
+		b := make([]byte, 3)
+		if _, err := io.ReadFull(r, b); err != nil || string(b) != "\r0\r" {
+			t.Fatalf("%q answer = %q, %v, want numeric OK without echo", cmd, b, err)
+		}
+	}
+	dte.Write([]byte("ATV1\r"))
+	if s, err := r.ReadString('K'); err != nil || s != "\r\nOK" {
+		t.Fatalf("ATV1 answer = %q, %v", s, err)
+	}
+	host, line := vm.NewLine()
+	defer host.Close()
+	if err := m.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	dte.Write([]byte("ATA\r"))
+	for {
+		s, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the ATA answer: %v", err)
+		}
+		if strings.HasPrefix(s, "CONNECT") {
+			if s != "CONNECT 57600\r\n" {
+				t.Errorf("ATA answer = %q, want CONNECT 57600", s)
+			}
+			break
+		}
+	}
+	go io.Copy(io.Discard, r)
+}
+
+// Test the output of custom commands is framed as information text
+func TestCommandOutput(t *testing.T) {
+	options = Options{Locale: "en", Echo: "off", Command: []string{"^I9$->Hello->OK"}}
+	defer func() {
+		options = Options{}
+		commands = nil
+	}()
+	if err := customCommands(); err != nil {
+		t.Fatalf("customCommands() error = %v", err)
+	}
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	m, err := vm.NewModem(modemConfig("tty0", dce, nil))
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer m.CloseSync()
+	setPersonality(m)
+
+	dte.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(dte)
+	for _, tc := range []struct{ cmd, want string }{
+		{"ATI9\r", "\r\nHello\r\n\r\nOK\r\n"},
+		{"ATV0I9\r", "Hello\r\n\r0\r"},
+	} {
+		dte.Write([]byte(tc.cmd))
+		b := make([]byte, len(tc.want))
+		if _, err := io.ReadFull(r, b); err != nil || string(b) != tc.want {
+			t.Errorf("%q answer = %q, %v, want %q", tc.cmd, b, err, tc.want)
+		}
+	}
+}
+
+func TestParseSReg(t *testing.T) {
+	if reg, value, err := parseSReg("7=45"); err != nil || reg != 7 || value != 45 {
+		t.Errorf("parseSReg() = %d, %d, %v", reg, value, err)
+	}
+	for _, bad := range []string{"7", "7=", "=45", "256=1", "7=256", "S7=45"} {
+		if _, _, err := parseSReg(bad); err == nil {
+			t.Errorf("parseSReg(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// pendingCall is an incoming call from a listener watched until the modem
+// answers it: a caller sending nothing within the idle timeout releases the
+// ringing modem instead of leaving it held by a half-open caller. The modem
+// stays the only reader of the connection, reading it while ringing, so a
+// caller hanging up is noticed by the modem itself and nothing the caller sends
+// is lost.
+type pendingCall struct {
+	net.Conn
+	heard  chan struct{} // Closed when the modem first reads data from the caller
+	closed chan struct{}
+	hOnce  sync.Once
+	cOnce  sync.Once
+}
+
+// newPendingCall starts watching the caller of conn.
+func newPendingCall(conn net.Conn) *pendingCall {
+	return &pendingCall{Conn: conn, heard: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (p *pendingCall) Read(b []byte) (int, error) {
+	n, err := p.Conn.Read(b)
+	if n > 0 {
+		p.hOnce.Do(func() { close(p.heard) })
+	}
+	return n, err
+}
+
+func (p *pendingCall) Close() error {
+	p.cOnce.Do(func() { close(p.closed) })
+	return p.Conn.Close()
+}
+
+// watch hangs up m, ringing with the call, if the caller sends nothing within
+// timeout before the call is answered.
+func (p *pendingCall) watch(m *vm.Modem, timeout time.Duration) {
+	expired := time.NewTimer(timeout)
+	defer expired.Stop()
+	select {
+	case <-p.closed:
+		return
+	case <-p.heard:
+		return
+	case <-expired.C:
+	}
+	m.Lock()
+	defer m.Unlock()
+	select {
+	case <-p.closed:
+		// The modem released the call
+		return
+	case <-p.heard:
+		return
+	default:
+	}
+	// While the call is open and the modem rings, the ringing call is this one:
+	// leaving Ringing any other way than answering closes it.
+	if m.Status() == vm.StatusRinging {
+		modemLog(m.Id()).Info("Incoming call dropped before answer", "reason", "caller idle")
+		m.Hangup()
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// Test a pending call releases the ringing modem when the caller stays silent
+// or hangs up, and the modem, the only reader of the call, gets what the caller
+// sent while ringing once answered
+func TestPendingCall(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	ttyData := make(chan string, 100)
+	go func() {
+		b := make([]byte, 256)
+		for {
+			n, err := dte.Read(b)
+			if err != nil {
+				return
+			}
+			ttyData <- string(b[:n])
+		}
+	}()
+	m, err := vm.NewModem(&vm.ModemConfig{Id: "tty0", TTY: dce})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer m.CloseSync()
+	waitStatus := func(want vm.ModemStatus) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for m.StatusSync() != want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if m.StatusSync() != want {
+			t.Fatalf("status = %v, want %v", m.StatusSync(), want)
+		}
+	}
+	ring := func(timeout time.Duration) net.Conn {
+		t.Helper()
+		caller, line := net.Pipe()
+		p := newPendingCall(line)
+		if err := m.IncomingCallSync(p); err != nil {
+			t.Fatalf("IncomingCallSync() error = %v", err)
+		}
+		go p.watch(m, timeout)
+		return caller
+	}
+	caller := ring(50 * time.Millisecond)
+	waitStatus(vm.StatusIdle)
+	if _, err := caller.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("silent caller read error = %v, want EOF", err)
+	}
+
+	caller = ring(time.Minute)
+	caller.Close()
+	waitStatus(vm.StatusIdle)
+
+	caller = ring(50 * time.Millisecond)
+	defer caller.Close()
+	if _, err := caller.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if st := m.StatusSync(); st != vm.StatusRinging {
+		t.Fatalf("status = %v after the caller sent data, want ringing", st)
+	}
+	if _, err := m.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	waitStatus(vm.StatusConnected)
+	var out string
+	timeout := time.After(2 * time.Second)
+	for !strings.Contains(out, "hello") {
+		select {
+		case data := <-ttyData:
+			out += data
+		case <-timeout:
+			t.Fatalf("TTY output = %q, want the data sent by the caller while ringing", out)
+		}
+	}
+}
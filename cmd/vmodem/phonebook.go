@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phonebook maps phone numbers to hosts, as listed in a phonebook file. The file
+// is reloaded on lookups once it changes, so entries can be edited while the
+// modems are running.
+//
+// Each line of the file holds a number, the host it dials and an optional local
+// address or interface, separated by spaces. Dashes and parentheses in numbers
+// are ignored. A ? in a number matches any single character and a * any
+// sequence of them, and the matched characters are available to the host as
+// %[1]s, %[2]s, ... Exact numbers take precedence over rules with wildcards,
+// which are tried in file order. Text after # is a comment:
+//
+//	5551212    bbs.example.com:23
+//	555-1313   10.0.0.5:6400       tun0
+//	1800*      gateway.example.com:%[1]s
+type Phonebook struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	exact   map[string]phoneEntry
+	rules   []*NumToHost
+}
+
+// phoneEntry is the host and local address of an exact number.
+type phoneEntry struct {
+	host, bind string
+}
+
+// LoadPhonebook loads the phonebook file at path.
+func LoadPhonebook(path string) (*Phonebook, error) {
+	p := &Phonebook{path: path}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Lookup returns the host and local address number dials, or an empty host if
+// it is not in the phonebook.
+func (p *Phonebook) Lookup(number string) (string, string) {
+	p.Lock()
+	defer p.Unlock()
+	if err := p.reload(); err != nil {
+		// Keep dialing with the last good phonebook
+		logger.Error("Error reloading phonebook", "err", err)
+	}
+	number = normalizeNumber(number)
+	if e, ok := p.exact[number]; ok {
+		return e.host, e.bind
+	}
+	for _, r := range p.rules {
+		if host := r.Match(number); host != "" {
+			return host, r.Bind
+		}
+	}
+	return "", ""
+}
+
+// reload reads the file again if it changed since it was last read.
+func (p *Phonebook) reload() error {
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(p.modTime) && fi.Size() == p.size {
+		return nil
+	}
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// A broken file is reported once, not on every lookup until fixed
+	p.modTime, p.size = fi.ModTime(), fi.Size()
+	exact, rules, err := parsePhonebook(f)
+	if err != nil {
+		return fmt.Errorf("%s:%v", p.path, err)
+	}
+	p.exact, p.rules = exact, rules
+	return nil
+}
+
+// parsePhonebook parses the entries of a phonebook file.
+func parsePhonebook(r io.Reader) (map[string]phoneEntry, []*NumToHost, error) {
+	exact := make(map[string]phoneEntry)
+	var rules []*NumToHost
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 || len(fields) < 2 {
+			return nil, nil, fmt.Errorf("%d: want number, host and optional bind", line)
+		}
+		number := normalizeNumber(fields[0])
+		if number == "" {
+			return nil, nil, fmt.Errorf("%d: empty number", line)
+		}
+		e := phoneEntry{host: fields[1]}
+		if len(fields) == 3 {
+			e.bind = fields[2]
+		}
+		if !strings.ContainsAny(number, "?*") {
+			if _, dup := exact[number]; dup {
+				return nil, nil, fmt.Errorf("%d: duplicate number %s", line, number)
+			}
+			exact[number] = e
+			continue
+		}
+		var re strings.Builder
+		re.WriteString("^")
+		for _, c := range number {
+			switch c {
+			case '?':
+				re.WriteString("(.)")
+			case '*':
+				re.WriteString("(.*)")
+			default:
+				re.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		re.WriteString("$")
+		rule, err := NewNumToHost(re.String(), e.host)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%d: %v", line, err)
+		}
+		rule.Bind = e.bind
+		rules = append(rules, rule)
+	}
+	return exact, rules, scanner.Err()
+}
+
+// normalizeNumber removes the punctuation of a phone number.
+func normalizeNumber(number string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune("-() ", r) {
+			return -1
+		}
+		return r
+	}, number)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test exact numbers, wildcard rules and reloading of the phonebook
+func TestPhonebook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phonebook")
+	write := func(content string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write(`# Test phonebook
+555-1212   bbs.example.com:23
+555????    bbs%[4]s.example.com     tun0
+1800*      gateway.example.com:%[1]s
+`, now)
+
+	p, err := LoadPhonebook(path)
+	if err != nil {
+		t.Fatalf("LoadPhonebook() error = %v", err)
+	}
+	for number, want := range map[string][2]string{
+		"5551212":  {"bbs.example.com:23", ""},
+		"5551239":  {"bbs9.example.com", "tun0"},
+		"18006400": {"gateway.example.com:6400", ""},
+		"555123":   {"", ""},
+	} {
+		if host, bind := p.Lookup(number); host != want[0] || bind != want[1] {
+			t.Errorf("Lookup(%s) = %q, %q, want %q, %q", number, host, bind, want[0], want[1])
+		}
+	}
+
+	// Edits are picked up, and a broken file keeps the last good entries
+	write("5551212 bbs.example.net:23\n", now.Add(time.Second))
+	if host, _ := p.Lookup("5551212"); host != "bbs.example.net:23" {
+		t.Errorf("Lookup() after edit = %q, want bbs.example.net:23", host)
+	}
+	write("5551212\n", now.Add(2*time.Second))
+	if host, _ := p.Lookup("5551212"); host != "bbs.example.net:23" {
+		t.Errorf("Lookup() with broken file = %q, want bbs.example.net:23", host)
+	}
+}
+
+// Test invalid phonebook files are rejected with their line
+func TestParsePhonebook(t *testing.T) {
+	for _, bad := range []string{
+		"5551212",
+		"5551212 a:23 eth0 extra",
+		"() a:23",
+		"5551212 a:23\n555-1212 b:23",
+	} {
+		if _, _, err := parsePhonebook(strings.NewReader(bad)); err == nil {
+			t.Errorf("parsePhonebook(%q) accepted", bad)
+		}
+	}
+}
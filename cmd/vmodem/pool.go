@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// poolScheme is the prefix of the dial targets calling a pool of the bank,
+// e.g. pool:lines.
+const poolScheme = "pool:"
+
+// poolNameRe matches valid pool names.
+var poolNameRe = regexp.MustCompile(`^[\w.-]+$`)
+
+// Pool is a hunt group: calls to its listen address, or to the pool: target a
+// number is routed to, roll over to the first idle modem of the pool in order,
+// and are busy only when all of them are occupied.
+type Pool struct {
+	// Name identifies the pool in pool: targets and the control API
+	Name string
+	// Modems are the names of the modems of the pool, in hunting order
+	Modems []string
+	// Addr is the address of the listener of the pool (optional). Modems of
+	// pools with a listener do not answer the main listener.
+	Addr string
+}
+
+// pools are the hunt groups of the bank, set at startup from the --pool
+// options.
+var pools []*Pool
+
+// parsePool parses a pool in the format name->modem,modem...[->listen address].
+func parsePool(s string) (*Pool, error) {
+	parts := strings.Split(s, "->")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, fmt.Errorf("invalid pool: %s", s)
+	}
+	p := &Pool{Name: parts[0]}
+	if !poolNameRe.MatchString(p.Name) {
+		return nil, fmt.Errorf("invalid pool name %q", p.Name)
+	}
+	for _, name := range strings.Split(parts[1], ",") {
+		if name = strings.TrimSpace(name); name == "" || slices.Contains(p.Modems, name) {
+			return nil, fmt.Errorf("pool %s: invalid modem list %q", p.Name, parts[1])
+		}
+		p.Modems = append(p.Modems, name)
+	}
+	if len(parts) == 3 {
+		p.Addr = parts[2]
+		if err := checkListenAddr(p.Addr); err != nil {
+			return nil, fmt.Errorf("pool %s: %v", p.Name, err)
+		}
+	}
+	return p, nil
+}
+
+// bankPools builds the pools of the bank from the --pool options, checking
+// their modems are in the bank.
+func bankPools() error {
+	pools = nil
+	for _, s := range options.Pool {
+		p, err := parsePool(s)
+		if err != nil {
+			return err
+		}
+		if poolNamed(p.Name) != nil {
+			return fmt.Errorf("duplicate pool %s", p.Name)
+		}
+		for _, name := range p.Modems {
+			if !slices.ContainsFunc(specs, func(spec ModemSpec) bool { return spec.Name == name }) {
+				return fmt.Errorf("pool %s: no modem %s", p.Name, name)
+			}
+		}
+		pools = append(pools, p)
+	}
+	return nil
+}
+
+// poolNamed returns the pool with the given name, or nil.
+func poolNamed(name string) *Pool {
+	for _, p := range pools {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// pooledListen reports whether the modem id belongs to a pool with its own
+// listener.
+func pooledListen(id string) bool {
+	for _, p := range pools {
+		if p.Addr != "" && slices.Contains(p.Modems, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// modems returns the running modems of the pool, in hunting order.
+func (p *Pool) modems() []*vm.Modem {
+	bankMu.Lock()
+	defer bankMu.Unlock()
+	var modems []*vm.Modem
+	for _, name := range p.Modems {
+		for i, inst := range instances {
+			if inst != nil && specs[i].Name == name {
+				modems = append(modems, inst.modem)
+			}
+		}
+	}
+	return modems
+}
+
+// dialPool calls the pool name from the modem m: the first idle modem of the
+// pool other than m rings with the call, which is busy if there is none.
+func dialPool(m *vm.Modem, name string) (io.ReadWriteCloser, error) {
+	p := poolNamed(name)
+	if p == nil {
+		return nil, fmt.Errorf("no pool %s", name)
+	}
+	local, remote := vm.NewLine()
+	info := vm.CallInfo{Source: m.Id()}
+	for _, callee := range p.modems() {
+		if callee == m {
+			continue
+		}
+		if err := callee.IncomingCallInfoSync(remote, info); err == nil {
+			modemLog(m.Id()).Debug("Pool call", "pool", name, "modem", callee.Id())
+			return local, nil
+		}
+	}
+	local.Close()
+	remote.Close()
+	return nil, fmt.Errorf("%w: all modems of pool %s are busy", vm.ErrRemoteBusy, name)
+}
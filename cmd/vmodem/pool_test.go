@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+func TestParsePool(t *testing.T) {
+	p, err := parsePool("lines->tty0, tty1->:2323")
+	if err != nil || p.Name != "lines" || !slices.Equal(p.Modems, []string{"tty0", "tty1"}) || p.Addr != ":2323" {
+		t.Errorf("parsePool() = %+v, %v", p, err)
+	}
+	for _, bad := range []string{"lines", "lines->", "a b->tty0", "lines->tty0,tty0", "lines->tty0->nowhere", "lines->tty0->:1->x"} {
+		if _, err := parsePool(bad); err == nil {
+			t.Errorf("parsePool(%q) accepted", bad)
+		}
+	}
+}
+
+// Test calls to a pool roll over to its next idle modem, and are busy when all
+// of them are occupied
+func TestPoolRollover(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "pool.sock")
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true, BusyStr: "BUSY\r\n",
+		Pool: []string{"lines->tty0,tty1->unix:" + addr}}
+	specs = []ModemSpec{{Name: "tty0"}, {Name: "tty1"}, {Name: "tty2"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		closeListeners()
+		stopBank()
+		options, specs, instances, listeners, numToHosts, pools = Options{}, nil, nil, nil, nil, nil
+	}()
+	if err := bankPools(); err != nil {
+		t.Fatalf("bankPools() error = %v", err)
+	}
+	if err := phoneTranslations(); err != nil {
+		t.Fatal(err)
+	}
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	if err := startListeners(); err != nil {
+		t.Fatalf("startListeners() error = %v", err)
+	}
+	modems := bank()
+	if shared := sharedModems(); len(shared) != 1 || shared[0] != modems[2] {
+		t.Errorf("sharedModems() = %v, want tty2 alone", shared)
+	}
+	waitStatus := func(m *vm.Modem, want vm.ModemStatus) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for m.StatusSync() != want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if m.StatusSync() != want {
+			t.Fatalf("%s status = %v, want %v", m.Id(), m.StatusSync(), want)
+		}
+	}
+
+	for _, m := range modems[:2] {
+		conn, err := net.Dial("unix", addr)
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer conn.Close()
+		waitStatus(m, vm.StatusRinging)
+	}
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if b, err := io.ReadAll(conn); err != nil || string(b) != "BUSY\r\n" {
+		t.Errorf("third call = %q, %v, want busy", b, err)
+	}
+	conn.Close()
+
+	srv := httptest.NewServer(newAPIHandler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/pools/lines")
+	if err != nil {
+		t.Fatalf("GET /pools/lines error = %v", err)
+	}
+	var st PoolState
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if err != nil || st.Idle != 0 || st.Busy != 2 || len(st.Modems) != 2 {
+		t.Errorf("GET /pools/lines = %+v, %v", st, err)
+	}
+
+	// Numbers reach the pool from the other modems of the bank
+	if _, err := outGoingCall(ctx, modems[2], "pool:lines"); !errors.Is(err, vm.ErrRemoteBusy) {
+		t.Errorf("outGoingCall() to a full pool error = %v, want busy", err)
+	}
+	modems[1].HangupSync()
+	call, err := outGoingCall(ctx, modems[2], "pool:lines")
+	if err != nil {
+		t.Fatalf("outGoingCall() error = %v", err)
+	}
+	defer call.Close()
+	waitStatus(modems[1], vm.StatusRinging)
+	if info := modems[1].CallInfoSync(); info.Source != "tty2" {
+		t.Errorf("CallInfo() = %+v, want source tty2", info)
+	}
+}
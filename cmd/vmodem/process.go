@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// procConn is a call connected to a local process: reads return its standard
+// output and writes go to its standard input. Closing the call ends the process.
+type procConn struct {
+	cmd       *exec.Cmd
+	stdin     *os.File
+	stdout    *os.File
+	stderr    *tailBuffer
+	exited    chan struct{}
+	err       error // Exit status, set once exited is closed
+	closeOnce sync.Once
+}
+
+// startProcess starts cmd connected to a new procConn. The end of its standard
+// error is kept for error reports.
+func startProcess(cmd *exec.Cmd) (*procConn, error) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return nil, err
+	}
+	p := &procConn{cmd: cmd, stdin: inW, stdout: outR, stderr: &tailBuffer{max: 1024}, exited: make(chan struct{})}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = inR, outW, p.stderr
+	err = cmd.Start()
+	// The process has its own copies
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return nil, err
+	}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+func (p *procConn) Read(b []byte) (int, error) {
+	return p.stdout.Read(b)
+}
+
+func (p *procConn) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+// Close ends the process, killing it if it is still running.
+func (p *procConn) Close() error {
+	p.closeOnce.Do(func() {
+		p.stdin.Close()
+		select {
+		case <-p.exited:
+		default:
+			p.cmd.Process.Kill()
+		}
+		p.stdout.Close()
+	})
+	return nil
+}
+
+// exitError waits for the process to exit and returns why, with the last line
+// of its standard error if any.
+func (p *procConn) exitError() error {
+	<-p.exited
+	lines := strings.Split(strings.TrimSpace(p.stderr.String()), "\n")
+	msg := strings.TrimSpace(lines[len(lines)-1])
+	switch {
+	case msg != "":
+		return errors.New(msg)
+	case p.err != nil:
+		return p.err
+	}
+	return errors.New("process exited")
+}
+
+// tailBuffer is a writer keeping the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, b...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(b), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
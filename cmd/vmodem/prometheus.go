@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// callDurationBuckets are the upper bounds in seconds of the call duration
+// histogram buckets.
+var callDurationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600}
+
+// histogram is a Prometheus histogram of the call durations of a modem.
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(callDurationBuckets))
+	}
+	for i, b := range callDurationBuckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// callDurations are the call duration histograms, by modem id. They outlive
+// the modems so restarts do not reset them.
+var callDurations = struct {
+	sync.Mutex
+	byId map[string]*histogram
+}{byId: make(map[string]*histogram)}
+
+// observeCallEnd records the duration of the call of m ending in a transition
+// from oldStatus to newStatus. The modem lock is held.
+func observeCallEnd(m *vm.Modem, oldStatus, newStatus vm.ModemStatus) {
+	online := func(s vm.ModemStatus) bool { return s == vm.StatusConnected || s == vm.StatusConnectedCmd }
+	if !online(oldStatus) || online(newStatus) {
+		return
+	}
+	d := m.Metrics().CallDuration()
+	callDurations.Lock()
+	defer callDurations.Unlock()
+	h := callDurations.byId[m.Id()]
+	if h == nil {
+		h = &histogram{}
+		callDurations.byId[m.Id()] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// modemStatuses are the statuses reported by the vmodem_state metric.
+var modemStatuses = []vm.ModemStatus{vm.StatusIdle, vm.StatusDialing, vm.StatusConnected, vm.StatusConnectedCmd, vm.StatusRinging}
+
+// writePrometheus writes the metrics of modems in the Prometheus text format.
+func writePrometheus(w io.Writer, modems []*vm.Modem) {
+	type sample struct {
+		id string
+		mt *vm.Metrics
+	}
+	samples := make([]sample, 0, len(modems))
+	for _, m := range modems {
+		samples = append(samples, sample{m.Id(), m.MetricsSync()})
+	}
+	family := func(name, kind, help string, value func(s sample) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, s := range samples {
+			fmt.Fprintf(w, "%s{modem=%q} %s\n", name, s.id, value(s))
+		}
+	}
+	itoa := func(v int) string { return strconv.Itoa(v) }
+
+	fmt.Fprintf(w, "# HELP vmodem_state Current status of the modem (1 for the current status).\n# TYPE vmodem_state gauge\n")
+	for _, s := range samples {
+		for _, st := range modemStatuses {
+			v := 0
+			if s.mt.Status == st {
+				v = 1
+			}
+			fmt.Fprintf(w, "vmodem_state{modem=%q,state=%q} %d\n", s.id, st, v)
+		}
+	}
+	fmt.Fprintf(w, "# HELP vmodem_calls_total Calls handled by the modem.\n# TYPE vmodem_calls_total counter\n")
+	for _, s := range samples {
+		fmt.Fprintf(w, "vmodem_calls_total{modem=%q,direction=\"in\"} %d\n", s.id, s.mt.NumInConns)
+		fmt.Fprintf(w, "vmodem_calls_total{modem=%q,direction=\"out\"} %d\n", s.id, s.mt.NumOutConns)
+	}
+	family("vmodem_tty_tx_bytes_total", "counter", "Bytes written to the TTY.", func(s sample) string { return itoa(s.mt.TtyTxBytes) })
+	family("vmodem_tty_rx_bytes_total", "counter", "Bytes read from the TTY.", func(s sample) string { return itoa(s.mt.TtyRxBytes) })
+	family("vmodem_tty_dropped_bytes_total", "counter", "Bytes discarded because the TTY buffer was full.", func(s sample) string { return itoa(s.mt.TtyDroppedBytes) })
+	family("vmodem_conn_tx_bytes_total", "counter", "Bytes sent to the calls.", func(s sample) string { return itoa(s.mt.ConnTxBytes) })
+	family("vmodem_conn_rx_bytes_total", "counter", "Bytes received from the calls.", func(s sample) string { return itoa(s.mt.ConnRxBytes) })
+	family("vmodem_command_errors_total", "counter", "AT commands answered with ERROR.", func(s sample) string { return itoa(s.mt.RetCodes[vm.RetCodeError]) })
+
+	callDurations.Lock()
+	defer callDurations.Unlock()
+	fmt.Fprintf(w, "# HELP vmodem_call_duration_seconds Duration of the finished calls.\n# TYPE vmodem_call_duration_seconds histogram\n")
+	for _, s := range samples {
+		h := callDurations.byId[s.id]
+		if h == nil {
+			h = &histogram{}
+		}
+		var cum uint64
+		for i, b := range callDurationBuckets {
+			if h.counts != nil {
+				cum += h.counts[i]
+			}
+			fmt.Fprintf(w, "vmodem_call_duration_seconds_bucket{modem=%q,le=%q} %d\n", s.id, strconv.FormatFloat(b, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(w, "vmodem_call_duration_seconds_bucket{modem=%q,le=\"+Inf\"} %d\n", s.id, h.count)
+		fmt.Fprintf(w, "vmodem_call_duration_seconds_sum{modem=%q} %s\n", s.id, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "vmodem_call_duration_seconds_count{modem=%q} %d\n", s.id, h.count)
+	}
+	fmt.Fprintf(w, "# HELP vmodem_uptime_seconds Time since vmodem started.\n# TYPE vmodem_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "vmodem_uptime_seconds %d\n", int(time.Since(tini)/time.Second))
+}
+
+// prometheusHandler serves the metrics of the bank in the Prometheus text format.
+func prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePrometheus(w, bank())
+}
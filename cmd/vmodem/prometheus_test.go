@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	callDurations.byId = make(map[string]*histogram)
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances = Options{}, nil, nil
+		callDurations.byId = make(map[string]*histogram)
+	}()
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	m := bank()[0]
+
+	// One finished incoming call and a failed command
+	host, line := vm.NewLine()
+	defer host.Close()
+	if err := m.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := m.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for m.StatusSync() != vm.StatusConnected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := m.HangupSync(); err != nil {
+		t.Fatalf("HangupSync() error = %v", err)
+	}
+	bankMu.Lock()
+	tty, err := os.OpenFile(instances[0].devs[0].Name(), os.O_RDWR, 0)
+	bankMu.Unlock()
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	defer tty.Close()
+	tty.WriteString("ATATX99Q9Q\r")
+	for m.MetricsSync().RetCodes[vm.RetCodeError] == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	prometheusHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`# TYPE vmodem_state gauge`,
+		`vmodem_state{modem="tty0",state="Idle"} 1`,
+		`vmodem_state{modem="tty0",state="Connected"} 0`,
+		`vmodem_calls_total{modem="tty0",direction="in"} 1`,
+		`vmodem_calls_total{modem="tty0",direction="out"} 0`,
+		`# TYPE vmodem_call_duration_seconds histogram`,
+		`vmodem_call_duration_seconds_bucket{modem="tty0",le="1"} 1`,
+		`vmodem_call_duration_seconds_bucket{modem="tty0",le="+Inf"} 1`,
+		`vmodem_call_duration_seconds_count{modem="tty0"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics lack %s:\n%s", want, body)
+		}
+	}
+	// The TTY echoes ERROR back to the modem, so there may be more than one
+	if !strings.Contains(body, `vmodem_command_errors_total{modem="tty0"} `) || strings.Contains(body, `vmodem_command_errors_total{modem="tty0"} 0`) {
+		t.Errorf("metrics lack the command errors:\n%s", body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %s", ct)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Proxy is a SOCKS5 or HTTP CONNECT proxy outgoing calls are routed through,
+// given as socks5://[user:password@]host:port or http://[user:password@]host:port
+type Proxy struct {
+	URL *url.URL
+}
+
+// ProxyRule routes the calls to the destinations (host:port) matching a regexp
+// through a proxy, or directly if Proxy is nil.
+type ProxyRule struct {
+	Proxy *Proxy
+	re    *regexp.Regexp
+}
+
+// ParseProxy parses a proxy URL. "direct" means no proxy and returns nil.
+func ParseProxy(s string) (*Proxy, error) {
+	if s == "direct" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, fmt.Errorf("proxy address: %v", err)
+	}
+	return &Proxy{URL: u}, nil
+}
+
+// NewProxyRule creates a rule routing the destinations matching reStr through
+// proxy (a proxy URL or "direct").
+func NewProxyRule(reStr, proxy string) (*ProxyRule, error) {
+	re, err := regexp.Compile(reStr)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParseProxy(proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyRule{Proxy: p, re: re}, nil
+}
+
+// proxies builds the default proxy and the proxy rules from the options.
+func proxies() error {
+	defaultProxy, proxyRules = nil, nil
+	if options.Proxy != "" {
+		p, err := ParseProxy(options.Proxy)
+		if err != nil {
+			return fmt.Errorf("proxy: %v", err)
+		}
+		defaultProxy = p
+	}
+	for _, r := range options.ProxyRule {
+		parts := strings.Split(r, "->")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid proxy rule: %s", r)
+		}
+		rule, err := NewProxyRule(parts[0], parts[1])
+		if err != nil {
+			return fmt.Errorf("proxy rule %s: %v", r, err)
+		}
+		proxyRules = append(proxyRules, rule)
+	}
+	return nil
+}
+
+// findProxy returns the proxy of the calls to address: that of the first
+// matching rule, or the default proxy.
+func findProxy(address string) *Proxy {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	for _, r := range proxyRules {
+		if r.re.MatchString(address) {
+			return r.Proxy
+		}
+	}
+	return defaultProxy
+}
+
+// dialCall connects a call to address (host:port) through its proxy, if any.
+func dialCall(ctx context.Context, address string, localAddr net.Addr) (net.Conn, error) {
+	p := findProxy(address)
+	if p == nil {
+		return dialer.DialContext(ctx, address, localAddr)
+	}
+	return p.DialContext(ctx, address, localAddr)
+}
+
+// DialContext connects to address (host:port) through the proxy, optionally
+// from localAddr. Host names are resolved by the proxy.
+func (p *Proxy) DialContext(ctx context.Context, address string, localAddr net.Addr) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", portStr)
+	}
+	conn, err := dialer.DialContext(ctx, p.URL.Host, localAddr)
+	if err != nil {
+		// A broken proxy is not a busy number
+		return nil, fmt.Errorf("proxy %s: %v", p.URL.Host, err)
+	}
+	// Bound the handshake by the call context
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	if p.URL.Scheme == "socks5" {
+		err = p.socks5Connect(conn, host, uint16(port))
+	} else {
+		err = p.httpConnect(conn, address)
+	}
+	if !stop() || err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socks5Replies are the errors of the SOCKS5 replies (RFC 1928). Those a modem
+// reports as BUSY or NO ANSWER wrap the matching connect errors.
+var socks5Replies = map[byte]error{
+	1: errors.New("general SOCKS server failure"),
+	2: errors.New("connection not allowed by ruleset"),
+	3: fmt.Errorf("network unreachable: %w", syscall.ENETUNREACH),
+	4: fmt.Errorf("host unreachable: %w", syscall.EHOSTUNREACH),
+	5: fmt.Errorf("connection refused: %w", syscall.ECONNREFUSED),
+	6: fmt.Errorf("TTL expired: %w", context.DeadlineExceeded),
+	7: errors.New("command not supported"),
+	8: errors.New("address type not supported"),
+}
+
+// socks5Connect asks a SOCKS5 proxy to connect to host:port, authenticating
+// with the user and password of the proxy URL, if any (RFC 1929).
+func (p *Proxy) socks5Connect(conn net.Conn, host string, port uint16) error {
+	method := byte(0x00) // No authentication
+	if p.URL.User != nil {
+		method = 0x02 // Username/password
+	}
+	if _, err := conn.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[0] != 5 || resp[1] != method {
+		return errors.New("socks5: no acceptable authentication method")
+	}
+	if method == 0x02 {
+		user := p.URL.User.Username()
+		pass, _ := p.URL.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return errors.New("socks5: user or password too long")
+		}
+		req := append([]byte{1, byte(len(user))}, user...)
+		req = append(append(req, byte(len(pass))), pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, resp[:]); err != nil {
+			return err
+		}
+		if resp[1] != 0 {
+			return errors.New("socks5: authentication failed")
+		}
+	}
+
+	req := []byte{5, 1, 0} // CONNECT
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("socks5: host name too long")
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return errors.New("socks5: invalid reply")
+	}
+	if reply[1] != 0 {
+		if err, ok := socks5Replies[reply[1]]; ok {
+			return fmt.Errorf("socks5: %w", err)
+		}
+		return fmt.Errorf("socks5: reply %d", reply[1])
+	}
+	// Skip the bound address
+	var skip int
+	switch reply[3] {
+	case 1:
+		skip = net.IPv4len + 2
+	case 4:
+		skip = net.IPv6len + 2
+	case 3:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		skip = int(l[0]) + 2
+	default:
+		return errors.New("socks5: invalid bound address")
+	}
+	_, err := io.CopyN(io.Discard, conn, int64(skip))
+	return err
+}
+
+// httpConnect asks an HTTP proxy to connect to address with the CONNECT method,
+// authenticating with the user and password of the proxy URL, if any.
+func (p *Proxy) httpConnect(conn net.Conn, address string) error {
+	req := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if p.URL.User != nil {
+		pass, _ := p.URL.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(p.URL.User.Username() + ":" + pass))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return err
+	}
+	// Read the response byte by byte so no call data is consumed after it
+	r := bufio.NewReaderSize(oneByteReader{conn}, 16)
+	resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusGatewayTimeout:
+		return fmt.Errorf("http proxy: %s: %w", resp.Status, context.DeadlineExceeded)
+	default:
+		return fmt.Errorf("http proxy: %s", strings.TrimSpace(resp.Status))
+	}
+}
+
+// oneByteReader reads one byte at a time.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(b []byte) (int, error) {
+	if len(b) > 1 {
+		b = b[:1]
+	}
+	return o.r.Read(b)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// serveProxy accepts one connection on a local listener and runs handshake on
+// it, then relays it to the address returned by handshake.
+func serveProxy(t *testing.T, handshake func(c net.Conn, r *bufio.Reader) string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		target := handshake(c, r)
+		if target == "" {
+			return
+		}
+		dst, err := net.Dial("tcp", target)
+		if err != nil {
+			return
+		}
+		defer dst.Close()
+		go io.Copy(dst, r)
+		io.Copy(c, dst)
+	}()
+	return l.Addr().String()
+}
+
+// echoServer returns the address of a local server echoing one line.
+func echoServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		c.Write([]byte(line))
+	}()
+	return l.Addr().String()
+}
+
+// Test calls through a SOCKS5 proxy with authentication
+func TestProxy_Socks5(t *testing.T) {
+	target := echoServer(t)
+	addr := serveProxy(t, func(c net.Conn, r *bufio.Reader) string {
+		greeting := make([]byte, 3)
+		io.ReadFull(r, greeting)
+		c.Write([]byte{5, 2})
+		auth := make([]byte, 2)
+		io.ReadFull(r, auth)
+		user := make([]byte, auth[1]+1)
+		io.ReadFull(r, user)
+		pass := make([]byte, user[len(user)-1])
+		io.ReadFull(r, pass)
+		if string(user[:len(user)-1]) != "user" || string(pass) != "secret" {
+			c.Write([]byte{1, 1})
+			return ""
+		}
+		c.Write([]byte{1, 0})
+		req := make([]byte, 5)
+		io.ReadFull(r, req)
+		host := make([]byte, req[4]+2)
+		io.ReadFull(r, host)
+		port := binary.BigEndian.Uint16(host[len(host)-2:])
+		c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		return net.JoinHostPort(string(host[:len(host)-2]), strconv.Itoa(int(port)))
+	})
+
+	p, err := ParseProxy("socks5://user:secret@" + addr)
+	if err != nil {
+		t.Fatalf("ParseProxy() error = %v", err)
+	}
+	_, port, _ := net.SplitHostPort(target)
+	conn, err := p.DialContext(context.Background(), net.JoinHostPort("localhost", port), nil)
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello\n"))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "hello\n" {
+		t.Errorf("echo = %q, %v, want hello", line, err)
+	}
+}
+
+// Test calls through an HTTP CONNECT proxy, and refusals reported as BUSY
+func TestProxy_HTTPConnect(t *testing.T) {
+	target := echoServer(t)
+	addr := serveProxy(t, func(c net.Conn, r *bufio.Reader) string {
+		req, err := http.ReadRequest(r)
+		if err != nil || req.Method != http.MethodConnect {
+			return ""
+		}
+		c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		return req.Host
+	})
+	p, err := ParseProxy("http://" + addr)
+	if err != nil {
+		t.Fatalf("ParseProxy() error = %v", err)
+	}
+	conn, err := p.DialContext(context.Background(), target, nil)
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello\n"))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "hello\n" {
+		t.Errorf("echo = %q, %v, want hello", line, err)
+	}
+
+	refused := serveProxy(t, func(c net.Conn, r *bufio.Reader) string {
+		io.ReadFull(r, make([]byte, 3))
+		c.Write([]byte{5, 0})
+		io.ReadFull(r, make([]byte, 10))
+		c.Write([]byte{5, 5, 0, 1})
+		return ""
+	})
+	p, _ = ParseProxy("socks5://" + refused)
+	_, err = p.DialContext(context.Background(), "10.0.0.1:23", nil)
+	if err := callError(err); !errors.Is(err, vm.ErrRemoteBusy) {
+		t.Errorf("refused call = %v, want busy", err)
+	}
+}
+
+// Test proxy rules route destinations through their proxy or directly
+func TestProxyRules(t *testing.T) {
+	defer func() { options, defaultProxy, proxyRules = Options{}, nil, nil }()
+	options.Proxy = "socks5://proxy.example.com:1080"
+	options.ProxyRule = []string{`^10\.->direct`, `\.lab:\d+$->http://lab-proxy:3128`}
+	if err := proxies(); err != nil {
+		t.Fatalf("proxies() error = %v", err)
+	}
+	for address, want := range map[string]string{
+		"10.0.0.1:23":        "",
+		"bbs.lab:23":         "lab-proxy:3128",
+		"bbs.example.com:23": "proxy.example.com:1080",
+	} {
+		got := ""
+		if p := findProxy(address); p != nil {
+			got = p.URL.Host
+		}
+		if got != want {
+			t.Errorf("findProxy(%s) = %q, want %q", address, got, want)
+		}
+	}
+
+	for _, bad := range []string{"ftp://proxy:21", "socks5://proxy", "http://%zz"} {
+		if _, err := ParseProxy(bad); err == nil {
+			t.Errorf("ParseProxy(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// reloadMu guards the settings reloaded on SIGHUP: the dial and hook tables and
+// the options they are built from.
+var reloadMu sync.RWMutex
+
+// writePidFile writes the process id to path.
+func writePidFile(path string) error {
+	return os.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+}
+
+// reload reads the command line args and the config file again and applies the
+// settings that take effect without restarting the modems: phone number
+// translations, routes, bind address, phonebook, proxies and custom commands and
+// lines. Calls in progress are kept. On error the running settings are left
+// as they were.
+func reload(args []string) error {
+	var next Options
+	p := flags.NewParser(&next, flags.HelpFlag|flags.PassDoubleDash)
+	if _, err := p.ParseArgs(args); err != nil {
+		return err
+	}
+	if next.Config != "" {
+		// The modems of the file are only read at startup
+		saved := configModems
+		err := loadConfig(p, next.Config)
+		configModems = saved
+		if err != nil {
+			return err
+		}
+	}
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	prev := options
+	restore := func(o Options) {
+		options.Translate, options.Route, options.Bind, options.Phonebook = o.Translate, o.Route, o.Bind, o.Phonebook
+		options.Command, options.Line = o.Command, o.Line
+		options.Proxy, options.ProxyRule = o.Proxy, o.ProxyRule
+	}
+	prevNumToHosts, prevRoutes, prevPhonebook, prevCommands, prevLines := numToHosts, dialRoutes, phonebook, commands, lines
+	prevProxy, prevProxyRules := defaultProxy, proxyRules
+	restore(next)
+	err := reloadTables()
+	if err != nil {
+		restore(prev)
+		numToHosts, dialRoutes, phonebook, commands, lines = prevNumToHosts, prevRoutes, prevPhonebook, prevCommands, prevLines
+		defaultProxy, proxyRules = prevProxy, prevProxyRules
+	}
+	return err
+}
+
+// reloadTables builds the tables of the reloaded settings from the options.
+func reloadTables() error {
+	if err := phoneTranslations(); err != nil {
+		return err
+	}
+	if err := routes(); err != nil {
+		return err
+	}
+	if err := proxies(); err != nil {
+		return err
+	}
+	phonebook = nil
+	if options.Phonebook != "" {
+		pb, err := LoadPhonebook(options.Phonebook)
+		if err != nil {
+			return fmt.Errorf("phonebook: %v", err)
+		}
+		phonebook = pb
+	}
+	if err := customCommands(); err != nil {
+		return err
+	}
+	return customLines()
+}
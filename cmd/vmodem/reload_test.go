@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vmodem.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	args := []string{"vmodem", "-c", path, "-C", "^I9$->Argument->OK"}
+	defer func() {
+		options, specs, configModems = Options{}, nil, nil
+		numToHosts, phonebook, commands, lines = nil, nil, nil, nil
+		defaultProxy, proxyRules = nil, nil
+	}()
+
+	write("tty:\n  num: 2\ndial:\n  translate:\n    - number: '^555$'\n      host: 'old.example.com:23'\n")
+	options = Options{NumTTYs: 2, Locale: "en", NoListen: true, Config: path}
+	if err := configure(); err != nil {
+		t.Fatalf("configure() error = %v", err)
+	}
+
+	// A new translation, bind address and modem count; the count needs a restart
+	write("tty:\n  num: 4\ndial:\n  bind: 127.0.0.1\n  translate:\n    - number: '^555$'\n      host: 'new.example.com:23'\n")
+	if err := reload(args); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if host, bind := findHost("555"); host != "new.example.com:23" || bind != "127.0.0.1" {
+		t.Errorf("findHost() = %s, %s after reload", host, bind)
+	}
+	if len(commands) != 1 || options.NumTTYs != 2 {
+		t.Errorf("commands = %d, num = %d after reload", len(commands), options.NumTTYs)
+	}
+
+	// A broken file keeps the running settings
+	write("dial:\n  translate:\n    - number: '^(555$'\n      host: 'broken.example.com:23'\n")
+	if err := reload(args); err == nil {
+		t.Fatalf("reload() of a broken file succeeded")
+	}
+	if host, bind := findHost("555"); host != "new.example.com:23" || bind != "127.0.0.1" {
+		t.Errorf("findHost() = %s, %s after failed reload", host, bind)
+	}
+}
+
+func TestWritePidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vmodem.pid")
+	if err := writePidFile(path); err != nil {
+		t.Fatalf("writePidFile() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if want := fmt.Sprintf("%d\n", os.Getpid()); string(data) != want {
+		t.Errorf("pidfile = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// ModemState is the state of a modem returned by the control API.
+type ModemState struct {
+	// Id is the modem identifier
+	Id string `json:"id"`
+	// Status is the modem status (Idle, Dialing, Connected, ...)
+	Status string `json:"status"`
+	// Call is the caller of the current incoming call, if any
+	Call *CallState `json:"call,omitempty"`
+	// SRegs are the values of the known S-registers, keyed by number
+	SRegs map[string]byte `json:"sregs,omitempty"`
+	// Impairment is the line impairment of the next calls
+	Impairment *ImpairmentState `json:"impairment,omitempty"`
+}
+
+// CallState describes the caller of an incoming call.
+type CallState struct {
+	// Number is the calling number
+	Number string `json:"number,omitempty"`
+	// Name is the calling party name
+	Name string `json:"name,omitempty"`
+	// Source is where the call comes from, such as the remote network address
+	Source string `json:"source,omitempty"`
+}
+
+// ImpairmentState is the line impairment of a modem, in the units of the
+// command line options.
+type ImpairmentState struct {
+	// LatencyMs is the delay of call data in each direction in milliseconds
+	LatencyMs int `json:"latencyMs"`
+	// JitterMs is the variation of the delay in milliseconds
+	JitterMs int `json:"jitterMs"`
+	// BitErrorRate is the probability of each bit of call data being flipped
+	BitErrorRate float64 `json:"bitErrorRate"`
+	// GarbageRate is the probability of a random byte being inserted after each byte
+	GarbageRate float64 `json:"garbageRate"`
+	// CarrierLossS is the mean connected time before the carrier drops in seconds
+	CarrierLossS int `json:"carrierLossS"`
+}
+
+// PoolState is the occupancy of a pool returned by the control API.
+type PoolState struct {
+	// Name is the pool name
+	Name string `json:"name"`
+	// Addr is the address of the listener of the pool, if any
+	Addr string `json:"addr,omitempty"`
+	// Modems are the modems of the pool in hunting order
+	Modems []ModemState `json:"modems"`
+	// Idle is the number of modems ready to answer a call
+	Idle int `json:"idle"`
+	// Busy is the number of modems dialing, ringing or in a call, or not running
+	Busy int `json:"busy"`
+}
+
+// RingRequest triggers an incoming call on a modem. The call connects to
+// Target (host:port) or, without one, to a line that echoes the data back.
+type RingRequest struct {
+	Target string `json:"target"`
+	Number string `json:"number"`
+	Name   string `json:"name"`
+}
+
+// SRegRequest sets an S-register.
+type SRegRequest struct {
+	Value *int `json:"value"`
+}
+
+func modemState(m *vm.Modem, detail bool) ModemState {
+	m.Lock()
+	defer m.Unlock()
+	st := ModemState{Id: m.Id(), Status: m.Status().String()}
+	if info := m.CallInfo(); info != (vm.CallInfo{}) {
+		st.Call = &CallState{Number: info.Number, Name: info.Name, Source: info.Source}
+	}
+	if detail {
+		st.SRegs = make(map[string]byte)
+		for reg := range vm.SRegDescriptors() {
+			st.SRegs[strconv.Itoa(int(reg))] = m.SReg(reg)
+		}
+		imp := m.Impairment()
+		st.Impairment = &ImpairmentState{
+			LatencyMs:    int(imp.Latency / time.Millisecond),
+			JitterMs:     int(imp.Jitter / time.Millisecond),
+			BitErrorRate: imp.BitErrorRate,
+			GarbageRate:  imp.GarbageRate,
+			CarrierLossS: int(imp.CarrierLoss / time.Second),
+		}
+	}
+	return st
+}
+
+func poolState(p *Pool) PoolState {
+	st := PoolState{Name: p.Name, Addr: p.Addr, Modems: make([]ModemState, 0)}
+	for _, m := range p.modems() {
+		ms := modemState(m, false)
+		if ms.Status == vm.StatusIdle.String() {
+			st.Idle++
+		}
+		st.Modems = append(st.Modems, ms)
+	}
+	st.Busy = len(p.Modems) - st.Idle
+	return st
+}
+
+// findModem returns the running modem with the given id, or nil.
+func findModem(id string) *vm.Modem {
+	modems := bank()
+	i := slices.IndexFunc(modems, func(m *vm.Modem) bool { return m.Id() == id })
+	if i < 0 {
+		return nil
+	}
+	return modems[i]
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// modemError writes the error of a modem operation: a modem in the wrong state
+// for it is a conflict.
+func modemError(w http.ResponseWriter, err error) {
+	if errors.Is(err, vm.ErrModemBusy) || errors.Is(err, vm.ErrNoCarrier) || errors.Is(err, vm.ErrInvalidStateTransition) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// modemHandler adapts a handler of a modem, answering 404 for unknown modems.
+func modemHandler(h func(w http.ResponseWriter, r *http.Request, m *vm.Modem)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := findModem(r.PathValue("id"))
+		if m == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no modem %s", r.PathValue("id")))
+			return
+		}
+		h(w, r, m)
+	}
+}
+
+// newAPIHandler returns the handler of the control API:
+//
+//	GET  /modems                    List the modems and their status
+//	GET  /modems/{id}               Status, S-registers and impairment of a modem
+//	POST /modems/{id}/ring          Trigger an incoming call (RingRequest)
+//	POST /modems/{id}/hangup        Hang up the current call
+//	PUT  /modems/{id}/sregs/{reg}   Set an S-register (SRegRequest)
+//	PUT  /modems/{id}/impairment    Change the impairment of the next calls
+//	GET  /pools                     List the pools and their occupancy
+//	GET  /pools/{name}              Occupancy of a pool
+func newAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /modems", func(w http.ResponseWriter, r *http.Request) {
+		states := make([]ModemState, 0)
+		for _, m := range bank() {
+			states = append(states, modemState(m, false))
+		}
+		writeJSON(w, http.StatusOK, states)
+	})
+	mux.HandleFunc("GET /modems/{id}", modemHandler(func(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+		writeJSON(w, http.StatusOK, modemState(m, true))
+	}))
+	mux.HandleFunc("POST /modems/{id}/ring", modemHandler(apiRing))
+	mux.HandleFunc("POST /modems/{id}/hangup", modemHandler(func(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+		if err := m.HangupSync(); err != nil {
+			modemError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, modemState(m, false))
+	}))
+	mux.HandleFunc("PUT /modems/{id}/sregs/{reg}", modemHandler(func(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+		reg, err := strconv.ParseUint(r.PathValue("reg"), 10, 8)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid register %s", r.PathValue("reg")))
+			return
+		}
+		var req SRegRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Value == nil || *req.Value < 0 || *req.Value > 255 {
+			writeError(w, http.StatusBadRequest, errors.New("value must be between 0 and 255"))
+			return
+		}
+		m.SetSRegSync(byte(reg), byte(*req.Value))
+		writeJSON(w, http.StatusOK, modemState(m, true))
+	}))
+	mux.HandleFunc("PUT /modems/{id}/impairment", modemHandler(func(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+		// Fields not in the request keep their values
+		imp := *modemState(m, true).Impairment
+		if err := json.NewDecoder(r.Body).Decode(&imp); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		switch {
+		case imp.LatencyMs < 0 || imp.JitterMs < 0 || imp.CarrierLossS < 0:
+			writeError(w, http.StatusBadRequest, errors.New("latency, jitter and carrier loss must not be negative"))
+			return
+		case imp.BitErrorRate < 0 || imp.BitErrorRate > 1 || imp.GarbageRate < 0 || imp.GarbageRate > 1:
+			writeError(w, http.StatusBadRequest, errors.New("rates must be between 0 and 1"))
+			return
+		}
+		m.Lock()
+		cur := m.Impairment()
+		cur.Latency = time.Duration(imp.LatencyMs) * time.Millisecond
+		cur.Jitter = time.Duration(imp.JitterMs) * time.Millisecond
+		cur.BitErrorRate = imp.BitErrorRate
+		cur.GarbageRate = imp.GarbageRate
+		cur.CarrierLoss = time.Duration(imp.CarrierLossS) * time.Second
+		m.SetImpairment(cur)
+		m.Unlock()
+		writeJSON(w, http.StatusOK, modemState(m, true))
+	}))
+	mux.HandleFunc("GET /pools", func(w http.ResponseWriter, r *http.Request) {
+		states := make([]PoolState, 0)
+		for _, p := range pools {
+			states = append(states, poolState(p))
+		}
+		writeJSON(w, http.StatusOK, states)
+	})
+	mux.HandleFunc("GET /pools/{name}", func(w http.ResponseWriter, r *http.Request) {
+		p := poolNamed(r.PathValue("name"))
+		if p == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no pool %s", r.PathValue("name")))
+			return
+		}
+		writeJSON(w, http.StatusOK, poolState(p))
+	})
+	return mux
+}
+
+// ringConn returns the connection of a call triggered by the operator, to
+// target or, without one, to a line that echoes the data back, and the source
+// of the call: the remote address, or origin for the echo line.
+func ringConn(ctx context.Context, target, origin string) (io.ReadWriteCloser, string, error) {
+	if target != "" {
+		c, err := dialer.DialContext(ctx, dialAddr(target), nil)
+		if err != nil {
+			return nil, "", err
+		}
+		return c, c.RemoteAddr().String(), nil
+	}
+	// Echo the call data back
+	host, line := vm.NewLine()
+	go io.Copy(host, host)
+	return line, origin, nil
+}
+
+// apiRing triggers an incoming call on m.
+func apiRing(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+	var req RingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	conn, source, err := ringConn(r.Context(), req.Target, "api")
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	info := vm.CallInfo{Number: req.Number, Name: req.Name, Source: source}
+	if err := m.IncomingCallInfoSync(conn, info); err != nil {
+		conn.Close()
+		modemError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, modemState(m, false))
+}
+
+// enableAPI serves the control API on addr.
+func enableAPI(addr string) {
+	go func() {
+		err := http.ListenAndServe(addr, newAPIHandler())
+		if err != nil {
+			shutdown("Error starting control API server", "err", err)
+		}
+	}()
+}
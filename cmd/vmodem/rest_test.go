@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+func TestControlAPI(t *testing.T) {
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances = Options{}, nil, nil
+	}()
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	m := bank()[0]
+	srv := httptest.NewServer(newAPIHandler())
+	defer srv.Close()
+
+	do := func(method, path, body string, want int, v any) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s status = %d, want %d", method, path, resp.StatusCode, want)
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("%s %s decode error = %v", method, path, err)
+			}
+		}
+	}
+
+	var list []ModemState
+	do("GET", "/modems", "", http.StatusOK, &list)
+	if len(list) != 1 || list[0].Id != m.Id() || list[0].Status != vm.StatusIdle.String() {
+		t.Errorf("GET /modems = %+v", list)
+	}
+	do("GET", "/modems/nope", "", http.StatusNotFound, nil)
+
+	var st ModemState
+	do("PUT", "/modems/"+m.Id()+"/sregs/12", `{"value": 20}`, http.StatusOK, &st)
+	if st.SRegs["12"] != 20 || m.SRegSync(12) != 20 {
+		t.Errorf("S12 = %d, want 20", st.SRegs["12"])
+	}
+	do("PUT", "/modems/"+m.Id()+"/sregs/12", `{"value": 256}`, http.StatusBadRequest, nil)
+
+	do("PUT", "/modems/"+m.Id()+"/impairment", `{"latencyMs": 150, "bitErrorRate": 0.001}`, http.StatusOK, &st)
+	if imp := m.ImpairmentSync(); imp.Latency.Milliseconds() != 150 || imp.BitErrorRate != 0.001 {
+		t.Errorf("Impairment() = %+v", imp)
+	}
+	do("PUT", "/modems/"+m.Id()+"/impairment", `{"garbageRate": 2}`, http.StatusBadRequest, nil)
+
+	do("POST", "/modems/"+m.Id()+"/ring", `{"number": "5551212", "name": "TEST"}`, http.StatusOK, &st)
+	if st.Status != vm.StatusRinging.String() || st.Call == nil || st.Call.Number != "5551212" {
+		t.Errorf("POST ring = %+v", st)
+	}
+	do("POST", "/modems/"+m.Id()+"/ring", "", http.StatusConflict, nil)
+
+	do("POST", "/modems/"+m.Id()+"/hangup", "", http.StatusOK, &st)
+	if st.Status != vm.StatusIdle.String() {
+		t.Errorf("status after hangup = %s, want %s", st.Status, vm.StatusIdle)
+	}
+}
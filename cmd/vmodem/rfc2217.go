@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// RFC 2217 server: with --rfc2217 the TTY of a modem is a Telnet server with
+// the COM port control option, which remote machines open as a serial port
+// attached to the modem, with socat, ttynvt or hub4com, or terminal programs
+// speaking RFC 2217. The serial settings of the client are kept and reported
+// back, and the modem status drives the modem state lines: DCD while in a
+// call and RI while ringing, with DSR and CTS always on.
+
+// rfc2217Signature identifies the server to clients asking for it.
+const rfc2217Signature = "vmodem"
+
+// COM port control commands sent by the client. The server answers with the
+// same command plus comServerOffset.
+const (
+	comSignature         = 0
+	comSetBaudRate       = 1
+	comSetDataSize       = 2
+	comSetParity         = 3
+	comSetStopSize       = 4
+	comSetControl        = 5
+	comNotifyLineState   = 6
+	comNotifyModemState  = 7
+	comFlowSuspend       = 8
+	comFlowResume        = 9
+	comSetLineStateMask  = 10
+	comSetModemStateMask = 11
+	comPurgeData         = 12
+
+	comServerOffset = 100
+)
+
+// Modem state lines and their changes in NOTIFY-MODEMSTATE.
+const (
+	modemStateDeltaCTS   = 0x01
+	modemStateDeltaDSR   = 0x02
+	modemStateTrailingRI = 0x04
+	modemStateDeltaDCD   = 0x08
+	modemStateCTS        = 0x10
+	modemStateDSR        = 0x20
+	modemStateRI         = 0x40
+	modemStateDCD        = 0x80
+)
+
+// comPort holds the serial settings of an RFC 2217 port, in the values of the
+// protocol.
+type comPort struct {
+	baud      uint32
+	dataSize  byte // 5 to 8 bits
+	parity    byte // 1 none, 2 odd, 3 even, 4 mark, 5 space
+	stopSize  byte // 1 one, 2 two, 3 one and a half
+	flow      byte // Outbound flow control, SET-CONTROL 1-3 or 17-19
+	inFlow    byte // Inbound flow control, SET-CONTROL 14-16
+	brk       bool
+	dtr, rts  bool
+	lineMask  byte
+	modemMask byte
+}
+
+// defaultComPort is the setting of a port before the client changes it:
+// 9600 8N1 without flow control, and DTR and RTS on.
+var defaultComPort = comPort{baud: 9600, dataSize: 8, parity: 1, stopSize: 1, flow: 1, inFlow: 14,
+	dtr: true, rts: true, modemMask: 0xff}
+
+// rfc2217Device is the TTY of a modem served over RFC 2217 on a TCP listener,
+// to one client at a time like a serial line. When the client disconnects the
+// next one is waited for, and output is discarded while no client is
+// connected. Dropping DTR, or the connection, drops the DTR of the modem, which
+// acts on it per AT&D.
+type rfc2217Device struct {
+	id        string
+	l         net.Listener
+	mu        sync.Mutex
+	cond      *sync.Cond  // Broadcast when output resumes or the client goes
+	conn      *telnetConn // Current client, nil if none
+	port      comPort
+	suspended bool // The client suspended output
+	notified  bool // The client got the modem state
+	state     byte // Modem state lines
+	modem     *vm.Modem
+}
+
+func newRFC2217Device(id, addr string) (*rfc2217Device, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	d := &rfc2217Device{id: id, l: l, port: defaultComPort, state: modemStateDSR | modemStateCTS}
+	d.cond = sync.NewCond(&d.mu)
+	return d, nil
+}
+
+// Name returns the address the server listens on.
+func (d *rfc2217Device) Name() string {
+	return d.l.Addr().String()
+}
+
+// client returns the connected client, waiting for one if there is none. The
+// server echoes, as the modem does, and asks for a binary session with COM
+// port control.
+func (d *rfc2217Device) client() (*telnetConn, error) {
+	d.mu.Lock()
+	c := d.conn
+	d.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	for {
+		conn, err := d.l.Accept()
+		if err != nil {
+			return nil, err
+		}
+		c = newTelnetConn(conn)
+		c.will = func(opt byte) bool {
+			return opt == telnetOptBinary || opt == telnetOptSGA || opt == telnetOptComPort
+		}
+		c.do = func(opt byte) bool {
+			return opt == telnetOptBinary || opt == telnetOptSGA || opt == telnetOptEcho
+		}
+		c.sub = func(opt byte, data []byte) error {
+			if opt != telnetOptComPort || len(data) == 0 {
+				return nil
+			}
+			return d.command(c, data[0], data[1:])
+		}
+		if err := d.negotiate(c); err != nil {
+			conn.Close()
+			continue
+		}
+		modemLog(d.id).Info("RFC 2217 client connected", "addr", conn.RemoteAddr())
+		d.mu.Lock()
+		d.conn = c
+		d.port.dtr, d.port.rts, d.port.brk = true, true, false
+		d.port.lineMask, d.port.modemMask = 0, 0xff
+		d.suspended, d.notified = false, false
+		d.mu.Unlock()
+		d.setDTR(true)
+		return c, nil
+	}
+}
+
+// negotiate opens the option negotiation with a new client.
+func (d *rfc2217Device) negotiate(c *telnetConn) error {
+	for _, r := range [][2]byte{
+		{telnetWILL, telnetOptEcho}, {telnetWILL, telnetOptSGA}, {telnetDO, telnetOptSGA},
+		{telnetWILL, telnetOptBinary}, {telnetDO, telnetOptBinary}, {telnetDO, telnetOptComPort},
+	} {
+		if err := c.request(r[0], r[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drop disconnects the client c, which drops DTR.
+func (d *rfc2217Device) drop(c *telnetConn) {
+	d.mu.Lock()
+	current := d.conn == c
+	if current {
+		d.conn = nil
+		d.cond.Broadcast()
+	}
+	d.mu.Unlock()
+	c.Close()
+	if current {
+		modemLog(d.id).Info("RFC 2217 client disconnected", "addr", c.RemoteAddr())
+		d.setDTR(false)
+	}
+}
+
+// command answers the COM port control command cmd of the client c.
+func (d *rfc2217Device) command(c *telnetConn, cmd byte, arg []byte) error {
+	d.mu.Lock()
+	p := &d.port
+	var reply []byte
+	dtr, baud := p.dtr, p.baud
+	switch cmd {
+	case comSignature:
+		if len(arg) > 0 {
+			d.mu.Unlock()
+			modemLog(d.id).Debug("RFC 2217 client signature", "signature", string(arg))
+			return nil
+		}
+		reply = []byte(rfc2217Signature)
+	case comSetBaudRate:
+		if len(arg) == 4 && binary.BigEndian.Uint32(arg) != 0 {
+			p.baud = binary.BigEndian.Uint32(arg)
+		}
+		reply = binary.BigEndian.AppendUint32(nil, p.baud)
+	case comSetDataSize:
+		reply = []byte{comSetting(&p.dataSize, arg, 5, 8)}
+	case comSetParity:
+		reply = []byte{comSetting(&p.parity, arg, 1, 5)}
+	case comSetStopSize:
+		reply = []byte{comSetting(&p.stopSize, arg, 1, 3)}
+	case comSetControl:
+		if len(arg) == 1 {
+			reply = []byte{p.control(arg[0])}
+		}
+	case comFlowSuspend, comFlowResume:
+		d.suspended = cmd == comFlowSuspend
+		d.cond.Broadcast()
+	case comSetLineStateMask:
+		if len(arg) == 1 {
+			p.lineMask = arg[0]
+			reply = arg
+		}
+	case comSetModemStateMask:
+		if len(arg) == 1 {
+			p.modemMask = arg[0]
+			reply = arg
+			d.notified = false
+		}
+	case comPurgeData:
+		// Nothing is buffered
+		if len(arg) == 1 {
+			reply = arg
+		}
+	}
+	if cmd >= comSetBaudRate && cmd <= comSetStopSize {
+		modemLog(d.id).Debug("RFC 2217 serial settings", "baud", p.baud, "data", p.dataSize,
+			"parity", p.parity, "stop", p.stopSize)
+	}
+	dtrChanged := dtr != p.dtr
+	dtr = p.dtr
+	notify := !d.notified
+	d.notified = true
+	state := d.state & p.modemMask
+	m := d.modem
+	if p.baud == baud || !options.FollowTTYSpeed {
+		m = nil
+	}
+	baud = p.baud
+	d.mu.Unlock()
+
+	if reply != nil {
+		if err := c.subnegotiate(telnetOptComPort, append([]byte{cmd + comServerOffset}, reply...)...); err != nil {
+			return err
+		}
+	}
+	if notify {
+		if err := c.subnegotiate(telnetOptComPort, comNotifyModemState+comServerOffset, state); err != nil {
+			return err
+		}
+	}
+	if m != nil {
+		// The modem lock may be held by a write waiting for the client
+		modemLog(d.id).Info("TTY speed changed", "speed", baud)
+		go m.SetLineSpeedSync(int(baud))
+	}
+	if dtrChanged {
+		modemLog(d.id).Debug("RFC 2217 client set DTR", "dtr", dtr)
+		d.setDTR(dtr)
+	}
+	return nil
+}
+
+// comSetting sets *v to the value in arg if it is within min and max, leaving
+// it as it is for queries (0) and invalid values, and returns it.
+func comSetting(v *byte, arg []byte, min, max byte) byte {
+	if len(arg) == 1 && arg[0] >= min && arg[0] <= max {
+		*v = arg[0]
+	}
+	return *v
+}
+
+// control applies the SET-CONTROL value v and returns the answer: the setting
+// asked for by queries, and v otherwise.
+func (p *comPort) control(v byte) byte {
+	onOff := func(on bool, yes, no byte) byte {
+		if on {
+			return yes
+		}
+		return no
+	}
+	switch {
+	case v == 0:
+		return p.flow
+	case v <= 3 || v >= 17 && v <= 19:
+		p.flow = v
+	case v == 4:
+		return onOff(p.brk, 5, 6)
+	case v == 5 || v == 6:
+		p.brk = v == 5
+	case v == 7:
+		return onOff(p.dtr, 8, 9)
+	case v == 8 || v == 9:
+		p.dtr = v == 8
+	case v == 10:
+		return onOff(p.rts, 11, 12)
+	case v == 11 || v == 12:
+		p.rts = v == 11
+	case v == 13:
+		return p.inFlow
+	case v <= 16:
+		p.inFlow = v
+	}
+	return v
+}
+
+// setDTR sets the DTR line of the modem from the client.
+func (d *rfc2217Device) setDTR(on bool) {
+	d.mu.Lock()
+	m := d.modem
+	d.mu.Unlock()
+	if m != nil {
+		// The modem lock may be held by a write waiting for the client
+		go m.SetDTRSync(on)
+	}
+}
+
+// watch drives the modem state lines from the status of m.
+func (d *rfc2217Device) watch(m *vm.Modem) {
+	d.mu.Lock()
+	d.modem = m
+	d.mu.Unlock()
+	_, changes := m.SubscribeSync()
+	go func() {
+		for change := range changes {
+			d.setModemState(change.To)
+		}
+	}()
+}
+
+// setModemState sets the modem state lines for the modem status, notifying
+// the client of the changes it asked for.
+func (d *rfc2217Device) setModemState(status vm.ModemStatus) {
+	state := byte(modemStateDSR | modemStateCTS)
+	switch status {
+	case vm.StatusConnected, vm.StatusConnectedCmd:
+		state |= modemStateDCD
+	case vm.StatusRinging:
+		state |= modemStateRI
+	case vm.StatusClosed:
+		state = 0
+	}
+	d.mu.Lock()
+	old := d.state
+	d.state = state
+	c, mask := d.conn, d.port.modemMask
+	d.mu.Unlock()
+
+	var delta byte
+	if (old^state)&modemStateDCD != 0 {
+		delta |= modemStateDeltaDCD
+	}
+	if old&modemStateRI != 0 && state&modemStateRI == 0 {
+		delta |= modemStateTrailingRI
+	}
+	if (old^state)&modemStateDSR != 0 {
+		delta |= modemStateDeltaDSR
+	}
+	if (old^state)&modemStateCTS != 0 {
+		delta |= modemStateDeltaCTS
+	}
+	if c == nil || !c.enabled(telnetOptComPort) || ((old^state)|delta)&mask == 0 {
+		return
+	}
+	c.subnegotiate(telnetOptComPort, comNotifyModemState+comServerOffset, (state|delta)&mask)
+}
+
+func (d *rfc2217Device) Read(b []byte) (int, error) {
+	for {
+		c, err := d.client()
+		if err != nil {
+			return 0, err
+		}
+		n, err := c.Read(b)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+		d.drop(c)
+	}
+}
+
+// Write sends b to the client, waiting while it suspends output.
+func (d *rfc2217Device) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	for d.suspended && d.conn != nil {
+		d.cond.Wait()
+	}
+	c := d.conn
+	d.mu.Unlock()
+	if c == nil {
+		return len(b), nil
+	}
+	if _, err := c.Write(b); err != nil {
+		d.drop(c)
+	}
+	return len(b), nil
+}
+
+func (d *rfc2217Device) Close() error {
+	err := d.l.Close()
+	d.mu.Lock()
+	c := d.conn
+	d.conn = nil
+	d.cond.Broadcast()
+	d.mu.Unlock()
+	if c != nil {
+		c.Close()
+	}
+	return err
+}
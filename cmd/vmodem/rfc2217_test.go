@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// readComPort reads from r, skipping data and other Telnet commands, until the
+// COM port subnegotiation with the server command cmd, and returns its data.
+func readComPort(t *testing.T, r *bufio.Reader, cmd byte) []byte {
+	t.Helper()
+	for {
+		ch, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("reading command %d: %v", cmd, err)
+		}
+		if ch != telnetIAC {
+			continue
+		}
+		if ch, _ = r.ReadByte(); ch != telnetSB {
+			continue
+		}
+		var data []byte
+		for {
+			ch, err := r.ReadByte()
+			if err != nil {
+				t.Fatalf("reading command %d: %v", cmd, err)
+			}
+			if ch == telnetIAC {
+				if ch, _ = r.ReadByte(); ch == telnetSE {
+					break
+				}
+			}
+			data = append(data, ch)
+		}
+		if len(data) >= 2 && data[0] == telnetOptComPort && data[1] == cmd {
+			return data[2:]
+		}
+	}
+}
+
+// Test a modem served over RFC 2217 negotiates the serial settings, follows
+// the baud rate, reports the modem state lines and hangs up when the client
+// drops DTR
+func TestRFC2217(t *testing.T) {
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true, FollowTTYSpeed: true}
+	specs = []ModemSpec{{Name: "tty0", RFC2217: "127.0.0.1:0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances = Options{}, nil, nil
+	}()
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	m := bank()[0]
+	conn, err := net.Dial("tcp", instances[0].devs[0].Name())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	want := []byte{telnetIAC, telnetWILL, telnetOptEcho, telnetIAC, telnetWILL, telnetOptSGA, telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetWILL, telnetOptBinary, telnetIAC, telnetDO, telnetOptBinary, telnetIAC, telnetDO, telnetOptComPort}
+	// The server reads once it has a client
+	conn.Write([]byte{telnetIAC, telnetWILL, telnetOptComPort})
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("negotiation = %v, %v, want %v", got, err, want)
+	}
+
+	// 38400 bps, 7E1, then queries
+	conn.Write([]byte{telnetIAC, telnetSB, telnetOptComPort, comSetBaudRate, 0, 0, 0x96, 0, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comSetDataSize, 7, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comSetParity, 3, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comSetBaudRate, 0, 0, 0, 0, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comSetControl, 7, telnetIAC, telnetSE})
+	if b := readComPort(t, r, comSetBaudRate+comServerOffset); !bytes.Equal(b, []byte{0, 0, 0x96, 0}) {
+		t.Errorf("baud rate = %v, want 38400", b)
+	}
+	if s := readComPort(t, r, comNotifyModemState+comServerOffset); !bytes.Equal(s, []byte{modemStateDSR | modemStateCTS}) {
+		t.Errorf("modem state = %v, want DSR and CTS", s)
+	}
+	if b := readComPort(t, r, comSetDataSize+comServerOffset); !bytes.Equal(b, []byte{7}) {
+		t.Errorf("data size = %v, want 7", b)
+	}
+	if b := readComPort(t, r, comSetParity+comServerOffset); !bytes.Equal(b, []byte{3}) {
+		t.Errorf("parity = %v, want even", b)
+	}
+	if b := readComPort(t, r, comSetBaudRate+comServerOffset); !bytes.Equal(b, []byte{0, 0, 0x96, 0}) {
+		t.Errorf("queried baud rate = %v, want 38400", b)
+	}
+	if b := readComPort(t, r, comSetControl+comServerOffset); !bytes.Equal(b, []byte{8}) {
+		t.Errorf("DTR = %v, want on", b)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for m.LineSpeedSync() != 38400 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if speed := m.LineSpeedSync(); speed != 38400 {
+		t.Errorf("LineSpeedSync() = %d, want 38400", speed)
+	}
+
+	// Commands go through the Telnet session
+	conn.Write([]byte("ATI0\r"))
+	if line, err := r.ReadString('K'); err != nil || !bytes.Contains([]byte(line), []byte("OK")) {
+		t.Errorf("ATI0 answer = %q, %v", line, err)
+	}
+
+	host, line := net.Pipe()
+	defer host.Close()
+	go io.Copy(io.Discard, host)
+	if err := m.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if s := readComPort(t, r, comNotifyModemState+comServerOffset); !bytes.Equal(s, []byte{modemStateRI | modemStateDSR | modemStateCTS}) {
+		t.Errorf("ringing modem state = %v, want RI, DSR and CTS", s)
+	}
+	if _, err := m.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	wantState := byte(modemStateDCD | modemStateDSR | modemStateCTS | modemStateDeltaDCD | modemStateTrailingRI)
+	if s := readComPort(t, r, comNotifyModemState+comServerOffset); !bytes.Equal(s, []byte{wantState}) {
+		t.Errorf("connected modem state = %v, want %v", s, wantState)
+	}
+
+	// Dropping DTR hangs up
+	conn.Write([]byte{telnetIAC, telnetSB, telnetOptComPort, comSetControl, 9, telnetIAC, telnetSE})
+	if b := readComPort(t, r, comSetControl+comServerOffset); !bytes.Equal(b, []byte{9}) {
+		t.Errorf("DTR = %v, want off", b)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for m.StatusSync() != vm.StatusIdle && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.StatusSync() != vm.StatusIdle {
+		t.Errorf("status = %v after dropping DTR, want idle", m.StatusSync())
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Route rewrites the dial strings matching a regexp into a dial target, such as
+// tcp://bbs$1.example.net:23, tls://..., telnet://... or a plain host:port.
+// $1, ${1} or ${name} in the target expand to the groups of the match, e.g.
+// ^1?555(\d{4})$ routes 5551234 to bbs1234.example.net:23 with bbs$1.example.net:23.
+type Route struct {
+	Target string
+	Bind   string
+	re     *regexp.Regexp
+}
+
+// NewRoute creates a route of the dial strings matching reStr to target.
+func NewRoute(reStr, target string) (*Route, error) {
+	re, err := regexp.Compile(reStr)
+	if err != nil {
+		return nil, err
+	}
+	return &Route{Target: target, re: re}, nil
+}
+
+// Match returns the target number is routed to, or an empty string if the
+// route does not match it.
+func (r *Route) Match(number string) string {
+	loc := r.re.FindStringSubmatchIndex(number)
+	if loc == nil {
+		return ""
+	}
+	return string(r.re.ExpandString(nil, r.Target, number, loc))
+}
+
+// routes builds the routing rules from the --route options.
+func routes() error {
+	dialRoutes = nil
+	for _, s := range options.Route {
+		parts := strings.Split(s, "->")
+		if len(parts) != 2 && len(parts) != 3 {
+			return fmt.Errorf("invalid route: %s", s)
+		}
+		r, err := NewRoute(parts[0], parts[1])
+		if err != nil {
+			return fmt.Errorf("route %s: %v", s, err)
+		}
+		if len(parts) == 3 {
+			r.Bind = parts[2]
+		}
+		dialRoutes = append(dialRoutes, r)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		re, target, number, want string
+	}{
+		{`^1?555(\d{4})$`, "bbs$1.example.net:23", "5551234", "bbs1234.example.net:23"},
+		{`^1?555(\d{4})$`, "bbs$1.example.net:23", "15559876", "bbs9876.example.net:23"},
+		{`^1?555(\d{4})$`, "bbs$1.example.net:23", "5551", ""},
+		{`^9(?P<port>\d+)$`, "telnet://bbs.example.net:${port}", "96400", "telnet://bbs.example.net:6400"},
+		{`^#(\w+)$`, "tls://${1}.example.net:992", "#mud", "tls://mud.example.net:992"},
+	}
+	for _, tt := range tests {
+		r, err := NewRoute(tt.re, tt.target)
+		if err != nil {
+			t.Fatalf("NewRoute(%s) error = %v", tt.re, err)
+		}
+		if got := r.Match(tt.number); got != tt.want {
+			t.Errorf("Route(%s -> %s).Match(%s) = %q, want %q", tt.re, tt.target, tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestRoutesPrecedence(t *testing.T) {
+	options = Options{
+		Route:     []string{`^555(\d{4})$->tcp://bbs$1.example.net:23->127.0.0.1`},
+		Translate: []string{`^555(\d{4})$->translated.example.net:23`},
+	}
+	defer func() {
+		options, dialRoutes, numToHosts = Options{}, nil, nil
+	}()
+	if err := phoneTranslations(); err != nil {
+		t.Fatal(err)
+	}
+	if err := routes(); err != nil {
+		t.Fatal(err)
+	}
+	if host, bind := findHost("5551234"); host != "tcp://bbs1234.example.net:23" || bind != "127.0.0.1" {
+		t.Errorf("findHost() = %s, %s, want the route", host, bind)
+	}
+
+	options.Route = []string{"^(5->x"}
+	if err := routes(); err == nil {
+		t.Errorf("routes() with an invalid regexp succeeded")
+	}
+}
+
+func TestParsePlainTargets(t *testing.T) {
+	options.DefaultPort = "2020"
+	defer func() { options = Options{} }()
+	tests := []struct{ target, want string }{
+		{"tcp://bbs.example.net:23", "bbs.example.net:23"},
+		{"tcp://bbs.example.net", "bbs.example.net:2020"},
+		{"telnet://bbs.example.net", "bbs.example.net:23"},
+		{"telnet://bbs.example.net:", "bbs.example.net:23"},
+		{"telnet://bbs.example.net:6400", "bbs.example.net:6400"},
+	}
+	for _, tt := range tests {
+		if addr, config, err := parseTarget(tt.target); err != nil || config != nil || addr != tt.want {
+			t.Errorf("parseTarget(%s) = %s, %v, %v, want %s", tt.target, addr, config, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sshScheme is the prefix of the dial targets reached over SSH.
+const sshScheme = "ssh://"
+
+// sshPasswordEnv passes the password of an ssh:// call to vmodem itself, which
+// ssh runs as its SSH_ASKPASS program.
+const sshPasswordEnv = "VMODEM_SSH_PASSWORD"
+
+// sshProgram is the OpenSSH client run by ssh:// calls.
+var sshProgram = "ssh"
+
+// sshTarget is an ssh:// dial target:
+// ssh://[user[:password]@]host[:port][?options], where the options are key
+// (private key file), known-hosts (known hosts file), insecure (accept any
+// host key), command (run instead of a shell) and term (terminal type of the
+// session, vt100 by default), e.g.
+// ssh://bob@shell.example.com?key=/etc/vmodem/id_ed25519&term=ansi
+type sshTarget struct {
+	user, password   string
+	host, port       string
+	key, knownHosts  string
+	command, term    string
+	insecure         bool
+	passwordRequired bool
+}
+
+// parseSSHTarget parses an ssh:// dial target.
+func parseSSHTarget(target string) (*sshTarget, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in %s", target)
+	}
+	t := &sshTarget{host: u.Hostname(), port: u.Port(), term: "vt100"}
+	if t.port == "" {
+		t.port = "22"
+	}
+	if u.User != nil {
+		t.user = u.User.Username()
+		t.password, t.passwordRequired = u.User.Password()
+	}
+	q := u.Query()
+	for k := range q {
+		switch k {
+		case "key", "known-hosts", "insecure", "command", "term":
+		default:
+			return nil, fmt.Errorf("unknown SSH option %s", k)
+		}
+	}
+	t.key, t.knownHosts, t.command = q.Get("key"), q.Get("known-hosts"), q.Get("command")
+	if term := q.Get("term"); term != "" {
+		t.term = term
+	}
+	if v := q.Get("insecure"); v != "" {
+		if t.insecure, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid insecure value %s", v)
+		}
+	}
+	return t, nil
+}
+
+// addr returns the host:port of the SSH server.
+func (t *sshTarget) addr() string {
+	return net.JoinHostPort(t.host, t.port)
+}
+
+// cmd returns the ssh command opening the session from localAddr, if not nil.
+// The session gets a terminal and no escape character, so the call is
+// transparent. Host keys are checked without asking, and without a password
+// ssh never prompts.
+func (t *sshTarget) cmd(localAddr net.Addr) *exec.Cmd {
+	args := []string{"-tt", "-e", "none", "-p", t.port}
+	if t.user != "" {
+		args = append(args, "-l", t.user)
+	}
+	if options.ConnectTimeout > 0 {
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", options.ConnectTimeout))
+	}
+	if addr, ok := localAddr.(*net.TCPAddr); ok {
+		args = append(args, "-b", addr.IP.String())
+	}
+	if t.key != "" {
+		args = append(args, "-i", t.key, "-o", "IdentitiesOnly=yes")
+	}
+	switch {
+	case t.insecure:
+		args = append(args, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile="+os.DevNull)
+	case t.knownHosts != "":
+		args = append(args, "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile="+t.knownHosts)
+	default:
+		args = append(args, "-o", "StrictHostKeyChecking=yes")
+	}
+	env := append(os.Environ(), "TERM="+t.term)
+	if t.passwordRequired {
+		auth := "keyboard-interactive,password"
+		if t.key != "" {
+			auth = "publickey," + auth
+		}
+		args = append(args, "-o", "PreferredAuthentications="+auth, "-o", "NumberOfPasswordPrompts=1")
+		if exe, err := os.Executable(); err == nil {
+			env = append(env, "SSH_ASKPASS="+exe, "SSH_ASKPASS_REQUIRE=force", sshPasswordEnv+"="+t.password)
+		}
+	} else {
+		args = append(args, "-o", "BatchMode=yes")
+	}
+	args = append(args, "--", t.host)
+	if t.command != "" {
+		args = append(args, t.command)
+	}
+	cmd := exec.Command(sshProgram, args...)
+	cmd.Env = env
+	return cmd
+}
+
+// dialSSH opens an SSH session to target from localAddr and returns it as the
+// call. The call connects once the host sends data; a session ending before
+// that fails like a connect, with the reason ssh gives.
+func dialSSH(ctx context.Context, target string, localAddr net.Addr) (io.ReadWriteCloser, error) {
+	t, err := parseSSHTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	p, err := startProcess(t.cmd(localAddr))
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		n   int
+		err error
+	}
+	first := make([]byte, 4096)
+	done := make(chan result, 1)
+	go func() {
+		n, err := p.Read(first)
+		done <- result{n, err}
+	}()
+	select {
+	case <-ctx.Done():
+		p.Close()
+		return nil, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			p.Close()
+			return nil, sshError(p.exitError())
+		}
+		return &sshConn{procConn: p, first: first[:r.n]}, nil
+	}
+}
+
+// sshError maps the connect failures ssh reports to the network errors they
+// stand for, so the call ends with the usual result code.
+func sshError(err error) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "Connection refused"):
+		return fmt.Errorf("%w: %s", syscall.ECONNREFUSED, msg)
+	case strings.Contains(msg, "timed out"):
+		return fmt.Errorf("%w: %s", context.DeadlineExceeded, msg)
+	case strings.Contains(msg, "No route to host"):
+		return fmt.Errorf("%w: %s", syscall.EHOSTUNREACH, msg)
+	}
+	return err
+}
+
+// sshConn is an SSH session, starting with the data read while connecting.
+type sshConn struct {
+	*procConn
+	first []byte
+}
+
+func (c *sshConn) Read(b []byte) (int, error) {
+	if len(c.first) > 0 {
+		n := copy(b, c.first)
+		c.first = c.first[n:]
+		return n, nil
+	}
+	return c.procConn.Read(b)
+}
+
+// sshAskpass answers the password prompt of ssh when vmodem runs as its
+// SSH_ASKPASS program, and reports whether it did.
+func sshAskpass() bool {
+	password, ok := os.LookupEnv(sshPasswordEnv)
+	if !ok {
+		return false
+	}
+	fmt.Println(password)
+	return true
+}
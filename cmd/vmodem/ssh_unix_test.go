@@ -0,0 +1,60 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// fakeSSH makes ssh:// calls run script in place of ssh.
+func fakeSSH(t *testing.T, script string) {
+	path := filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	saved := sshProgram
+	sshProgram = path
+	t.Cleanup(func() { sshProgram = saved })
+}
+
+func TestDialSSH(t *testing.T) {
+	fakeSSH(t, `echo "$TERM login:"; exec cat`)
+	ctx, stop := context.WithTimeout(context.Background(), 2*time.Second)
+	defer stop()
+	conn, err := dialSSH(ctx, "ssh://bob@shell.example.com", nil)
+	if err != nil {
+		t.Fatalf("dialSSH() error = %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("bob\n"))
+	buf := make([]byte, 32)
+	n, err := io.ReadAtLeast(conn, buf, len("vt100 login:\nbob\n"))
+	if err != nil || string(buf[:n]) != "vt100 login:\nbob\n" {
+		t.Errorf("session = %q, %v", buf[:n], err)
+	}
+}
+
+func TestDialSSHFailure(t *testing.T) {
+	fakeSSH(t, `echo "ssh: connect to host shell.example.com port 22: Connection refused" >&2; exit 255`)
+	_, err := dialSSH(context.Background(), "ssh://shell.example.com", nil)
+	if err = callError(err); !errors.Is(err, vm.ErrRemoteBusy) || !strings.Contains(err.Error(), "Connection refused") {
+		t.Errorf("dialSSH() error = %v, want busy", err)
+	}
+
+	// Hung sessions are abandoned at the connect timeout
+	fakeSSH(t, `exec sleep 10`)
+	ctx, stop := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer stop()
+	if _, err := dialSSH(ctx, "ssh://shell.example.com", nil); !errors.Is(callError(err), vm.ErrNoAnswer) {
+		t.Errorf("dialSSH() error = %v, want no answer", err)
+	}
+}
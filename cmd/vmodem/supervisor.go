@@ -0,0 +1,566 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+	t "github.com/nayarsystems/iotrace"
+)
+
+const (
+	// restartDelay is the delay before a failed modem is restarted, doubled after
+	// each failed restart up to maxRestartDelay
+	restartDelay    = time.Second
+	maxRestartDelay = time.Minute
+	// ttyPoll is how often the DTR line and the baud rate of the TTYs are checked
+	ttyPoll = 250 * time.Millisecond
+)
+
+// ModemSpec configures one modem of the bank.
+type ModemSpec struct {
+	// Name is the name of the TTY under the TTY path, and the modem Id (default: ttyN)
+	Name string `yaml:"name"`
+	// Listen is the address of a TCP or unix: listener for the incoming calls of
+	// this modem alone (optional). Modems without one share the main listener
+	Listen string `yaml:"listen"`
+	// Init lists AT commands run after the global init commands
+	Init []string `yaml:"init"`
+	// Link is a stable symlink to the TTY, e.g. /dev/ttyVM0 (optional)
+	Link string `yaml:"link"`
+	// Com is the com0com port the modem opens on Windows instead of a named pipe,
+	// e.g. COM10 when applications open its pair COM11 (optional)
+	Com string `yaml:"com"`
+	// Device is a real serial device used as the DTE side instead of a TTY,
+	// in the --device format (optional)
+	Device string `yaml:"device"`
+	// RFC2217 is the host:port of an RFC 2217 server serving the DTE side over
+	// the network instead of a TTY (optional)
+	RFC2217 string `yaml:"rfc2217"`
+	// AnswerExec is the program run on the TTY for each answered call, instead
+	// of --answer-exec (optional)
+	AnswerExec string `yaml:"answer-exec"`
+}
+
+// instance is a running modem of the bank with its TTY devices.
+type instance struct {
+	modem *vm.Modem
+	devs  []Device
+}
+
+func (inst *instance) close() {
+	inst.modem.CloseSync()
+	inst.closeDevs()
+}
+
+func (inst *instance) closeDevs() {
+	for _, d := range inst.devs {
+		d.Close()
+	}
+}
+
+var (
+	bankMu       sync.Mutex
+	specs        []ModemSpec // Modems of the bank, set by configure
+	configModems []ModemSpec // Modems listed in the config file
+	instances    []*instance
+	listeners    []net.Listener
+)
+
+// bankSpecs returns the modems of the bank: those of the config file, or
+// options.NumTTYs modems otherwise.
+func bankSpecs() ([]ModemSpec, error) {
+	if len(configModems) == 0 {
+		s := make([]ModemSpec, options.NumTTYs)
+		for i := range s {
+			s[i].Name = fmt.Sprintf("tty%d", options.StartNum+i)
+		}
+		return addLinks(s)
+	}
+	s := make([]ModemSpec, len(configModems))
+	names := make(map[string]bool)
+	addrs := make(map[string]bool)
+	for _, addr := range bankAddrs() {
+		addrs[addr] = true
+	}
+	for i, spec := range configModems {
+		if spec.Name == "" {
+			spec.Name = fmt.Sprintf("tty%d", options.StartNum+i)
+		}
+		if strings.ContainsRune(spec.Name, '/') || names[spec.Name] {
+			return nil, fmt.Errorf("invalid or duplicate modem name %q", spec.Name)
+		}
+		names[spec.Name] = true
+		if spec.Listen != "" {
+			if err := checkListenAddr(spec.Listen); err != nil {
+				return nil, fmt.Errorf("%s: listen: %v", spec.Name, err)
+			}
+			if addrs[spec.Listen] {
+				return nil, fmt.Errorf("%s: listen address %s already in use", spec.Name, spec.Listen)
+			}
+			addrs[spec.Listen] = true
+		}
+		s[i] = spec
+	}
+	return addLinks(s)
+}
+
+// addLinks sets the --link symlinks, --com ports, --device serial devices and
+// --rfc2217 servers on the modems of s, in order, and checks they are unique.
+func addLinks(s []ModemSpec) ([]ModemSpec, error) {
+	fields := []struct {
+		what  string
+		opts  []string
+		field func(*ModemSpec) *string
+	}{
+		{"link", options.Link, func(spec *ModemSpec) *string { return &spec.Link }},
+		{"COM port", options.Com, func(spec *ModemSpec) *string { return &spec.Com }},
+		{"device", options.Device, func(spec *ModemSpec) *string { return &spec.Device }},
+		{"RFC 2217 address", options.RFC2217, func(spec *ModemSpec) *string { return &spec.RFC2217 }},
+	}
+	for _, f := range fields {
+		if len(f.opts) > len(s) {
+			return nil, fmt.Errorf("%d %ss for %d modems", len(f.opts), f.what, len(s))
+		}
+		seen := make(map[string]bool)
+		for i := range s {
+			v := f.field(&s[i])
+			if i < len(f.opts) {
+				*v = f.opts[i]
+			}
+			if *v == "" {
+				continue
+			}
+			key := *v
+			if f.what == "device" {
+				path, _, _, err := parseDevice(key)
+				if err != nil {
+					return nil, fmt.Errorf("%s: device: %v", s[i].Name, err)
+				}
+				key = path
+			}
+			if seen[key] {
+				return nil, fmt.Errorf("duplicate %s %s", f.what, key)
+			}
+			seen[key] = true
+		}
+	}
+	return s, nil
+}
+
+// bank returns the running modems, in the order of specs.
+func bank() []*vm.Modem {
+	bankMu.Lock()
+	defer bankMu.Unlock()
+	modems := make([]*vm.Modem, 0, len(instances))
+	for _, inst := range instances {
+		if inst != nil {
+			modems = append(modems, inst.modem)
+		}
+	}
+	return modems
+}
+
+// sharedModems returns the running modems answering calls of the main listener:
+// those without a listener of their own or of their pool.
+func sharedModems() []*vm.Modem {
+	bankMu.Lock()
+	defer bankMu.Unlock()
+	var modems []*vm.Modem
+	for i, inst := range instances {
+		if inst != nil && specs[i].Listen == "" && !pooledListen(specs[i].Name) {
+			modems = append(modems, inst.modem)
+		}
+	}
+	return modems
+}
+
+// ttyPath returns the path of the TTY of spec with the given suffix.
+func ttyPath(spec ModemSpec, suffix string) string {
+	return filepath.Join(options.TtyPath, spec.Name+suffix)
+}
+
+// startBank starts all the modems of the bank.
+func startBank() error {
+	bankMu.Lock()
+	instances = make([]*instance, len(specs))
+	bankMu.Unlock()
+	for i := range specs {
+		inst, err := startInstance(i)
+		if err != nil {
+			return err
+		}
+		bankMu.Lock()
+		instances[i] = inst
+		bankMu.Unlock()
+	}
+	return nil
+}
+
+// watchTTY polls the DTE side of the modem m on tty until the modem is closed:
+// DTR, which the modem acts on per AT&D, and with --follow-tty-speed the baud
+// rate the DTE sets, e.g. with stty, to pace the calls to it. Terminals are not
+// notified of these changes, so they are polled.
+func watchTTY(m *vm.Modem, tty Device) {
+	lines := dteLines(tty)
+	if lines == nil {
+		return
+	}
+	dtr, speed, err := lines()
+	if err != nil {
+		modemLog(m.Id()).Debug("Cannot watch the TTY", "err", err)
+		return
+	}
+	m.SetDTRSync(dtr)
+	follow := options.FollowTTYSpeed
+	go func() {
+		tick := time.NewTicker(ttyPoll)
+		defer tick.Stop()
+		for range tick.C {
+			if m.StatusSync() == vm.StatusClosed {
+				return
+			}
+			d, s, err := lines()
+			if err != nil {
+				return
+			}
+			if d != dtr {
+				dtr = d
+				modemLog(m.Id()).Debug("DTR changed", "dtr", d)
+				m.SetDTRSync(d)
+			}
+			if follow && s != speed && s != 0 {
+				speed = s
+				modemLog(m.Id()).Info("TTY speed changed", "speed", s)
+				m.SetLineSpeedSync(s)
+			}
+		}
+	}()
+}
+
+// startInstance creates the modem of specs[i] with its TTY devices.
+func startInstance(i int) (*instance, error) {
+	spec := specs[i]
+	inst := &instance{}
+	tty, err := newDevice(spec, "")
+	if err != nil {
+		return nil, fmt.Errorf("error creating tty: %v", err)
+	}
+	inst.devs = append(inst.devs, tty)
+
+	var rwc io.ReadWriteCloser = tty
+	var dump *hexDumpTTY
+	if hexdump != nil {
+		dump = &hexDumpTTY{ReadWriteCloser: tty, d: hexdump, id: spec.Name}
+		rwc = dump
+	}
+	if len(options.Verbose) > 2 {
+		rwc = t.NewRWCTracer(rwc, 16, time.Millisecond*time.Duration(options.NagleTimeout),
+			newModemTraceHook(spec.Name, "w"),
+			newModemTraceHook(spec.Name, "r"),
+		)
+	}
+
+	var sharedTty Device
+	var sharedRwc io.ReadWriteCloser
+	if options.Shared {
+		sharedTty, err = newDevice(spec, "-op")
+		if err != nil {
+			inst.closeDevs()
+			return nil, fmt.Errorf("error creating shared tty: %v", err)
+		}
+		inst.devs = append(inst.devs, sharedTty)
+		sharedRwc = sharedTty
+	}
+
+	m, err := vm.NewModem(modemConfig(spec.Name, rwc, sharedRwc))
+	if err != nil {
+		inst.closeDevs()
+		return nil, fmt.Errorf("error creating modem: %v", err)
+	}
+	inst.modem = m
+	setPersonality(m)
+	watchTTY(m, tty)
+	if dump != nil {
+		dump.m.Store(m)
+	}
+	if d, ok := tty.(*rfc2217Device); ok {
+		d.watch(m)
+	}
+
+	// Execute initialization commands before exposing the TTY
+	initCmds := append(slices.Clone(options.InitCmd), spec.Init...)
+	if answerCommand(spec.Name) != "" {
+		initCmds = append([]string{answerInit}, initCmds...)
+	}
+	for _, initCmd := range initCmds {
+		modemLog(m.Id()).Debug("Executing init command", "cmd", "AT"+initCmd)
+
+		// Send the AT command
+		// The response will be written to the TTY but since it's not yet exposed
+		// via symlink, no external process will see it
+		result := m.ProcessAtCommandSync(initCmd)
+
+		modemLog(m.Id()).Debug("Init command result", "result", result)
+
+		// Small delay to ensure the command is fully processed
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	path, err := exposeDevice(tty, spec, "")
+	if err != nil {
+		inst.close()
+		return nil, fmt.Errorf("error exposing tty: %v", err)
+	}
+	modemLog(m.Id()).Debug("Created and listen", "path", path)
+	if sharedTty != nil {
+		path, err := exposeDevice(sharedTty, spec, "-op")
+		if err != nil {
+			inst.close()
+			return nil, fmt.Errorf("error exposing shared tty: %v", err)
+		}
+		modemLog(m.Id()).Debug("Shared line", "path", path)
+	}
+	if options.Monitor {
+		mon, err := newDevice(spec, "-mon")
+		if err != nil {
+			inst.close()
+			return nil, fmt.Errorf("error creating monitor tty: %v", err)
+		}
+		inst.devs = append(inst.devs, mon)
+		addMonitor(m, mon)
+		path, err := exposeDevice(mon, spec, "-mon")
+		if err != nil {
+			inst.close()
+			return nil, fmt.Errorf("error exposing monitor tty: %v", err)
+		}
+		modemLog(m.Id()).Debug("Monitor", "path", path)
+	}
+	return inst, nil
+}
+
+// symlinkTTY links path to the slave side of tty, replacing the link of a
+// previous instance.
+func symlinkTTY(tty Device, path string) error {
+	os.Remove(path)
+	return os.Symlink(tty.Name(), path)
+}
+
+// modemDead restarts a modem closed by the failure of its TTY. It is called
+// with the modem locked, so the bank is looked up apart. In --stdio mode the
+// DTE is gone for good, so vmodem exits instead.
+func modemDead(m *vm.Modem, err error) {
+	if options.Stdio {
+		modemLog(m.Id()).Info("Standard I/O closed, exiting", "err", err)
+		cancel()
+		return
+	}
+	modemLog(m.Id()).Error("Modem failed", "err", err)
+	go func() {
+		bankMu.Lock()
+		defer bankMu.Unlock()
+		for i, inst := range instances {
+			if inst != nil && inst.modem == m {
+				go restartInstance(i, inst)
+				return
+			}
+		}
+	}()
+}
+
+// restartInstance replaces the failed instance inst of specs[i] with a new one,
+// retrying with an increasing delay until it starts or the server exits.
+func restartInstance(i int, inst *instance) {
+	inst.closeDevs()
+	delay := restartDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		next, err := startInstance(i)
+		if err != nil {
+			modemLog(specs[i].Name).Error("Restart failed", "err", err)
+			delay = min(2*delay, maxRestartDelay)
+			continue
+		}
+		modemLog(specs[i].Name).Info("Modem restarted")
+		bankMu.Lock()
+		if ctx.Err() != nil {
+			bankMu.Unlock()
+			next.close()
+			return
+		}
+		instances[i] = next
+		bankMu.Unlock()
+		return
+	}
+}
+
+// stopBank closes all the modems of the bank.
+func stopBank() {
+	bankMu.Lock()
+	insts := slices.Clone(instances)
+	bankMu.Unlock()
+	for _, inst := range insts {
+		if inst != nil {
+			inst.close()
+		}
+	}
+}
+
+// bankAddrs returns the addresses of the listeners shared by the bank: the
+// main listener, unless disabled or in --stdio mode, and those added with
+// --listen.
+func bankAddrs() []string {
+	var addrs []string
+	if !options.NoListen && !options.Stdio {
+		addrs = append(addrs, options.ListenAddr)
+	}
+	return append(addrs, options.Listen...)
+}
+
+// startListeners opens the listeners shared by the bank and the listeners of
+// the modems with their own. Sockets passed by systemd socket activation
+// replace the TCP listeners of the bank.
+func startListeners() error {
+	activated, err := activatedListeners()
+	if err != nil {
+		return err
+	}
+	if activated == nil {
+		for _, addr := range bankAddrs() {
+			l, err := listenCalls(addr)
+			if err != nil {
+				return err
+			}
+			activated = append(activated, l)
+		}
+	}
+	for _, l := range activated {
+		listeners = append(listeners, l)
+		go serveCalls(l, sharedModems)
+	}
+	for _, addr := range options.WSListen {
+		l, err := listenWebSocket(addr)
+		if err != nil {
+			return fmt.Errorf("ws-listen: %v", err)
+		}
+		listeners = append(listeners, l)
+		go serveCalls(l, sharedModems)
+	}
+	for i, spec := range specs {
+		if spec.Listen == "" {
+			continue
+		}
+		l, err := listenCalls(spec.Listen)
+		if err != nil {
+			return fmt.Errorf("%s: %v", spec.Name, err)
+		}
+		listeners = append(listeners, l)
+		go serveCalls(l, func() []*vm.Modem {
+			bankMu.Lock()
+			defer bankMu.Unlock()
+			return []*vm.Modem{instances[i].modem}
+		})
+	}
+	for _, p := range pools {
+		if p.Addr == "" {
+			continue
+		}
+		l, err := listenCalls(p.Addr)
+		if err != nil {
+			return fmt.Errorf("pool %s: %v", p.Name, err)
+		}
+		listeners = append(listeners, l)
+		go serveCalls(l, p.modems)
+	}
+	return nil
+}
+
+// closeListeners closes all listeners.
+func closeListeners() {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// callSource returns the address of the caller of conn or, for calls over a
+// Unix domain socket, which have no caller address, the socket they came in on.
+func callSource(conn net.Conn) string {
+	if conn.LocalAddr().Network() == "unix" {
+		return unixScheme + conn.LocalAddr().String()
+	}
+	return conn.RemoteAddr().String()
+}
+
+// offerCall rings m with the incoming call conn if m is idle, reporting whether
+// it took the call. A busy modem is left alone, so it does not signal busy to a
+// call another modem may still take.
+func offerCall(m *vm.Modem, conn io.ReadWriteCloser, info vm.CallInfo) bool {
+	m.Lock()
+	defer m.Unlock()
+	if m.Status() != vm.StatusIdle {
+		return false
+	}
+	return m.IncomingCallInfo(conn, info) == nil
+}
+
+// serveCalls passes the calls accepted by l to the first free modem of those
+// returned by modems, answering with the busy string if none is free, until l is
+// closed.
+func serveCalls(l net.Listener, modems func() []*vm.Modem) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				shutdown("Error accepting calls", "err", err)
+			}
+			break
+		}
+		// Present the caller address as caller ID
+		info := vm.CallInfo{Source: callSource(conn)}
+		if _, ok := unixPath(info.Source); !ok {
+			if host, _, err := net.SplitHostPort(info.Source); err == nil {
+				info.Number = host
+			}
+		}
+		var call io.ReadWriteCloser = conn
+		var pending *pendingCall
+		if options.IdleTimeout > 0 {
+			pending = newPendingCall(conn)
+			call = pending
+		}
+		// Offer the call to the free modems. If none takes it, the last busy
+		// one rejects it with the busy string and busy callback of the modems,
+		// unless it has just become free.
+		var taker, busy *vm.Modem
+		for _, m := range modems() {
+			if offerCall(m, call, info) {
+				taker = m
+				break
+			}
+			busy = m
+		}
+		if taker == nil && busy != nil && busy.IncomingCallInfoSync(call, info) == nil {
+			taker = busy
+		}
+		if taker != nil {
+			if pending != nil {
+				go pending.watch(taker, time.Duration(options.IdleTimeout)*time.Second)
+			}
+		} else {
+			conn.Close()
+			logger.Warn("No free modems for incoming call", "source", info.Source)
+		}
+	}
+}
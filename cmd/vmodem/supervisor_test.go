@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// Test the modems of the config file are validated and named
+func TestBankSpecs(t *testing.T) {
+	options = Options{NumTTYs: 2, StartNum: 3, ListenAddr: "0.0.0.0:2020"}
+	defer func() { options, configModems = Options{}, nil }()
+
+	s, err := bankSpecs()
+	if err != nil || len(s) != 2 || s[0].Name != "tty3" || s[1].Name != "tty4" {
+		t.Errorf("bankSpecs() = %v, %v, want tty3 and tty4", s, err)
+	}
+
+	configModems = []ModemSpec{{Name: "bbs1"}, {Listen: "127.0.0.1:2323"}}
+	s, err = bankSpecs()
+	if err != nil || len(s) != 2 || s[0].Name != "bbs1" || s[1].Name != "tty4" {
+		t.Errorf("bankSpecs() = %v, %v, want bbs1 and tty4", s, err)
+	}
+
+	for _, bad := range [][]ModemSpec{
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "../a"}},
+		{{Listen: "nowhere"}},
+		{{Listen: "0.0.0.0:2020"}},
+		{{Listen: "127.0.0.1:2323"}, {Listen: "127.0.0.1:2323"}},
+	} {
+		configModems = bad
+		if _, err := bankSpecs(); err == nil {
+			t.Errorf("bankSpecs() accepted %v", bad)
+		}
+	}
+}
+
+// Test a modem whose TTY fails is restarted on a new TTY
+func TestRestartInstance(t *testing.T) {
+	options = Options{TtyPath: t.TempDir(), Locale: "en"}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances = Options{}, nil, nil
+	}()
+
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	failed := bank()[0]
+
+	// Break the TTY under the modem
+	bankMu.Lock()
+	instances[0].devs[0].(*UnixPty).Master().Close()
+	bankMu.Unlock()
+
+	deadline := time.Now().Add(restartDelay + 2*time.Second)
+	for bank()[0] == failed && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if bank()[0] == failed {
+		t.Fatal("failed modem not restarted")
+	}
+	if failed.StatusSync() != vm.StatusClosed || bank()[0].StatusSync() != vm.StatusIdle {
+		t.Errorf("status = %v, %v, want failed modem closed and new one idle", failed.StatusSync(), bank()[0].StatusSync())
+	}
+	bankMu.Lock()
+	want := instances[0].devs[0].Name()
+	bankMu.Unlock()
+	if link, err := os.Readlink(ttyPath(specs[0], "")); err != nil || link != want {
+		t.Errorf("TTY link = %q, %v, want %q", link, err, want)
+	}
+}
+
+// Test calls to a --listen address ring a free modem with the caller ID
+func TestListenIncomingCall(t *testing.T) {
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true, Listen: []string{"127.0.0.1:0"}}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		closeListeners()
+		cancel()
+		stopBank()
+		options, specs, instances, listeners = Options{}, nil, nil, nil
+	}()
+
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	if err := startListeners(); err != nil {
+		t.Fatalf("startListeners() error = %v", err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("%d listeners, want 1", len(listeners))
+	}
+	conn, err := net.Dial("tcp", listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	m := bank()[0]
+	deadline := time.Now().Add(2 * time.Second)
+	for m.StatusSync() != vm.StatusRinging && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.StatusSync() != vm.StatusRinging {
+		t.Fatalf("status = %v, want ringing", m.StatusSync())
+	}
+	if info := m.CallInfoSync(); info.Number != "127.0.0.1" || info.Source != conn.LocalAddr().String() {
+		t.Errorf("CallInfo() = %+v, want caller %s", info, conn.LocalAddr())
+	}
+}
+
+// Test --stdio serves the modem on stdin and stdout and exits when stdin closes
+func TestStdioModem(t *testing.T) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outR.Close()
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = inR, outW
+	options = Options{TtyPath: t.TempDir(), Locale: "en", Stdio: true, ListenAddr: "127.0.0.1:0"}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		os.Stdin, os.Stdout = stdin, stdout
+		closeListeners()
+		cancel()
+		stopBank()
+		options, specs, instances, listeners = Options{}, nil, nil, nil
+	}()
+
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	if err := startListeners(); err != nil {
+		t.Fatalf("startListeners() error = %v", err)
+	}
+	if len(listeners) != 0 {
+		t.Errorf("%d listeners, want none in stdio mode", len(listeners))
+	}
+	if _, err := os.Lstat(ttyPath(specs[0], "")); err == nil {
+		t.Errorf("TTY link created in stdio mode")
+	}
+
+	inW.WriteString("ATE0\r")
+	outR.SetReadDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(outR)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stdout: %v", err)
+		}
+		if line == "OK\r\n" {
+			break
+		}
+	}
+
+	inW.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("stdin closed without exiting")
+	}
+}
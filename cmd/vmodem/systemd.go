@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemd integration. Each feature is enabled by the environment systemd sets
+// up for the service, so vmodem runs unchanged outside of it.
+
+// sdListenFdsStart is the first file descriptor passed by socket activation.
+const sdListenFdsStart = 3
+
+// activatedListeners returns the listeners passed by systemd socket activation,
+// if any (sd_listen_fds). They take the place of the listeners of the bank.
+func activatedListeners() ([]net.Listener, error) {
+	return fileListeners(sdListenFdsStart)
+}
+
+// fileListeners returns the LISTEN_FDS listeners from file descriptor first on,
+// if LISTEN_PID is this process, and clears the variables so child processes
+// do not inherit them.
+func fileListeners(first int) ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	var ls []net.Listener
+	for fd := first; fd < first+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		// FileListener works on a duplicate of the descriptor
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket activation fd %d: %v", fd, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// sdNotify sends state to the service manager (sd_notify), if it asked for
+// notifications. Errors are reported but not fatal.
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	if strings.HasPrefix(addr, "@") {
+		// Abstract socket
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err == nil {
+		_, err = conn.Write([]byte(state))
+		conn.Close()
+	}
+	if err != nil {
+		logger.Error("Error notifying systemd", "err", err)
+	}
+}
+
+// sdWatchdogInterval returns the interval of the watchdog pings the service
+// manager expects (WATCHDOG_USEC), or 0 if it expects none.
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// startSystemdWatchdog pings the systemd watchdog at half its interval while
+// the modems respond, so a wedged modem gets the service restarted.
+func startSystemdWatchdog() {
+	interval := sdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// Taking each modem lock fails to return on a deadlock
+			for _, m := range bank() {
+				m.Lock()
+				m.Unlock()
+			}
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
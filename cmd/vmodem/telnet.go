@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+)
+
+// telnetScheme is the prefix of the dial targets reached over Telnet.
+const telnetScheme = "telnet://"
+
+// Telnet commands (RFC 854).
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+// Telnet options (RFC 856, RFC 857, RFC 858, RFC 2217).
+const (
+	telnetOptBinary  = 0
+	telnetOptEcho    = 1
+	telnetOptSGA     = 3
+	telnetOptComPort = 44
+)
+
+// telnetConn is a Telnet connection carrying the data of a call, or of the TTY
+// of a modem served over RFC 2217. It strips the Telnet commands from the data
+// read and answers the option negotiation, and IAC bytes written are escaped.
+// As a client it lets the server echo and suppress go ahead and refuses any
+// other option.
+type telnetConn struct {
+	net.Conn
+	r   *bufio.Reader
+	wmu sync.Mutex
+	// will and do report whether an option the peer offers to enable on its
+	// side, or asks us to enable on ours, is accepted
+	will, do func(opt byte) bool
+	// sub handles the subnegotiations of enabled options, if not nil
+	sub func(opt byte, data []byte) error
+	// him and us are the options enabled on the side of the peer and ours.
+	// Requests for an option already in the requested state are not answered,
+	// so the negotiation does not loop.
+	him, us [256]bool
+}
+
+func newTelnetConn(conn net.Conn) *telnetConn {
+	return &telnetConn{
+		Conn: conn,
+		r:    bufio.NewReader(conn),
+		will: func(opt byte) bool { return opt == telnetOptEcho || opt == telnetOptSGA },
+		do:   func(opt byte) bool { return opt == telnetOptSGA },
+	}
+}
+
+func (c *telnetConn) Read(b []byte) (int, error) {
+	n := 0
+	// Block until some data is read, then take only what is buffered
+	for n < len(b) && (n == 0 || c.r.Buffered() > 0) {
+		ch, err := c.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if ch != telnetIAC {
+			b[n] = ch
+			n++
+			continue
+		}
+		data, err := c.command()
+		if err != nil {
+			return n, err
+		}
+		if data {
+			b[n] = telnetIAC
+			n++
+		}
+	}
+	return n, nil
+}
+
+// command handles the Telnet command after an IAC, reporting whether it is an
+// escaped IAC data byte.
+func (c *telnetConn) command() (bool, error) {
+	cmd, err := c.r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch cmd {
+	case telnetIAC:
+		return true, nil
+	case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+		opt, err := c.r.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		return false, c.negotiate(cmd, opt)
+	case telnetSB:
+		var data []byte
+		for {
+			ch, err := c.r.ReadByte()
+			if err != nil {
+				return false, err
+			}
+			if ch == telnetIAC {
+				if ch, err = c.r.ReadByte(); err != nil {
+					return false, err
+				}
+				if ch == telnetSE {
+					break
+				}
+			}
+			data = append(data, ch)
+		}
+		// Skip the subnegotiation of options we never agree to
+		if len(data) > 0 && c.sub != nil && c.enabled(data[0]) {
+			return false, c.sub(data[0], data[1:])
+		}
+	}
+	return false, nil
+}
+
+// negotiate answers the request cmd of the peer for the option opt.
+func (c *telnetConn) negotiate(cmd, opt byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var reply byte
+	switch cmd {
+	case telnetWILL:
+		switch {
+		case c.him[opt]:
+			return nil
+		case c.will(opt):
+			c.him[opt] = true
+			reply = telnetDO
+		default:
+			reply = telnetDONT
+		}
+	case telnetWONT:
+		if !c.him[opt] {
+			return nil
+		}
+		c.him[opt] = false
+		reply = telnetDONT
+	case telnetDO:
+		switch {
+		case c.us[opt]:
+			return nil
+		case c.do(opt):
+			c.us[opt] = true
+			reply = telnetWILL
+		default:
+			reply = telnetWONT
+		}
+	case telnetDONT:
+		if !c.us[opt] {
+			return nil
+		}
+		c.us[opt] = false
+		reply = telnetWONT
+	}
+	_, err := c.Conn.Write([]byte{telnetIAC, reply, opt})
+	return err
+}
+
+// request asks the peer to enable the option opt on its side (DO) or offers to
+// enable it on ours (WILL). The option counts as enabled unless refused.
+func (c *telnetConn) request(cmd, opt byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if cmd == telnetDO {
+		c.him[opt] = true
+	} else {
+		c.us[opt] = true
+	}
+	_, err := c.Conn.Write([]byte{telnetIAC, cmd, opt})
+	return err
+}
+
+// enabled reports whether the option opt is enabled on either side.
+func (c *telnetConn) enabled(opt byte) bool {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.him[opt] || c.us[opt]
+}
+
+// subnegotiate sends the subnegotiation IAC SB opt data IAC SE, escaping IAC
+// bytes in data.
+func (c *telnetConn) subnegotiate(opt byte, data ...byte) error {
+	out := []byte{telnetIAC, telnetSB, opt}
+	for _, ch := range data {
+		out = append(out, ch)
+		if ch == telnetIAC {
+			out = append(out, telnetIAC)
+		}
+	}
+	out = append(out, telnetIAC, telnetSE)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Conn.Write(out)
+	return err
+}
+
+func (c *telnetConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	out := b
+	if bytes.IndexByte(b, telnetIAC) >= 0 {
+		out = make([]byte, 0, len(b)+8)
+		for _, ch := range b {
+			out = append(out, ch)
+			if ch == telnetIAC {
+				out = append(out, telnetIAC)
+			}
+		}
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
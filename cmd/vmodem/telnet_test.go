@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTelnetConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	conn := newTelnetConn(client)
+	defer conn.Close()
+
+	// The server negotiates echo, suppress go ahead and terminal type, with data around
+	go server.Write([]byte{'h', 'i', telnetIAC, telnetWILL, telnetOptEcho, telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetDO, 24, telnetIAC, telnetSB, 24, 1, telnetIAC, telnetSE, telnetIAC, telnetIAC, '!'})
+	want := []byte{telnetIAC, telnetDO, telnetOptEcho, telnetIAC, telnetWILL, telnetOptSGA, telnetIAC, telnetWONT, 24}
+	replies := make([]byte, len(want))
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(server, replies)
+		done <- err
+	}()
+
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !bytes.Equal(got, []byte{'h', 'i', telnetIAC, '!'}) {
+		t.Errorf("data = %v, want hi, IAC and !", got)
+	}
+	if err := <-done; err != nil || !bytes.Equal(replies, want) {
+		t.Errorf("negotiation replies = %v, %v, want %v", replies, err, want)
+	}
+
+	// IAC bytes written are escaped
+	go conn.Write([]byte{'a', telnetIAC, 'b'})
+	server.SetDeadline(time.Now().Add(2 * time.Second))
+	sent := make([]byte, 4)
+	if _, err := io.ReadFull(server, sent); err != nil || !bytes.Equal(sent, []byte{'a', telnetIAC, telnetIAC, 'b'}) {
+		t.Errorf("written = %v, %v", sent, err)
+	}
+}
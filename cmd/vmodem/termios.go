@@ -0,0 +1,48 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setupPty puts the slave of p in raw mode if raw, so that call data and
+// command lines go through unchanged until an application configures it, and
+// sets its baud rate to speed if not 0.
+func setupPty(p *UnixPty, raw bool, speed int) error {
+	fd := int(p.Slave().Fd())
+	t, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return err
+	}
+	if raw {
+		makeRaw(t)
+	}
+	if speed != 0 {
+		if err := setTermiosSpeed(t, speed); err != nil {
+			return err
+		}
+	}
+	return unix.IoctlSetTermios(fd, ioctlSetTermios, t)
+}
+
+// makeRaw sets t to raw mode, like cfmakeraw.
+func makeRaw(t *unix.Termios) {
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+}
+
+// ptySpeed returns the baud rate set on the slave of p, by the daemon or by
+// the application using it.
+func ptySpeed(p *UnixPty) (int, error) {
+	t, err := unix.IoctlGetTermios(int(p.Slave().Fd()), ioctlGetTermios)
+	if err != nil {
+		return 0, err
+	}
+	return termiosSpeed(t), nil
+}
@@ -0,0 +1,29 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioctlGetTermios = unix.TIOCGETA
+	ioctlSetTermios = unix.TIOCSETA
+)
+
+// termiosSpeed returns the output baud rate of t.
+func termiosSpeed(t *unix.Termios) int {
+	return int(t.Ospeed)
+}
+
+// setTermiosSpeed sets the input and output baud rate of t.
+func setTermiosSpeed(t *unix.Termios, speed int) error {
+	setSpeed(&t.Ispeed, speed)
+	setSpeed(&t.Ospeed, speed)
+	return nil
+}
+
+// setSpeed sets a speed field of a termios, whose type depends on the system.
+func setSpeed[T ~int32 | ~uint32 | ~uint64](field *T, speed int) {
+	*field = T(speed)
+}
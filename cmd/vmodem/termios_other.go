@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package main
+
+import "fmt"
+
+// setupPty leaves the slave of p as created. Only Linux and the BSDs support
+// raw mode and baud rates.
+func setupPty(p *UnixPty, raw bool, speed int) error {
+	if speed != 0 {
+		return fmt.Errorf("tty-speed not supported on this platform")
+	}
+	return nil
+}
+
+// ptySpeed returns the baud rate set on the slave of p. Only Linux and the
+// BSDs support it.
+func ptySpeed(p *UnixPty) (int, error) {
+	return 0, fmt.Errorf("TTY speed not supported on this platform")
+}
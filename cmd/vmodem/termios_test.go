@@ -0,0 +1,102 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+	"golang.org/x/sys/unix"
+)
+
+// Test the PTY is set to raw mode at the chosen baud rate
+func TestSetupPty(t *testing.T) {
+	pty, err := NewPty()
+	if err != nil {
+		t.Fatalf("NewPty() error = %v", err)
+	}
+	defer pty.Close()
+
+	if err := setupPty(pty, true, 2400); err != nil {
+		t.Fatalf("setupPty() error = %v", err)
+	}
+	tio, err := unix.IoctlGetTermios(int(pty.Slave().Fd()), ioctlGetTermios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tio.Lflag&(unix.ECHO|unix.ICANON) != 0 || tio.Oflag&unix.OPOST != 0 || tio.Iflag&unix.ICRNL != 0 {
+		t.Errorf("termios = %+v, want raw mode", tio)
+	}
+	if speed, err := ptySpeed(pty); err != nil || speed != 2400 {
+		t.Errorf("ptySpeed() = %d, %v, want 2400", speed, err)
+	}
+}
+
+// Test the calls of a modem are paced to the baud rate the DTE sets on its TTY,
+// and setting 0 baud drops DTR, which hangs up
+func TestWatchTTY(t *testing.T) {
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true, FollowTTYSpeed: true}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		stopBank()
+		options, specs, instances = Options{}, nil, nil
+	}()
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	m := bank()[0]
+	if speed := m.LineSpeedSync(); speed != 0 {
+		t.Errorf("LineSpeedSync() = %d before the DTE set a speed, want 0", speed)
+	}
+
+	// The DTE runs stty 9600
+	if err := setupPty(instances[0].devs[0].(*UnixPty), false, 9600); err != nil {
+		t.Fatalf("setupPty() error = %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for m.LineSpeedSync() != 9600 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if speed := m.LineSpeedSync(); speed != 9600 {
+		t.Errorf("LineSpeedSync() = %d after stty 9600, want 9600", speed)
+	}
+
+	host, line := net.Pipe()
+	defer host.Close()
+	go io.Copy(io.Discard, host)
+	if err := m.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := m.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	// The DTE runs stty 0
+	pty := instances[0].devs[0].(*UnixPty)
+	tio, err := unix.IoctlGetTermios(int(pty.Slave().Fd()), ioctlGetTermios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setTermiosSpeed(tio, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.IoctlSetTermios(int(pty.Slave().Fd()), ioctlSetTermios, tio); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for m.StatusSync() != vm.StatusIdle && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.StatusSync() != vm.StatusIdle {
+		t.Errorf("status = %v after stty 0, want idle", m.StatusSync())
+	}
+	if speed := m.LineSpeedSync(); speed != 9600 {
+		t.Errorf("LineSpeedSync() = %d after stty 0, want 9600", speed)
+	}
+}
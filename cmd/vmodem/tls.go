@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tlsScheme is the prefix of the dial targets reached over TLS.
+const tlsScheme = "tls://"
+
+// parseTarget returns the address dialed for target and, for tls://host:port
+// targets, the TLS configuration of the call. TLS targets take their options
+// from the query: sni (server name, the host by default), insecure (skip the
+// server certificate verification), cert and key (client certificate files)
+// and ca (file of the CA certificates trusted instead of the system ones), e.g.
+// tls://bbs.example.com:992?sni=bbs.example.org&ca=/etc/vmodem/ca.pem
+//
+// tcp://host:port targets are plain TCP like host:port, and telnet://host[:port]
+// targets speak Telnet on port 23 by default. ws:// and wss:// targets are
+// WebSocket URLs, see parseWSTarget. unix: targets return the path of the
+// socket, see unixPath, ssh:// targets the address of the SSH server, see
+// parseSSHTarget, and exec:// targets the program they run, see execArgs.
+func parseTarget(target string) (string, *tls.Config, error) {
+	if isWSTarget(target) {
+		return parseWSTarget(target)
+	}
+	if name, ok := strings.CutPrefix(target, poolScheme); ok {
+		if !poolNameRe.MatchString(name) {
+			return "", nil, fmt.Errorf("invalid pool name in %s", target)
+		}
+		return name, nil, nil
+	}
+	if strings.HasPrefix(target, execScheme) {
+		args, err := execArgs(target)
+		if err != nil {
+			return "", nil, err
+		}
+		return args[0], nil, nil
+	}
+	if strings.HasPrefix(target, sshScheme) {
+		t, err := parseSSHTarget(target)
+		if err != nil {
+			return "", nil, err
+		}
+		return t.addr(), nil, nil
+	}
+	if path, ok := unixPath(target); ok {
+		if path == "" {
+			return "", nil, fmt.Errorf("no socket path in %s", target)
+		}
+		return path, nil, nil
+	}
+	if host, ok := strings.CutPrefix(target, "tcp://"); ok {
+		return dialAddr(host), nil, nil
+	}
+	if host, ok := strings.CutPrefix(target, telnetScheme); ok {
+		if h, port, err := net.SplitHostPort(host); err == nil && port != "" {
+			return net.JoinHostPort(h, port), nil, nil
+		} else if err == nil {
+			host = h
+		}
+		return net.JoinHostPort(host, "23"), nil, nil
+	}
+	if !strings.HasPrefix(target, tlsScheme) {
+		return dialAddr(target), nil, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", nil, err
+	}
+	if u.Host == "" {
+		return "", nil, fmt.Errorf("no host in %s", target)
+	}
+	addr := dialAddr(u.Host)
+	host, _, _ := net.SplitHostPort(addr)
+	config := &tls.Config{ServerName: host}
+
+	q := u.Query()
+	for k := range q {
+		switch k {
+		case "sni", "insecure", "cert", "key", "ca":
+		default:
+			return "", nil, fmt.Errorf("unknown TLS option %s", k)
+		}
+	}
+	if sni := q.Get("sni"); sni != "" {
+		config.ServerName = sni
+	}
+	if v := q.Get("insecure"); v != "" {
+		if config.InsecureSkipVerify, err = strconv.ParseBool(v); err != nil {
+			return "", nil, fmt.Errorf("invalid insecure value %s", v)
+		}
+	}
+	if cert, key := q.Get("cert"), q.Get("key"); cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return "", nil, fmt.Errorf("client certificate needs both cert and key")
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return "", nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	if ca := q.Get("ca"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return "", nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return "", nil, fmt.Errorf("no certificates in %s", ca)
+		}
+	}
+	return addr, config, nil
+}
+
+// startTLS runs the TLS handshake of a call over conn, closing conn if it fails.
+func startTLS(ctx context.Context, conn net.Conn, config *tls.Config) (net.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
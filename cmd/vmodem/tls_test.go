@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test TLS dial targets and their options
+func TestParseTarget(t *testing.T) {
+	defer func() { options = Options{} }()
+	options.DefaultPort = "2020"
+
+	addr, config, err := parseTarget("bbs.example.com")
+	if err != nil || addr != "bbs.example.com:2020" || config != nil {
+		t.Errorf("parseTarget() = %q, %v, %v, want plain TCP", addr, config, err)
+	}
+	addr, config, err = parseTarget("tls://bbs.example.com:992?sni=bbs.example.org&insecure=1")
+	if err != nil || addr != "bbs.example.com:992" || config == nil {
+		t.Fatalf("parseTarget() = %q, %v, %v, want TLS", addr, config, err)
+	}
+	if config.ServerName != "bbs.example.org" || !config.InsecureSkipVerify {
+		t.Errorf("ServerName, InsecureSkipVerify = %q, %v", config.ServerName, config.InsecureSkipVerify)
+	}
+	for _, bad := range []string{
+		"tls://",
+		"tls://bbs.example.com?insecure=maybe",
+		"tls://bbs.example.com?cert=client.pem",
+		"tls://bbs.example.com?ca=/nonexistent/ca.pem",
+		"tls://bbs.example.com?sni=a&verify=no",
+	} {
+		if _, _, err := parseTarget(bad); err == nil {
+			t.Errorf("parseTarget(%q) accepted", bad)
+		}
+	}
+}
+
+// Test calls to TLS targets verify the server with the given CA
+func TestStartTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("CONNECT"))
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // Rejected handshakes
+	srv.StartTLS()
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(ca, block, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func(target string) error {
+		host, config, err := parseTarget(target)
+		if err != nil {
+			return err
+		}
+		conn, err := NewDialer().DialContext(context.Background(), host, nil)
+		if err != nil {
+			return err
+		}
+		if conn, err = startTLS(context.Background(), conn, config); err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		status, err := bufio.NewReader(conn).ReadString('\n')
+		if err == nil && !strings.Contains(status, "200") {
+			t.Errorf("status = %q, want 200", status)
+		}
+		return err
+	}
+	if err := dial("tls://" + addr + "?ca=" + ca + "&sni=example.com"); err != nil {
+		t.Errorf("dial with CA error = %v", err)
+	}
+	if err := dial("tls://" + addr + "?insecure=true"); err != nil {
+		t.Errorf("dial with insecure error = %v", err)
+	}
+	if err := dial("tls://" + addr); err == nil {
+		t.Error("dial accepted an untrusted certificate")
+	}
+}
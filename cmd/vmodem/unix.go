@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// unixScheme is the prefix of Unix domain socket addresses, which dial targets
+// and listen addresses take in place of host:port: unix:/path for a socket in
+// the filesystem and unix:@name for an abstract socket (Linux). The URL form
+// unix:///path is accepted as well.
+const unixScheme = "unix:"
+
+// unixPath returns the socket path of the unix: address addr, and false if addr
+// is not one.
+func unixPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, unixScheme)
+	if !ok {
+		return "", false
+	}
+	if p, ok := strings.CutPrefix(path, "//"); ok {
+		path = p
+	}
+	return path, true
+}
+
+// checkListenAddr validates an address calls are accepted on: host:port or a
+// unix: socket.
+func checkListenAddr(addr string) error {
+	if path, ok := unixPath(addr); ok {
+		if path == "" || path == "@" {
+			return fmt.Errorf("no socket path in %s", addr)
+		}
+		return nil
+	}
+	_, _, err := net.SplitHostPort(addr)
+	return err
+}
+
+// listenCalls opens a listener for incoming calls on addr: host:port over TCP,
+// or a unix: socket. A socket file left behind by a previous run is replaced.
+func listenCalls(addr string) (net.Listener, error) {
+	path, ok := unixPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if !strings.HasPrefix(path, "@") {
+		if fi, err := os.Lstat(path); err == nil && fi.Mode().Type() == os.ModeSocket {
+			os.Remove(path)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// dialUnix connects a call to the Unix domain socket at path. A socket nobody
+// listens on is busy, like a refused TCP connection.
+func dialUnix(ctx context.Context, path string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", path)
+}
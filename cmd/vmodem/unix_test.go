@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+func TestUnixTargets(t *testing.T) {
+	defer func() { options, numToHosts = Options{}, nil }()
+	if err := phoneTranslations(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ number, want string }{
+		{"unix:/run/dosbox.sock", "/run/dosbox.sock"},
+		{"unix:///run/dosbox.sock", "/run/dosbox.sock"},
+		{"unix:@vice-modem", "@vice-modem"},
+	}
+	for _, tt := range tests {
+		target, _ := findHost(tt.number)
+		if host, _, err := parseTarget(target); err != nil || host != tt.want {
+			t.Errorf("parseTarget(findHost(%s)) = %q, %v, want %q", tt.number, host, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"unix:", "unix:@", "unix"} {
+		if err := checkListenAddr(bad); err == nil {
+			t.Errorf("checkListenAddr(%q) accepted", bad)
+		}
+	}
+}
+
+// Test calls in and out over Unix domain sockets
+func TestUnixCalls(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "modem.sock")
+	options = Options{TtyPath: dir, Locale: "en", NoListen: true, Listen: []string{"unix:" + in}}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		closeListeners()
+		stopBank()
+		options, specs, instances, listeners, numToHosts = Options{}, nil, nil, nil, nil
+	}()
+	if err := phoneTranslations(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	if err := startListeners(); err != nil {
+		t.Fatalf("startListeners() error = %v", err)
+	}
+	conn, err := net.Dial("unix", in)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	m := bank()[0]
+	deadline := time.Now().Add(2 * time.Second)
+	for m.StatusSync() != vm.StatusRinging && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.StatusSync() != vm.StatusRinging {
+		t.Fatalf("status = %v, want ringing", m.StatusSync())
+	}
+	if info := m.CallInfoSync(); info.Source != "unix:"+in || info.Number != "" {
+		t.Errorf("CallInfo() = %+v, want source unix:%s", info, in)
+	}
+
+	out := filepath.Join(dir, "remote.sock")
+	l, err := net.Listen("unix", out)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	call, err := outGoingCall(ctx, m, "unix:"+out)
+	if err != nil {
+		t.Fatalf("outGoingCall() error = %v", err)
+	}
+	call.Close()
+
+	// Nobody listens on the socket any more
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if _, err := outGoingCall(ctx, m, "unix:"+out); !errors.Is(err, vm.ErrRemoteBusy) {
+		t.Errorf("outGoingCall() to a closed socket error = %v, want busy", err)
+	}
+}
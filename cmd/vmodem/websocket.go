@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// WebSocket lines (RFC 6455). Calls are carried as binary messages; text
+// messages received from the peer are passed to the modem as well.
+
+// wsGUID is appended to the handshake key to compute the accept hash.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var errWSProtocol = errors.New("websocket: protocol error")
+
+// wsAccept returns the Sec-WebSocket-Accept value of key.
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHas reports whether the comma separated header name of h contains token.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is a WebSocket over a connection. Reads return the payload of the
+// data messages; each write is sent as a binary message.
+type wsConn struct {
+	net.Conn
+	r      *bufio.Reader
+	client bool // Mask the frames sent
+
+	// Read state
+	remaining int64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+	eof       bool
+
+	wmu    sync.Mutex
+	closed bool // Close frame sent
+}
+
+func newWSConn(conn net.Conn, r *bufio.Reader, client bool) *wsConn {
+	return &wsConn{Conn: conn, r: r, client: client}
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.r.Read(b)
+	if c.masked {
+		for i := range b[:n] {
+			b[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers, handling control frames, until a data frame.
+func (c *wsConn) nextFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return err
+	}
+	op := hdr[0] & 0x0f
+	masked := hdr[1]&0x80 != 0
+	if hdr[0]&0x70 != 0 || masked == c.client {
+		// Reserved bits set, or masking the wrong way for our side
+		return errWSProtocol
+	}
+	length := int64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		var l [2]byte
+		if _, err := io.ReadFull(c.r, l[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(l[:]))
+	case 127:
+		var l [8]byte
+		if _, err := io.ReadFull(c.r, l[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(l[:]))
+		if length < 0 {
+			return errWSProtocol
+		}
+	}
+	c.masked, c.maskPos = masked, 0
+	if masked {
+		if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch op {
+	case wsContinuation, wsText, wsBinary:
+		c.remaining = length
+		return nil
+	case wsClose, wsPing, wsPong:
+	default:
+		return errWSProtocol
+	}
+	// Control frames are short and not fragmented
+	if length > 125 || hdr[0]&0x80 == 0 {
+		return errWSProtocol
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= c.mask[i&3]
+		}
+	}
+	switch op {
+	case wsPing:
+		return c.writeFrame(wsPong, payload)
+	case wsClose:
+		// Echo the status code and end the call
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		c.writeFrame(wsClose, payload)
+		c.eof = true
+	}
+	return nil
+}
+
+// writeFrame sends a final frame of opcode op.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if op == wsClose {
+		c.closed = true
+	}
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|op)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch l := len(payload); {
+	case l <= 125:
+		frame = append(frame, maskBit|byte(l))
+	case l <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, maskBit|126), uint16(l))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, maskBit|127), uint64(l))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i&3]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close sends a normal closure frame, if none was sent, and closes the connection.
+func (c *wsConn) Close() error {
+	c.writeFrame(wsClose, []byte{0x03, 0xe8}) // 1000
+	return c.Conn.Close()
+}
+
+// wsListener is a net.Listener of the WebSocket calls accepted on an HTTP
+// server address, so they are served like TCP calls.
+type wsListener struct {
+	l     net.Listener
+	path  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// splitWSAddr splits a --ws-listen address, host:port[/path], defaulting the
+// path to /.
+func splitWSAddr(addr string) (string, string, error) {
+	hostPort, path := addr, "/"
+	if i := strings.IndexByte(addr, '/'); i >= 0 {
+		hostPort, path = addr[:i], addr[i:]
+	}
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		return "", "", err
+	}
+	return hostPort, path, nil
+}
+
+// listenWebSocket serves WebSocket upgrades on addr, host:port[/path].
+func listenWebSocket(addr string) (*wsListener, error) {
+	hostPort, path, err := splitWSAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", hostPort)
+	if err != nil {
+		return nil, err
+	}
+	wl := &wsListener{l: l, path: path, conns: make(chan net.Conn), done: make(chan struct{})}
+	srv := &http.Server{Handler: wl, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(l)
+	return wl, nil
+}
+
+func (wl *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-wl.conns:
+		return conn, nil
+	case <-wl.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (wl *wsListener) Close() error {
+	wl.once.Do(func() { close(wl.done) })
+	return wl.l.Close()
+}
+
+func (wl *wsListener) Addr() net.Addr {
+	return wl.l.Addr()
+}
+
+// ServeHTTP upgrades the requests to the WebSocket path and passes them to Accept.
+func (wl *wsListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != wl.path {
+		http.NotFound(w, r)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n"
+	if _, err := io.WriteString(conn, resp); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	select {
+	case wl.conns <- newWSConn(conn, brw.Reader, false):
+	case <-wl.done:
+		conn.Close()
+	}
+}
+
+// isWSTarget reports whether target is a ws:// or wss:// URL.
+func isWSTarget(target string) bool {
+	return strings.HasPrefix(target, "ws://") || strings.HasPrefix(target, "wss://")
+}
+
+// parseWSTarget returns the address dialed for a ws:// or wss:// target and,
+// for wss://, the TLS configuration of the call.
+func parseWSTarget(target string) (string, *tls.Config, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", nil, err
+	}
+	if u.Hostname() == "" {
+		return "", nil, fmt.Errorf("no host in %s", target)
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"ws": "80", "wss": "443"}[u.Scheme]
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	if u.Scheme == "wss" {
+		return addr, &tls.Config{ServerName: u.Hostname()}, nil
+	}
+	return addr, nil, nil
+}
+
+// wsHandshake opens the WebSocket of the ws:// or wss:// target over conn,
+// closing conn if it fails.
+func wsHandshake(ctx context.Context, conn net.Conn, target string) (net.Conn, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+
+	// Bound the handshake by the call context
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	r := bufio.NewReader(conn)
+	err = req.Write(conn)
+	var resp *http.Response
+	if err == nil {
+		resp, err = http.ReadResponse(r, req)
+	}
+	if err == nil {
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusServiceUnavailable:
+			err = fmt.Errorf("websocket: %s: %w", resp.Status, syscall.ECONNREFUSED)
+		case resp.StatusCode != http.StatusSwitchingProtocols:
+			err = fmt.Errorf("websocket: %s", strings.TrimSpace(resp.Status))
+		case resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key):
+			err = errors.New("websocket: invalid accept key")
+		}
+	}
+	if !stop() || err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return newWSConn(conn, r, true), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+func TestWSAccept(t *testing.T) {
+	// Example of RFC 6455 section 1.3
+	if got := wsAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wsAccept() = %s", got)
+	}
+}
+
+// dialWS opens a WebSocket to target on the address of l.
+func dialWS(t *testing.T, l net.Listener, target string) (net.Conn, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	ctx, stop := context.WithTimeout(context.Background(), 2*time.Second)
+	defer stop()
+	return wsHandshake(ctx, conn, target)
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	l, err := listenWebSocket("127.0.0.1:0/modem")
+	if err != nil {
+		t.Fatalf("listenWebSocket() error = %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+
+	if _, err := dialWS(t, l, "ws://"+l.Addr().String()+"/other"); err == nil {
+		t.Errorf("handshake on the wrong path succeeded")
+	}
+	conn, err := dialWS(t, l, "ws://"+l.Addr().String()+"/modem")
+	if err != nil {
+		t.Fatalf("wsHandshake() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Pings are answered without disturbing the data
+	if err := conn.(*wsConn).writeFrame(wsPing, []byte("ping")); err != nil {
+		t.Fatalf("ping error = %v", err)
+	}
+	for _, size := range []int{5, 300, 70000} {
+		data := bytes.Repeat([]byte{'a', 0xff, '\r'}, size)[:size]
+		if _, err := conn.Write(data); err != nil {
+			t.Fatalf("Write(%d) error = %v", size, err)
+		}
+		got := make([]byte, size)
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("Read(%d) error = %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("echo of %d bytes differs", size)
+		}
+	}
+
+	// A close from the peer ends the call
+	conn.(*wsConn).writeFrame(wsClose, []byte{0x03, 0xe8})
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() after close error = %v, want EOF", err)
+	}
+}
+
+func TestParseWSTarget(t *testing.T) {
+	tests := []struct {
+		target, addr string
+		tls          bool
+	}{
+		{"ws://bbs.example.com/modem", "bbs.example.com:80", false},
+		{"ws://10.0.0.1:8080/", "10.0.0.1:8080", false},
+		{"wss://bbs.example.com/modem?id=1", "bbs.example.com:443", true},
+	}
+	for _, tt := range tests {
+		addr, config, err := parseTarget(tt.target)
+		if err != nil || addr != tt.addr || (config != nil) != tt.tls {
+			t.Errorf("parseTarget(%s) = %s, %v, %v", tt.target, addr, config, err)
+		}
+		if config != nil && config.ServerName != "bbs.example.com" {
+			t.Errorf("parseTarget(%s) server name = %s", tt.target, config.ServerName)
+		}
+	}
+	if _, _, err := parseTarget("ws:///modem"); err == nil {
+		t.Errorf("parseTarget() without host succeeded")
+	}
+}
+
+func TestWSListenIncomingCall(t *testing.T) {
+	options = Options{TtyPath: t.TempDir(), Locale: "en", NoListen: true, WSListen: []string{"127.0.0.1:0"}}
+	specs = []ModemSpec{{Name: "tty0"}}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer func() {
+		closeListeners()
+		cancel()
+		stopBank()
+		options, specs, instances, listeners = Options{}, nil, nil, nil
+	}()
+
+	if err := startBank(); err != nil {
+		t.Fatalf("startBank() error = %v", err)
+	}
+	if err := startListeners(); err != nil {
+		t.Fatalf("startListeners() error = %v", err)
+	}
+	conn, err := dialWS(t, listeners[0], "ws://"+listeners[0].Addr().String()+"/")
+	if err != nil {
+		t.Fatalf("wsHandshake() error = %v", err)
+	}
+	defer conn.Close()
+
+	m := bank()[0]
+	deadline := time.Now().Add(2 * time.Second)
+	for m.StatusSync() != vm.StatusRinging && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.StatusSync() != vm.StatusRinging {
+		t.Fatalf("status = %v, want ringing", m.StatusSync())
+	}
+	if info := m.CallInfoSync(); info.Source != conn.LocalAddr().String() {
+		t.Errorf("CallInfo() = %+v, want caller %s", info, conn.LocalAddr())
+	}
+}
@@ -0,0 +1,51 @@
+package vmodem
+
+// DTR modes of the modem (&D), the action taken when the DTE drops DTR.
+const (
+	dtrIgnore  = 0 // &D0: DTR is ignored
+	dtrCommand = 1 // &D1: online command mode, like the +++ escape sequence
+	dtrHangup  = 2 // &D2: hang up (default)
+	dtrReset   = 3 // &D3: hang up and reset, like ATZ
+)
+
+// setDTR sets the state of the DTR line, acting on it when it drops.
+func (m *Modem) setDTR(on bool) {
+	drop := m.dtr && !on
+	m.dtr = on
+	if !drop || m.status() == StatusClosed {
+		return
+	}
+	switch m.dtrCtl {
+	case dtrCommand:
+		if m.status() == StatusConnected {
+			m.setStatus(StatusConnectedCmd, CauseDTR)
+		}
+	case dtrHangup, dtrReset:
+		switch m.status() {
+		case StatusConnected, StatusConnectedCmd, StatusDialing:
+			m.setStatus(StatusIdle, CauseDTR)
+		}
+		if m.dtrCtl == dtrReset {
+			m.resetSettings()
+		}
+	}
+}
+
+// SetDTR reports the state of the DTR line of the DTE, for TTYs that carry it
+// such as serial ports. The modem acts on DTR dropping per AT&D: &D0 ignores it,
+// &D1 switches an active call to online command mode, &D2 (the default) hangs
+// up, and &D3 hangs up and resets the modem like ATZ.
+// The modem lock must be held before calling this method.
+// Use SetDTRSync for automatic lock management.
+func (m *Modem) SetDTR(on bool) {
+	m.checkLock()
+	m.setDTR(on)
+}
+
+// SetDTRSync reports the state of the DTR line with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetDTRSync(on bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.setDTR(on)
+}
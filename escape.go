@@ -0,0 +1,51 @@
+package vmodem
+
+import (
+	"bytes"
+	"time"
+)
+
+// escapeMatcher detects the "+++" escape sequence in online TTY input, across
+// chunk boundaries. All bytes of a chunk are received at the same time, so once
+// a chunk holds data the pre guard time rules out an escape in the rest of it:
+// large transfers are skipped without looking at each byte.
+type escapeMatcher struct {
+	char     byte          // Escape character (S2)
+	disabled bool          // S2 above 127 disables the escape sequence
+	guard    time.Duration // Guard time (S12)
+	preGuard bool          // Data must not precede the sequence within the guard time
+	count    int           // Escape characters matched so far
+	lastEsc  time.Time
+	lastData time.Time
+}
+
+// scan advances the matcher over data received at now, stopping at the byte
+// that completes an escape sequence. It returns the number of bytes consumed
+// and whether they end with a complete escape sequence; the post guard time is
+// up to the caller.
+func (e *escapeMatcher) scan(data []byte, now time.Time) (int, bool) {
+	for i := 0; i < len(data); i++ {
+		if e.disabled || data[i] != e.char || (e.preGuard && now.Sub(e.lastData) < e.guard) {
+			e.count = 0
+			e.lastData = now
+			if e.disabled || (e.preGuard && e.guard > 0) {
+				return len(data), false
+			}
+			j := bytes.IndexByte(data[i+1:], e.char)
+			if j < 0 {
+				return len(data), false
+			}
+			i += j
+			continue
+		}
+		if now.Sub(e.lastEsc) > e.guard {
+			e.count = 0
+		}
+		e.count++
+		e.lastEsc = now
+		if e.count == 3 {
+			return i + 1, true
+		}
+	}
+	return len(data), false
+}
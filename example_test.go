@@ -0,0 +1,292 @@
+package vmodem_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jaracil/vmodem"
+)
+
+// terminal plays the DTE side of a modem TTY, collecting everything the modem writes.
+type terminal struct {
+	conn  net.Conn
+	lines chan string
+}
+
+// newTerminal returns a terminal and the TTY end to hand to the modem.
+func newTerminal() (*terminal, io.ReadWriteCloser) {
+	dte, dce := net.Pipe()
+	t := &terminal{conn: dte, lines: make(chan string, 100)}
+	go func() {
+		scanner := bufio.NewScanner(dte)
+		scanner.Split(scanCR)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				t.lines <- line
+			}
+		}
+		close(t.lines)
+	}()
+	return t, dce
+}
+
+// scanCR splits modem output into lines terminated by CR or LF.
+func scanCR(data []byte, atEOF bool) (int, []byte, error) {
+	for i, b := range data {
+		if b == '\r' || b == '\n' {
+			return i + 1, data[:i], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// send types s on the terminal.
+func (t *terminal) send(s string) {
+	go t.conn.Write([]byte(s))
+}
+
+// expect prints the lines written by the modem until one equals s.
+func (t *terminal) expect(s string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-t.lines:
+			fmt.Println(line)
+			if line == s {
+				return
+			}
+		case <-timeout:
+			fmt.Println("timeout waiting for", s)
+			return
+		}
+	}
+}
+
+// Configure a modem and talk to it through its TTY.
+func Example() {
+	term, tty := newTerminal()
+	modem, err := vmodem.NewModem(&vmodem.ModemConfig{
+		Id:  "tty0",
+		TTY: tty,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer modem.CloseSync()
+
+	term.send("ATE0\r")
+	term.expect("OK")
+	term.send("ATS0?\r")
+	term.expect("OK")
+	// Output:
+	// ATE0
+	// OK
+	// 000
+	// OK
+}
+
+// Dial a TCP service; the dialed number is the host:port to connect to.
+func ExampleModemConfig_outgoingCall() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn) // Echo service
+		conn.Close()
+	}()
+
+	term, tty := newTerminal()
+	modem, err := vmodem.NewModem(&vmodem.ModemConfig{
+		Id:  "tty0",
+		TTY: tty,
+		OutgoingCall: func(m *vmodem.Modem, number string) (io.ReadWriteCloser, error) {
+			return net.Dial("tcp", number)
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer modem.CloseSync()
+
+	modem.ProcessAtCommandSync("E0")
+	term.send("ATDT" + listener.Addr().String() + "\r")
+	term.expect("CONNECT")
+	term.send("hello\r")
+	term.expect("hello")
+	// Output:
+	// CONNECT
+	// hello
+}
+
+// Route calls accepted on a TCP listener to a modem, which rings until the DTE answers.
+func ExampleModem_IncomingCall() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer listener.Close()
+
+	term, tty := newTerminal()
+	modem, err := vmodem.NewModem(&vmodem.ModemConfig{
+		Id:  "tty0",
+		TTY: tty,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0")
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		if err := modem.IncomingCallSync(conn); err != nil {
+			conn.Close()
+		}
+	}()
+
+	caller, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer caller.Close()
+
+	term.expect("RING")
+	term.send("ATA\r")
+	term.expect("CONNECT")
+	caller.Write([]byte("hi there\r"))
+	term.expect("hi there")
+	// Output:
+	// RING
+	// CONNECT
+	// hi there
+}
+
+// Wire two modems back to back so dialing on one rings the other.
+func Example_nullModem() {
+	termA, ttyA := newTerminal()
+	termB, ttyB := newTerminal()
+
+	modemB, err := vmodem.NewModem(&vmodem.ModemConfig{
+		Id:         "B",
+		TTY:        ttyB,
+		AnswerChar: "C",
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer modemB.CloseSync()
+	modemB.ProcessAtCommandSync("E0")
+
+	modemA, err := vmodem.NewModem(&vmodem.ModemConfig{
+		Id:         "A",
+		TTY:        ttyA,
+		AnswerChar: "C",
+		OutgoingCall: func(m *vmodem.Modem, number string) (io.ReadWriteCloser, error) {
+			lineA, lineB := net.Pipe()
+			if err := modemB.IncomingCallSync(lineB); err != nil {
+				return nil, err
+			}
+			return lineA, nil
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer modemA.CloseSync()
+	modemA.ProcessAtCommandSync("E0")
+
+	termA.send("ATD1\r")
+	termB.expect("RING")
+	termB.send("ATA\r")
+	termB.expect("CONNECT")
+	termA.expect("CONNECT")
+	termA.send("ping\r")
+	termB.expect("ping")
+	// Output:
+	// RING
+	// CONNECT
+	// CONNECT
+	// ping
+}
+
+// Implement a custom AT command with a command hook.
+func ExampleCommandHookType() {
+	term, tty := newTerminal()
+	modem, err := vmodem.NewModem(&vmodem.ModemConfig{
+		Id:  "tty0",
+		TTY: tty,
+		CommandHook: func(m *vmodem.Modem, cmd vmodem.Command) vmodem.RetCode {
+			if cmd.Name == "I" && cmd.Number == "0" {
+				m.WriteInfoText("VModem v1.0")
+				return vmodem.RetCodeOk
+			}
+			return vmodem.RetCodeSkip
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0")
+
+	term.send("ATI0\r")
+	term.expect("OK")
+	// Output:
+	// VModem v1.0
+	// OK
+}
+
+// Embed a modem and exchange data with the call in-process through an in-memory line.
+func ExampleNewLine() {
+	term, tty := newTerminal()
+	modem, err := vmodem.NewModem(&vmodem.ModemConfig{
+		Id:  "tty0",
+		TTY: tty,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0")
+
+	host, line := vmodem.NewLine()
+	if err := modem.IncomingCallSync(line); err != nil {
+		fmt.Println(err)
+		return
+	}
+	term.expect("RING")
+	term.send("ATA\r")
+	term.expect("CONNECT")
+	host.Write([]byte("Welcome to the BBS\r"))
+	term.expect("Welcome to the BBS")
+	// Output:
+	// RING
+	// CONNECT
+	// Welcome to the BBS
+}
@@ -0,0 +1,119 @@
+package vmodem
+
+// Filter transforms the data of a call while the modem is online, e.g. to escape
+// Telnet IAC bytes, translate charsets or log traffic. Filter receives a chunk of
+// data and returns the data to pass on, which may be p itself, a new slice or
+// nothing at all. Filters are called with the modem lock held and may keep
+// state between chunks, as data of a direction always flows in order. They are
+// skipped while the modem is binary transparent (see Transparency).
+type Filter interface {
+	Filter(p []byte) []byte
+}
+
+// FilterFunc adapts an ordinary function to the Filter interface.
+type FilterFunc func(p []byte) []byte
+
+// Filter calls f(p).
+func (f FilterFunc) Filter(p []byte) []byte {
+	return f(p)
+}
+
+// FilterDirection selects the data flow a filter is applied to.
+type FilterDirection int
+
+const (
+	// FilterToLine filters data typed on the TTY before it is sent to the connection
+	FilterToLine FilterDirection = iota
+	// FilterToTTY filters data received from the connection before it is written to the TTY
+	FilterToTTY
+)
+
+// String returns a human-readable string representation of the filter direction.
+func (d FilterDirection) String() string {
+	switch d {
+	case FilterToLine:
+		return "ToLine"
+	case FilterToTTY:
+		return "ToTTY"
+	default:
+		return "Unknown"
+	}
+}
+
+type filterEntry struct {
+	id     int
+	filter Filter
+}
+
+// AddFilter appends f to the filter chain of the given direction and returns an
+// identifier for RemoveFilter. Filters of a direction are applied in the order they
+// were added. It returns 0, adding nothing, for an unknown direction.
+// The +++ escape sequence is detected on the data typed on the TTY, before any
+// filter.
+// The modem lock must be held before calling this method.
+// Use AddFilterSync for automatic lock management.
+func (m *Modem) AddFilter(dir FilterDirection, f Filter) int {
+	m.checkLock()
+	return m.addFilter(dir, f)
+}
+
+// AddFilterSync appends f to the filter chain of the given direction with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) AddFilterSync(dir FilterDirection, f Filter) int {
+	m.Lock()
+	defer m.Unlock()
+	return m.addFilter(dir, f)
+}
+
+func (m *Modem) addFilter(dir FilterDirection, f Filter) int {
+	if dir != FilterToLine && dir != FilterToTTY {
+		return 0
+	}
+	m.filterSeq++
+	m.filters[dir] = append(m.filters[dir], filterEntry{id: m.filterSeq, filter: f})
+	return m.filterSeq
+}
+
+// RemoveFilter removes the filter with the given identifier.
+// The modem lock must be held before calling this method.
+// Use RemoveFilterSync for automatic lock management.
+func (m *Modem) RemoveFilter(id int) {
+	m.checkLock()
+	m.removeFilter(id)
+}
+
+// RemoveFilterSync removes the filter with the given identifier with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) RemoveFilterSync(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.removeFilter(id)
+}
+
+func (m *Modem) removeFilter(id int) {
+	for dir, chain := range m.filters {
+		for i, e := range chain {
+			if e.id == id {
+				// Copy, so the chain is not modified while a filter runs
+				m.filters[dir] = append(chain[:i:i], chain[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// filter runs p through the filter chain of a direction.
+func (m *Modem) filter(dir FilterDirection, p []byte) []byte {
+	chain := m.filters[dir]
+	if len(chain) == 0 || m.transparent() {
+		// Fast path, no filters installed
+		return p
+	}
+	for _, e := range chain {
+		if len(p) == 0 {
+			break
+		}
+		p = e.filter.Filter(p)
+	}
+	return p
+}
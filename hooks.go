@@ -0,0 +1,160 @@
+package vmodem
+
+import "strings"
+
+type commandHookEntry struct {
+	id   int
+	hook CommandHookType
+}
+
+// RegisterCommandHook adds hook to the chain of command hooks and returns an
+// identifier for UnregisterCommandHook. Hooks are called in registration order,
+// after the CommandHook given in ModemConfig, until one returns something other
+// than RetCodeSkip.
+// The modem lock must be held before calling this method.
+// Use RegisterCommandHookSync for automatic lock management.
+func (m *Modem) RegisterCommandHook(hook CommandHookType) int {
+	m.checkLock()
+	return m.registerCommandHook(hook)
+}
+
+// RegisterCommandHookSync adds hook to the chain of command hooks with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) RegisterCommandHookSync(hook CommandHookType) int {
+	m.Lock()
+	defer m.Unlock()
+	return m.registerCommandHook(hook)
+}
+
+func (m *Modem) registerCommandHook(hook CommandHookType) int {
+	m.commandHookSeq++
+	// Copy on write, so hooks may be (un)registered while the chain runs
+	hooks := make([]commandHookEntry, len(m.commandHooks), len(m.commandHooks)+1)
+	copy(hooks, m.commandHooks)
+	m.commandHooks = append(hooks, commandHookEntry{id: m.commandHookSeq, hook: hook})
+	return m.commandHookSeq
+}
+
+// UnregisterCommandHook removes the command hook with the given identifier.
+// The modem lock must be held before calling this method.
+// Use UnregisterCommandHookSync for automatic lock management.
+func (m *Modem) UnregisterCommandHook(id int) {
+	m.checkLock()
+	m.unregisterCommandHook(id)
+}
+
+// UnregisterCommandHookSync removes the command hook with the given identifier with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) UnregisterCommandHookSync(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.unregisterCommandHook(id)
+}
+
+func (m *Modem) unregisterCommandHook(id int) {
+	hooks := make([]commandHookEntry, 0, len(m.commandHooks))
+	for _, e := range m.commandHooks {
+		if e.id != id {
+			hooks = append(hooks, e)
+		}
+	}
+	m.commandHooks = hooks
+}
+
+// SetCommandHook replaces the CommandHook given in ModemConfig, which runs before
+// the hooks added with RegisterCommandHook. A nil hook removes it.
+// The modem lock must be held before calling this method.
+// Use SetCommandHookSync for automatic lock management.
+func (m *Modem) SetCommandHook(hook CommandHookType) {
+	m.checkLock()
+	m.setCommandHook(hook)
+}
+
+// SetCommandHookSync replaces the CommandHook given in ModemConfig with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetCommandHookSync(hook CommandHookType) {
+	m.Lock()
+	defer m.Unlock()
+	m.setCommandHook(hook)
+}
+
+func (m *Modem) setCommandHook(hook CommandHookType) {
+	m.unregisterCommandHook(m.configHookId)
+	m.configHookId = 0
+	if hook == nil {
+		return
+	}
+	m.configHookId = m.registerCommandHook(hook)
+	// Move it to the front of the chain
+	last := len(m.commandHooks) - 1
+	hooks := append([]commandHookEntry{m.commandHooks[last]}, m.commandHooks[:last]...)
+	m.commandHooks = hooks
+}
+
+// runCommandHooks calls the command hooks in order until one handles the command.
+func (m *Modem) runCommandHooks(cmd Command) RetCode {
+	for _, e := range m.commandHooks {
+		if r := e.hook(m, cmd); r != RetCodeSkip {
+			return r
+		}
+	}
+	return RetCodeSkip
+}
+
+// RegisterCommand sets handler as the implementation of the AT command name,
+// e.g. "+CSQ", "&Z" or "I", replacing any handler registered for it. Names are
+// case-insensitive. Handlers run after the command hooks and before the built-in
+// commands, which still run if the handler returns RetCodeSkip.
+// The modem lock must be held before calling this method.
+// Use RegisterCommandSync for automatic lock management.
+func (m *Modem) RegisterCommand(name string, handler CommandHookType) {
+	m.checkLock()
+	m.registerCommand(name, handler)
+}
+
+// RegisterCommandSync sets handler as the implementation of the AT command name with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) RegisterCommandSync(name string, handler CommandHookType) {
+	m.Lock()
+	defer m.Unlock()
+	m.registerCommand(name, handler)
+}
+
+func (m *Modem) registerCommand(name string, handler CommandHookType) {
+	if handler == nil {
+		m.unregisterCommand(name)
+		return
+	}
+	if m.commands == nil {
+		m.commands = make(map[string]CommandHookType)
+	}
+	m.commands[strings.ToUpper(name)] = handler
+}
+
+// UnregisterCommand removes the handler of the AT command name.
+// The modem lock must be held before calling this method.
+// Use UnregisterCommandSync for automatic lock management.
+func (m *Modem) UnregisterCommand(name string) {
+	m.checkLock()
+	m.unregisterCommand(name)
+}
+
+// UnregisterCommandSync removes the handler of the AT command name with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) UnregisterCommandSync(name string) {
+	m.Lock()
+	defer m.Unlock()
+	m.unregisterCommand(name)
+}
+
+func (m *Modem) unregisterCommand(name string) {
+	delete(m.commands, strings.ToUpper(name))
+}
+
+// runCommand calls the handler registered for the command, if any.
+func (m *Modem) runCommand(cmd Command) RetCode {
+	if handler := m.commands[cmd.Name]; handler != nil {
+		return handler(m, cmd)
+	}
+	return RetCodeSkip
+}
@@ -0,0 +1,207 @@
+package vmodem
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// JitterDistribution selects how the delay of call data varies around the base latency.
+type JitterDistribution int
+
+const (
+	// JitterUniform spreads delays evenly between Latency-Jitter and Latency+Jitter
+	JitterUniform JitterDistribution = iota
+	// JitterNormal draws delays from a normal distribution with mean Latency and
+	// standard deviation Jitter
+	JitterNormal
+)
+
+// String returns a human-readable string representation of the jitter distribution.
+func (d JitterDistribution) String() string {
+	switch d {
+	case JitterUniform:
+		return "Uniform"
+	case JitterNormal:
+		return "Normal"
+	default:
+		return "Unknown"
+	}
+}
+
+// LineImpairment degrades the emulated line of connected calls, to test
+// protocols under realistic dial-up conditions. The zero value is a perfect line.
+type LineImpairment struct {
+	// Latency is the base delay of call data in each direction
+	Latency time.Duration
+	// Jitter is the variation of the delay around Latency
+	Jitter time.Duration
+	// Distribution selects how the delay varies (default: JitterUniform)
+	Distribution JitterDistribution
+	// BitErrorRate is the probability of each bit of call data being flipped (0 to 1)
+	BitErrorRate float64
+	// GarbageRate is the probability of a random byte being inserted after each
+	// byte of call data (0 to 1)
+	GarbageRate float64
+	// CarrierLoss is the mean connected time before the carrier drops on its own,
+	// exponentially distributed so the drop is equally likely at any moment of a
+	// call (0 = never)
+	CarrierLoss time.Duration
+}
+
+// delays reports whether the impairment delays call data.
+func (i LineImpairment) delays() bool {
+	return i.Latency > 0 || i.Jitter > 0
+}
+
+// noisy reports whether the impairment corrupts call data.
+func (i LineImpairment) noisy() bool {
+	return i.BitErrorRate > 0 || i.GarbageRate > 0
+}
+
+// carrierTime returns how long the carrier of a new call lasts, 0 if it never drops.
+func (i LineImpairment) carrierTime() time.Duration {
+	if i.CarrierLoss <= 0 {
+		return 0
+	}
+	return time.Duration(rand.ExpFloat64() * float64(i.CarrierLoss))
+}
+
+// Impairment returns the line impairment of the modem.
+// The modem lock must be held before calling this method.
+// Use ImpairmentSync for automatic lock management.
+func (m *Modem) Impairment() LineImpairment {
+	m.checkLock()
+	return m.impairment
+}
+
+// ImpairmentSync returns the line impairment with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) ImpairmentSync() LineImpairment {
+	m.Lock()
+	defer m.Unlock()
+	return m.impairment
+}
+
+// SetImpairment changes the line impairment at runtime. It applies to the calls
+// connected afterwards; a call in progress keeps the line it started with.
+// The modem lock must be held before calling this method.
+// Use SetImpairmentSync for automatic lock management.
+func (m *Modem) SetImpairment(impairment LineImpairment) {
+	m.checkLock()
+	m.impairment = impairment
+}
+
+// SetImpairmentSync changes the line impairment with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetImpairmentSync(impairment LineImpairment) {
+	m.Lock()
+	defer m.Unlock()
+	m.impairment = impairment
+}
+
+// lineNoise corrupts the data of one direction of a call. Errors are spaced
+// by geometrically distributed gaps, so the cost does not depend on the rate.
+type lineNoise struct {
+	imp         LineImpairment
+	nextFlip    int64 // Bits until the next flipped bit
+	nextGarbage int64 // Bytes until the next inserted byte
+	buf         []byte
+	corrupt     atomic.Int64 // Bytes flipped or inserted, read by the metrics
+}
+
+// newLineNoise returns the noise model of imp, nil for a clean line.
+func newLineNoise(imp LineImpairment) *lineNoise {
+	if !imp.noisy() {
+		return nil
+	}
+	n := &lineNoise{imp: imp}
+	n.nextFlip = gap(imp.BitErrorRate)
+	n.nextGarbage = gap(imp.GarbageRate)
+	return n
+}
+
+// gap returns the number of trials before the next event of probability p,
+// or -1 if p is zero.
+func gap(p float64) int64 {
+	switch {
+	case p <= 0:
+		return -1
+	case p >= 1:
+		return 0
+	}
+	return int64(math.Log(1-rand.Float64()) / math.Log(1-p))
+}
+
+// apply returns p with the noise added. The result is only valid until the
+// next call, and p is not modified. A nil lineNoise returns p.
+func (n *lineNoise) apply(p []byte) []byte {
+	if n == nil {
+		return p
+	}
+	buf := n.buf[:0]
+	for n.nextGarbage >= 0 && n.nextGarbage < int64(len(p)) {
+		k := n.nextGarbage + 1
+		buf = append(buf, p[:k]...)
+		buf = append(buf, byte(rand.IntN(256)))
+		n.corrupt.Add(1)
+		p = p[k:]
+		n.nextGarbage = gap(n.imp.GarbageRate)
+	}
+	buf = append(buf, p...)
+	if n.nextGarbage >= 0 {
+		n.nextGarbage -= int64(len(p))
+	}
+	if n.nextFlip >= 0 {
+		bits := int64(len(buf)) * 8
+		pos := n.nextFlip
+		last := int64(-1)
+		for ; pos < bits; pos += gap(n.imp.BitErrorRate) + 1 {
+			buf[pos/8] ^= 1 << (pos % 8)
+			if pos/8 != last {
+				last = pos / 8
+				n.corrupt.Add(1)
+			}
+		}
+		n.nextFlip = pos - bits
+	}
+	n.buf = buf
+	return buf
+}
+
+// corrupted returns the number of bytes flipped or inserted so far, 0 for a
+// clean line.
+func (n *lineNoise) corrupted() int {
+	if n == nil {
+		return 0
+	}
+	return int(n.corrupt.Load())
+}
+
+// lineDelay computes the delivery time of the data of one direction of a call.
+// Data is delivered in order, like on a serial line: a chunk is never due
+// before the previous one.
+type lineDelay struct {
+	imp  LineImpairment
+	last time.Time
+}
+
+// due returns when data received at now must be delivered.
+func (d *lineDelay) due(now time.Time) time.Time {
+	delay := d.imp.Latency
+	if j := d.imp.Jitter; j > 0 {
+		switch d.imp.Distribution {
+		case JitterNormal:
+			delay += time.Duration(rand.NormFloat64() * float64(j))
+		default:
+			delay += time.Duration(rand.Int64N(int64(2*j)+1)) - j
+		}
+	}
+	t := now.Add(max(delay, 0))
+	if t.Before(d.last) {
+		t = d.last
+	}
+	d.last = t
+	return t
+}
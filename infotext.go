@@ -0,0 +1,49 @@
+package vmodem
+
+import "strings"
+
+// lineChars returns the command line termination (S3) and response
+// formatting (S4) characters, falling back to CR and LF when unset.
+func (m *Modem) lineChars() (string, string) {
+	s3, s4 := m.sregs[3], m.sregs[4]
+	if s3 == 0 {
+		s3 = '\r'
+	}
+	if s4 == 0 {
+		s4 = '\n'
+	}
+	return string(s3), string(s4)
+}
+
+func (m *Modem) writeInfoText(lines ...string) {
+	if len(lines) == 0 {
+		return
+	}
+	s3, s4 := m.lineChars()
+	eol := s3 + s4
+	text := strings.Join(lines, eol) + eol
+	if !m.shortForm {
+		text = eol + text
+	}
+	m.ttyWriteStr(text)
+}
+
+// WriteInfoText writes an information response (e.g. the answer to a query
+// handled by a CommandHook) framed as required by V.250: in verbose mode (ATV1)
+// it is headed and trailed by S3/S4 (CR LF by default), in numeric mode (ATV0)
+// it is only trailed by them. Each line is sent separated by S3/S4. Unlike result
+// codes, information text is written in quiet mode (ATQ1) too.
+// The modem lock must be held before calling this method.
+// Use WriteInfoTextSync for automatic lock management.
+func (m *Modem) WriteInfoText(lines ...string) {
+	m.checkLock()
+	m.writeInfoText(lines...)
+}
+
+// WriteInfoTextSync writes an information response with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) WriteInfoTextSync(lines ...string) {
+	m.Lock()
+	defer m.Unlock()
+	m.writeInfoText(lines...)
+}
@@ -0,0 +1,81 @@
+package vmodem
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// rateWindow is the number of seconds data rates are averaged over.
+const rateWindow = 5
+
+// rateMeter measures a data rate over the last rateWindow seconds, in buckets
+// of one second. It is used under the modem lock.
+type rateMeter struct {
+	buckets [rateWindow]int
+	sec     int64 // Unix second of the current bucket
+}
+
+// advance moves the meter to the second of now, clearing expired buckets.
+func (r *rateMeter) advance(now time.Time) {
+	s := now.Unix()
+	if s-r.sec >= rateWindow {
+		clear(r.buckets[:])
+	} else {
+		for t := r.sec + 1; t <= s; t++ {
+			r.buckets[t%rateWindow] = 0
+		}
+	}
+	r.sec = max(r.sec, s)
+}
+
+// add counts n bytes transferred at now.
+func (r *rateMeter) add(n int, now time.Time) {
+	r.advance(now)
+	r.buckets[r.sec%rateWindow] += n
+}
+
+// rate returns the average bytes per second up to now.
+func (r *rateMeter) rate(now time.Time) float64 {
+	r.advance(now)
+	total := 0
+	for _, n := range r.buckets {
+		total += n
+	}
+	elapsed := float64(rateWindow-1) + float64(now.Nanosecond())/1e9
+	return float64(total) / elapsed
+}
+
+// expvarModems is the expvar map modems publish their metrics in, keyed by Id.
+var expvarModems = sync.OnceValue(func() *expvar.Map { return expvar.NewMap("vmodem") })
+
+// expvarModem publishes the metrics of a modem as JSON.
+type expvarModem struct {
+	m *Modem
+}
+
+func (v *expvarModem) String() string {
+	b, err := json.Marshal(v.m.MetricsSync())
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
+
+// publishExpvar publishes the metrics of m in the "vmodem" expvar map, replacing
+// those of any other modem with the same Id.
+func (m *Modem) publishExpvar() {
+	m.expvar = &expvarModem{m: m}
+	expvarModems().Set(m.id, m.expvar)
+}
+
+// unpublishExpvar removes the metrics of m from expvar, unless another modem
+// with the same Id has replaced them. expvar holds its lock while reading the
+// metrics, which takes the modem lock, so this must run without the modem lock.
+func (m *Modem) unpublishExpvar(v *expvarModem) {
+	vars := expvarModems()
+	if vars.Get(m.id) == v {
+		vars.Delete(m.id)
+	}
+}
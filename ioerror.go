@@ -0,0 +1,76 @@
+package vmodem
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// IOOperation identifies the operation that failed in an IOErrorType callback.
+type IOOperation int
+
+const (
+	// OpTTYRead is a read from the TTY
+	OpTTYRead IOOperation = iota
+	// OpTTYWrite is a write to the TTY
+	OpTTYWrite
+	// OpConnRead is a read from the call connection
+	OpConnRead
+	// OpConnWrite is a write to the call connection
+	OpConnWrite
+	// OpDial is an outgoing call attempt
+	OpDial
+)
+
+// String returns a human-readable string representation of the operation.
+func (op IOOperation) String() string {
+	switch op {
+	case OpTTYRead:
+		return "TTYRead"
+	case OpTTYWrite:
+		return "TTYWrite"
+	case OpConnRead:
+		return "ConnRead"
+	case OpConnWrite:
+		return "ConnWrite"
+	case OpDial:
+		return "Dial"
+	default:
+		return "Unknown"
+	}
+}
+
+// IOErrorType defines a callback function that is called when an I/O operation
+// of the modem fails. TTY failures close the modem; connection failures end the
+// call. A remote side closing the connection (io.EOF) is a normal hangup and is
+// not reported. It is called with the modem lock held.
+type IOErrorType func(m *Modem, op IOOperation, err error)
+
+// DeadType defines a callback function that is called once when the modem is
+// closed because its TTY failed, so the embedder can recreate the TTY and the
+// modem. It receives the TTY error and is called with the modem lock held.
+type DeadType func(m *Modem, err error)
+
+// ioError reports a failed I/O operation to the IOError callback.
+func (m *Modem) ioError(op IOOperation, err error) {
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	if (op == OpConnRead && errors.Is(err, io.EOF)) || (op == OpDial && errors.Is(err, context.Canceled)) {
+		return
+	}
+	if m.ioErrorHook != nil {
+		m.ioErrorHook(m, op, err)
+	}
+}
+
+// die closes the modem after a TTY failure and sends the dead notification.
+func (m *Modem) die(op IOOperation, err error) {
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	m.ioError(op, err)
+	if m.setStatus(StatusClosed, CauseTTY) == nil && m.dead != nil {
+		m.dead(m, err)
+	}
+}
@@ -0,0 +1,47 @@
+package vmodem
+
+import (
+	"context"
+	"time"
+)
+
+// responseDelay returns how long the modem waits before writing result code r
+// in reply to a command line.
+func (m *Modem) responseDelay(r RetCode) time.Duration {
+	if d, ok := m.respDelays[r]; ok {
+		return d
+	}
+	return m.respDelay
+}
+
+// commandResult writes the result code of a command line after its response
+// delay. The lock is released while waiting, so calls and the API keep running.
+func (m *Modem) commandResult(r RetCode) {
+	if d := m.responseDelay(r); d > 0 && r != RetCodeSilent {
+		m.Unlock()
+		time.Sleep(d)
+		m.Lock()
+		if m.status() == StatusClosed {
+			return
+		}
+	}
+	m.printRetCode(r)
+}
+
+// handshake connects the call being answered once the connect delay is over,
+// unless the answer is aborted first (which changes the status and cancels ctx).
+func (m *Modem) handshake(ctx context.Context, delay time.Duration, cause TransitionCause) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(delay):
+	}
+	m.Lock()
+	defer m.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	if err := m.setStatus(StatusConnected, cause); err != nil {
+		m.setStatus(StatusIdle, CauseRemote)
+	}
+}
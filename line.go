@@ -0,0 +1,115 @@
+package vmodem
+
+import (
+	"io"
+	"sync"
+)
+
+// lineBufferSize is the data an in-memory line holds in each direction before
+// writers block, like the send buffer of a socket.
+const lineBufferSize = 64 * 1024
+
+// lineBuffer carries data in one direction of an in-memory line, in a ring
+// buffer allocated on first use.
+type lineBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	head   int // Offset of the first unread byte
+	size   int // Bytes not read yet
+	closed bool
+}
+
+func newLineBuffer() *lineBuffer {
+	b := &lineBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *lineBuffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.size == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		b.cond.Wait()
+	}
+	n := copy(p, b.buf[b.head:min(b.head+b.size, len(b.buf))])
+	if n < len(p) && n < b.size {
+		// Wrap around
+		n += copy(p[n:], b.buf[:b.size-n])
+	}
+	b.head = (b.head + n) % len(b.buf)
+	b.size -= n
+	b.cond.Broadcast()
+	return n, nil
+}
+
+func (b *lineBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.buf == nil {
+		b.buf = make([]byte, lineBufferSize)
+	}
+	written := 0
+	for written < len(p) {
+		if b.closed {
+			return written, io.ErrClosedPipe
+		}
+		if b.size == len(b.buf) {
+			b.cond.Wait()
+			continue
+		}
+		tail := (b.head + b.size) % len(b.buf)
+		end := len(b.buf)
+		if tail < b.head {
+			end = b.head
+		}
+		n := copy(b.buf[tail:end], p[written:])
+		b.size += n
+		written += n
+		b.cond.Broadcast()
+	}
+	return written, nil
+}
+
+func (b *lineBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// lineEnd is one end of an in-memory line.
+type lineEnd struct {
+	rx *lineBuffer
+	tx *lineBuffer
+}
+
+func (e *lineEnd) Read(p []byte) (int, error) {
+	return e.rx.read(p)
+}
+
+func (e *lineEnd) Write(p []byte) (int, error) {
+	return e.tx.write(p)
+}
+
+// Close hangs up the line: the other end reads the remaining data and then
+// io.EOF, and writes on either end fail with io.ErrClosedPipe.
+func (e *lineEnd) Close() error {
+	e.tx.close()
+	e.rx.close()
+	return nil
+}
+
+// NewLine returns the two ends of an in-memory phone line, so an application can
+// embed the modem and talk to the call directly without sockets. Hand modemEnd to
+// the modem, through IncomingCall or as the connection returned by an OutgoingCall
+// hook, and use hostEnd to exchange data with the DTE once the call is connected.
+// Writes are buffered, blocking only when the other end falls 64 KiB behind.
+func NewLine() (hostEnd io.ReadWriteCloser, modemEnd io.ReadWriteCloser) {
+	a := newLineBuffer()
+	b := newLineBuffer()
+	return &lineEnd{rx: a, tx: b}, &lineEnd{rx: b, tx: a}
+}
@@ -0,0 +1,109 @@
+package vmodem
+
+import "io"
+
+// observerQueueLen is the number of pending writes an observer can lag behind
+// before further data is dropped for it.
+const observerQueueLen = 64
+
+type observer struct {
+	w     io.Writer
+	input bool
+	ch    chan []byte
+}
+
+func (o *observer) run() {
+	for b := range o.ch {
+		if _, err := o.w.Write(b); err != nil {
+			break
+		}
+	}
+	// Keep draining so the modem never blocks on a dead observer
+	for range o.ch {
+	}
+}
+
+// AddObserver attaches a read-only observer that receives a copy of everything
+// written to the TTY and, if input is true, everything received from it.
+// Observers are fed asynchronously: a slow observer loses data instead of
+// delaying the modem, and a failing one is silently ignored.
+// It returns an identifier for RemoveObserver.
+// The modem lock must be held before calling this method.
+// Use AddObserverSync for automatic lock management.
+func (m *Modem) AddObserver(w io.Writer, input bool) int {
+	m.checkLock()
+	return m.addObserver(w, input)
+}
+
+// AddObserverSync attaches a read-only observer with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) AddObserverSync(w io.Writer, input bool) int {
+	m.Lock()
+	defer m.Unlock()
+	return m.addObserver(w, input)
+}
+
+func (m *Modem) addObserver(w io.Writer, input bool) int {
+	o := &observer{
+		w:     w,
+		input: input,
+		ch:    make(chan []byte, observerQueueLen),
+	}
+	m.observerSeq++
+	if m.status() == StatusClosed {
+		close(o.ch)
+		go o.run()
+	} else {
+		if m.observers == nil {
+			m.observers = make(map[int]*observer)
+		}
+		m.observers[m.observerSeq] = o
+		m.goTask(o.run)
+	}
+	return m.observerSeq
+}
+
+// RemoveObserver detaches the observer with the given identifier.
+// The modem lock must be held before calling this method.
+// Use RemoveObserverSync for automatic lock management.
+func (m *Modem) RemoveObserver(id int) {
+	m.checkLock()
+	m.removeObserver(id)
+}
+
+// RemoveObserverSync detaches the observer with the given identifier with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) RemoveObserverSync(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.removeObserver(id)
+}
+
+func (m *Modem) removeObserver(id int) {
+	if o, ok := m.observers[id]; ok {
+		close(o.ch)
+		delete(m.observers, id)
+	}
+}
+
+func (m *Modem) removeObservers() {
+	for id := range m.observers {
+		m.removeObserver(id)
+	}
+}
+
+// observe copies TTY traffic to the attached observers.
+func (m *Modem) observe(b []byte, input bool) {
+	if len(m.observers) == 0 || len(b) == 0 {
+		return
+	}
+	for _, o := range m.observers {
+		if input && !o.input {
+			continue
+		}
+		select {
+		case o.ch <- append([]byte(nil), b...):
+		default:
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package vmodem
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"strconv"
+	"time"
+)
+
+// Option sets up a modem created with NewModemWithOptions.
+type Option func(o *modemOptions)
+
+type modemOptions struct {
+	config ModemConfig
+	init   []func(m *Modem)
+}
+
+// NewModemWithOptions creates a modem attached to tty, configured by opts, and
+// starts it. The modem is closed when ctx is done.
+// Options are the preferred way to configure new modems; ModemConfig and NewModem
+// remain supported.
+//
+// Returns ErrConfigRequired if tty is nil.
+func NewModemWithOptions(ctx context.Context, tty io.ReadWriteCloser, opts ...Option) (*Modem, error) {
+	o := &modemOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.config.TTY = tty
+	m, err := newModem(&o.config, func(m *Modem) {
+		for _, init := range o.init {
+			init(m)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	context.AfterFunc(ctx, func() { m.CloseSync() })
+	return m, nil
+}
+
+// WithConfig uses config as the base configuration; later options override it.
+// Its TTY field is ignored.
+func WithConfig(config ModemConfig) Option {
+	return func(o *modemOptions) { o.config = config }
+}
+
+// WithId sets the modem identifier.
+func WithId(id string) Option {
+	return func(o *modemOptions) { o.config.Id = id }
+}
+
+// WithOutgoingCall sets the outgoing call hook.
+func WithOutgoingCall(hook OutgoingCallType) Option {
+	return func(o *modemOptions) { o.config.OutgoingCall = hook }
+}
+
+// WithOutgoingCallContext sets an outgoing call hook that observes call cancellation.
+func WithOutgoingCallContext(hook OutgoingCallContextType) Option {
+	return func(o *modemOptions) { o.config.OutgoingCallContext = hook }
+}
+
+// WithCommandHook sets the first command hook of the chain.
+func WithCommandHook(hook CommandHookType) Option {
+	return func(o *modemOptions) { o.config.CommandHook = hook }
+}
+
+// WithCommand registers handler as the implementation of the AT command name.
+// See Modem.RegisterCommand.
+func WithCommand(name string, handler CommandHookType) Option {
+	return func(o *modemOptions) {
+		o.init = append(o.init, func(m *Modem) { m.registerCommand(name, handler) })
+	}
+}
+
+// WithLineHook sets the command line hook.
+func WithLineHook(hook LineHookType) Option {
+	return func(o *modemOptions) { o.config.LineHook = hook }
+}
+
+// WithStatusTransition sets the status change callback.
+func WithStatusTransition(callback StatusTransitionType) Option {
+	return func(o *modemOptions) { o.config.StatusTransition = callback }
+}
+
+// WithSRegChange sets the callback for S-register changes made by the DTE.
+func WithSRegChange(callback SRegChangeType) Option {
+	return func(o *modemOptions) { o.config.SRegChange = callback }
+}
+
+// WithRing sets the callback called on every ring of an incoming call.
+func WithRing(callback RingType) Option {
+	return func(o *modemOptions) { o.config.Ring = callback }
+}
+
+// WithMissedCall sets the callback for incoming calls that were not answered.
+func WithMissedCall(callback MissedCallType) Option {
+	return func(o *modemOptions) { o.config.MissedCall = callback }
+}
+
+// WithBusy sets the busy indication and callback for incoming calls rejected
+// because the modem is not idle. Either may be empty.
+func WithBusy(busyStr string, callback BusyCallType) Option {
+	return func(o *modemOptions) {
+		o.config.BusyStr = busyStr
+		o.config.BusyCall = callback
+	}
+}
+
+// WithSharedTTY shares the modem with a second terminal. See ModemConfig.SharedTTY.
+func WithSharedTTY(tty io.ReadWriteCloser, arbitration InputArbitration) Option {
+	return func(o *modemOptions) {
+		o.config.SharedTTY = tty
+		o.config.SharedInput = arbitration
+	}
+}
+
+// WithLogger sets the logger for modem diagnostics.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *modemOptions) { o.config.Logger = logger }
+}
+
+// WithConnectStr sets the text sent when a connection is established.
+func WithConnectStr(s string) Option {
+	return func(o *modemOptions) { o.config.ConnectStr = s }
+}
+
+// WithConnectSpeed reports speed in the CONNECT result, e.g. "CONNECT 33600".
+func WithConnectSpeed(speed int) Option {
+	return func(o *modemOptions) { o.config.ConnectStr = "CONNECT " + strconv.Itoa(speed) }
+}
+
+// WithLineSpeed paces call data to an emulated line speed in bits per second.
+func WithLineSpeed(speed int) Option {
+	return func(o *modemOptions) { o.config.LineSpeed = speed }
+}
+
+// WithExpvar publishes the modem metrics in the "vmodem" expvar map.
+func WithExpvar() Option {
+	return func(o *modemOptions) { o.config.PublishExpvar = true }
+}
+
+// WithCharDelay paces command mode output for vintage terminals, with at least d
+// between characters.
+func WithCharDelay(d time.Duration) Option {
+	return func(o *modemOptions) { o.config.CharDelay = d }
+}
+
+// WithWriteCoalescing sets the maximum size of connection writes and how long TTY
+// input is held for more input to coalesce before it is written.
+func WithWriteCoalescing(chunkSize int, flushDelay time.Duration) Option {
+	return func(o *modemOptions) {
+		o.config.WriteChunkSize = chunkSize
+		o.config.WriteFlushDelay = flushDelay
+	}
+}
+
+// WithTransparency selects when online data passes through the modem unmodified.
+func WithTransparency(t Transparency) Option {
+	return func(o *modemOptions) { o.config.Transparency = t }
+}
+
+// WithImpairment degrades the emulated line of connected calls.
+func WithImpairment(impairment LineImpairment) Option {
+	return func(o *modemOptions) { o.config.Impairment = impairment }
+}
+
+// WithLocale sets the verbose result code text table.
+func WithLocale(locale ResultLocale) Option {
+	return func(o *modemOptions) { o.config.Locale = locale }
+}
+
+// WithResultText overrides the verbose text of individual result codes.
+func WithResultText(text ResultLocale) Option {
+	return func(o *modemOptions) { o.config.ResultText = text }
+}
+
+// WithNumericCodes overrides the numeric form of individual result codes.
+func WithNumericCodes(codes map[RetCode]string) Option {
+	return func(o *modemOptions) { o.config.NumericCodes = codes }
+}
+
+// WithRingMax sets the maximum number of rings before an incoming call is dropped.
+func WithRingMax(rings int) Option {
+	return func(o *modemOptions) { o.config.RingMax = rings }
+}
+
+// WithRingTimeout sets the maximum time an incoming call rings.
+func WithRingTimeout(timeout time.Duration) Option {
+	return func(o *modemOptions) { o.config.RingTimeout = timeout }
+}
+
+// WithAnswerChar sets the character sent when answering and expected when dialing.
+func WithAnswerChar(c string) Option {
+	return func(o *modemOptions) { o.config.AnswerChar = c }
+}
+
+// WithGuardTime sets the +++ escape sequence guard time in 50ms increments (S12).
+func WithGuardTime(guardTime int) Option {
+	return func(o *modemOptions) { o.config.GuardTime = guardTime }
+}
+
+// WithEscapeGuards enables or disables the guard times before and after the +++ escape sequence.
+func WithEscapeGuards(pre bool, post bool) Option {
+	return func(o *modemOptions) {
+		o.config.DisablePreGuard = !pre
+		o.config.DisablePostGuard = !post
+	}
+}
+
+// WithMaxCmdLen sets the maximum command line length.
+func WithMaxCmdLen(n int) Option {
+	return func(o *modemOptions) { o.config.MaxCmdLen = n }
+}
+
+// WithCommandParity sets the parity handling of command mode input.
+func WithCommandParity(parity Parity) Option {
+	return func(o *modemOptions) { o.config.CommandParity = parity }
+}
+
+// WithResumeBufferSize sets the remote data kept while in online command mode.
+func WithResumeBufferSize(size int) Option {
+	return func(o *modemOptions) { o.config.ResumeBufferSize = size }
+}
+
+// WithUnsolicitedPolicy sets what happens to unsolicited result codes sent in data mode.
+func WithUnsolicitedPolicy(policy UnsolicitedPolicy) Option {
+	return func(o *modemOptions) { o.config.UnsolicitedPolicy = policy }
+}
+
+// WithResponseDelay sets how long the modem takes to answer a command line.
+func WithResponseDelay(delay time.Duration) Option {
+	return func(o *modemOptions) { o.config.ResponseDelay = delay }
+}
+
+// WithResultDelay sets the response delay of result code code, overriding WithResponseDelay.
+func WithResultDelay(code RetCode, delay time.Duration) Option {
+	return func(o *modemOptions) {
+		delays := maps.Clone(o.config.ResponseDelays)
+		if delays == nil {
+			delays = make(map[RetCode]time.Duration)
+		}
+		delays[code] = delay
+		o.config.ResponseDelays = delays
+	}
+}
+
+// WithConnectDelay sets the handshake time between the remote answering and CONNECT.
+func WithConnectDelay(delay time.Duration) Option {
+	return func(o *modemOptions) { o.config.ConnectDelay = delay }
+}
+
+// WithUnknownCommandPolicy sets the result of unknown commands.
+func WithUnknownCommandPolicy(policy UnknownCommandPolicy) Option {
+	return func(o *modemOptions) { o.config.UnknownCommandPolicy = policy }
+}
+
+// WithUnknownCommand delegates unknown commands to hook (UnknownCommandHook policy).
+func WithUnknownCommand(hook CommandHookType) Option {
+	return func(o *modemOptions) {
+		o.config.UnknownCommandPolicy = UnknownCommandHook
+		o.config.UnknownCommand = hook
+	}
+}
+
+// WithCallRecord sets the callback called with the detail record of every call when it ends.
+func WithCallRecord(callback CallRecordType) Option {
+	return func(o *modemOptions) { o.config.CallRecord = callback }
+}
+
+// WithCapture records both directions of every call to c.
+func WithCapture(c *Capture) Option {
+	return func(o *modemOptions) { o.config.Capture = c }
+}
+
+// WithDialTimeout sets a hard limit on the time an outgoing call may take to connect.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(o *modemOptions) { o.config.DialTimeout = timeout }
+}
+
+// WithIOError sets the callback for failed TTY and connection I/O operations.
+func WithIOError(callback IOErrorType) Option {
+	return func(o *modemOptions) { o.config.IOError = callback }
+}
+
+// WithDead sets the callback for the modem being closed by a TTY failure.
+func WithDead(callback DeadType) Option {
+	return func(o *modemOptions) { o.config.Dead = callback }
+}
+
+// WithTTYBuffer queues TTY output in a buffer of size bytes delivered by a writer
+// goroutine, so a stalled DTE reader does not block the modem. policy selects
+// what happens when the buffer is full.
+func WithTTYBuffer(size int, policy TTYOverflowPolicy) Option {
+	return func(o *modemOptions) {
+		o.config.TTYBufferSize = size
+		o.config.TTYOverflow = policy
+	}
+}
+
+// WithEcho sets command echo (ATE), also as the default restored by ATZ and AT&F.
+func WithEcho(echo bool) Option {
+	return func(o *modemOptions) {
+		o.init = append(o.init, func(m *Modem) { m.setEcho(echo) })
+	}
+}
+
+// WithVerbose selects verbose or numeric result codes (ATV), also as the default
+// restored by ATZ and AT&F.
+func WithVerbose(verbose bool) Option {
+	return func(o *modemOptions) {
+		o.init = append(o.init, func(m *Modem) { m.setVerbose(verbose) })
+	}
+}
+
+// WithQuiet suppresses result codes (ATQ), also as the default restored by ATZ and AT&F.
+func WithQuiet(quiet bool) Option {
+	return func(o *modemOptions) {
+		o.init = append(o.init, func(m *Modem) { m.setQuiet(quiet) })
+	}
+}
+
+// WithSReg sets the initial value of S-register reg, also as the default restored by ATZ and AT&F.
+func WithSReg(reg byte, value byte) Option {
+	return func(o *modemOptions) {
+		sregs := maps.Clone(o.config.SRegs)
+		if sregs == nil {
+			sregs = make(map[byte]byte)
+		}
+		sregs[reg] = value
+		o.config.SRegs = sregs
+	}
+}
@@ -0,0 +1,66 @@
+package vmodem
+
+import (
+	"context"
+	"io"
+)
+
+// pairAnswerChar is the answer character used by modem pairs when none is configured.
+const pairAnswerChar = "C"
+
+// NewPair creates two modems wired back to back through an in-memory line, like a
+// null-modem cable with a phone exchange in between: dialing any number on one
+// rings the other, and the caller gets CONNECT once the other side answers.
+// The outgoing call hooks of both configurations are replaced, and their AnswerChar
+// must match; when empty, both use the same default.
+// The configurations are not modified.
+//
+// Returns ErrConfigRequired if a configuration is missing or invalid.
+func NewPair(configA *ModemConfig, configB *ModemConfig) (*Modem, *Modem, error) {
+	if configA == nil || configB == nil {
+		return nil, nil, ErrConfigRequired
+	}
+	cfgA := *configA
+	cfgB := *configB
+	switch {
+	case cfgA.AnswerChar == "" && cfgB.AnswerChar == "":
+		cfgA.AnswerChar = pairAnswerChar
+		cfgB.AnswerChar = pairAnswerChar
+	case cfgA.AnswerChar == "":
+		cfgA.AnswerChar = cfgB.AnswerChar
+	case cfgB.AnswerChar == "":
+		cfgB.AnswerChar = cfgA.AnswerChar
+	case cfgA.AnswerChar[0] != cfgB.AnswerChar[0]:
+		return nil, nil, ErrConfigRequired
+	}
+
+	var a, b *Modem
+	cfgA.OutgoingCall = nil
+	cfgA.OutgoingCallContext = func(ctx context.Context, m *Modem, number string) (io.ReadWriteCloser, error) {
+		return ringPeer(b)
+	}
+	cfgB.OutgoingCall = nil
+	cfgB.OutgoingCallContext = func(ctx context.Context, m *Modem, number string) (io.ReadWriteCloser, error) {
+		return ringPeer(a)
+	}
+
+	a, err := NewModem(&cfgA)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err = NewModem(&cfgB)
+	if err != nil {
+		a.CloseSync()
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+// ringPeer places an incoming call on peer and returns the caller's end of the line.
+func ringPeer(peer *Modem) (io.ReadWriteCloser, error) {
+	callerEnd, peerEnd := NewLine()
+	if err := peer.IncomingCallSync(peerEnd); err != nil {
+		return nil, err
+	}
+	return callerEnd, nil
+}
@@ -0,0 +1,394 @@
+package vmodem
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// pumpBufferSize is the size of the buffers moving call data, as used by io.Copy
+	pumpBufferSize = 32 * 1024
+	// pumpQueueLen is the number of data chunks queued in each direction
+	pumpQueueLen = 16
+)
+
+// pumpBuffers recycles the buffers of the data pumps of all modems.
+var pumpBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, pumpBufferSize)
+		return &b
+	},
+}
+
+// putPumpBuffer returns b to the buffer pool.
+func putPumpBuffer(b *[]byte) {
+	*b = (*b)[:cap(*b)]
+	pumpBuffers.Put(b)
+}
+
+// chunk is call data queued in a pump, with the time it is due for delivery.
+type chunk struct {
+	b   *[]byte
+	due time.Time
+}
+
+// pump moves the data of a connected call between the TTY and the connection
+// with one goroutine per direction. Both stop when the call ends or either
+// direction fails, so a slow or broken connection never blocks the modem: a
+// stalled peer fills the bounded queue of TTY input, and the TTY read task then
+// waits for room without holding the modem lock.
+type pump struct {
+	call    context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	toLine  chan chunk
+	err     error // Set by the line writer before cancelling ctx
+	imp     LineImpairment
+	delay   lineDelay     // Delay of TTY input, used by send under the modem lock
+	noise   *lineNoise    // Noise of TTY input, used by send under the modem lock
+	rxNoise *lineNoise    // Noise of connection data, used by the remote writer
+	txPace  *throttle     // Pacing of TTY input, used by the line writer
+	rxPace  *throttle     // Pacing of connection data, used by the remote writer
+	chunk   int           // Maximum size of connection writes
+	flush   time.Duration // Time TTY input is held waiting for more to coalesce
+	capture *Capture      // Capture recording the call, nil if none
+}
+
+// writeChunkSize returns the size of connection writes for the configured size,
+// pumpBufferSize by default and at most.
+func writeChunkSize(size int) int {
+	if size <= 0 {
+		return pumpBufferSize
+	}
+	return min(size, pumpBufferSize)
+}
+
+// startPump starts the data pump of the current call over conn. Incoming calls
+// start it when they ring, so the caller hanging up is noticed, and outgoing
+// calls when they connect.
+func (m *Modem) startPump(conn io.ReadWriteCloser) {
+	p := &pump{
+		call:    m.call.ctx,
+		toLine:  make(chan chunk, pumpQueueLen),
+		imp:     m.impairment,
+		delay:   lineDelay{imp: m.impairment},
+		noise:   newLineNoise(m.impairment),
+		rxNoise: newLineNoise(m.impairment),
+		txPace:  newThrottle(m.lineSpeed),
+		rxPace:  newThrottle(m.lineSpeed),
+		chunk:   m.writeChunk,
+		flush:   m.flushDelay,
+	}
+	p.ctx, p.cancel = context.WithCancel(p.call)
+	m.call.pump = p
+	conn = m.captureCall(p, conn)
+	m.goTask(func() { m.pumpToLine(p, conn) })
+	m.goTask(func() { m.pumpFromLine(p, conn) })
+}
+
+// paced returns the time the data of p waited for the line speed, in both
+// directions.
+func (p *pump) paced() time.Duration {
+	return p.txPace.paced() + p.rxPace.paced()
+}
+
+// corrupted returns the number of bytes of p damaged by line noise, in both
+// directions.
+func (p *pump) corrupted() int {
+	return p.noise.corrupted() + p.rxNoise.corrupted()
+}
+
+// startCarrierLoss arms the carrier loss of the line impairment when the call
+// of p connects.
+func (m *Modem) startCarrierLoss(p *pump) {
+	if d := m.impairment.carrierTime(); d > 0 {
+		m.goTask(func() { m.carrierLoss(p, d) })
+	}
+}
+
+// greet queues b for the connection ahead of any TTY input, such as the answer
+// character. It is called when the call connects, while the queue is empty.
+func (p *pump) greet(b []byte) {
+	buf := pumpBuffers.Get().(*[]byte)
+	*buf = (*buf)[:copy(*buf, b)]
+	select {
+	case p.toLine <- chunk{b: buf}:
+	default:
+		putPumpBuffer(buf)
+	}
+}
+
+// send queues TTY input for the connection, called with mu locked. While the
+// queue is full mu is unlocked, so a stalled connection blocks the TTY but not
+// the modem. It returns false if the pump has stopped. The data is copied, so
+// the caller keeps ownership of data.
+func (p *pump) send(mu sync.Locker, data []byte) bool {
+	due := time.Time{}
+	if p.imp.delays() {
+		due = p.delay.due(time.Now())
+	}
+	data = p.noise.apply(data)
+	for len(data) > 0 {
+		b := pumpBuffers.Get().(*[]byte)
+		n := copy(*b, data)
+		*b = (*b)[:n]
+		data = data[n:]
+		c := chunk{b: b, due: due}
+		select {
+		case p.toLine <- c:
+			continue
+		default:
+		}
+		mu.Unlock()
+		select {
+		case p.toLine <- c:
+		case <-p.ctx.Done():
+			putPumpBuffer(b)
+			mu.Lock()
+			return false
+		}
+		mu.Lock()
+	}
+	return true
+}
+
+// sleepUntil waits until t, returning false if ctx is done first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// pumpToLine writes queued TTY input to the connection. Chunks already queued,
+// or queued within the flush delay, are coalesced into writes of up to the write
+// chunk size, so bulk input does not cost a write per read. Without a flush delay
+// input is written as soon as the queue is empty, keeping typing interactive.
+func (m *Modem) pumpToLine(p *pump, conn io.Writer) {
+	t := p.txPace
+	out := pumpBuffers.Get().(*[]byte)
+	defer putPumpBuffer(out)
+	flush := time.NewTimer(time.Hour)
+	flush.Stop()
+	var next chunk // Chunk taken from the queue but left for the next write
+	for {
+		c := next
+		if next.b != nil {
+			next = chunk{}
+		} else {
+			select {
+			case <-p.ctx.Done():
+				return
+			case c = <-p.toLine:
+			}
+		}
+		if !sleepUntil(p.ctx, c.due) {
+			putPumpBuffer(c.b)
+			return
+		}
+		data := append((*out)[:0], *c.b...)
+		putPumpBuffer(c.b)
+		waiting := false
+		if p.flush > 0 {
+			flush.Reset(p.flush)
+			waiting = true
+		}
+	coalesce:
+		for len(data) < p.chunk {
+			var more chunk
+			select {
+			case more = <-p.toLine:
+			default:
+				if !waiting {
+					break coalesce
+				}
+				select {
+				case more = <-p.toLine:
+				case <-flush.C:
+					waiting = false
+					break coalesce
+				case <-p.ctx.Done():
+					return
+				}
+			}
+			if len(data)+len(*more.b) > cap(data) || time.Now().Before(more.due) {
+				next = more
+				break
+			}
+			data = append(data, *more.b...)
+			putPumpBuffer(more.b)
+		}
+		if waiting {
+			flush.Stop()
+		}
+		var err error
+		for len(data) > 0 && err == nil {
+			n := t.wait(p.ctx, min(len(data), p.chunk))
+			if n == 0 {
+				break
+			}
+			_, err = conn.Write(data[:n])
+			data = data[n:]
+		}
+		if err != nil {
+			m.lineFailed(p, err)
+			if next.b != nil {
+				putPumpBuffer(next.b)
+			}
+			return
+		}
+	}
+}
+
+// lineFailed stops p after the connection write error err and hangs up its call.
+func (m *Modem) lineFailed(p *pump, err error) {
+	p.err = err
+	p.cancel()
+	m.Lock()
+	m.pumpFailed(p)
+	m.Unlock()
+}
+
+// pumpFailed hangs up the call of p after a connection write failure, unless
+// it has already ended.
+func (m *Modem) pumpFailed(p *pump) {
+	if p.call.Err() != nil {
+		return
+	}
+	m.log.Info("connection write failed", "error", p.err)
+	m.ioError(OpConnWrite, p.err)
+	m.setStatus(StatusIdle, CauseRemote)
+}
+
+// carrierLoss drops the call of p after d, unless it has already ended.
+func (m *Modem) carrierLoss(p *pump, d time.Duration) {
+	if !sleepUntil(p.ctx, time.Now().Add(d)) {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	if p.ctx.Err() != nil {
+		return
+	}
+	p.cancel()
+	m.log.Info("carrier lost", "after", d)
+	m.setStatus(StatusIdle, CauseCarrierLoss)
+}
+
+// pumpFromLine copies data received from the connection to the TTY, or keeps
+// it until the call is online (answer or ATO), for the whole duration of a call.
+// The remote closing the connection or a read error hangs up the call, ringing
+// or connected.
+func (m *Modem) pumpFromLine(p *pump, conn io.Reader) {
+	w := &remoteWriter{m: m, p: p, t: p.rxPace, noise: p.rxNoise}
+	var err error
+	if p.imp.delays() {
+		err = m.delayFromLine(p, conn, w)
+	} else {
+		b := pumpBuffers.Get().(*[]byte)
+		_, err = io.CopyBuffer(w, conn, *b)
+		putPumpBuffer(b)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if p.ctx.Err() != nil {
+		return
+	}
+	p.cancel()
+	if err == nil {
+		err = io.EOF
+	}
+	m.log.Info("remote hung up", "error", err)
+	m.ioError(OpConnRead, err)
+	m.setStatus(StatusIdle, CauseRemote)
+}
+
+// delayFromLine reads the connection into a delay line, delivering each chunk
+// to w when due, until the connection fails or the pump stops. The read error
+// is returned (nil on EOF) once the data read before it has been delivered.
+func (m *Modem) delayFromLine(p *pump, conn io.Reader, w io.Writer) error {
+	queue := make(chan chunk, pumpQueueLen)
+	delivered := make(chan struct{})
+	m.goTask(func() {
+		defer close(delivered)
+		for c := range queue {
+			if sleepUntil(p.ctx, c.due) {
+				w.Write(*c.b)
+			}
+			putPumpBuffer(c.b)
+		}
+	})
+	defer func() {
+		close(queue)
+		<-delivered
+	}()
+	delay := lineDelay{imp: p.imp}
+	for {
+		b := pumpBuffers.Get().(*[]byte)
+		n, err := conn.Read(*b)
+		if n > 0 {
+			*b = (*b)[:n]
+			select {
+			case queue <- chunk{b: b, due: delay.due(time.Now())}:
+			case <-p.ctx.Done():
+				putPumpBuffer(b)
+				return nil
+			}
+		} else {
+			putPumpBuffer(b)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// remoteWriter passes connection data to the modem while its pump runs, paced
+// to the line speed and with the line noise added. The data is written to the
+// TTY without the modem lock.
+type remoteWriter struct {
+	m     *Modem
+	p     *pump
+	t     *throttle
+	noise *lineNoise
+}
+
+func (w *remoteWriter) Write(b []byte) (int, error) {
+	data := w.noise.apply(b)
+	for len(data) > 0 {
+		n := w.t.wait(w.p.ctx, len(data))
+		w.m.Lock()
+		if w.p.ctx.Err() != nil {
+			w.m.Unlock()
+			return 0, w.p.ctx.Err()
+		}
+		if paused := w.m.xoff; paused != nil {
+			// XOFF typed on the TTY: hold the connection back until XON
+			w.m.Unlock()
+			select {
+			case <-paused:
+			case <-w.p.ctx.Done():
+			}
+			continue
+		}
+		if out := w.m.remoteInput(data[:n]); len(out) > 0 {
+			w.m.remoteWrite(out)
+		}
+		w.m.Unlock()
+		data = data[n:]
+	}
+	return len(b), nil
+}
@@ -0,0 +1,151 @@
+package vmodem
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// sharedLineHold is how long a TTY keeps the input after its last byte when
+// input arbitration gives it exclusive use of the modem.
+const sharedLineHold = 2 * time.Second
+
+// InputArbitration selects how input from the two TTYs of a shared line is combined.
+type InputArbitration int
+
+const (
+	// ArbitrationMerge accepts input from both TTYs as it arrives
+	ArbitrationMerge InputArbitration = iota
+	// ArbitrationExclusive gives the input to the first TTY that types, until it is idle for two seconds
+	ArbitrationExclusive
+	// ArbitrationOperator lets the secondary TTY (the operator) preempt the primary one;
+	// primary input is discarded until the operator is idle for two seconds
+	ArbitrationOperator
+)
+
+// String returns a human-readable string representation of the arbitration policy.
+func (a InputArbitration) String() string {
+	switch a {
+	case ArbitrationMerge:
+		return "Merge"
+	case ArbitrationExclusive:
+		return "Exclusive"
+	case ArbitrationOperator:
+		return "Operator"
+	default:
+		return "Unknown"
+	}
+}
+
+type sharedChunk struct {
+	data      []byte
+	secondary bool
+	err       error
+}
+
+// sharedLine lets two TTYs share a modem: both see everything the modem
+// writes, and their input is combined according to the arbitration policy.
+type sharedLine struct {
+	primary     io.ReadWriteCloser
+	secondary   io.ReadWriteCloser
+	arbitration InputArbitration
+	input       chan sharedChunk
+	done        chan struct{}
+	closeOnce   sync.Once
+	pending     []byte
+	owner       *bool
+	ownerTime   time.Time
+}
+
+func newSharedLine(primary io.ReadWriteCloser, secondary io.ReadWriteCloser, arbitration InputArbitration) *sharedLine {
+	l := &sharedLine{
+		primary:     primary,
+		secondary:   secondary,
+		arbitration: arbitration,
+		input:       make(chan sharedChunk),
+		done:        make(chan struct{}),
+	}
+	go l.readTask(primary, false)
+	go l.readTask(secondary, true)
+	return l
+}
+
+func (l *sharedLine) readTask(tty io.Reader, secondary bool) {
+	for {
+		buff := make([]byte, 128)
+		n, err := tty.Read(buff)
+		select {
+		case l.input <- sharedChunk{data: buff[:n], secondary: secondary, err: err}:
+		case <-l.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// accept applies the arbitration policy to input coming from one of the TTYs.
+func (l *sharedLine) accept(secondary bool) bool {
+	if l.arbitration == ArbitrationMerge {
+		return true
+	}
+	now := time.Now()
+	if l.owner != nil && now.Sub(l.ownerTime) > sharedLineHold {
+		l.owner = nil
+	}
+	switch {
+	case l.owner == nil:
+		if l.arbitration == ArbitrationOperator && !secondary {
+			return true
+		}
+	case *l.owner != secondary:
+		if l.arbitration == ArbitrationExclusive || !secondary {
+			return false
+		}
+	}
+	l.owner = &secondary
+	l.ownerTime = now
+	return true
+}
+
+// Read returns input from either TTY. Read errors of the secondary TTY detach
+// it silently; those of the primary one are returned to the modem.
+func (l *sharedLine) Read(p []byte) (int, error) {
+	for len(l.pending) == 0 {
+		select {
+		case c := <-l.input:
+			if c.err != nil {
+				if c.secondary {
+					continue
+				}
+				return 0, c.err
+			}
+			if l.accept(c.secondary) {
+				l.pending = c.data
+			}
+		case <-l.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+// Write sends modem output to both TTYs. Only primary TTY errors are reported.
+func (l *sharedLine) Write(p []byte) (int, error) {
+	n, err := l.primary.Write(p)
+	_, _ = l.secondary.Write(p)
+	return n, err
+}
+
+// Close closes both TTYs.
+func (l *sharedLine) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	err := l.primary.Close()
+	l.secondary.Close()
+	return err
+}
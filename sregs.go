@@ -0,0 +1,84 @@
+package vmodem
+
+import (
+	"maps"
+	"slices"
+)
+
+// SRegDescriptor describes an S-register known to the modem.
+type SRegDescriptor struct {
+	// Description is a short description of the register
+	Description string
+	// Default is the value of the register in a new modem
+	Default byte
+	// Min and Max are the values the DTE may write with ATSn=v
+	Min, Max byte
+	// ReadOnly reports whether the register only reports modem status; ATSn=v fails
+	ReadOnly bool
+}
+
+// sregTable describes the registers used by the modem. Registers not in the
+// table default to zero and accept any value.
+var sregTable = map[byte]SRegDescriptor{
+	0:  {Description: "Rings before auto-answer (0: disabled)", Default: 0, Max: 255},
+	1:  {Description: "Ring count of the current incoming call", ReadOnly: true, Max: 255},
+	2:  {Description: "Escape character (above 127: disabled)", Default: '+', Max: 255},
+	3:  {Description: "Command line termination character", Default: '\r', Max: 127},
+	4:  {Description: "Response formatting character", Default: '\n', Max: 127},
+	7:  {Description: "Seconds to wait for carrier (0: no limit)", Default: 50, Max: 255},
+	8:  {Description: "Seconds of pause for a comma in a dial string", Default: 2, Max: 255},
+	12: {Description: "Escape guard time in 50ms increments", Default: 20, Max: 255},
+}
+
+// SRegDescriptors returns the descriptors of the registers known to the modem,
+// keyed by register number.
+func SRegDescriptors() map[byte]SRegDescriptor {
+	return maps.Clone(sregTable)
+}
+
+// DefaultSRegs returns the S-register values of a new modem:
+//
+//	S0  = 0   Rings before auto-answer (0: disabled)
+//	S1  = 0   Ring count of the current incoming call (read-only)
+//	S2  = 43  Escape character ('+'; values above 127 disable the escape sequence)
+//	S3  = 13  Command line termination character (CR)
+//	S4  = 10  Response formatting character (LF)
+//	S7  = 50  Seconds to wait for carrier
+//	S8  = 2   Seconds of pause for a comma in a dial string
+//	S12 = 20  Escape sequence guard time in 50ms increments (1s)
+//
+// Registers not listed are zero. ATZ and AT&F restore these values, or those
+// configured with ModemConfig.SRegs.
+func DefaultSRegs() map[byte]byte {
+	regs := make(map[byte]byte, len(sregTable))
+	for reg, d := range sregTable {
+		regs[reg] = d.Default
+	}
+	return regs
+}
+
+// checkSReg reports whether the DTE may write value to register reg.
+func checkSReg(reg byte, value int) bool {
+	d, ok := sregTable[reg]
+	if !ok {
+		return value >= 0 && value <= 255
+	}
+	return !d.ReadOnly && value >= int(d.Min) && value <= int(d.Max)
+}
+
+// resetSRegs restores the default value of every writable register on behalf
+// of the DTE.
+func (m *Modem) resetSRegs() {
+	regs := slices.Collect(maps.Keys(m.sregs))
+	for reg := range m.defSRegs {
+		if _, ok := m.sregs[reg]; !ok {
+			regs = append(regs, reg)
+		}
+	}
+	slices.Sort(regs)
+	for _, reg := range regs {
+		if !sregTable[reg].ReadOnly {
+			m.dteSetSReg(reg, m.defSRegs[reg])
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package vmodem
+
+import "time"
+
+// subscriberQueueLen is the number of state changes a subscriber can lag behind
+// before further changes are dropped for it.
+const subscriberQueueLen = 16
+
+// TransitionCause identifies what triggered a modem state change.
+type TransitionCause int
+
+const (
+	// CauseAPI is a change requested by the application (SetStatus, Close)
+	CauseAPI TransitionCause = iota
+	// CauseCommand is a change caused by an AT command
+	CauseCommand
+	// CauseEscape is a change to online command mode caused by the +++ escape sequence
+	CauseEscape
+	// CauseRemote is a change caused by the remote side: incoming call, answer, hangup or failed call
+	CauseRemote
+	// CauseTimeout is a change caused by an incoming call ringing for too long or an
+	// outgoing call not answered
+	CauseTimeout
+	// CauseAutoAnswer is a change caused by the modem answering a call on its own (S0)
+	CauseAutoAnswer
+	// CauseTTY is a change caused by TTY activity: a key aborting a dial or a TTY failure
+	CauseTTY
+	// CauseCarrierLoss is a call dropped by the emulated carrier loss of the line impairment
+	CauseCarrierLoss
+	// CauseBusy is an outgoing call failed because the called number is busy
+	CauseBusy
+	// CauseDTR is a change caused by the DTE dropping DTR, per AT&D
+	CauseDTR
+)
+
+// String returns a human-readable string representation of the transition cause.
+func (c TransitionCause) String() string {
+	switch c {
+	case CauseAPI:
+		return "API"
+	case CauseCommand:
+		return "Command"
+	case CauseEscape:
+		return "Escape"
+	case CauseRemote:
+		return "Remote"
+	case CauseTimeout:
+		return "Timeout"
+	case CauseAutoAnswer:
+		return "AutoAnswer"
+	case CauseTTY:
+		return "TTY"
+	case CauseCarrierLoss:
+		return "CarrierLoss"
+	case CauseBusy:
+		return "Busy"
+	case CauseDTR:
+		return "DTR"
+	default:
+		return "Unknown"
+	}
+}
+
+// StateChange describes a modem state transition delivered to subscribers.
+type StateChange struct {
+	// From is the status before the transition
+	From ModemStatus
+	// To is the status after the transition
+	To ModemStatus
+	// Time is when the transition happened
+	Time time.Time
+	// Cause is what triggered the transition
+	Cause TransitionCause
+}
+
+// Subscribe returns a channel that receives every state change of the modem,
+// and an identifier for Unsubscribe. Changes are delivered without blocking the
+// modem: if the subscriber lags more than a few changes behind, further changes
+// are dropped for it. The channel is closed by Unsubscribe and after the
+// transition to StatusClosed has been delivered.
+// The modem lock must be held before calling this method.
+// Use SubscribeSync for automatic lock management.
+func (m *Modem) Subscribe() (int, <-chan StateChange) {
+	m.checkLock()
+	return m.subscribe()
+}
+
+// SubscribeSync returns a channel of state changes with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SubscribeSync() (int, <-chan StateChange) {
+	m.Lock()
+	defer m.Unlock()
+	return m.subscribe()
+}
+
+func (m *Modem) subscribe() (int, <-chan StateChange) {
+	ch := make(chan StateChange, subscriberQueueLen)
+	m.subscriberSeq++
+	if m.status() == StatusClosed {
+		close(ch)
+	} else {
+		if m.subscribers == nil {
+			m.subscribers = make(map[int]chan StateChange)
+		}
+		m.subscribers[m.subscriberSeq] = ch
+	}
+	return m.subscriberSeq, ch
+}
+
+// Unsubscribe stops the delivery of state changes to the subscriber with the
+// given identifier and closes its channel.
+// The modem lock must be held before calling this method.
+// Use UnsubscribeSync for automatic lock management.
+func (m *Modem) Unsubscribe(id int) {
+	m.checkLock()
+	m.unsubscribe(id)
+}
+
+// UnsubscribeSync stops the delivery of state changes with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) UnsubscribeSync(id int) {
+	m.Lock()
+	defer m.Unlock()
+	m.unsubscribe(id)
+}
+
+func (m *Modem) unsubscribe(id int) {
+	if ch, ok := m.subscribers[id]; ok {
+		close(ch)
+		delete(m.subscribers, id)
+	}
+}
+
+// notify delivers a state change to the subscribers.
+func (m *Modem) notify(change StateChange) {
+	for _, ch := range m.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+	if change.To == StatusClosed {
+		for id := range m.subscribers {
+			m.unsubscribe(id)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package vmodem
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// minLineSpeed and maxLineSpeed bound the emulated DCE speed in bits per second
+	minLineSpeed = 300
+	maxLineSpeed = 56000
+	// throttleSlice is the time worth of data paced at once, so output flows
+	// smoothly instead of in bursts
+	throttleSlice = 20 * time.Millisecond
+)
+
+// throttle paces the data of one direction of a call to the emulated line
+// speed, 10 bits per byte (8N1). A nil throttle does not pace.
+type throttle struct {
+	byteTime time.Duration
+	burst    int
+	next     time.Time
+	waited   atomic.Int64 // Time data waited for the line, read by the metrics
+}
+
+// newThrottle returns a throttle for speed bits per second, nil if speed is 0.
+func newThrottle(speed int) *throttle {
+	if speed <= 0 {
+		return nil
+	}
+	return &throttle{
+		byteTime: 10 * time.Second / time.Duration(speed),
+		burst:    max(1, int(throttleSlice*time.Duration(speed)/(10*time.Second))),
+	}
+}
+
+// wait returns the size of the next slice of n bytes to send and waits until it
+// may be sent. It returns 0 if ctx is done first.
+func (t *throttle) wait(ctx context.Context, n int) int {
+	if t == nil {
+		return n
+	}
+	n = min(n, t.burst)
+	now := time.Now()
+	if t.next.Before(now) {
+		// Idle line, no credit for the time without data
+		t.next = now
+	}
+	if d := t.next.Sub(now); d > 0 {
+		t.waited.Add(int64(d))
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-timer.C:
+		}
+	}
+	t.next = t.next.Add(time.Duration(n) * t.byteTime)
+	return n
+}
+
+// paced returns the time data waited for the line speed, 0 for a nil throttle.
+func (t *throttle) paced() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Duration(t.waited.Load())
+}
+
+// lineSpeed returns speed limited to the range of emulated line speeds, 0 if
+// pacing is disabled.
+func lineSpeed(speed int) int {
+	if speed <= 0 {
+		return 0
+	}
+	return min(max(speed, minLineSpeed), maxLineSpeed)
+}
+
+// LineSpeed returns the emulated line speed of the next calls in bits per second,
+// 0 if they are not paced.
+// The modem lock must be held before calling this method.
+// Use LineSpeedSync for automatic lock management.
+func (m *Modem) LineSpeed() int {
+	m.checkLock()
+	return m.lineSpeed
+}
+
+// LineSpeedSync returns the line speed of the next calls with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) LineSpeedSync() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.lineSpeed
+}
+
+// SetLineSpeed changes the emulated line speed of the calls started afterwards
+// to speed bits per second, limited to 300 to 56000, or stops pacing them if
+// speed is 0. A call in progress keeps the speed it started with.
+// The modem lock must be held before calling this method.
+// Use SetLineSpeedSync for automatic lock management.
+func (m *Modem) SetLineSpeed(speed int) {
+	m.checkLock()
+	m.lineSpeed = lineSpeed(speed)
+}
+
+// SetLineSpeedSync changes the line speed of the next calls with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetLineSpeedSync(speed int) {
+	m.Lock()
+	defer m.Unlock()
+	m.lineSpeed = lineSpeed(speed)
+}
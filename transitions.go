@@ -0,0 +1,83 @@
+package vmodem
+
+import "strings"
+
+//go:generate go test -run TestTransitionsDoc -update
+
+// transitionTable lists, for each status, the statuses the modem may move to.
+// Setting the current status again is always allowed and does nothing.
+var transitionTable = map[ModemStatus][]ModemStatus{
+	StatusIdle:         {StatusDialing, StatusRinging, StatusClosed},
+	StatusDialing:      {StatusIdle, StatusConnected, StatusClosed},
+	StatusConnected:    {StatusIdle, StatusConnectedCmd, StatusClosed},
+	StatusConnectedCmd: {StatusIdle, StatusConnected, StatusClosed},
+	StatusRinging:      {StatusIdle, StatusConnected, StatusClosed},
+	StatusClosed:       {},
+}
+
+// Transitions returns a copy of the state machine transition table, mapping each
+// status to the statuses it may move to. StatusClosed is terminal.
+func Transitions() map[ModemStatus][]ModemStatus {
+	table := make(map[ModemStatus][]ModemStatus, len(transitionTable))
+	for from, to := range transitionTable {
+		table[from] = append([]ModemStatus{}, to...)
+	}
+	return table
+}
+
+// ValidTransition reports whether the state machine allows moving from one status to another.
+// Moving to the current status is valid for every status but StatusClosed.
+func ValidTransition(from ModemStatus, to ModemStatus) bool {
+	allowed, ok := transitionTable[from]
+	if !ok || from == StatusClosed {
+		return false
+	}
+	if from == to {
+		return true
+	}
+	for _, s := range allowed {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CanTransition reports whether the modem may move from its current status to
+// status now, checking both the transition table and the preconditions of the
+// target status (a call needs a connection to ring or go online).
+// The modem lock must be held before calling this method, and the answer is only
+// valid while it remains held.
+// Use CanTransitionSync for automatic lock management.
+func (m *Modem) CanTransition(status ModemStatus) bool {
+	m.checkLock()
+	return m.checkTransition(status) == nil
+}
+
+// CanTransitionSync reports whether the modem may move to status with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+// The answer may be stale by the time it is used.
+func (m *Modem) CanTransitionSync(status ModemStatus) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.checkTransition(status) == nil
+}
+
+// transitionsMarkdown renders the transition table as the Markdown table
+// embedded in the README.
+func transitionsMarkdown() string {
+	var b strings.Builder
+	b.WriteString("| From | To |\n")
+	b.WriteString("|------|----|\n")
+	for from := StatusIdle; from <= StatusClosed; from++ {
+		var to []string
+		for _, s := range transitionTable[from] {
+			to = append(to, s.String())
+		}
+		if len(to) == 0 {
+			to = append(to, "none (terminal)")
+		}
+		b.WriteString("| " + from.String() + " | " + strings.Join(to, ", ") + " |\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,194 @@
+package vmodem
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test transition table contents and queries
+func TestValidTransition(t *testing.T) {
+	tests := []struct {
+		from     ModemStatus
+		to       ModemStatus
+		expected bool
+	}{
+		{StatusIdle, StatusIdle, true},
+		{StatusIdle, StatusDialing, true},
+		{StatusIdle, StatusConnected, false},
+		{StatusIdle, StatusConnectedCmd, false},
+		{StatusDialing, StatusConnected, true},
+		{StatusDialing, StatusRinging, false},
+		{StatusConnected, StatusConnectedCmd, true},
+		{StatusConnectedCmd, StatusConnected, true},
+		{StatusRinging, StatusConnected, true},
+		{StatusRinging, StatusDialing, false},
+		{StatusClosed, StatusIdle, false},
+		{StatusClosed, StatusClosed, false},
+		{ModemStatus(99), StatusIdle, false},
+		{StatusIdle, ModemStatus(99), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v to %v", tt.from, tt.to), func(t *testing.T) {
+			if result := ValidTransition(tt.from, tt.to); result != tt.expected {
+				t.Errorf("ValidTransition(%v, %v) = %v, want %v", tt.from, tt.to, result, tt.expected)
+			}
+		})
+	}
+
+	// Every status but Closed can be closed, and callers get a copy of the table
+	table := Transitions()
+	for from := range table {
+		if from != StatusClosed && !ValidTransition(from, StatusClosed) {
+			t.Errorf("Status %v cannot be closed", from)
+		}
+	}
+	table[StatusIdle] = append(table[StatusIdle], StatusConnected)
+	if ValidTransition(StatusIdle, StatusConnected) {
+		t.Error("Modifying the returned table should not change the state machine")
+	}
+}
+
+// Test random sequences of API calls and TTY/bearer events never panic and
+// only produce transitions allowed by the table
+func TestModem_RandomOperations(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	statuses := []ModemStatus{StatusIdle, StatusDialing, StatusConnected, StatusConnectedCmd, StatusRinging, StatusClosed, ModemStatus(99)}
+	commands := []string{"A", "H", "O", "Z", "&F", "D1", "DT2", "S0=1", "S0=0", "E0", "V0", "Q1", "Q0"}
+	inputs := []string{"ATA\r", "ATH\r", "ATO\r", "ATD1\r", "+++", "data", "x", "ATS0=1\r"}
+
+	for seq := 0; seq < 30; seq++ {
+		var mu sync.Mutex
+		var invalid []string
+		tty := NewMockReadWriteCloser([]byte{})
+		var conns []*MockConnection
+
+		modem, err := NewModem(&ModemConfig{
+			Id:               "test-modem",
+			TTY:              tty,
+			GuardTime:        1,
+			DisablePostGuard: rnd.Intn(2) == 0,
+			OutgoingCall: func(m *Modem, number string) (io.ReadWriteCloser, error) {
+				if number == "2" {
+					return nil, ErrNoCarrier
+				}
+				conn, _ := NewMockConnection()
+				return conn, nil
+			},
+			StatusTransition: func(m *Modem, prevStatus ModemStatus, newStatus ModemStatus) {
+				if !ValidTransition(prevStatus, newStatus) || prevStatus == newStatus {
+					mu.Lock()
+					invalid = append(invalid, fmt.Sprintf("%v -> %v", prevStatus, newStatus))
+					mu.Unlock()
+				}
+			},
+		})
+		if err != nil {
+			t.Fatalf("NewModem() error = %v", err)
+		}
+
+		for op := 0; op < 40; op++ {
+			switch rnd.Intn(6) {
+			case 0:
+				modem.SetStatusSync(statuses[rnd.Intn(len(statuses))])
+			case 1:
+				modem.ProcessAtCommandSync(commands[rnd.Intn(len(commands))])
+			case 2:
+				local, remote := NewMockConnection()
+				conns = append(conns, remote)
+				if modem.IncomingCallSync(local) != nil {
+					local.Close()
+				}
+			case 3:
+				// Remote side hangs up
+				if len(conns) > 0 {
+					conns[rnd.Intn(len(conns))].Close()
+				}
+			case 4:
+				tty.WriteInput([]byte(inputs[rnd.Intn(len(inputs))]))
+			case 5:
+				time.Sleep(time.Duration(rnd.Intn(5)) * time.Millisecond)
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		modem.CloseSync()
+
+		if modem.StatusSync() != StatusClosed {
+			t.Errorf("Sequence %d: expected modem to be closed, got %v", seq, modem.StatusSync())
+		}
+
+		mu.Lock()
+		if len(invalid) > 0 {
+			t.Errorf("Sequence %d: transitions outside the table: %v", seq, invalid)
+		}
+		mu.Unlock()
+	}
+}
+
+var update = flag.Bool("update", false, "update generated documentation")
+
+// Test the README transition table is generated from the code
+func TestTransitionsDoc(t *testing.T) {
+	const begin, end = "<!-- transitions:begin -->\n", "<!-- transitions:end -->"
+	readme, err := os.ReadFile("README.md")
+	if err != nil {
+		t.Fatalf("Failed to read README.md: %v", err)
+	}
+	doc := string(readme)
+	start, stop := strings.Index(doc, begin), strings.Index(doc, end)
+	if start < 0 || stop < start {
+		t.Fatal("README.md has no transition table markers")
+	}
+	start += len(begin)
+	table := transitionsMarkdown()
+	if doc[start:stop] == table {
+		return
+	}
+	if !*update {
+		t.Fatal("README.md transition table is out of date, run go generate")
+	}
+	doc = doc[:start] + table + doc[stop:]
+	if err := os.WriteFile("README.md", []byte(doc), 0644); err != nil {
+		t.Fatalf("Failed to write README.md: %v", err)
+	}
+}
+
+// Test transitions checked against the modem state
+func TestModem_CanTransition(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{Id: "test-modem", TTY: tty})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	// Ringing is allowed from Idle but needs a call connection
+	if modem.CanTransitionSync(StatusRinging) {
+		t.Error("CanTransitionSync(Ringing) without a connection = true, want false")
+	}
+	if !modem.CanTransitionSync(StatusDialing) || modem.CanTransitionSync(StatusConnectedCmd) {
+		t.Error("CanTransitionSync() disagrees with the transition table from Idle")
+	}
+	if err := modem.IncomingCallSync(NewMockReadWriteCloser([]byte{})); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	modem.Lock()
+	if !modem.CanTransition(StatusConnected) {
+		t.Error("CanTransition(Connected) while ringing = false, want true")
+	}
+	modem.Unlock()
+
+	modem.CloseSync()
+	for s := StatusIdle; s <= StatusClosed; s++ {
+		if modem.CanTransitionSync(s) {
+			t.Errorf("CanTransitionSync(%v) on a closed modem = true, want false", s)
+		}
+	}
+}
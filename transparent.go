@@ -0,0 +1,130 @@
+package vmodem
+
+const (
+	// xon resumes output to the TTY with XON/XOFF flow control (&K4)
+	xon = 0x11
+	// xoff pauses output to the TTY with XON/XOFF flow control (&K4)
+	xoff = 0x13
+)
+
+// Flow control (&K) and error control (\N) modes of the modem.
+const (
+	flowNone     = 0 // &K0: no flow control
+	flowHardware = 3 // &K3: RTS/CTS, handled by the TTY (default)
+	flowXonXoff  = 4 // &K4: XON/XOFF typed on the TTY pause and resume its output
+
+	errorDirect  = 1 // \N1: direct mode, data passes through the modem untouched
+	errorDefault = 3 // \N3: auto-reliable (default)
+)
+
+// Transparency selects whether online data passes through the modem unmodified,
+// as binary file transfer protocols (XMODEM, ZMODEM) need. A transparent modem
+// does not detect the +++ escape sequence, does not interpret XON/XOFF and does
+// not run the data filters; the call is left with the API or by hanging up. The
+// line impairment still applies, as it emulates the line rather than the modem.
+type Transparency int
+
+const (
+	// TransparencyAuto makes the modem transparent in direct mode (\N1) unless
+	// XON/XOFF flow control (&K4) is selected
+	TransparencyAuto Transparency = iota
+	// TransparencyOn always makes the modem transparent
+	TransparencyOn
+	// TransparencyOff never makes the modem transparent
+	TransparencyOff
+)
+
+// String returns a human-readable string representation of the transparency setting.
+func (t Transparency) String() string {
+	switch t {
+	case TransparencyAuto:
+		return "Auto"
+	case TransparencyOn:
+		return "On"
+	case TransparencyOff:
+		return "Off"
+	default:
+		return "Unknown"
+	}
+}
+
+// transparent reports whether online data passes through unmodified.
+func (m *Modem) transparent() bool {
+	switch m.transparency {
+	case TransparencyOn:
+		return true
+	case TransparencyOff:
+		return false
+	}
+	return m.errorCtl == errorDirect && m.flowCtl != flowXonXoff
+}
+
+// xonXoff reports whether XON/XOFF typed on the TTY is interpreted.
+func (m *Modem) xonXoff() bool {
+	return m.flowCtl == flowXonXoff && !m.transparent()
+}
+
+// indexFlow returns the index of the first XON or XOFF in p, or -1.
+func indexFlow(p []byte) int {
+	for i, b := range p {
+		if b == xon || b == xoff {
+			return i
+		}
+	}
+	return -1
+}
+
+// setXoff pauses or resumes the output of call data to the TTY. Resuming in
+// online mode delivers the data held while paused.
+func (m *Modem) setXoff(paused bool) {
+	switch {
+	case paused && m.xoff == nil:
+		m.xoff = make(chan struct{})
+	case !paused && m.xoff != nil:
+		close(m.xoff)
+		m.xoff = nil
+		if m.status() == StatusConnected {
+			m.deliverResumeBuf()
+		}
+	}
+}
+
+// Transparent reports whether online data currently passes through the modem
+// unmodified, according to the transparency setting and the &K and \N modes.
+// The modem lock must be held before calling this method.
+// Use TransparentSync for automatic lock management.
+func (m *Modem) Transparent() bool {
+	m.checkLock()
+	return m.transparent()
+}
+
+// TransparentSync reports whether online data passes through unmodified with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) TransparentSync() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.transparent()
+}
+
+// SetTransparency changes the transparency setting, e.g. around a file transfer.
+// The modem lock must be held before calling this method.
+// Use SetTransparencySync for automatic lock management.
+func (m *Modem) SetTransparency(t Transparency) {
+	m.checkLock()
+	m.setTransparency(t)
+}
+
+// SetTransparencySync changes the transparency setting with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetTransparencySync(t Transparency) {
+	m.Lock()
+	defer m.Unlock()
+	m.setTransparency(t)
+}
+
+func (m *Modem) setTransparency(t Transparency) {
+	m.transparency = t
+	if !m.xonXoff() {
+		m.setXoff(false)
+	}
+}
@@ -0,0 +1,97 @@
+package vmodem
+
+import (
+	"io"
+	"sync"
+)
+
+// detachedTTY stands in for the TTY while none is attached: output is
+// discarded and reads block until another TTY is attached.
+type detachedTTY struct {
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newDetachedTTY() *detachedTTY {
+	return &detachedTTY{done: make(chan struct{})}
+}
+
+func (d *detachedTTY) Read(p []byte) (int, error) {
+	<-d.done
+	return 0, io.EOF
+}
+
+func (d *detachedTTY) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (d *detachedTTY) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	return nil
+}
+
+// bufferTTY wraps tty in a buffered writer when the modem is configured with one.
+func (m *Modem) bufferTTY(tty io.ReadWriteCloser) io.ReadWriteCloser {
+	if m.ttyBufSize <= 0 {
+		return tty
+	}
+	return newBufferedTTY(tty, m.ttyBufSize, m.ttyOverflow, &m.ttyDropped)
+}
+
+// startTtyReadTask starts the read task of the current TTY, stopping the task
+// of the previous one.
+func (m *Modem) startTtyReadTask() {
+	if m.ttyStop != nil {
+		close(m.ttyStop)
+	}
+	tty, stop := m.tty, make(chan struct{})
+	m.ttyStop = stop
+	m.goTask(func() { m.ttyReadTask(tty, stop) })
+}
+
+// SetTTY attaches tty as the DTE side of the modem, replacing the current one
+// without affecting the modem state or an active call, e.g. when a terminal
+// client disconnects and later reconnects. A nil tty detaches the current one:
+// modem output is discarded until a TTY is attached again.
+// The previous TTY is returned (nil if none was attached) and is not closed;
+// a read pending on it is abandoned and its data discarded, so callers
+// usually close it. A partially typed command line is lost.
+// Returns ErrModemClosed if the modem is closed.
+// The modem lock must be held before calling this method.
+// Use SetTTYSync for automatic lock management.
+func (m *Modem) SetTTY(tty io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	m.checkLock()
+	return m.setTTY(tty)
+}
+
+// SetTTYSync attaches tty as the DTE side of the modem with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetTTYSync(tty io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.setTTY(tty)
+}
+
+func (m *Modem) setTTY(tty io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	if m.status() == StatusClosed {
+		return nil, ErrModemClosed
+	}
+	prev := m.tty
+	if tty == nil {
+		m.log.Info("tty detached")
+		tty = newDetachedTTY()
+	} else {
+		m.log.Info("tty attached")
+		tty = m.bufferTTY(tty)
+	}
+	m.tty = tty
+	if b, ok := prev.(*bufferedTTY); ok {
+		prev = b.detach()
+	}
+	if d, ok := prev.(*detachedTTY); ok {
+		d.Close()
+		prev = nil
+	}
+	m.startTtyReadTask()
+	return prev, nil
+}
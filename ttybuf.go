@@ -0,0 +1,154 @@
+package vmodem
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ttyFlushTimeout bounds how long closing a buffered TTY waits for pending output.
+const ttyFlushTimeout = time.Second
+
+// TTYOverflowPolicy selects what a buffered TTY writer does when its buffer is full.
+type TTYOverflowPolicy int
+
+const (
+	// TTYOverflowBlock makes writers wait for buffer space (backpressure)
+	TTYOverflowBlock TTYOverflowPolicy = iota
+	// TTYOverflowDrop discards output that does not fit in the buffer
+	TTYOverflowDrop
+)
+
+// String returns a human-readable string representation of the overflow policy.
+func (p TTYOverflowPolicy) String() string {
+	switch p {
+	case TTYOverflowBlock:
+		return "Block"
+	case TTYOverflowDrop:
+		return "Drop"
+	default:
+		return "Unknown"
+	}
+}
+
+// bufferedTTY decouples modem output from the DTE: writes are queued and
+// delivered by a writer goroutine, so a stalled reader does not block the
+// modem while it holds its lock. Reads go straight to the TTY.
+type bufferedTTY struct {
+	io.ReadWriteCloser
+	size      int
+	policy    TTYOverflowPolicy
+	dropped   *atomic.Int64
+	mu        sync.Mutex
+	cond      *sync.Cond
+	buf       []byte
+	inflight  int
+	err       error
+	closing   bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newBufferedTTY(tty io.ReadWriteCloser, size int, policy TTYOverflowPolicy, dropped *atomic.Int64) *bufferedTTY {
+	t := &bufferedTTY{
+		ReadWriteCloser: tty,
+		size:            size,
+		policy:          policy,
+		dropped:         dropped,
+		done:            make(chan struct{}),
+	}
+	t.cond = sync.NewCond(&t.mu)
+	go t.writeTask()
+	return t
+}
+
+func (t *bufferedTTY) writeTask() {
+	defer close(t.done)
+	for {
+		t.mu.Lock()
+		for len(t.buf) == 0 && !t.closing {
+			t.cond.Wait()
+		}
+		if len(t.buf) == 0 {
+			t.mu.Unlock()
+			return
+		}
+		data := t.buf
+		t.buf = nil
+		t.inflight = len(data)
+		t.mu.Unlock()
+
+		_, err := t.ReadWriteCloser.Write(data)
+
+		t.mu.Lock()
+		t.inflight = 0
+		if err != nil {
+			t.err = err
+			t.buf = nil
+		}
+		t.cond.Broadcast()
+		t.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Write queues p for delivery. Errors of previous deliveries are returned here.
+func (t *bufferedTTY) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if t.err != nil {
+			return n, t.err
+		}
+		if t.closing {
+			return n, io.ErrClosedPipe
+		}
+		free := t.size - len(t.buf) - t.inflight
+		if free <= 0 {
+			if t.policy == TTYOverflowDrop {
+				t.dropped.Add(int64(len(p) - n))
+				return len(p), nil
+			}
+			t.cond.Wait()
+			continue
+		}
+		k := min(free, len(p)-n)
+		t.buf = append(t.buf, p[n:n+k]...)
+		n += k
+		t.cond.Broadcast()
+	}
+	return n, nil
+}
+
+// pending returns the number of bytes queued or being delivered.
+func (t *bufferedTTY) pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.buf) + t.inflight
+}
+
+// detach stops accepting output and returns the underlying TTY without closing
+// it. Pending output is still delivered in the background.
+func (t *bufferedTTY) detach() io.ReadWriteCloser {
+	t.mu.Lock()
+	t.closing = true
+	t.cond.Broadcast()
+	t.mu.Unlock()
+	return t.ReadWriteCloser
+}
+
+// Close delivers pending output, waiting up to ttyFlushTimeout, and closes the TTY.
+func (t *bufferedTTY) Close() error {
+	tty := t.detach()
+	select {
+	case <-t.done:
+	case <-time.After(ttyFlushTimeout):
+	}
+	var err error
+	t.closeOnce.Do(func() { err = tty.Close() })
+	return err
+}
@@ -0,0 +1,62 @@
+package vmodem
+
+// UnknownCommandPolicy selects the result of AT commands that are not handled by
+// a command hook, a registered command or the built-in command set.
+type UnknownCommandPolicy int
+
+const (
+	// UnknownCommandOk accepts unknown commands with OK, as legacy software expects
+	UnknownCommandOk UnknownCommandPolicy = iota
+	// UnknownCommandError rejects unknown commands with ERROR, for strict conformance testing
+	UnknownCommandError
+	// UnknownCommandHook passes unknown commands to the UnknownCommand hook; commands
+	// it skips, or all of them if there is no hook, are rejected with ERROR
+	UnknownCommandHook
+)
+
+// String returns a human-readable string representation of the unknown command policy.
+func (p UnknownCommandPolicy) String() string {
+	switch p {
+	case UnknownCommandOk:
+		return "Ok"
+	case UnknownCommandError:
+		return "Error"
+	case UnknownCommandHook:
+		return "Hook"
+	default:
+		return "Unknown"
+	}
+}
+
+// unknownCommand returns the result of a command no handler knows about,
+// according to the unknown command policy.
+func (m *Modem) unknownCommand(cmd Command) RetCode {
+	m.log.Debug("unknown command", "command", cmd.Raw, "policy", m.unknownPolicy)
+	switch m.unknownPolicy {
+	case UnknownCommandOk:
+		return RetCodeOk
+	case UnknownCommandHook:
+		if m.unknownCmd != nil {
+			if r := m.unknownCmd(m, cmd); r != RetCodeSkip {
+				return r
+			}
+		}
+	}
+	return RetCodeError
+}
+
+// SetUnknownCommandPolicy changes the result of unknown commands.
+// The modem lock must be held before calling this method.
+// Use SetUnknownCommandPolicySync for automatic lock management.
+func (m *Modem) SetUnknownCommandPolicy(policy UnknownCommandPolicy) {
+	m.checkLock()
+	m.unknownPolicy = policy
+}
+
+// SetUnknownCommandPolicySync changes the result of unknown commands with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetUnknownCommandPolicySync(policy UnknownCommandPolicy) {
+	m.Lock()
+	defer m.Unlock()
+	m.unknownPolicy = policy
+}
@@ -0,0 +1,87 @@
+package vmodem
+
+// maxUnsolicitedQueue is the number of unsolicited result codes kept while in
+// data mode with UnsolicitedQueue; further codes are dropped.
+const maxUnsolicitedQueue = 32
+
+// UnsolicitedPolicy selects what happens to unsolicited result codes sent while
+// the TTY is in data mode.
+type UnsolicitedPolicy int
+
+const (
+	// UnsolicitedDrop discards unsolicited result codes sent in data mode
+	UnsolicitedDrop UnsolicitedPolicy = iota
+	// UnsolicitedQueue keeps them and sends them when the modem returns to command mode
+	UnsolicitedQueue
+)
+
+// String returns a human-readable string representation of the unsolicited policy.
+func (p UnsolicitedPolicy) String() string {
+	switch p {
+	case UnsolicitedDrop:
+		return "Drop"
+	case UnsolicitedQueue:
+		return "Queue"
+	default:
+		return "Unknown"
+	}
+}
+
+// SendUnsolicited writes an unsolicited result code (e.g. "RING" or "+CLIP: ...")
+// to the TTY, framed like any other result: standard codes known to
+// CmdReturnFromString follow the verbose/numeric and locale settings, other text
+// is written as an information line. Nothing is written in quiet mode (ATQ1).
+// While the modem is in data mode the code is queued or dropped according to the
+// UnsolicitedPolicy configuration; ErrModemBusy is returned when it is dropped.
+// Returns ErrModemClosed if the modem is closed.
+// The modem lock must be held before calling this method.
+// Use SendUnsolicitedSync for automatic lock management.
+func (m *Modem) SendUnsolicited(code string) error {
+	m.checkLock()
+	return m.sendUnsolicited(code)
+}
+
+// SendUnsolicitedSync writes an unsolicited result code with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SendUnsolicitedSync(code string) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.sendUnsolicited(code)
+}
+
+func (m *Modem) sendUnsolicited(code string) error {
+	switch m.status() {
+	case StatusClosed:
+		return ErrModemClosed
+	case StatusConnected:
+		if m.urcPolicy != UnsolicitedQueue || len(m.urcQueue) >= maxUnsolicitedQueue {
+			m.log.Debug("unsolicited result code dropped", "code", code)
+			return ErrModemBusy
+		}
+		m.urcQueue = append(m.urcQueue, code)
+		return nil
+	}
+	m.printUnsolicited(code)
+	return nil
+}
+
+func (m *Modem) printUnsolicited(code string) {
+	switch ret := CmdReturnFromString(code); ret {
+	case RetCodeUnknown, RetCodeSilent, RetCodeSkip:
+	default:
+		m.printRetCode(ret)
+		return
+	}
+	if !m.quietMode {
+		m.writeInfoText(code)
+	}
+}
+
+// flushUnsolicited sends the result codes queued while in data mode.
+func (m *Modem) flushUnsolicited() {
+	queue := m.urcQueue
+	m.urcQueue = nil
+	for _, code := range queue {
+		m.printUnsolicited(code)
+	}
+}
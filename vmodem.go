@@ -25,15 +25,21 @@
 package vmodem
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
+	"math/bits"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"github.com/jaracil/vmodem/atparse"
 )
 
 var (
@@ -45,8 +51,37 @@ var (
 	ErrInvalidStateTransition = errors.New("invalid state transition")
 	// ErrNoCarrier is returned when no network connection can be established
 	ErrNoCarrier = errors.New("no carrier")
+	// ErrRemoteBusy can be returned (or wrapped) by OutgoingCall when the called
+	// number is busy, so the call fails with BUSY instead of NO CARRIER
+	ErrRemoteBusy = errors.New("remote busy")
+	// ErrNoAnswer can be returned (or wrapped) by OutgoingCall when the called
+	// number does not answer, so the call fails with NO ANSWER instead of NO CARRIER
+	ErrNoAnswer = errors.New("no answer")
+	// ErrModemClosed is returned when attempting to reconfigure a closed modem
+	ErrModemClosed = errors.New("modem closed")
+	// ErrControllerStopped is returned by Controller operations after the controller is stopped
+	ErrControllerStopped = errors.New("controller stopped")
+)
+
+const (
+	// defaultMaxCmdLen is the command line length used when none is configured
+	defaultMaxCmdLen = 100
+	// minMaxCmdLen is the minimum command line length required by V.250
+	minMaxCmdLen = 40
+	// callStallThreshold is the minimum data gap counted as stall time during a call
+	callStallThreshold = time.Second
+	// defaultResumeBufferSize is the remote data buffered in online command mode when none is configured
+	defaultResumeBufferSize = 4096
+	// ttyReadSize is the size of the pooled buffers online TTY input is read into
+	ttyReadSize = 4096
+	// ttyCmdReadSize is the size of the buffer TTY input is read into in command
+	// mode, where it is typed by hand, so idle modems keep little memory
+	ttyCmdReadSize = 64
 )
 
+// discardLog is the logger of modems without one.
+var discardLog = slog.New(slog.NewTextHandler(io.Discard, nil))
+
 // ModemStatus represents the current operational state of the modem.
 // The modem follows a strict state machine with defined transitions.
 type ModemStatus int
@@ -144,6 +179,74 @@ func CmdReturnFromString(s string) RetCode {
 	}
 }
 
+// ResultLocale maps result codes to the text sent to the TTY in verbose mode.
+// Codes missing from a locale fall back to LocaleEnglish. Numeric (V0) result
+// codes are not affected by the locale.
+type ResultLocale map[RetCode]string
+
+var (
+	// LocaleEnglish contains the standard Hayes verbose result codes
+	LocaleEnglish = ResultLocale{
+		RetCodeOk:         "OK",
+		RetCodeError:      "ERROR",
+		RetCodeConnect:    "CONNECT",
+		RetCodeNoCarrier:  "NO CARRIER",
+		RetCodeNoDialtone: "NO DIALTONE",
+		RetCodeBusy:       "BUSY",
+		RetCodeNoAnswer:   "NO ANSWER",
+		RetCodeRing:       "RING",
+	}
+	// LocaleFrench contains the verbose result codes of French Minitel-era modems
+	LocaleFrench = ResultLocale{
+		RetCodeOk:         "OK",
+		RetCodeError:      "ERREUR",
+		RetCodeConnect:    "CONNEXION",
+		RetCodeNoCarrier:  "PAS DE PORTEUSE",
+		RetCodeNoDialtone: "PAS DE TONALITE",
+		RetCodeBusy:       "OCCUPE",
+		RetCodeNoAnswer:   "PAS DE REPONSE",
+		RetCodeRing:       "SONNERIE",
+	}
+	// Locales contains the built-in locales indexed by language code
+	Locales = map[string]ResultLocale{
+		"en": LocaleEnglish,
+		"fr": LocaleFrench,
+	}
+)
+
+// Parity selects how the high bit of bytes received in command mode is handled.
+// With ParityEven and ParityOdd, command mode output (echo, information text and
+// result codes) is sent with the same parity. Data mode traffic is always passed
+// through untouched.
+type Parity int
+
+const (
+	// ParityNone uses command mode bytes as received (8 data bits)
+	ParityNone Parity = iota
+	// ParityStrip clears the high bit without checking it (7 data bits, any parity)
+	ParityStrip
+	// ParityEven clears the high bit and discards bytes with odd parity
+	ParityEven
+	// ParityOdd clears the high bit and discards bytes with even parity
+	ParityOdd
+)
+
+// String returns a human-readable string representation of the parity mode.
+func (p Parity) String() string {
+	switch p {
+	case ParityNone:
+		return "None"
+	case ParityStrip:
+		return "Strip"
+	case ParityEven:
+		return "Even"
+	case ParityOdd:
+		return "Odd"
+	default:
+		return "Unknown"
+	}
+}
+
 // Modem represents a virtual Hayes-compatible modem that bridges TTY interfaces
 // with TCP/IP networks. It implements a complete modem state machine with support
 // for AT commands, phone number translation, and extensible hooks.
@@ -153,43 +256,168 @@ func CmdReturnFromString(s string) RetCode {
 // available for convenience that acquire and release the lock automatically.
 type Modem struct {
 	sync.Mutex
-	st               ModemStatus
+	ttyMu            sync.Mutex   // Serializes TTY writes, taken after the modem lock
+	st               atomic.Int32 // ModemStatus, written under the lock but read without it
 	stCtx            context.Context
 	stCtxCancel      context.CancelFunc
+	call             *CallHandle
 	id               string
 	tty              io.ReadWriteCloser
 	conn             io.ReadWriteCloser
 	statusTransition StatusTransitionType
-	outgoingCall     OutgoingCallType
-	commandHook      CommandHookType
+	sregChange       SRegChangeType
+	outgoingCall     OutgoingCallContextType
+	commandHooks     []commandHookEntry
+	commands         map[string]CommandHookType
+	commandHookSeq   int
+	configHookId     int
 	lineHook         LineHookType
 	connectStr       string
+	locale           ResultLocale
+	resultText       ResultLocale
+	numericCodes     map[RetCode]string
 	answerChar       string
 	sregs            map[byte]byte
 	echo             bool
 	shortForm        bool
+	defEcho          bool
+	defShortForm     bool
+	defQuiet         bool
+	defSRegs         map[byte]byte
 	quietMode        bool
 	ringCount        int
 	ringMax          int
+	ringTimeout      time.Duration
+	ring             RingType
+	callInfo         CallInfo
+	callerId         bool
+	missedCall       MissedCallType
+	busyStr          string
+	busyCall         BusyCallType
 	disablePreGuard  bool
 	disablePostGuard bool
+	maxCmdLen        int
+	cmdParity        Parity
 	metrics          *Metrics
+	callLastData     time.Time
+	callDropBase     int64 // ttyDropped when the current call connected
+	callResumeLost   int   // Remote data of the current call lost for a full resume buffer
+	resumeBuf        []byte
+	resumeBufSize    int
+	urcPolicy        UnsolicitedPolicy
+	urcQueue         []string
+	callRecord       CallRecordType
+	capture          *Capture
+	records          []CallRecord
+	ttyBufSize       int
+	ttyOverflow      TTYOverflowPolicy
+	ttyDropped       atomic.Int64
+	transitions      int           // Depth of the status transitions in progress
+	ttyErr           error         // TTY write error of the transition in progress
+	ttyStop          chan struct{} // Closed to stop the read task of the current TTY
+	ioErrorHook      IOErrorType
+	dialTimeout      time.Duration
+	dead             DeadType
+	lineSpeed        int
+	impairment       LineImpairment
+	respDelay        time.Duration
+	respDelays       map[RetCode]time.Duration
+	connectDelay     time.Duration
+	answering        bool
+	unknownPolicy    UnknownCommandPolicy
+	unknownCmd       CommandHookType
+	flowCtl          int
+	errorCtl         int
+	dtrCtl           int
+	dtr              bool
+	transparency     Transparency
+	xoff             chan struct{} // Closed on XON, nil unless paused by XOFF
+	charDelay        time.Duration
+	charNext         time.Time // Earliest time of the next paced character
+	writeChunk       int
+	flushDelay       time.Duration
+	txRate           rateMeter
+	rxRate           rateMeter
+	expvar           *expvarModem
+	observers        map[int]*observer
+	filters          [2][]filterEntry
+	filterSeq        int
+	observerSeq      int
+	subscribers      map[int]chan StateChange
+	subscriberSeq    int
+	log              *slog.Logger
+	closeErr         error
+	tasks            sync.WaitGroup
 }
 
 // StatusTransitionType defines a callback function that is called whenever the modem
 // changes state. It receives the modem instance and both the previous and new status.
 type StatusTransitionType func(m *Modem, prevStatus ModemStatus, newStatus ModemStatus)
 
+// SRegChangeType defines a callback function that is called when the DTE changes
+// an S-register value. It receives the modem instance, the register number and its
+// previous and new values. It is called with the modem lock held.
+type SRegChangeType func(m *Modem, reg byte, prev byte, value byte)
+
+// CallInfo contains caller information attached to an incoming call.
+type CallInfo struct {
+	// Number is the calling number
+	Number string
+	// Name is the calling party name
+	Name string
+	// Source describes where the call comes from, such as the remote network address
+	Source string
+}
+
+// RingType defines a callback function that is called each time an incoming call rings.
+// It receives the modem instance, the number of rings so far and the caller information.
+// It is called with the modem lock held and may answer (Answer) or reject (Hangup) the call.
+type RingType func(m *Modem, rings int, info CallInfo)
+
+// MissedCallType defines a callback function that is called when an incoming call
+// stops ringing without being answered. It receives the modem instance and the number
+// of rings that were sent to the TTY. It is called with the modem lock held.
+type MissedCallType func(m *Modem, rings int)
+
+// BusyCallType defines a callback function that is called when an incoming call is
+// rejected because the modem is not idle. It receives the modem instance and the
+// rejected connection, which remains owned by the caller of IncomingCall.
+// It is called with the modem lock held.
+type BusyCallType func(m *Modem, conn io.ReadWriteCloser)
+
 // OutgoingCallType defines a callback function for handling outgoing calls.
 // It receives the modem instance and phone number, and should return a connection
 // or an error if the call cannot be established.
 type OutgoingCallType func(m *Modem, number string) (io.ReadWriteCloser, error)
 
+// OutgoingCallContextType is like OutgoingCallType, but also receives the context of
+// the dialing phase. The context is cancelled when the DTE aborts the call by pressing
+// a key, when the call is hung up or the modem is closed, and once dialing is over, so
+// it can bound a dial (e.g. net.Dialer.DialContext) but not the established connection.
+// The callback is called without the modem lock held.
+type OutgoingCallContextType func(ctx context.Context, m *Modem, number string) (io.ReadWriteCloser, error)
+
+// Command is a single AT command of a command line, as passed to command hooks.
+type Command = atparse.Command
+
 // CommandHookType defines a callback function for handling custom AT commands.
-// It receives the modem instance, command character, numeric parameter, and flags
-// indicating if it's an assignment or query. It should return a RetCode indicating
-// how the command should be processed.
-type CommandHookType func(m *Modem, cmdChar string, cmdNum string, cmdAssign bool, cmdQuery bool, cmdAssignVal string) RetCode
+// It receives the modem instance and the parsed command. It should return a RetCode
+// indicating how the command should be processed.
+type CommandHookType func(m *Modem, cmd Command) RetCode
+
+// LegacyCommandHookType is the signature of command hooks before Command was introduced,
+// receiving the command name, numeric parameter, assignment and query flags, and
+// assigned value. Test commands ("=?") are passed as both assignment and query.
+//
+// Deprecated: Use CommandHookType. LegacyCommandHook adapts existing hooks.
+type LegacyCommandHookType func(m *Modem, cmdChar string, cmdNum string, cmdAssign bool, cmdQuery bool, cmdAssignVal string) RetCode
+
+// LegacyCommandHook adapts a hook with the positional signature to CommandHookType.
+func LegacyCommandHook(hook LegacyCommandHookType) CommandHookType {
+	return func(m *Modem, cmd Command) RetCode {
+		return hook(m, cmd.Name, cmd.Number, cmd.Assign || cmd.Test, cmd.Query || cmd.Test, cmd.Value)
+	}
+}
 
 // LineHookType defines a callback function for handling complete command lines.
 // It receives the modem instance and the complete command line. It should return
@@ -203,26 +431,125 @@ type ModemConfig struct {
 	Id string
 	// OutgoingCall is an optional callback for handling outgoing calls
 	OutgoingCall OutgoingCallType
+	// OutgoingCallContext is an optional callback for handling outgoing calls that can
+	// observe call cancellation. It takes precedence over OutgoingCall.
+	OutgoingCallContext OutgoingCallContextType
 	// CommandHook is an optional callback for handling custom AT commands
 	CommandHook CommandHookType
 	// LineHook is an optional callback for handling complete command lines
 	LineHook LineHookType
 	// StatusTransition is an optional callback for status change notifications
 	StatusTransition StatusTransitionType
+	// SRegChange is an optional callback for S-register changes made by the DTE
+	SRegChange SRegChangeType
 	// TTY is the terminal device interface (required)
 	TTY io.ReadWriteCloser
-	// ConnectStr is the string sent when a connection is established (default: "CONNECT")
+	// SharedTTY is an optional second terminal sharing the modem with TTY. Both see
+	// all modem output and their input is combined according to SharedInput.
+	SharedTTY io.ReadWriteCloser
+	// SharedInput selects how input from TTY and SharedTTY is arbitrated (default: ArbitrationMerge)
+	SharedInput InputArbitration
+	// Logger receives the modem diagnostics, tagged with a "modem" attribute holding Id (default: discard)
+	Logger *slog.Logger
+	// ConnectStr is the string sent when a connection is established (default: the locale's CONNECT text)
 	ConnectStr string
+	// Locale is the verbose result code text table (default: LocaleEnglish)
+	Locale ResultLocale
+	// ResultText overrides the verbose text of individual result codes on top of Locale,
+	// e.g. vendor strings like "CONNECT 2400/ARQ". A text with "\n" separators is sent
+	// as several result lines, e.g. "CARRIER 2400\nPROTOCOL: LAPM\nCONNECT 2400".
+	ResultText ResultLocale
+	// NumericCodes overrides the numeric (V0) form of individual result codes, e.g. "10" for CONNECT 2400
+	NumericCodes map[RetCode]string
 	// RingMax is the maximum number of rings before hanging up (default: 5)
 	RingMax int
+	// RingTimeout is the maximum time an incoming call rings before it is abandoned (default: 0, no limit)
+	RingTimeout time.Duration
+	// Ring is an optional callback called on every ring of an incoming call
+	Ring RingType
+	// MissedCall is an optional callback for incoming calls that were not answered
+	MissedCall MissedCallType
+	// BusyStr is an optional busy indication written to incoming connections rejected
+	// because the modem is not idle
+	BusyStr string
+	// BusyCall is an optional callback for incoming connections rejected because the modem is not idle
+	BusyCall BusyCallType
 	// AnswerChar is an optional character sent when answering a call
 	AnswerChar string
-	// GuardTime is the guard time for +++ escape sequence in 50ms increments (default: 20)
+	// GuardTime is the guard time for +++ escape sequence in 50ms increments (default: 20).
+	// It takes precedence over S12 in SRegs when not zero.
 	GuardTime int
+	// SRegs overrides the initial value of S-registers, also restored by ATZ and AT&F
+	// (default: DefaultSRegs)
+	SRegs map[byte]byte
 	// DisablePreGuard disables the pre-guard time check for +++ escape sequence
 	DisablePreGuard bool
 	// DisablePostGuard disables the post-guard time check for +++ escape sequence
 	DisablePostGuard bool
+	// MaxCmdLen is the maximum length of a command line after the AT prefix (default: 100, minimum: 40).
+	// Longer lines are rejected with ERROR.
+	MaxCmdLen int
+	// CommandParity controls parity handling of bytes received in command mode (default: ParityNone)
+	CommandParity Parity
+	// UnsolicitedPolicy selects whether unsolicited result codes sent in data mode are
+	// dropped or queued until the modem returns to command mode (default: UnsolicitedDrop)
+	UnsolicitedPolicy UnsolicitedPolicy
+	// CallRecord is called with the detail record of every call when it ends (optional)
+	CallRecord CallRecordType
+	// TTYBufferSize enables a buffered TTY writer of this many bytes, so a stalled
+	// DTE reader does not block the modem (default: 0, unbuffered)
+	TTYBufferSize int
+	// DialTimeout is a hard limit on the time an outgoing call may take to connect,
+	// in addition to S7. Dials exceeding it are abandoned with NO ANSWER (default: 0, S7 only)
+	DialTimeout time.Duration
+	// IOError is an optional callback for failed TTY and connection I/O operations
+	IOError IOErrorType
+	// Dead is an optional callback for the modem being closed by a TTY failure
+	Dead DeadType
+	// TTYOverflow selects whether writes wait or are dropped when the TTY buffer is full
+	// (default: TTYOverflowBlock)
+	TTYOverflow TTYOverflowPolicy
+	// ResumeBufferSize is the maximum remote data kept while ringing or in online command
+	// mode and delivered to the TTY on CONNECT or ATO (default: 4096). Data beyond this
+	// limit is dropped.
+	ResumeBufferSize int
+	// LineSpeed is the emulated DCE speed in bits per second (300 to 56000). Call data
+	// is paced to it in both directions, 10 bits per byte (default: 0, not paced)
+	LineSpeed int
+	// Impairment adds latency and jitter to the call data of connected calls (optional)
+	Impairment LineImpairment
+	// ResponseDelay is how long the modem takes to answer a command line with its
+	// result code, emulating real modem latency (default: 0)
+	ResponseDelay time.Duration
+	// ResponseDelays overrides ResponseDelay for specific result codes (optional)
+	ResponseDelays map[RetCode]time.Duration
+	// ConnectDelay is the handshake (training) time between the remote answering and
+	// CONNECT, for both outgoing and answered calls (default: 0)
+	ConnectDelay time.Duration
+	// UnknownCommandPolicy selects whether unknown commands return OK, ERROR or the
+	// result of the UnknownCommand hook (default: UnknownCommandOk)
+	UnknownCommandPolicy UnknownCommandPolicy
+	// UnknownCommand is the hook for unknown commands with the UnknownCommandHook policy (optional)
+	UnknownCommand CommandHookType
+	// CharDelay is the minimum delay between the characters of command mode output:
+	// echo, result codes and information text, but not call data (default: 0)
+	CharDelay time.Duration
+	// Transparency selects when online data passes through the modem unmodified for
+	// binary file transfers (default: TransparencyAuto, in direct mode \N1)
+	Transparency Transparency
+	// PublishExpvar publishes the modem metrics as JSON in the "vmodem" expvar map,
+	// keyed by Id, until the modem is closed (default: false)
+	PublishExpvar bool
+	// WriteChunkSize is the maximum size of each write of call data to the connection,
+	// at most 32KB (default: 32KB). TTY input queued while a write is in progress is
+	// coalesced into the next one
+	WriteChunkSize int
+	// WriteFlushDelay is how long TTY input is held for more input to coalesce before
+	// it is written to the connection, trading latency for fewer writes like Nagle's
+	// algorithm (default: 0, input already queued is coalesced without waiting)
+	WriteFlushDelay time.Duration
+	// Capture records both directions of every call with timestamps (optional)
+	Capture *Capture
 }
 
 // Metrics contains runtime statistics and performance information for a modem instance.
@@ -234,6 +561,8 @@ type Metrics struct {
 	TtyTxBytes int
 	// TtyRxBytes is the total number of bytes received from the TTY
 	TtyRxBytes int
+	// TtyDroppedBytes is the total number of bytes discarded because the TTY buffer was full
+	TtyDroppedBytes int
 	// ConnTxBytes is the total number of bytes transmitted to network connections (online mode)
 	ConnTxBytes int
 	// ConnRxBytes is the total number of bytes received from network connections (online mode)
@@ -252,14 +581,99 @@ type Metrics struct {
 	LastAtCmdTime time.Time
 	// LastConnTime is the timestamp of the last connection establishment
 	LastConnTime time.Time
+	// CallStartTime is the timestamp when the current (or last) call was connected
+	CallStartTime time.Time
+	// CallEndTime is the timestamp when the last call ended (zero while a call is active)
+	CallEndTime time.Time
+	// CallTxBytes is the number of bytes transmitted to the connection during the current (or last) call
+	CallTxBytes int
+	// CallRxBytes is the number of bytes received from the connection during the current (or last) call
+	CallRxBytes int
+	// CallStallTime is the accumulated time of the current (or last) call without data flowing
+	// in either direction, counting only gaps longer than one second
+	CallStallTime time.Duration
+	// CallPacingTime is the accumulated time data of the current (or last) call waited
+	// for the emulated line speed, in both directions
+	CallPacingTime time.Duration
+	// CallCorruptBytes is the number of bytes of the current (or last) call flipped or
+	// inserted by line noise, in both directions
+	CallCorruptBytes int
+	// CallDroppedBytes is the number of bytes discarded during the current (or last) call
+	// because the TTY buffer or the online command mode buffer was full
+	CallDroppedBytes int
+	// TxRate is the rate of data transmitted to connections, in bytes per second
+	// over the last five seconds
+	TxRate float64
+	// RxRate is the rate of data received from connections, in bytes per second
+	// over the last five seconds
+	RxRate float64
+	// RetCodes is the number of result codes emitted to the TTY, per code
+	RetCodes map[RetCode]int
+	// LineQueueLen is the number of TTY input chunks queued for the connection
+	LineQueueLen int
+	// ResumeBufLen is the number of bytes of remote data held in online command mode
+	ResumeBufLen int
+	// TtyBufferLen is the number of bytes waiting in the TTY buffer (TTYBufferSize)
+	TtyBufferLen int
+}
+
+// CallDuration returns the duration of the current (or last) call.
+// Returns zero if no call has been connected yet.
+func (mt *Metrics) CallDuration() time.Duration {
+	if mt.CallStartTime.IsZero() {
+		return 0
+	}
+	if mt.CallEndTime.IsZero() {
+		return time.Since(mt.CallStartTime)
+	}
+	return mt.CallEndTime.Sub(mt.CallStartTime)
+}
+
+// CallThroughput returns the effective throughput of the current (or last) call
+// in bytes per second for each direction. Stall time is excluded so the figures
+// reflect the rate achieved while data was actually flowing.
+func (mt *Metrics) CallThroughput() (txBps float64, rxBps float64) {
+	active := mt.CallDuration() - mt.CallStallTime
+	if active <= 0 {
+		return 0, 0
+	}
+	return float64(mt.CallTxBytes) / active.Seconds(), float64(mt.CallRxBytes) / active.Seconds()
 }
 
-func checkValidCmdChar(b byte) bool {
-	return (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z')
+// CallRetransmitBytes returns the retransmission-equivalent volume of the current
+// (or last) call: the bytes damaged by line noise or dropped for lack of buffer
+// room, which an error-correcting link would have to send again.
+func (mt *Metrics) CallRetransmitBytes() int {
+	return mt.CallCorruptBytes + mt.CallDroppedBytes
 }
 
-func checkValidNumChar(b byte) bool {
-	return (b >= '0' && b <= '9')
+// checkParity reports whether b carries a valid parity bit for the given mode.
+func checkParity(b byte, p Parity) bool {
+	switch p {
+	case ParityEven:
+		return bits.OnesCount8(b)%2 == 0
+	case ParityOdd:
+		return bits.OnesCount8(b)%2 == 1
+	default:
+		return true
+	}
+}
+
+// addParity returns b framed as 7 data bits with the parity bit for the given
+// mode, or b itself for modes that do not check parity.
+func addParity(b []byte, p Parity) []byte {
+	if p != ParityEven && p != ParityOdd {
+		return b
+	}
+	out := make([]byte, len(b))
+	for i, c := range b {
+		c &= 0x7f
+		if !checkParity(c, p) {
+			c |= 0x80
+		}
+		out[i] = c
+	}
+	return out
 }
 
 func (m *Modem) checkLock() {
@@ -269,17 +683,145 @@ func (m *Modem) checkLock() {
 }
 
 func (m *Modem) ttyWrite(b []byte) {
+	m.ttyOutput(b, false)
+}
+
+// ttyText writes command mode output (echo, information text) to the TTY, paced
+// by the inter-character delay.
+func (m *Modem) ttyText(b []byte) {
+	m.ttyOutput(b, true)
+}
+
+func (m *Modem) ttyOutput(b []byte, paced bool) {
+	m.metrics.LastTtyTxTime = time.Now()
+	var n int
+	var err error
+	if paced {
+		n, err = m.pacedWrite(b)
+	} else {
+		n, err = m.writeTTY(b)
+	}
+	m.observe(b[:n], false)
+	if err != nil || n == 0 {
+		m.ttyFailed(err)
+		return
+	}
+	m.metrics.TtyTxBytes += n
+}
+
+// ttyFailed closes the modem after the TTY write error err. During a status
+// transition, such as one printing its result code, the modem is closed once
+// the transition is done instead of in the middle of it.
+func (m *Modem) ttyFailed(err error) {
+	m.log.Warn("tty write failed", "error", err)
+	if m.transitions > 0 {
+		if m.ttyErr == nil {
+			m.ttyErr = err
+			if err == nil {
+				m.ttyErr = io.ErrNoProgress
+			}
+		}
+		return
+	}
+	m.die(OpTTYWrite, err)
+}
+
+// endTransition ends a status transition, closing the modem if the TTY failed
+// during the outermost one.
+func (m *Modem) endTransition() {
+	m.transitions--
+	if m.transitions > 0 || m.ttyErr == nil {
+		return
+	}
+	err := m.ttyErr
+	m.ttyErr = nil
+	if m.status() != StatusClosed {
+		m.die(OpTTYWrite, err)
+	}
+}
+
+// writeTTY writes b to the TTY, in turn with the remote data written without
+// the modem lock.
+func (m *Modem) writeTTY(b []byte) (int, error) {
+	m.ttyMu.Lock()
+	defer m.ttyMu.Unlock()
+	return m.tty.Write(b)
+}
+
+// remoteWrite writes the remote data b to the TTY without holding the modem
+// lock, so a TTY that is slow to drain does not block the modem. It is called
+// with the lock held and returns with it held. b is copied under the lock, and
+// the TTY is taken before the modem lock is released, so output written later
+// by the modem, like NO CARRIER, follows it.
+func (m *Modem) remoteWrite(b []byte) {
+	b = append([]byte(nil), b...)
+	tty := m.tty
+	m.ttyMu.Lock()
+	m.Unlock()
+	n, err := tty.Write(b)
+	m.ttyMu.Unlock()
+	m.Lock()
 	m.metrics.LastTtyTxTime = time.Now()
-	n, err := m.tty.Write(b)
+	m.observe(b[:n], false)
 	if err != nil || n == 0 {
-		m.setStatus(StatusClosed)
+		if m.tty == tty && m.status() != StatusClosed {
+			m.ttyFailed(err)
+		}
 		return
 	}
 	m.metrics.TtyTxBytes += n
 }
 
+// callData accounts a data event of the active call, adding the gap since the
+// previous one to the stall time when it exceeds callStallThreshold.
+func (m *Modem) callData() {
+	now := time.Now()
+	if gap := now.Sub(m.callLastData); gap > callStallThreshold {
+		m.metrics.CallStallTime += gap
+	}
+	m.callLastData = now
+}
+
+// callStats updates the pacing, noise and drop figures of the current call
+// from its pump and buffers. It does nothing between calls, so the figures of
+// the last call are kept.
+func (m *Modem) callStats() {
+	if m.metrics.CallStartTime.IsZero() || !m.metrics.CallEndTime.IsZero() {
+		return
+	}
+	if m.call != nil && m.call.pump != nil {
+		m.metrics.CallPacingTime = m.call.pump.paced()
+		m.metrics.CallCorruptBytes = m.call.pump.corrupted()
+	}
+	m.metrics.CallDroppedBytes = m.callResumeLost + int(m.ttyDropped.Load()-m.callDropBase)
+}
+
+// pacedWrite writes command mode output b to the TTY, framed with the command
+// parity, one character at a time at least the inter-character delay apart, for
+// vintage machines that drop characters at full speed. Without a delay b is
+// written at once. The modem lock is held while waiting, as a real modem is busy
+// sending.
+func (m *Modem) pacedWrite(b []byte) (int, error) {
+	m.ttyMu.Lock()
+	defer m.ttyMu.Unlock()
+	b = addParity(b, m.cmdParity)
+	if m.charDelay <= 0 {
+		return m.tty.Write(b)
+	}
+	for i := range b {
+		if d := time.Until(m.charNext); d > 0 {
+			time.Sleep(d)
+		}
+		if _, err := m.tty.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+		m.charNext = time.Now().Add(m.charDelay)
+	}
+	return len(b), nil
+}
+
 func (m *Modem) ttyWriteStr(s string) {
-	m.ttyWrite([]byte(s))
+	m.ttyText([]byte(s))
 }
 
 // TtyWriteStr writes a string to the TTY device.
@@ -327,6 +869,156 @@ func (m *Modem) CrSync() string {
 	return m.cr()
 }
 
+// SetLocale changes the verbose result code text table.
+// A nil locale restores LocaleEnglish.
+// The modem lock must be held before calling this method.
+// Use SetLocaleSync for automatic lock management.
+func (m *Modem) SetLocale(locale ResultLocale) {
+	m.checkLock()
+	m.setLocale(locale)
+}
+
+// SetLocaleSync changes the verbose result code text table with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetLocaleSync(locale ResultLocale) {
+	m.Lock()
+	defer m.Unlock()
+	m.setLocale(locale)
+}
+
+func (m *Modem) setLocale(locale ResultLocale) {
+	if locale == nil {
+		locale = LocaleEnglish
+	}
+	m.locale = locale
+}
+
+// withContext adapts an OutgoingCallType hook to OutgoingCallContextType.
+func withContext(hook OutgoingCallType) OutgoingCallContextType {
+	if hook == nil {
+		return nil
+	}
+	return func(ctx context.Context, m *Modem, number string) (io.ReadWriteCloser, error) {
+		return hook(m, number)
+	}
+}
+
+// SetOutgoingCall replaces the outgoing call hook. A nil hook disables dialing.
+// A call already being dialed completes with the previous hook.
+// The modem lock must be held before calling this method.
+// Use SetOutgoingCallSync for automatic lock management.
+func (m *Modem) SetOutgoingCall(hook OutgoingCallType) {
+	m.checkLock()
+	m.outgoingCall = withContext(hook)
+}
+
+// SetOutgoingCallSync replaces the outgoing call hook with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetOutgoingCallSync(hook OutgoingCallType) {
+	m.Lock()
+	defer m.Unlock()
+	m.outgoingCall = withContext(hook)
+}
+
+// SetOutgoingCallContext replaces the outgoing call hook with one that observes call
+// cancellation. A nil hook disables dialing.
+// The modem lock must be held before calling this method.
+// Use SetOutgoingCallContextSync for automatic lock management.
+func (m *Modem) SetOutgoingCallContext(hook OutgoingCallContextType) {
+	m.checkLock()
+	m.outgoingCall = hook
+}
+
+// SetOutgoingCallContextSync replaces the outgoing call hook with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetOutgoingCallContextSync(hook OutgoingCallContextType) {
+	m.Lock()
+	defer m.Unlock()
+	m.outgoingCall = hook
+}
+
+// SetConnectStr changes the string sent when a connection is established.
+// An empty string restores the locale's CONNECT text.
+// The modem lock must be held before calling this method.
+// Use SetConnectStrSync for automatic lock management.
+func (m *Modem) SetConnectStr(s string) {
+	m.checkLock()
+	m.connectStr = s
+}
+
+// SetConnectStrSync changes the connect string with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetConnectStrSync(s string) {
+	m.Lock()
+	defer m.Unlock()
+	m.connectStr = s
+}
+
+func (m *Modem) setEcho(echo bool) {
+	m.echo = echo
+	m.defEcho = echo
+}
+
+// SetEcho turns command echo on or off, both now and as the default restored by ATZ and AT&F.
+// The modem lock must be held before calling this method.
+// Use SetEchoSync for automatic lock management.
+func (m *Modem) SetEcho(echo bool) {
+	m.checkLock()
+	m.setEcho(echo)
+}
+
+// SetEchoSync turns command echo on or off with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetEchoSync(echo bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.setEcho(echo)
+}
+
+func (m *Modem) setVerbose(verbose bool) {
+	m.shortForm = !verbose
+	m.defShortForm = !verbose
+}
+
+// SetVerbose selects verbose (true) or numeric (false) result codes, both now and as
+// the default restored by ATZ and AT&F.
+// The modem lock must be held before calling this method.
+// Use SetVerboseSync for automatic lock management.
+func (m *Modem) SetVerbose(verbose bool) {
+	m.checkLock()
+	m.setVerbose(verbose)
+}
+
+// SetVerboseSync selects verbose or numeric result codes with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetVerboseSync(verbose bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.setVerbose(verbose)
+}
+
+func (m *Modem) setQuiet(quiet bool) {
+	m.quietMode = quiet
+	m.defQuiet = quiet
+}
+
+// SetQuiet suppresses (true) or sends (false) result codes, both now and as the
+// default restored by ATZ and AT&F.
+// The modem lock must be held before calling this method.
+// Use SetQuietSync for automatic lock management.
+func (m *Modem) SetQuiet(quiet bool) {
+	m.checkLock()
+	m.setQuiet(quiet)
+}
+
+// SetQuietSync suppresses or sends result codes with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetQuietSync(quiet bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.setQuiet(quiet)
+}
+
 func (m *Modem) printRetCode(ret RetCode) {
 	retStr := ""
 	if m.shortForm {
@@ -350,249 +1042,526 @@ func (m *Modem) printRetCode(ret RetCode) {
 		case RetCodeRing:
 			retStr = "2"
 		}
+		if code := m.numericCodes[ret]; code != "" {
+			retStr = code
+		}
 	} else {
 		switch ret {
 		case RetCodeSilent, RetCodeSkip:
 			return
-		case RetCodeOk:
-			retStr = "OK"
-		case RetCodeError:
-			retStr = "ERROR"
 		case RetCodeConnect:
 			retStr = m.connectStr
-		case RetCodeNoCarrier:
-			retStr = "NO CARRIER"
-		case RetCodeNoDialtone:
-			retStr = "NO DIALTONE"
-		case RetCodeBusy:
-			retStr = "BUSY"
-		case RetCodeNoAnswer:
-			retStr = "NO ANSWER"
-		case RetCodeRing:
-			retStr = "RING"
+		}
+		if retStr == "" {
+			retStr = m.resultText[ret]
+		}
+		if retStr == "" {
+			retStr = m.locale[ret]
+		}
+		if retStr == "" {
+			retStr = LocaleEnglish[ret]
 		}
 	}
 	if !m.quietMode {
-		// Write directly to TTY without error handling to avoid recursion during state transitions
 		m.metrics.LastTtyTxTime = time.Now()
-		_, _ = m.tty.Write([]byte(m.cr() + retStr + m.cr()))
+		if m.metrics.RetCodes == nil {
+			m.metrics.RetCodes = make(map[RetCode]int)
+		}
+		m.metrics.RetCodes[ret]++
+		// Multi-line texts are sent as consecutive result lines
+		b := []byte(m.cr() + strings.ReplaceAll(retStr, "\n", m.cr()+m.cr()) + m.cr())
+		n, err := m.pacedWrite(b)
+		m.observe(b[:n], false)
+		if err != nil {
+			m.ttyFailed(err)
+		}
 	}
 }
 
 // SetStatus changes the modem's operational status.
+// Returns ErrInvalidStateTransition if the modem cannot move to status from its current state.
 // The modem lock must be held before calling this method.
 // Use SetStatusSync for automatic lock management.
-func (m *Modem) SetStatus(status ModemStatus) {
+func (m *Modem) SetStatus(status ModemStatus) error {
 	m.checkLock()
-	m.setStatus(status)
+	return m.setStatus(status, CauseAPI)
 }
 
 // SetStatusSync changes the modem's operational status with automatic lock management.
 // This is a convenience method that acquires and releases the modem lock.
-func (m *Modem) SetStatusSync(status ModemStatus) {
+func (m *Modem) SetStatusSync(status ModemStatus) error {
 	m.Lock()
 	defer m.Unlock()
-	m.setStatus(status)
+	return m.setStatus(status, CauseAPI)
+}
+
+// checkTransition validates a transition from the current status to status
+// against the transition table and the preconditions of the target status.
+func (m *Modem) checkTransition(status ModemStatus) error {
+	if !ValidTransition(m.status(), status) {
+		return ErrInvalidStateTransition
+	}
+	// Calls need a connection to ring or go online
+	if (status == StatusConnected || status == StatusRinging) && m.conn == nil {
+		return ErrInvalidStateTransition
+	}
+	return nil
 }
 
-func (m *Modem) setStatus(status ModemStatus) {
-	prevStatus := m.st
+func (m *Modem) setStatus(status ModemStatus, cause TransitionCause) error {
+	prevStatus := m.status()
 	if prevStatus == status {
-		return
+		return nil
 	}
-	if prevStatus == StatusClosed {
-		panic(ErrInvalidStateTransition)
+	if err := m.checkTransition(status); err != nil {
+		m.log.Warn("invalid state transition", "from", prevStatus, "to", status)
+		return err
 	}
+	m.log.Debug("status transition", "from", prevStatus, "to", status, "cause", cause)
+	m.transitions++
+	defer m.endTransition()
+	answering := m.answering
+	m.answering = false
 	m.stCtxCancel()
 	m.stCtx, m.stCtxCancel = context.WithCancel(context.Background())
-	m.st = status
-	switch m.st {
+	// Stored first, so the side effects of the transition and its callbacks
+	// see the new status. Lock-free readers may see it before they are done.
+	m.st.Store(int32(status))
+	m.setXoff(false)
+	if (prevStatus == StatusConnected || prevStatus == StatusConnectedCmd) && (status == StatusIdle || status == StatusClosed) {
+		m.callData()
+		m.callStats()
+		m.metrics.CallEndTime = m.callLastData
+		m.resumeBuf = nil
+	}
+	if status == StatusIdle || status == StatusClosed {
+		m.endCall(cause)
+	}
+	switch m.status() {
 	case StatusIdle:
-		if prevStatus == StatusConnected || prevStatus == StatusConnectedCmd || prevStatus == StatusDialing {
+		if prevStatus == StatusDialing && cause == CauseTimeout {
+			m.printRetCode(RetCodeNoAnswer)
+		} else if prevStatus == StatusDialing && cause == CauseBusy {
+			m.printRetCode(RetCodeBusy)
+		} else if prevStatus == StatusConnected || prevStatus == StatusConnectedCmd || prevStatus == StatusDialing || answering {
 			m.printRetCode(RetCodeNoCarrier)
 		}
+		if prevStatus == StatusRinging && !answering {
+			m.log.Info("incoming call not answered", "rings", m.ringCount)
+			if m.missedCall != nil {
+				m.missedCall(m, m.ringCount)
+			}
+		}
 
 		if m.conn != nil {
 			m.conn.Close()
 			m.conn = nil
 		}
+		m.callInfo = CallInfo{}
 
 	case StatusConnected:
-		if prevStatus != StatusDialing && prevStatus != StatusRinging && prevStatus != StatusConnectedCmd {
-			panic(ErrInvalidStateTransition)
-		}
 		if prevStatus == StatusRinging {
-			if m.answerChar != "" {
-				// Cannot handle error by changing state inside setStatus to avoid recursion
-				_, _ = m.conn.Write([]byte(m.answerChar[0:1]))
-			}
 			m.metrics.NumInConns++
 		}
 		if prevStatus == StatusDialing {
 			m.metrics.NumOutConns++
 		}
-		m.metrics.NumConns++
-		m.metrics.LastConnTime = time.Now()
+		if prevStatus != StatusConnectedCmd {
+			// ATO resumes the call: it is not a new connection
+			m.metrics.NumConns++
+			m.metrics.LastConnTime = time.Now()
+			m.metrics.CallStartTime = m.metrics.LastConnTime
+			m.metrics.CallEndTime = time.Time{}
+			m.metrics.CallTxBytes = 0
+			m.metrics.CallRxBytes = 0
+			m.metrics.CallStallTime = 0
+			m.metrics.CallPacingTime = 0
+			m.metrics.CallCorruptBytes = 0
+			m.metrics.CallDroppedBytes = 0
+			m.callDropBase = m.ttyDropped.Load()
+			m.callResumeLost = 0
+			m.callLastData = m.metrics.LastConnTime
+		}
 		m.printRetCode(RetCodeConnect)
-		go m.onlineTask(m.stCtx)
-	case StatusConnectedCmd:
-		if prevStatus != StatusConnected {
-			panic(ErrInvalidStateTransition)
+		if prevStatus != StatusConnectedCmd {
+			if m.call == nil {
+				m.call = newCall(false, "", CallInfo{})
+			}
+			m.call.connect = m.metrics.CallStartTime
+			if m.call.pump == nil {
+				m.startPump(m.conn)
+			}
+			if prevStatus == StatusRinging && m.answerChar != "" {
+				m.call.pump.greet([]byte(m.answerChar[0:1]))
+			}
+			m.startCarrierLoss(m.call.pump)
 		}
+		// Deliver remote data received while ringing or in online command mode
+		m.deliverResumeBuf()
+	case StatusConnectedCmd:
 		m.printRetCode(RetCodeOk)
-	case StatusDialing:
-		if prevStatus != StatusIdle {
-			panic(ErrInvalidStateTransition)
-		}
 	case StatusRinging:
-		if prevStatus != StatusIdle {
-			panic(ErrInvalidStateTransition)
-		}
-		go m.ringer(m.stCtx)
+		m.ringCount = 0
+		m.setSReg(1, 0)
+		ctx := m.stCtx
+		m.goTask(func() { m.ringer(ctx) })
 	case StatusClosed:
-		m.tty.Close()
-		if prevStatus == StatusConnected || prevStatus == StatusConnectedCmd || prevStatus == StatusRinging {
+		m.closeErr = m.tty.Close()
+		m.removeObservers()
+		if v := m.expvar; v != nil {
+			m.expvar = nil
+			m.goTask(func() { m.unpublishExpvar(v) })
+		}
+		if m.conn != nil {
 			m.conn.Close()
 			m.conn = nil
 		}
+		m.callInfo = CallInfo{}
+	}
+	switch m.status() {
+	case StatusIdle, StatusConnectedCmd:
+		m.flushUnsolicited()
+	case StatusClosed:
+		m.urcQueue = nil
 	}
+	m.notify(StateChange{From: prevStatus, To: status, Time: time.Now(), Cause: cause})
 	if m.statusTransition != nil {
 		m.statusTransition(m, prevStatus, status)
 	}
+	return nil
+}
+
+// deliverResumeBuf writes the remote data held while the modem was not online,
+// or its output paused, to the TTY.
+func (m *Modem) deliverResumeBuf() {
+	if len(m.resumeBuf) == 0 {
+		return
+	}
+	buf := m.resumeBuf
+	m.resumeBuf = nil
+	m.metrics.LastTtyTxTime = time.Now()
+	n, err := m.writeTTY(buf)
+	m.observe(buf[:n], false)
+	if err != nil {
+		m.ttyFailed(err)
+		return
+	}
+	m.metrics.TtyTxBytes += n
 }
 
 func (m *Modem) status() ModemStatus {
-	return m.st
+	return ModemStatus(m.st.Load())
 }
 
 // Status returns the current operational status of the modem.
-// The modem lock must be held before calling this method.
-// Use StatusSync for automatic lock management.
+// Unlike most methods it does not need the modem lock, so supervisors can poll it
+// without contending with the data path. Without the lock the status may change
+// right after it is read; hold the lock to act on it consistently. A status is
+// visible as soon as the transition to it starts, before its result code is
+// written, its call data flows and its callbacks return: acquiring the lock
+// after reading it waits for the transition to complete.
 func (m *Modem) Status() ModemStatus {
-	m.checkLock()
 	return m.status()
 }
 
-// StatusSync returns the current operational status of the modem with automatic lock management.
-// This is a convenience method that acquires and releases the modem lock.
+// StatusSync returns the current operational status of the modem.
+// It is equivalent to Status and does not take the modem lock.
 func (m *Modem) StatusSync() ModemStatus {
-	m.Lock()
-	defer m.Unlock()
 	return m.status()
 }
 
-func (m *Modem) close() {
-	m.setStatus(StatusClosed)
+func (m *Modem) close() error {
+	if m.status() == StatusClosed {
+		return nil
+	}
+	m.setStatus(StatusClosed, CauseAPI)
+	return m.closeErr
 }
 
-// Close terminates the modem and closes all associated resources.
+// Close terminates the modem, hanging up the active call, and closes all associated
+// resources. It returns the error from closing the TTY, if any; closing an already
+// closed modem returns nil. Background tasks finish asynchronously, use Wait to
+// block until they have exited.
 // The modem lock must be held before calling this method.
 // Use CloseSync for automatic lock management.
-func (m *Modem) Close() {
+func (m *Modem) Close() error {
 	m.checkLock()
-	m.close()
+	return m.close()
 }
 
 // CloseSync terminates the modem and closes all associated resources with automatic lock management.
 // This is a convenience method that acquires and releases the modem lock.
-func (m *Modem) CloseSync() {
+func (m *Modem) CloseSync() error {
 	m.Lock()
 	defer m.Unlock()
-	m.close()
+	return m.close()
+}
+
+// Wait blocks until all background tasks of the modem (TTY reader, ringer, dialer,
+// call and observer tasks) have exited, which happens once the modem is closed.
+// A TTY read still pending then is not waited for: the goroutine blocked in it can
+// outlive Wait until the TTY returns from Read, typically when it is closed. A dial
+// in progress through an OutgoingCall hook without context, or a blocked observer
+// write, is waited for until it returns.
+// The modem lock must not be held when calling this method.
+func (m *Modem) Wait() {
+	m.tasks.Wait()
+}
+
+// goTask runs f in a background task tracked by Wait.
+func (m *Modem) goTask(f func()) {
+	m.tasks.Add(1)
+	go func() {
+		defer m.tasks.Done()
+		f()
+	}()
 }
 
 func (m *Modem) ringer(ctx context.Context) {
+	var deadline <-chan time.Time
+	if m.ringTimeout > 0 {
+		deadline = time.After(m.ringTimeout)
+	}
 	m.Lock()
 	for m.status() == StatusRinging {
-		if ctx.Err() != nil {
+		if ctx.Err() != nil || m.answering {
 			break
 		}
 		m.ringCount++
+		m.setSReg(1, byte(min(m.ringCount, 255)))
 		m.printRetCode(RetCodeRing)
+		if m.ringCount == 1 && m.callerId {
+			m.presentCallerId()
+		}
+		if m.ring != nil {
+			m.ring(m, m.ringCount, m.callInfo)
+			if m.status() != StatusRinging || m.answering {
+				// Answered or rejected by the callback
+				break
+			}
+		}
 		if m.ringCount > m.ringMax {
-			m.setStatus(StatusIdle)
+			m.setStatus(StatusIdle, CauseTimeout)
 			break
 		}
 		if m.sregs[0] > 0 && m.ringCount >= int(m.sregs[0]) {
-			m.setStatus(StatusConnected)
+			m.answer(CauseAutoAnswer)
 			break
 		}
 		m.Unlock()
+		timedOut := false
 		select {
 		case <-ctx.Done():
+		case <-deadline:
+			timedOut = true
 		case <-time.After(2 * time.Second):
 		}
 		m.Lock()
+		if timedOut && ctx.Err() == nil {
+			m.setStatus(StatusIdle, CauseTimeout)
+			break
+		}
 	}
-	m.ringCount = 0
 	m.Unlock()
 }
 
-func (m *Modem) onlineTask(ctx context.Context) {
-	buff := make([]byte, 128)
-	m.Lock()
-	for ctx.Err() == nil {
-		m.Unlock()
-		n, err := m.conn.Read(buff)
-		m.Lock()
-		if ctx.Err() != nil {
-			break
+// remoteData handles data received from the remote side of the call.
+func (m *Modem) remoteData(b []byte) {
+	if data := m.remoteInput(b); len(data) > 0 {
+		m.ttyWrite(data)
+	}
+}
+
+// remoteInput accounts the remote data b and returns what is to be written to
+// the TTY, after the filters. Data is held for ATO instead while the modem is
+// not online or its output is paused.
+func (m *Modem) remoteInput(b []byte) []byte {
+	m.metrics.ConnRxBytes += len(b)
+	m.metrics.CallRxBytes += len(b)
+	m.callData()
+	m.rxRate.add(len(b), m.callLastData)
+	data := m.filter(FilterToTTY, b)
+	if len(data) == 0 {
+		return nil
+	}
+	if m.status() != StatusConnected || m.xoff != nil {
+		room := m.resumeBufSize - len(m.resumeBuf)
+		if room > len(data) {
+			room = len(data)
 		}
-		if err != nil || n == 0 {
-			m.setStatus(StatusIdle)
-			break
+		if room > 0 {
+			m.resumeBuf = append(m.resumeBuf, data[:room]...)
 		}
-		m.metrics.ConnRxBytes += n
-		m.Unlock()
-		m.ttyWrite(buff[:n])
-		m.Lock()
+		m.callResumeLost += len(data) - max(room, 0)
+		return nil
 	}
-	m.Unlock()
+	return data
 }
 
-func (m *Modem) incomingCall(conn io.ReadWriteCloser) error {
-	if m.status() != StatusIdle {
-		return ErrModemBusy
+func (m *Modem) injectRemoteData(b []byte) error {
+	if m.status() != StatusConnected && m.status() != StatusConnectedCmd {
+		return ErrNoCarrier
+	}
+	if len(b) > 0 {
+		m.remoteData(b)
 	}
-	m.conn = conn
-	m.setStatus(StatusRinging)
 	return nil
 }
 
-// IncomingCall simulates an incoming call by transitioning the modem to ringing state.
-// The provided connection will be used for the call if answered.
+// InjectRemoteData handles b as if it had been received from the call connection:
+// it is accounted in the metrics, filtered, and written to the TTY or kept for ATO
+// in online command mode. It is meant for tests that need no real connection.
+// Returns ErrNoCarrier if the modem is not connected.
 // The modem lock must be held before calling this method.
-// Use IncomingCallSync for automatic lock management.
-func (m *Modem) IncomingCall(conn io.ReadWriteCloser) error {
+// Use InjectRemoteDataSync for automatic lock management.
+func (m *Modem) InjectRemoteData(b []byte) error {
 	m.checkLock()
-	return m.incomingCall(conn)
+	return m.injectRemoteData(b)
 }
 
-// IncomingCallSync simulates an incoming call with automatic lock management.
+// InjectRemoteDataSync handles b as if it had been received from the call connection
+// with automatic lock management.
 // This is a convenience method that acquires and releases the modem lock.
-func (m *Modem) IncomingCallSync(conn io.ReadWriteCloser) error {
+func (m *Modem) InjectRemoteDataSync(b []byte) error {
 	m.Lock()
 	defer m.Unlock()
-	return m.incomingCall(conn)
+	return m.injectRemoteData(b)
 }
 
-func (m *Modem) processDialing(ctx context.Context, number string) {
-	if ctx.Err() != nil {
-		return
-	}
+func (m *Modem) incomingCall(conn io.ReadWriteCloser, info CallInfo) error {
+	if m.status() != StatusIdle {
+		m.log.Info("incoming call rejected", "status", m.status())
+		if m.busyStr != "" {
+			_, _ = conn.Write([]byte(m.busyStr))
+		}
+		if m.busyCall != nil {
+			m.busyCall(m, conn)
+		}
+		return ErrModemBusy
+	}
+	m.log.Info("incoming call", "number", info.Number, "name", info.Name, "source", info.Source)
+	m.conn = conn
+	m.callInfo = info
+	m.call = newCall(true, info.Number, info)
+	if err := m.setStatus(StatusRinging, CauseRemote); err != nil {
+		m.conn = nil
+		m.callInfo = CallInfo{}
+		m.call = nil
+		return err
+	}
+	// Read the connection while ringing to notice the caller hanging up
+	m.startPump(conn)
+	return nil
+}
+
+// IncomingCall simulates an incoming call by transitioning the modem to ringing state.
+// The provided connection will be used for the call if answered.
+// If the modem is not idle, the busy indication is sent to conn and ErrModemBusy is
+// returned; conn is not closed in that case.
+// The modem lock must be held before calling this method.
+// Use IncomingCallSync for automatic lock management.
+func (m *Modem) IncomingCall(conn io.ReadWriteCloser) error {
+	m.checkLock()
+	return m.incomingCall(conn, CallInfo{})
+}
+
+// IncomingCallSync simulates an incoming call with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) IncomingCallSync(conn io.ReadWriteCloser) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.incomingCall(conn, CallInfo{})
+}
+
+// IncomingCallInfo is like IncomingCall, but also attaches caller information to
+// the call. The information is passed to the Ring callback, presented to the DTE
+// after the first ring when caller ID is enabled (AT+VCID=1), and available through
+// CallInfo until the call ends.
+// The modem lock must be held before calling this method.
+// Use IncomingCallInfoSync for automatic lock management.
+func (m *Modem) IncomingCallInfo(conn io.ReadWriteCloser, info CallInfo) error {
+	m.checkLock()
+	return m.incomingCall(conn, info)
+}
+
+// IncomingCallInfoSync simulates an incoming call with caller information with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) IncomingCallInfoSync(conn io.ReadWriteCloser, info CallInfo) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.incomingCall(conn, info)
+}
+
+func (m *Modem) getCallInfo() CallInfo {
+	return m.callInfo
+}
+
+// CallInfo returns the caller information of the current incoming call.
+// It is empty when no incoming call is ringing or connected, and for calls made through IncomingCall.
+// The modem lock must be held before calling this method.
+// Use CallInfoSync for automatic lock management.
+func (m *Modem) CallInfo() CallInfo {
+	m.checkLock()
+	return m.getCallInfo()
+}
+
+// CallInfoSync returns the caller information of the current incoming call with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) CallInfoSync() CallInfo {
+	m.Lock()
+	defer m.Unlock()
+	return m.getCallInfo()
+}
+
+// presentCallerId writes the caller information to the TTY in the
+// format used by caller ID capable modems.
+func (m *Modem) presentCallerId() {
+	now := time.Now()
+	lines := []string{"DATE = " + now.Format("0102"), "TIME = " + now.Format("1504")}
+	if m.callInfo.Number != "" {
+		lines = append(lines, "NMBR = "+m.callInfo.Number)
+	} else {
+		lines = append(lines, "NMBR = O") // Out of area
+	}
+	if m.callInfo.Name != "" {
+		lines = append(lines, "NAME = "+m.callInfo.Name)
+	}
+	m.writeInfoText(lines...)
+}
+
+func (m *Modem) processDialing(ctx context.Context, number string, delay time.Duration) {
+	if ctx.Err() != nil {
+		return
+	}
 	fail := false
 	transport := false
-	conn, err := m.outgoingCall(m, number)
+	failOp, failErr := OpDial, error(nil)
+	m.log.Info("dialing", "number", number)
+	conn, err := m.outgoingCall(ctx, m, number)
 	if err != nil {
+		m.log.Info("outgoing call failed", "number", number, "error", err)
 		fail = true
+		failErr = err
 	} else {
 		transport = true
 	}
 	if m.answerChar != "" && transport {
+		// Unblock the read if dialing is aborted while waiting for the answer
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
 		buff := make([]byte, 1)
 		n, err := conn.Read(buff)
+		stop()
 		if err != nil || n != 1 || buff[0] != m.answerChar[0] {
+			m.log.Info("remote did not answer", "number", number, "error", err)
 			fail = true
+			failOp, failErr = OpConnRead, err
+		}
+	}
+	if !fail && delay > 0 {
+		// Handshake: the call is still dialing, and can be aborted, until CONNECT
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
 		}
 	}
 	m.Lock()
@@ -607,41 +1576,281 @@ func (m *Modem) processDialing(ctx context.Context, number string) {
 		if transport {
 			conn.Close()
 		}
-		m.setStatus(StatusIdle)
+		if failErr != nil {
+			m.ioError(failOp, failErr)
+		}
+		switch {
+		case errors.Is(failErr, ErrRemoteBusy):
+			m.setStatus(StatusIdle, CauseBusy)
+		case errors.Is(failErr, ErrNoAnswer):
+			m.setStatus(StatusIdle, CauseTimeout)
+		default:
+			m.setStatus(StatusIdle, CauseRemote)
+		}
 		return
 	}
 	m.conn = conn
-	m.setStatus(StatusConnected)
+	m.setStatus(StatusConnected, CauseRemote)
 }
 
-func (m *Modem) processCommand(cmdChar string, cmdNum string, cmdAssign bool, cmdQuery bool, cmdAssignVal string) RetCode {
-	if m.commandHook != nil {
-		r := m.commandHook(m, cmdChar, cmdNum, cmdAssign, cmdQuery, cmdAssignVal)
-		if r != RetCodeSkip {
-			return r
+// dialString returns the dial target of an ATD command, with the tone or pulse
+// modifier and Hayes punctuation removed: spaces always, dashes and parentheses
+// unless the target is a host name (it contains '.', ':' or '_'), which is kept
+// as typed along with its case. A leading T or P is only a modifier when a
+// digit, a space or dial punctuation follows it, so host names starting with
+// those letters are dialed whole. It reports false if the string has characters
+// that are not valid in a dial string.
+func dialString(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) > 0 && strings.ContainsRune("TtPp", rune(s[0])) &&
+		(len(s) == 1 || s[1] >= '0' && s[1] <= '9' || strings.ContainsRune(" ()-*#,;!@+[", rune(s[1]))) {
+		s = s[1:]
+	}
+	host := strings.ContainsAny(s, ".:_")
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == ' ':
+		case (r == '-' || r == '(' || r == ')') && !host:
+		case r < utf8.RuneSelf && (isAlnum(byte(r)) || strings.ContainsRune(".:-_,;*#+!@/[]", r)):
+			b.WriteRune(r)
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// isAlnum reports whether b is an ASCII letter or digit.
+func isAlnum(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func (m *Modem) dial(number string, cause TransitionCause) (*CallHandle, error) {
+	if m.status() != StatusIdle {
+		return nil, ErrModemBusy
+	}
+	if m.outgoingCall == nil {
+		return nil, ErrNoCarrier
+	}
+	m.call = newCall(false, number, CallInfo{})
+	m.setStatus(StatusDialing, cause)
+	ctx := m.stCtx
+	delay := m.connectDelay
+	m.goTask(func() { m.processDialing(ctx, number, delay) })
+	if timeout := m.dialDeadline(); timeout > 0 {
+		m.goTask(func() { m.dialTimer(ctx, timeout) })
+	}
+	return m.call, nil
+}
+
+// dialDeadline returns how long a dial may take before it is abandoned: the
+// earlier of S7 (seconds to wait for carrier) and the configured DialTimeout.
+// Zero means no limit.
+func (m *Modem) dialDeadline() time.Duration {
+	timeout := time.Duration(m.sregs[7]) * time.Second
+	if m.dialTimeout > 0 && (timeout == 0 || m.dialTimeout < timeout) {
+		timeout = m.dialTimeout
+	}
+	return timeout
+}
+
+// dialTimer abandons the dial started with ctx if it is still in progress after
+// timeout. The dial context is cancelled and the connection returned late by the
+// outgoing call hook, if any, is closed by processDialing.
+func (m *Modem) dialTimer(ctx context.Context, timeout time.Duration) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(timeout):
+	}
+	m.Lock()
+	defer m.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	m.log.Info("dial timeout", "timeout", timeout)
+	m.setStatus(StatusIdle, CauseTimeout)
+}
+
+// Dial originates a call to number as if the DTE had typed ATD, without the
+// dial modifiers (T/P) being parsed. The call is placed in the background through
+// the OutgoingCall hook and its outcome (CONNECT or NO CARRIER) is written to the
+// TTY; follow it with Subscribe or the StatusTransition callback. The returned
+// handle is done when the call fails or, once connected, is hung up.
+// Returns ErrModemBusy if the modem is not idle, or ErrNoCarrier (after writing
+// NO CARRIER to the TTY) if no OutgoingCall hook is configured.
+// The modem lock must be held before calling this method.
+// Use DialSync for automatic lock management.
+func (m *Modem) Dial(number string) (*CallHandle, error) {
+	m.checkLock()
+	return m.apiDial(number)
+}
+
+// DialSync originates a call to number with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) DialSync(number string) (*CallHandle, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.apiDial(number)
+}
+
+func (m *Modem) apiDial(number string) (*CallHandle, error) {
+	call, err := m.dial(number, CauseAPI)
+	if err == ErrNoCarrier {
+		m.printRetCode(RetCodeNoCarrier)
+	}
+	return call, err
+}
+
+func (m *Modem) answer(cause TransitionCause) (*CallHandle, error) {
+	if m.status() != StatusRinging {
+		return nil, ErrNoCarrier
+	}
+	if m.answering {
+		return m.call, nil
+	}
+	if m.connectDelay > 0 {
+		if err := m.checkTransition(StatusConnected); err != nil {
+			return nil, err
 		}
+		m.answering = true
+		ctx, delay := m.stCtx, m.connectDelay
+		m.goTask(func() { m.handshake(ctx, delay, cause) })
+		return m.call, nil
+	}
+	if err := m.setStatus(StatusConnected, cause); err != nil {
+		return nil, err
+	}
+	return m.call, nil
+}
+
+// Answer accepts a ringing incoming call as if the DTE had typed ATA and returns
+// its handle, which is done when the call is hung up.
+// CONNECT is written to the TTY when the call goes online.
+// Returns ErrNoCarrier if no call is ringing.
+// The modem lock must be held before calling this method.
+// Use AnswerSync for automatic lock management.
+func (m *Modem) Answer() (*CallHandle, error) {
+	m.checkLock()
+	return m.answer(CauseAPI)
+}
+
+// AnswerSync accepts a ringing incoming call with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) AnswerSync() (*CallHandle, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.answer(CauseAPI)
+}
+
+func (m *Modem) hangup() error {
+	if m.status() == StatusIdle {
+		return nil
+	}
+	return m.setStatus(StatusIdle, CauseAPI)
+}
+
+// Hangup returns the modem to idle, ending an active call as if the DTE had typed ATH.
+// It also aborts a call being dialed and rejects a ringing one. NO CARRIER is written
+// to the TTY when an active or dialing call is dropped. Hanging up an idle modem does nothing.
+// Returns ErrInvalidStateTransition if the modem is closed.
+// The modem lock must be held before calling this method.
+// Use HangupSync for automatic lock management.
+func (m *Modem) Hangup() error {
+	m.checkLock()
+	return m.hangup()
+}
+
+// HangupSync returns the modem to idle with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) HangupSync() error {
+	m.Lock()
+	defer m.Unlock()
+	return m.hangup()
+}
+
+func (m *Modem) sReg(reg byte) byte {
+	return m.sregs[reg]
+}
+
+// SReg returns the value of S-register reg.
+// The modem lock must be held before calling this method.
+// Use SRegSync for automatic lock management.
+func (m *Modem) SReg(reg byte) byte {
+	m.checkLock()
+	return m.sReg(reg)
+}
+
+// SRegSync returns the value of S-register reg with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SRegSync(reg byte) byte {
+	m.Lock()
+	defer m.Unlock()
+	return m.sReg(reg)
+}
+
+func (m *Modem) setSReg(reg byte, value byte) {
+	m.sregs[reg] = value
+}
+
+// SetSReg sets S-register reg to value. The SRegChange callback is not called,
+// as it only reports changes made by the DTE.
+// The modem lock must be held before calling this method.
+// Use SetSRegSync for automatic lock management.
+func (m *Modem) SetSReg(reg byte, value byte) {
+	m.checkLock()
+	m.setSReg(reg, value)
+}
+
+// SetSRegSync sets S-register reg to value with automatic lock management.
+// This is a convenience method that acquires and releases the modem lock.
+func (m *Modem) SetSRegSync(reg byte, value byte) {
+	m.Lock()
+	defer m.Unlock()
+	m.setSReg(reg, value)
+}
+
+// dteSetSReg sets a register on behalf of the DTE and reports the change.
+func (m *Modem) dteSetSReg(reg byte, value byte) {
+	prev := m.sregs[reg]
+	m.setSReg(reg, value)
+	if prev != value && m.sregChange != nil {
+		m.sregChange(m, reg, prev, value)
+	}
+}
+
+func (m *Modem) processCommand(cmd Command) RetCode {
+	if r := m.runCommandHooks(cmd); r != RetCodeSkip {
+		return r
+	}
+	if r := m.runCommand(cmd); r != RetCodeSkip {
+		return r
 	}
-	switch cmdChar {
+	switch cmd.Name {
 	case "S":
-		r, _ := strconv.Atoi(cmdNum)
+		r, _ := strconv.Atoi(cmd.Number)
 		if r < 0 || r > 255 {
 			return RetCodeError
 		}
-		if cmdAssign {
-			v, _ := strconv.Atoi(cmdAssignVal)
-			if v < 0 || v > 255 {
+		if cmd.Assign {
+			v, err := 0, error(nil)
+			if cmd.Value != "" {
+				v, err = strconv.Atoi(cmd.Value)
+			}
+			if err != nil || !checkSReg(byte(r), v) {
 				return RetCodeError
 			}
-			m.sregs[byte(r)] = byte(v)
+			m.dteSetSReg(byte(r), byte(v))
 			return RetCodeOk
 		}
-		if cmdQuery {
+		if cmd.Query {
 			v := m.sregs[byte(r)]
-			m.ttyWriteStr(fmt.Sprintf(m.cr()+"%03d\r\n", v))
+			m.writeInfoText(fmt.Sprintf("%03d", v))
 			return RetCodeOk
 		}
 	case "E":
-		n, _ := strconv.Atoi(cmdNum)
+		n, _ := strconv.Atoi(cmd.Number)
 		switch n {
 		case 0:
 			m.echo = false
@@ -651,7 +1860,7 @@ func (m *Modem) processCommand(cmdChar string, cmdNum string, cmdAssign bool, cm
 			return RetCodeError
 		}
 	case "V":
-		n, _ := strconv.Atoi(cmdNum)
+		n, _ := strconv.Atoi(cmd.Number)
 		switch n {
 		case 0:
 			m.shortForm = true
@@ -664,39 +1873,35 @@ func (m *Modem) processCommand(cmdChar string, cmdNum string, cmdAssign bool, cm
 		if m.status() != StatusIdle {
 			return RetCodeError
 		}
-		if m.outgoingCall != nil {
-			m.setStatus(StatusDialing)
-			number := strings.ToUpper(strings.TrimSpace(cmdAssignVal))
-			if len(number) > 0 && (number[0] == 'T' || number[0] == 'P') {
-				number = number[1:]
-				number = strings.TrimSpace(number)
-			}
-			go m.processDialing(m.stCtx, number)
-			return RetCodeSilent
+		number, ok := dialString(cmd.Value)
+		if !ok {
+			return RetCodeError
 		}
-		return RetCodeNoCarrier
+		if _, err := m.dial(number, CauseCommand); err != nil {
+			return RetCodeNoCarrier
+		}
+		return RetCodeSilent
 	case "A":
 		if m.status() == StatusIdle {
 			return RetCodeNoCarrier
 		}
-		if m.status() != StatusRinging {
+		if _, err := m.answer(CauseCommand); err != nil {
 			return RetCodeError
 		}
-		m.setStatus(StatusConnected)
 		return RetCodeSilent
 	case "H":
 		if m.status() == StatusConnected || m.status() == StatusConnectedCmd {
-			m.setStatus(StatusIdle)
+			m.setStatus(StatusIdle, CauseCommand)
 			return RetCodeSilent
 		}
 	case "O":
 		if m.status() != StatusConnectedCmd {
 			return RetCodeError
 		}
-		m.setStatus(StatusConnected)
+		m.setStatus(StatusConnected, CauseCommand)
 		return RetCodeSilent
 	case "Q":
-		n, _ := strconv.Atoi(cmdNum)
+		n, _ := strconv.Atoi(cmd.Number)
 		switch n {
 		case 0:
 			m.quietMode = false
@@ -705,25 +1910,79 @@ func (m *Modem) processCommand(cmdChar string, cmdNum string, cmdAssign bool, cm
 		default:
 			return RetCodeError
 		}
+	case "+VCID":
+		if cmd.Test {
+			m.writeInfoText("+VCID: (0,1)")
+			return RetCodeOk
+		}
+		if cmd.Query {
+			v := 0
+			if m.callerId {
+				v = 1
+			}
+			m.writeInfoText(fmt.Sprintf("%d", v))
+			return RetCodeOk
+		}
+		switch cmd.Value {
+		case "0":
+			m.callerId = false
+		case "1":
+			m.callerId = true
+		default:
+			return RetCodeError
+		}
+	case "&K":
+		n, _ := strconv.Atoi(cmd.Number)
+		switch n {
+		case flowNone, flowHardware, flowXonXoff:
+			m.flowCtl = n
+		default:
+			return RetCodeError
+		}
+	case "\\N":
+		n, _ := strconv.Atoi(cmd.Number)
+		if n < 0 || n > 5 {
+			return RetCodeError
+		}
+		m.errorCtl = n
+	case "&D":
+		n, _ := strconv.Atoi(cmd.Number)
+		if n < dtrIgnore || n > dtrReset {
+			return RetCodeError
+		}
+		m.dtrCtl = n
 	case "&F", "Z":
-		m.sregs[0] = 0
-		m.echo = true
-		m.shortForm = false
-		m.quietMode = false
+		m.resetSettings()
 		if m.status() == StatusConnected || m.status() == StatusConnectedCmd {
-			m.setStatus(StatusIdle)
+			m.setStatus(StatusIdle, CauseCommand)
 			return RetCodeSilent
 		}
+	default:
+		return m.unknownCommand(cmd)
 	}
 	return RetCodeOk
 }
 
+// resetSettings restores the settings changed by AT commands to their defaults,
+// as ATZ and AT&F.
+func (m *Modem) resetSettings() {
+	m.resetSRegs()
+	m.flowCtl = flowHardware
+	m.errorCtl = errorDefault
+	m.dtrCtl = dtrHangup
+	m.echo = m.defEcho
+	m.shortForm = m.defShortForm
+	m.quietMode = m.defQuiet
+	m.callerId = false
+}
+
 func (m *Modem) processAtCommand(cmd string) RetCode {
 	if m.status() != StatusIdle && m.status() != StatusConnectedCmd && m.status() != StatusRinging {
 		return RetCodeError
 	}
 	// Update LastAtCmdTime before processing hooks
 	m.metrics.LastAtCmdTime = time.Now()
+	m.log.Debug("at command", "line", cmd)
 	// Call line hook if present
 	if m.lineHook != nil {
 		r := m.lineHook(m, cmd)
@@ -731,110 +1990,15 @@ func (m *Modem) processAtCommand(cmd string) RetCode {
 			return r
 		}
 	}
-	cmdBuf := bytes.NewBufferString(cmd)
+	cmds, err := atparse.Parse(cmd)
 	cmdRet := RetCodeOk
-	e := false
-	for cmdBuf.Len() > 0 && !e {
-		cmdChar := ""
-		cmdNum := ""
-		cmdLong := false
-		cmdAssign := false
-		cmdQuery := false
-		cmdAssignVal := ""
-
-		for cmdBuf.Len() > 0 && !e {
-			b, err := cmdBuf.ReadByte()
-			if err != nil {
-				e = true
-				break
-			}
-
-			if b == '?' {
-				if cmdChar != "" {
-					cmdQuery = true
-					break
-				} else {
-					e = true
-					break
-				}
-			}
-
-			if cmdAssign {
-				if !cmdLong && !checkValidNumChar(b) { // short command only accepts numbers
-					cmdBuf.UnreadByte()
-					break
-				}
-				cmdAssignVal += string(b)
-				continue
-			}
-
-			if b == '+' || b == '#' {
-				if cmdChar == "" {
-					cmdLong = true
-					cmdChar += string(b)
-					continue
-				} else {
-					e = true
-					break
-				}
-			}
-
-			if b == '=' {
-				if cmdChar != "" {
-					cmdAssign = true
-					continue
-				} else {
-					e = true
-					break
-				}
-			}
-
-			if cmdLong {
-				if checkValidCmdChar(b) {
-					cmdChar += string(b)
-					continue
-				} else {
-					e = true
-					break
-				}
-			}
-
-			if cmdChar == "" || cmdChar == "&" || cmdChar == "%" {
-				if (b == '&' || b == '%') && cmdChar == "" && cmdBuf.Len() > 0 {
-					cmdChar += string(b)
-					continue
-				}
-				if checkValidCmdChar(b) {
-					cmdChar += string(b)
-					if cmdChar == "d" || cmdChar == "D" {
-						cmdLong = true
-						cmdAssign = true
-					}
-				} else {
-					e = true
-					break
-				}
-			} else {
-				if checkValidNumChar(b) {
-					cmdNum += string(b)
-				} else {
-					cmdBuf.UnreadByte()
-					break
-				}
-			}
-		}
-		if !e {
-			cmdRet = m.processCommand(strings.ToUpper(cmdChar), cmdNum, cmdAssign, cmdQuery, cmdAssignVal)
-			if cmdRet == RetCodeError {
-				break
-			}
-		}
-		if cmdLong {
-			break // long commands don't support chaining
+	for _, c := range cmds {
+		cmdRet = m.processCommand(c)
+		if cmdRet == RetCodeError {
+			return cmdRet
 		}
 	}
-
-	if e {
+	if err != nil {
 		cmdRet = RetCodeError
 	}
 	return cmdRet
@@ -861,8 +2025,21 @@ func (m *Modem) ProcessAtCommandSync(cmd string) RetCode {
 // Use MetricsSync for automatic lock management.
 func (m *Modem) Metrics() *Metrics {
 	m.checkLock()
+	m.callStats()
 	copy := *m.metrics
 	copy.Status = m.status()
+	copy.TtyDroppedBytes = int(m.ttyDropped.Load())
+	now := time.Now()
+	copy.TxRate = m.txRate.rate(now)
+	copy.RxRate = m.rxRate.rate(now)
+	copy.RetCodes = maps.Clone(m.metrics.RetCodes)
+	if m.call != nil && m.call.pump != nil {
+		copy.LineQueueLen = len(m.call.pump.toLine)
+	}
+	copy.ResumeBufLen = len(m.resumeBuf)
+	if t, ok := m.tty.(*bufferedTTY); ok {
+		copy.TtyBufferLen = t.pending()
+	}
 	return &copy
 }
 
@@ -874,136 +2051,370 @@ func (m *Modem) MetricsSync() *Metrics {
 	return m.Metrics()
 }
 
-func (m *Modem) ttyReadTask() {
-	aFlag := false
-	atFlag := false
-	buffer := *bytes.NewBuffer(nil)
-	byteBuff := make([]byte, 1)
-	lastCmd := ""
-	plusCnt := 0
-	lastPlus := time.Time{}
-	lastNotPlus := time.Time{}
+// ttyInput is the state of the TTY input parser, owned by the TTY read task.
+type ttyInput struct {
+	aFlag    bool
+	atFlag   bool
+	line     []byte // Command line being typed, reused
+	echo     [1]byte
+	lastCmd  string
+	overflow bool
+	esc      escapeMatcher
+	held     []byte          // 'A' typed right after an escape sequence
+	escCtx   context.Context // State an escape sequence waits for the post guard time in, nil if none
+	escTimer *time.Timer     // Post guard timer, reused
+}
+
+// armEscape starts the post guard time of an escape sequence typed in the state of ctx.
+func (in *ttyInput) armEscape(ctx context.Context, guard time.Duration) {
+	in.escCtx = ctx
+	if in.escTimer == nil {
+		in.escTimer = time.NewTimer(guard)
+	} else {
+		in.escTimer.Reset(guard)
+	}
+}
+
+// disarmEscape stops the post guard timer.
+func (in *ttyInput) disarmEscape() {
+	if in.escCtx != nil {
+		in.escCtx = nil
+		in.escTimer.Stop()
+	}
+}
+
+// ttyRead is the result of a TTY read.
+type ttyRead struct {
+	n   int
+	err error
+}
+
+// ttyReader reads tty into each buffer received from bufs, until bufs is closed.
+// It runs apart from the read task so the task never blocks in a read: a TTY
+// that does not return from Read after the modem is closed only keeps this
+// goroutine, which is not waited for by Wait.
+func ttyReader(tty io.Reader, bufs <-chan []byte, reads chan<- ttyRead) {
+	for b := range bufs {
+		n, err := tty.Read(b)
+		reads <- ttyRead{n: n, err: err}
+	}
+}
+
+// ttyReadTask processes the input of tty until the modem is closed or stop is
+// closed by attaching another TTY. It sleeps in a select over the TTY data, the
+// state context and the post guard timer, so an idle modem uses no CPU and state
+// changes are handled without waiting for input.
+func (m *Modem) ttyReadTask(tty io.Reader, stop <-chan struct{}) {
+	in := &ttyInput{}
+	cmdBuf := make([]byte, ttyCmdReadSize)
+	bufs := make(chan []byte)
+	reads := make(chan ttyRead, 1)
+	go ttyReader(tty, bufs, reads)
+	defer close(bufs)
 
 	m.Lock()
+	defer m.Unlock()
 	for m.status() != StatusClosed {
+		// Only online modems hold a large buffer, taken from the pump pool
+		buff, pooled := cmdBuf, (*[]byte)(nil)
+		if m.status() == StatusConnected {
+			pooled = pumpBuffers.Get().(*[]byte)
+			buff = (*pooled)[:ttyReadSize]
+		}
 		m.Unlock()
-		n, err := m.tty.Read(byteBuff)
+		bufs <- buff
+		r, ok := m.ttyWait(in, stop, reads)
 		m.Lock()
-		if m.status() == StatusClosed {
-			break
+		if !ok {
+			// Abandoned to the reader, the pooled buffer is left to the GC
+			return
 		}
-
-		if err != nil || n == 0 {
-			m.setStatus(StatusClosed)
-			break
+		ok = m.ttyChunk(in, tty, buff[:r.n], r.err)
+		if pooled != nil {
+			putPumpBuffer(pooled)
 		}
-		m.metrics.LastTtyRxTime = time.Now()
-		m.metrics.TtyRxBytes += n
-		if m.status() == StatusConnected { // online mode pass-through
-			m.metrics.ConnTxBytes += n
-			if m.conn != nil {
-				if _, err := m.conn.Write(byteBuff); err != nil {
-					// Connection write failed, disconnect
-					m.setStatus(StatusIdle)
-					continue
-				}
-			}
-			if byteBuff[0] == '+' {
-				if !m.disablePreGuard {
-					if time.Since(lastNotPlus) < time.Duration(m.sregs[12])*50*time.Millisecond {
-						plusCnt = 0
-						lastNotPlus = time.Now()
-						continue
-					}
-				}
+		if !ok {
+			return
+		}
+	}
+}
 
-				if time.Since(lastPlus) > time.Duration(m.sregs[12])*50*time.Millisecond {
-					plusCnt = 0
-				}
-				plusCnt++
-				lastPlus = time.Now()
-				if plusCnt == 3 {
-					if m.disablePostGuard {
-						m.setStatus(StatusConnectedCmd)
-					} else {
-						go func(ctx context.Context) {
-							time.Sleep(time.Duration(m.sregs[12]) * 50 * time.Millisecond)
-							m.Lock()
-							defer m.Unlock()
-							if ctx.Err() != nil || plusCnt != 3 {
-								return
-							}
-							m.setStatus(StatusConnectedCmd)
-						}(m.stCtx)
-					}
+// ttyWait waits for the pending TTY read without the modem lock, handling state
+// changes and the post guard timer meanwhile. It returns false if the read task
+// must stop first.
+func (m *Modem) ttyWait(in *ttyInput, stop <-chan struct{}, reads <-chan ttyRead) (ttyRead, bool) {
+	for {
+		m.Lock()
+		if m.status() == StatusClosed {
+			m.Unlock()
+			return ttyRead{}, false
+		}
+		stCtx := m.stCtx
+		if in.escCtx != nil && in.escCtx.Err() != nil {
+			in.disarmEscape()
+		}
+		var guard <-chan time.Time
+		if in.escCtx != nil {
+			guard = in.escTimer.C
+		}
+		m.Unlock()
+		select {
+		case r := <-reads:
+			return r, true
+		case <-stop:
+			return ttyRead{}, false
+		case <-stCtx.Done():
+		case <-guard:
+			m.Lock()
+			if in.escCtx.Err() == nil {
+				if in.esc.count == 3 {
+					m.setStatus(StatusConnectedCmd, CauseEscape)
+				} else if held := in.held; held != nil {
+					// "+++A" and nothing more: not an escape, the A is data
+					in.held = nil
+					m.sendInput(held)
 				}
-			} else {
-				plusCnt = 0
-				lastNotPlus = time.Now()
 			}
-			continue
-		} else {
-			plusCnt = 0
+			in.escCtx = nil
+			m.Unlock()
 		}
+	}
+}
+
+// ttyChunk processes a chunk of input read from tty, or the read error err. It
+// returns false if the read task must stop.
+func (m *Modem) ttyChunk(in *ttyInput, tty io.Reader, data []byte, err error) bool {
+	if m.status() == StatusClosed {
+		return false
+	}
+	if m.tty != tty {
+		// Detached by SetTTY, the new TTY has its own task
+		return false
+	}
 
-		if m.status() == StatusDialing {
-			m.setStatus(StatusIdle)
+	if err != nil || len(data) == 0 {
+		m.log.Warn("tty read failed", "error", err)
+		m.die(OpTTYRead, err)
+		return false
+	}
+	m.metrics.LastTtyRxTime = time.Now()
+	m.metrics.TtyRxBytes += len(data)
+	m.observe(data, true)
+	for len(data) > 0 && m.status() != StatusClosed && m.tty == tty {
+		if m.status() == StatusConnected { // online mode pass-through
+			data = data[m.onlineInput(in, data):]
 			continue
 		}
+		in.esc.count = 0
+		in.held = nil
+		m.commandInput(in, data[0])
+		data = data[1:]
+	}
+	return true
+}
 
-		if !atFlag {
-			if m.echo {
-				m.ttyWrite(byteBuff)
+// onlineInput sends TTY input received in online mode to the connection,
+// watching for the escape sequence and XON/XOFF unless the modem is transparent.
+// It returns the number of bytes consumed, which is less than len(data) if the
+// modem leaves online mode: the rest of the input is for the command parser.
+func (m *Modem) onlineInput(in *ttyInput, data []byte) int {
+	if m.transparent() {
+		in.esc.count = 0
+		if held := in.held; held != nil {
+			in.held = nil
+			if !m.sendInput(held) {
+				return 0
 			}
-			if bytes.ToUpper(byteBuff)[0] == 'A' {
-				aFlag = true
-				continue
+		}
+		m.sendInput(data)
+		return len(data)
+	}
+	if !m.xonXoff() {
+		return m.onlineData(in, data)
+	}
+	n := 0
+	for n < len(data) {
+		i := indexFlow(data[n:])
+		if i < 0 {
+			return n + m.onlineData(in, data[n:])
+		}
+		if i > 0 {
+			k := m.onlineData(in, data[n:n+i])
+			n += k
+			if k < i || m.status() != StatusConnected {
+				return n
 			}
-			if aFlag && byteBuff[0] == '/' {
-				aFlag = false
+		}
+		// XON/XOFF control the TTY output instead of reaching the line
+		m.setXoff(data[n] == xoff)
+		n++
+	}
+	return n
+}
+
+// onlineData sends online TTY input to the connection, watching for the escape
+// sequence. It returns the number of bytes consumed, like onlineInput.
+func (m *Modem) onlineData(in *ttyInput, data []byte) int {
+	now := time.Now()
+	guard := time.Duration(m.sregs[12]) * 50 * time.Millisecond
+	in.esc.char = m.sregs[2]
+	in.esc.disabled = m.sregs[2] >= 128
+	in.esc.guard = guard
+	in.esc.preGuard = !m.disablePreGuard
+	start := 0
+	for i := 0; i < len(data); {
+		b := data[i]
+		if in.held != nil {
+			held := in.held
+			in.held = nil
+			if b == 'T' || b == 't' {
+				// "+++AT": the escape sequence followed by a command
+				if !m.sendInput(data[start:i]) {
+					return i + 1
+				}
+				m.setStatus(StatusConnectedCmd, CauseEscape)
 				if m.echo {
-					m.ttyWriteStr("\r")
+					m.ttyText(append(held, b))
 				}
-				r := m.processAtCommand(lastCmd)
-				m.printRetCode(r)
-				continue
+				in.atFlag = true
+				in.aFlag = false
+				in.line = in.line[:0]
+				return i + 1
 			}
-			if aFlag && bytes.ToUpper(byteBuff)[0] == 'T' {
-				atFlag = true
-				aFlag = false
-				continue
+			if !m.sendInput(data[start:i]) || !m.sendInput(held) {
+				return i
 			}
-			aFlag = false
-		} else {
-			if byteBuff[0] == 0x7f {
-				if buffer.Len() > 0 {
-					buffer.Truncate(buffer.Len() - 1)
-					if m.echo {
-						m.ttyWriteStr("\x1b[D \x1b[D")
-					}
-				}
-				continue
+			start = i
+		}
+		if in.esc.count == 3 && !m.disablePostGuard && (b == 'A' || b == 'a') {
+			// Typed before the post guard time: hold it back until the next
+			// byte tells whether a command follows the escape sequence
+			if !m.sendInput(data[start:i]) {
+				return i + 1
 			}
-			if byteBuff[0] == '\r' {
-				atFlag = false
-				lastCmd = buffer.String()
-				if m.echo {
-					m.ttyWriteStr("\r")
-				}
-				r := m.processAtCommand(lastCmd)
-				m.printRetCode(r)
-				buffer.Reset()
-				continue
+			in.esc.count = 0
+			in.held = []byte{b}
+			i++
+			start = i
+			continue
+		}
+		n, escaped := in.esc.scan(data[i:], now)
+		i += n
+		if !escaped {
+			continue
+		}
+		if m.disablePostGuard {
+			if m.sendInput(data[start:i]) {
+				m.setStatus(StatusConnectedCmd, CauseEscape)
 			}
-			if buffer.Len() < 100 && strconv.IsPrint(rune(byteBuff[0])) {
-				buffer.Write(byteBuff)
-				if m.echo {
-					m.ttyWrite(byteBuff)
-				}
+			return i
+		}
+		// Completed by the read task if nothing is typed within the guard time
+		in.armEscape(m.stCtx, guard)
+	}
+	m.sendInput(data[start:])
+	return len(data)
+}
+
+// sendInput passes online TTY input to the data pump of the call, unlocking the
+// modem while the connection is stalled. If the connection failed the call is
+// hung up and false is returned; false is also returned if the modem left online
+// mode while unlocked.
+func (m *Modem) sendInput(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	m.metrics.ConnTxBytes += len(data)
+	m.metrics.CallTxBytes += len(data)
+	m.callData()
+	m.txRate.add(len(data), m.callLastData)
+	if out := m.filter(FilterToLine, data); m.call != nil && m.call.pump != nil && len(out) > 0 {
+		p := m.call.pump
+		if !p.send(m, out) {
+			m.pumpFailed(p)
+			return false
+		}
+	}
+	return m.status() == StatusConnected
+}
+
+// commandInput processes a TTY input byte received in command mode.
+func (m *Modem) commandInput(in *ttyInput, b byte) {
+	if m.status() == StatusDialing || m.answering {
+		m.setStatus(StatusIdle, CauseTTY)
+		return
+	}
+
+	if m.cmdParity != ParityNone {
+		if !checkParity(b, m.cmdParity) {
+			return
+		}
+		b &= 0x7f
+	}
+
+	in.echo[0] = b
+	if !in.atFlag {
+		if m.echo {
+			m.ttyText(in.echo[:])
+		}
+		if b == 'A' || b == 'a' {
+			in.aFlag = true
+			return
+		}
+		if in.aFlag && b == '/' {
+			in.aFlag = false
+			if m.echo {
+				m.ttyWriteStr("\r")
 			}
+			r := m.processAtCommand(in.lastCmd)
+			m.commandResult(r)
+			return
+		}
+		if in.aFlag && (b == 'T' || b == 't') {
+			in.atFlag = true
+			in.aFlag = false
+			return
+		}
+		in.aFlag = false
+		return
+	}
+
+	if b == 0x7f {
+		if len(in.line) > 0 {
+			in.line = in.line[:len(in.line)-1]
+			if m.echo {
+				m.ttyWriteStr("\x1b[D \x1b[D")
+			}
+		}
+		return
+	}
+	if b == '\r' {
+		in.atFlag = false
+		if m.echo {
+			m.ttyWriteStr("\r")
+		}
+		if in.overflow {
+			in.overflow = false
+			m.printRetCode(RetCodeError)
+			in.line = in.line[:0]
+			return
+		}
+		in.lastCmd = string(in.line)
+		in.line = in.line[:0]
+		r := m.processAtCommand(in.lastCmd)
+		m.commandResult(r)
+		return
+	}
+	if strconv.IsPrint(rune(b)) {
+		if len(in.line) >= m.maxCmdLen {
+			in.overflow = true
+			return
+		}
+		in.line = append(in.line, b)
+		if m.echo {
+			m.ttyText(in.echo[:])
 		}
 	}
-	m.Unlock()
 }
 
 // NewModem creates a new modem instance with the specified configuration.
@@ -1012,6 +2423,11 @@ func (m *Modem) ttyReadTask() {
 //
 // Returns ErrConfigRequired if config is nil or required fields are missing.
 func NewModem(config *ModemConfig) (*Modem, error) {
+	return newModem(config, nil)
+}
+
+// newModem creates a modem, calling init (if not nil) before the TTY is read.
+func newModem(config *ModemConfig, init func(m *Modem)) (*Modem, error) {
 	if config == nil {
 		return nil, ErrConfigRequired
 	}
@@ -1021,35 +2437,109 @@ func NewModem(config *ModemConfig) (*Modem, error) {
 	}
 
 	m := &Modem{
-		st:               StatusIdle,
 		id:               config.Id,
-		outgoingCall:     config.OutgoingCall,
-		commandHook:      config.CommandHook,
+		outgoingCall:     config.OutgoingCallContext,
 		lineHook:         config.LineHook,
 		statusTransition: config.StatusTransition,
+		sregChange:       config.SRegChange,
 		tty:              config.TTY,
 		connectStr:       config.ConnectStr,
+		locale:           config.Locale,
+		resultText:       config.ResultText,
+		numericCodes:     config.NumericCodes,
 		ringMax:          config.RingMax,
+		ringTimeout:      config.RingTimeout,
+		ring:             config.Ring,
+		missedCall:       config.MissedCall,
+		busyStr:          config.BusyStr,
+		busyCall:         config.BusyCall,
 		answerChar:       config.AnswerChar,
 		disablePreGuard:  config.DisablePreGuard,
 		disablePostGuard: config.DisablePostGuard,
+		maxCmdLen:        config.MaxCmdLen,
+		cmdParity:        config.CommandParity,
+		resumeBufSize:    config.ResumeBufferSize,
 		echo:             true,
+		defEcho:          true,
 		sregs:            make(map[byte]byte),
 		metrics:          &Metrics{},
+		urcPolicy:        config.UnsolicitedPolicy,
+		callRecord:       config.CallRecord,
+		capture:          config.Capture,
+		ttyBufSize:       config.TTYBufferSize,
+		ttyOverflow:      config.TTYOverflow,
+		ioErrorHook:      config.IOError,
+		dialTimeout:      config.DialTimeout,
+		dead:             config.Dead,
+		lineSpeed:        lineSpeed(config.LineSpeed),
+		impairment:       config.Impairment,
+		respDelay:        config.ResponseDelay,
+		respDelays:       maps.Clone(config.ResponseDelays),
+		connectDelay:     config.ConnectDelay,
+		unknownPolicy:    config.UnknownCommandPolicy,
+		unknownCmd:       config.UnknownCommand,
+		flowCtl:          flowHardware,
+		errorCtl:         errorDefault,
+		dtrCtl:           dtrHangup,
+		dtr:              true,
+		transparency:     config.Transparency,
+		charDelay:        config.CharDelay,
+		writeChunk:       writeChunkSize(config.WriteChunkSize),
+		flushDelay:       config.WriteFlushDelay,
+	}
+
+	if m.outgoingCall == nil {
+		m.outgoingCall = withContext(config.OutgoingCall)
+	}
+
+	m.setCommandHook(config.CommandHook)
+
+	if config.SharedTTY != nil {
+		m.tty = newSharedLine(config.TTY, config.SharedTTY, config.SharedInput)
+	}
+	m.tty = m.bufferTTY(m.tty)
+
+	if config.Logger != nil {
+		m.log = config.Logger.With("modem", m.id)
+	} else {
+		m.log = discardLog
 	}
 
 	m.stCtx, m.stCtxCancel = context.WithCancel(context.Background())
 
-	if m.connectStr == "" {
-		m.connectStr = "CONNECT"
+	if m.locale == nil {
+		m.locale = LocaleEnglish
 	}
 
 	if m.ringMax == 0 {
 		m.ringMax = 5
 	}
 
-	m.sregs[12] = byte(config.GuardTime)
+	if m.resumeBufSize == 0 {
+		m.resumeBufSize = defaultResumeBufferSize
+	}
+
+	if m.maxCmdLen == 0 {
+		m.maxCmdLen = defaultMaxCmdLen
+	} else if m.maxCmdLen < minMaxCmdLen {
+		m.maxCmdLen = minMaxCmdLen
+	}
+
+	m.defSRegs = DefaultSRegs()
+	maps.Copy(m.defSRegs, config.SRegs)
+	if config.GuardTime != 0 {
+		m.defSRegs[12] = byte(config.GuardTime)
+	}
+	maps.Copy(m.sregs, m.defSRegs)
+
+	if init != nil {
+		init(m)
+	}
+
+	if config.PublishExpvar {
+		m.publishExpvar()
+	}
 
-	go m.ttyReadTask()
+	m.startTtyReadTask()
 	return m, nil
 }
@@ -0,0 +1,144 @@
+package vmodem
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// benchChunk is the size of the writes of the throughput benchmarks
+const benchChunk = 32 * 1024
+
+// connectedModem returns a modem online with a call, the DTE end of its TTY and
+// the host end of the call.
+func connectedModem(b *testing.B) (net.Conn, io.ReadWriteCloser) {
+	dte, dce := net.Pipe()
+	modem, err := NewModem(&ModemConfig{Id: "bench", TTY: dce})
+	if err != nil {
+		b.Fatalf("NewModem() error = %v", err)
+	}
+	modem.ProcessAtCommandSync("E0Q1")
+	host, line := NewLine()
+	if err := modem.IncomingCallSync(line); err != nil {
+		b.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		b.Fatalf("AnswerSync() error = %v", err)
+	}
+	b.Cleanup(func() {
+		modem.CloseSync()
+		dte.Close()
+		host.Close()
+	})
+	return dte, host
+}
+
+// Benchmark data typed on the TTY and sent to the remote
+func BenchmarkModem_Upstream(b *testing.B) {
+	dte, host := connectedModem(b)
+	go io.Copy(io.Discard, host)
+	chunk := make([]byte, benchChunk)
+	b.SetBytes(benchChunk)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := dte.Write(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Benchmark data received from the remote and written to the TTY
+func BenchmarkModem_Downstream(b *testing.B) {
+	dte, host := connectedModem(b)
+	chunk := make([]byte, benchChunk)
+	done := make(chan struct{})
+	go func() {
+		io.CopyN(io.Discard, dte, int64(b.N)*benchChunk)
+		close(done)
+	}()
+	b.SetBytes(benchChunk)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := host.Write(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+	<-done
+}
+
+// idleTTY is a TTY nobody types on, as in a bank of idle modems.
+type idleTTY struct {
+	closed chan struct{}
+}
+
+func newIdleTTY() *idleTTY {
+	return &idleTTY{closed: make(chan struct{})}
+}
+
+func (t *idleTTY) Read(p []byte) (int, error) {
+	<-t.closed
+	return 0, io.EOF
+}
+
+func (t *idleTTY) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (t *idleTTY) Close() error {
+	close(t.closed)
+	return nil
+}
+
+// idleModemMemory returns the heap and stack memory in use per idle modem, for
+// a bank of n modems.
+func idleModemMemory(tb testing.TB, n int) uint64 {
+	inUse := func() uint64 {
+		var ms runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc + ms.StackInuse
+	}
+	before := inUse()
+	modems := make([]*Modem, n)
+	for i := range modems {
+		m, err := NewModem(&ModemConfig{Id: fmt.Sprintf("modem%d", i), TTY: newIdleTTY()})
+		if err != nil {
+			tb.Fatalf("NewModem() error = %v", err)
+		}
+		modems[i] = m
+	}
+	time.Sleep(10 * time.Millisecond)
+	after := inUse()
+	for _, m := range modems {
+		m.CloseSync()
+		m.Wait()
+	}
+	if after < before {
+		return 0
+	}
+	return (after - before) / uint64(n)
+}
+
+// Benchmark the memory of a bank of idle modems
+func BenchmarkModem_IdleMemory(b *testing.B) {
+	var perModem uint64
+	for range b.N {
+		perModem = idleModemMemory(b, 500)
+	}
+	b.ReportMetric(float64(perModem), "B/modem")
+}
+
+// idleModemBudget is the target memory of an idle modem in a large bank
+const idleModemBudget = 100 * 1024
+
+// Test idle modems stay within their memory budget
+func TestModem_IdleMemory(t *testing.T) {
+	if perModem := idleModemMemory(t, 200); perModem > idleModemBudget {
+		t.Errorf("Idle modem uses %d bytes, want at most %d", perModem, idleModemBudget)
+	}
+}
@@ -1,9 +1,20 @@
 package vmodem
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
 	"io"
+	"math/bits"
+	"net"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -556,6 +567,18 @@ func TestModem_CommunicationMetrics(t *testing.T) {
 		t.Error("Caller should have transmitted bytes to connection")
 	}
 
+	if callerMetrics.CallTxBytes != len(testData) {
+		t.Errorf("Caller call bytes should be %d, got %d", len(testData), callerMetrics.CallTxBytes)
+	}
+
+	if answererMetrics.CallRxBytes != len(testData) {
+		t.Errorf("Answerer call bytes should be %d, got %d", len(testData), answererMetrics.CallRxBytes)
+	}
+
+	if txBps, _ := callerMetrics.CallThroughput(); txBps <= 0 {
+		t.Errorf("Caller call throughput should be positive, got %f", txBps)
+	}
+
 	if answererMetrics.ConnRxBytes == 0 {
 		t.Error("Answerer should have received bytes from connection")
 	}
@@ -651,3 +674,2143 @@ func TestModem_ReturnToOnlineMode(t *testing.T) {
 		t.Errorf("Data transfer should work after returning online, got %q", answererReceived)
 	}
 }
+
+// Test remote data received in command mode is delivered on ATO
+func TestModem_ResumeBuffersRemoteData(t *testing.T) {
+	// Create TTYs and connection
+	callerTTY := NewMockReadWriteCloser([]byte{})
+	answererTTY := NewMockReadWriteCloser([]byte{})
+	callerConn, answererConn := NewMockConnection()
+
+	// Create modems
+	outgoingCall := func(m *Modem, number string) (io.ReadWriteCloser, error) {
+		return callerConn, nil
+	}
+
+	callerConfig := &ModemConfig{
+		Id:           "caller",
+		TTY:          callerTTY,
+		OutgoingCall: outgoingCall,
+		GuardTime:    2,
+		AnswerChar:   "C",
+	}
+
+	caller, err := NewModem(callerConfig)
+	if err != nil {
+		t.Fatalf("Failed to create caller modem: %v", err)
+	}
+	defer caller.CloseSync()
+
+	answererConfig := &ModemConfig{
+		Id:         "answerer",
+		TTY:        answererTTY,
+		AnswerChar: "C",
+	}
+
+	answerer, err := NewModem(answererConfig)
+	if err != nil {
+		t.Fatalf("Failed to create answerer modem: %v", err)
+	}
+	defer answerer.CloseSync()
+
+	// Wait for initialization
+	time.Sleep(20 * time.Millisecond)
+
+	// Establish connection
+	err = answerer.IncomingCallSync(answererConn)
+	if err != nil {
+		t.Fatalf("Failed to set up incoming call: %v", err)
+	}
+	callerTTY.WriteInput([]byte("ATDT12345\r"))
+	time.Sleep(30 * time.Millisecond)
+	answererTTY.WriteInput([]byte("ATA\r"))
+	time.Sleep(100 * time.Millisecond)
+
+	// Enter command mode with escape sequence
+	callerTTY.WriteInput([]byte("+++"))
+	time.Sleep(200 * time.Millisecond)
+
+	if caller.StatusSync() != StatusConnectedCmd {
+		t.Fatalf("Caller should be in command mode, got %v", caller.StatusSync())
+	}
+
+	// Remote sends data while the caller is in command mode
+	callerTTY.ClearWrites()
+	answererTTY.WriteInput([]byte("While you were away"))
+	time.Sleep(50 * time.Millisecond)
+
+	if strings.Contains(callerTTY.GetWrittenString(), "While you were away") {
+		t.Errorf("Remote data should be held in command mode, got %q", callerTTY.GetWrittenString())
+	}
+
+	// Return to online mode, buffered data is delivered
+	callerTTY.WriteInput([]byte("ATO\r"))
+	time.Sleep(50 * time.Millisecond)
+
+	if caller.StatusSync() != StatusConnected {
+		t.Fatalf("Caller should be back in connected mode, got %v", caller.StatusSync())
+	}
+
+	if !strings.Contains(callerTTY.GetWrittenString(), "While you were away") {
+		t.Errorf("Buffered remote data should be delivered on ATO, got %q", callerTTY.GetWrittenString())
+	}
+
+	// ATO resumes the call, it is not a new connection
+	if m := caller.MetricsSync(); m.NumConns != 1 || m.NumOutConns != 1 {
+		t.Errorf("NumConns, NumOutConns = %d, %d after ATO, want 1, 1", m.NumConns, m.NumOutConns)
+	}
+}
+
+// Test originating calls through the Dial API
+func TestModem_Dial(t *testing.T) {
+	callerTTY := NewMockReadWriteCloser([]byte{})
+	callerConn, _ := NewMockConnection()
+	dialed := make(chan string, 1)
+
+	outgoingCall := func(m *Modem, number string) (io.ReadWriteCloser, error) {
+		dialed <- number
+		return callerConn, nil
+	}
+
+	caller, err := NewModem(&ModemConfig{
+		Id:           "caller",
+		TTY:          callerTTY,
+		OutgoingCall: outgoingCall,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create caller modem: %v", err)
+	}
+	defer caller.CloseSync()
+
+	if _, err := caller.DialSync("example.com:23"); err != nil {
+		t.Fatalf("DialSync() error = %v", err)
+	}
+
+	select {
+	case number := <-dialed:
+		if number != "example.com:23" {
+			t.Errorf("Dialed number = %q, want %q", number, "example.com:23")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OutgoingCall hook not called")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if caller.StatusSync() != StatusConnected {
+		t.Errorf("Caller should be connected, got %v", caller.StatusSync())
+	}
+	if !strings.Contains(callerTTY.GetWrittenString(), "CONNECT") {
+		t.Errorf("Expected CONNECT on the TTY, got %q", callerTTY.GetWrittenString())
+	}
+
+	if _, err := caller.DialSync("1"); err != ErrModemBusy {
+		t.Errorf("DialSync() while connected error = %v, want %v", err, ErrModemBusy)
+	}
+
+	// Without an OutgoingCall hook the DTE sees NO CARRIER
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{Id: "no-hook", TTY: tty})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	if _, err := modem.DialSync("1"); err != ErrNoCarrier {
+		t.Errorf("DialSync() without hook error = %v, want %v", err, ErrNoCarrier)
+	}
+	if !strings.Contains(tty.GetWrittenString(), "NO CARRIER") {
+		t.Errorf("Expected NO CARRIER on the TTY, got %q", tty.GetWrittenString())
+	}
+}
+
+// Test answering and hanging up calls through the API
+func TestModem_AnswerHangup(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{
+		Id:  "answerer",
+		TTY: tty,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	if _, err := modem.AnswerSync(); err != ErrNoCarrier {
+		t.Errorf("AnswerSync() without a call error = %v, want %v", err, ErrNoCarrier)
+	}
+	if err := modem.HangupSync(); err != nil {
+		t.Errorf("HangupSync() while idle error = %v", err)
+	}
+
+	conn, remote := NewMockConnection()
+	if err := modem.IncomingCallSync(conn); err != nil {
+		t.Fatalf("Failed to set up incoming call: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	if modem.StatusSync() != StatusConnected {
+		t.Errorf("Modem should be connected after answering, got %v", modem.StatusSync())
+	}
+	if !strings.Contains(tty.GetWrittenString(), "CONNECT") {
+		t.Errorf("Expected CONNECT on the TTY, got %q", tty.GetWrittenString())
+	}
+
+	tty.ClearWrites()
+	if err := modem.HangupSync(); err != nil {
+		t.Fatalf("HangupSync() error = %v", err)
+	}
+	if modem.StatusSync() != StatusIdle {
+		t.Errorf("Modem should be idle after hanging up, got %v", modem.StatusSync())
+	}
+	if !strings.Contains(tty.GetWrittenString(), "NO CARRIER") {
+		t.Errorf("Expected NO CARRIER on the TTY, got %q", tty.GetWrittenString())
+	}
+
+	// The remote side sees the connection closed
+	if _, err := remote.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected remote connection to be closed after hanging up")
+	}
+
+	modem.CloseSync()
+	if err := modem.HangupSync(); err != ErrInvalidStateTransition {
+		t.Errorf("HangupSync() on closed modem error = %v, want %v", err, ErrInvalidStateTransition)
+	}
+}
+
+// Test call detail records of answered and missed calls
+func TestModem_CallRecords(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	var mu sync.Mutex
+	var delivered []CallRecord
+	modem, err := NewModem(&ModemConfig{
+		Id:          "cdr",
+		TTY:         tty,
+		RingTimeout: 100 * time.Millisecond,
+		CallRecord: func(m *Modem, rec CallRecord) {
+			mu.Lock()
+			delivered = append(delivered, rec)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	conn, remote := NewMockConnection()
+	if err := modem.IncomingCallInfoSync(conn, CallInfo{Number: "5551234"}); err != nil {
+		t.Fatalf("Failed to set up incoming call: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	remote.Write([]byte("hello"))
+	time.Sleep(50 * time.Millisecond)
+	if err := modem.HangupSync(); err != nil {
+		t.Fatalf("HangupSync() error = %v", err)
+	}
+
+	// Unanswered call
+	conn, _ = NewMockConnection()
+	if err := modem.IncomingCallSync(conn); err != nil {
+		t.Fatalf("Failed to set up incoming call: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	records := modem.CallRecordsSync()
+	if len(records) != 2 {
+		t.Fatalf("CallRecordsSync() returned %d records, want 2", len(records))
+	}
+	rec := records[0]
+	if rec.Modem != "cdr" || !rec.Incoming || rec.Number != "5551234" {
+		t.Errorf("record = %+v, want incoming call from 5551234 on cdr", rec)
+	}
+	if !rec.Connected() || rec.Duration() <= 0 {
+		t.Errorf("answered call Connected() = %v, Duration() = %v", rec.Connected(), rec.Duration())
+	}
+	if rec.RxBytes != 5 || rec.Cause != CauseAPI {
+		t.Errorf("RxBytes = %d, Cause = %v, want 5, %v", rec.RxBytes, rec.Cause, CauseAPI)
+	}
+	if _, rxBps := rec.Throughput(); rxBps <= 0 {
+		t.Errorf("Throughput() rx = %v, want positive", rxBps)
+	}
+	if records[1].Connected() || records[1].Cause != CauseTimeout {
+		t.Errorf("missed call Connected() = %v, Cause = %v, want false, %v", records[1].Connected(), records[1].Cause, CauseTimeout)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 2 {
+		t.Errorf("CallRecord callback called %d times, want 2", len(delivered))
+	}
+}
+
+// Test the dial context is cancelled when the DTE aborts a call
+func TestModem_OutgoingCallContext(t *testing.T) {
+	callerTTY := NewMockReadWriteCloser([]byte{})
+	cancelled := make(chan error, 1)
+
+	outgoingCall := func(ctx context.Context, m *Modem, number string) (io.ReadWriteCloser, error) {
+		select {
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+			return nil, ErrNoCarrier
+		}
+	}
+
+	caller, err := NewModem(&ModemConfig{
+		Id:                  "caller",
+		TTY:                 callerTTY,
+		OutgoingCallContext: outgoingCall,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create caller modem: %v", err)
+	}
+	defer caller.CloseSync()
+
+	callerTTY.WriteInput([]byte("ATDT12345\r"))
+	time.Sleep(50 * time.Millisecond)
+
+	if caller.StatusSync() != StatusDialing {
+		t.Fatalf("Caller should be dialing, got %v", caller.StatusSync())
+	}
+
+	// Any key aborts the call
+	callerTTY.WriteInput([]byte("x"))
+
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("Dial context error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dial context not cancelled after abort")
+	}
+
+	if caller.StatusSync() != StatusIdle {
+		t.Errorf("Caller should be idle after abort, got %v", caller.StatusSync())
+	}
+}
+
+// Test caller information on incoming calls
+func TestModem_IncomingCallInfo(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	rings := make(chan CallInfo, 10)
+	modem, err := NewModem(&ModemConfig{
+		Id:  "answerer",
+		TTY: tty,
+		Ring: func(m *Modem, n int, info CallInfo) {
+			rings <- info
+			if info.Name == "Friend" {
+				m.Answer()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	if r := modem.ProcessAtCommandSync("+VCID=1"); r != RetCodeOk {
+		t.Fatalf("AT+VCID=1 returned %v", r)
+	}
+
+	info := CallInfo{Number: "5551234", Name: "Friend", Source: "192.0.2.1:4000"}
+	conn, _ := NewMockConnection()
+	if err := modem.IncomingCallInfoSync(conn, info); err != nil {
+		t.Fatalf("Failed to set up incoming call: %v", err)
+	}
+
+	select {
+	case got := <-rings:
+		if got != info {
+			t.Errorf("Ring callback info = %+v, want %+v", got, info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Ring callback not called")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The ring callback answered the call
+	if modem.StatusSync() != StatusConnected {
+		t.Errorf("Modem should be connected, got %v", modem.StatusSync())
+	}
+	if got := modem.CallInfoSync(); got != info {
+		t.Errorf("CallInfoSync() = %+v, want %+v", got, info)
+	}
+
+	response := tty.GetWrittenString()
+	for _, s := range []string{"RING", "NMBR = 5551234", "NAME = Friend", "CONNECT"} {
+		if !strings.Contains(response, s) {
+			t.Errorf("Expected %q on the TTY, got %q", s, response)
+		}
+	}
+
+	modem.HangupSync()
+	if got := modem.CallInfoSync(); got != (CallInfo{}) {
+		t.Errorf("CallInfoSync() after hangup = %+v, want empty", got)
+	}
+}
+
+// Test unsolicited result codes in command and data mode
+func TestModem_SendUnsolicited(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{
+		Id:                "answerer",
+		TTY:               tty,
+		UnsolicitedPolicy: UnsolicitedQueue,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	if err := modem.SendUnsolicitedSync("+CLIP: \"5551234\",129"); err != nil {
+		t.Errorf("SendUnsolicitedSync() in command mode error = %v", err)
+	}
+	if got := tty.GetWrittenString(); got != "\r\n+CLIP: \"5551234\",129\r\n" {
+		t.Errorf("Unsolicited text = %q", got)
+	}
+
+	// Standard codes follow the numeric setting
+	modem.ProcessAtCommandSync("V0")
+	tty.ClearWrites()
+	modem.SendUnsolicitedSync("RING")
+	if got := tty.GetWrittenString(); got != "\r2\r" {
+		t.Errorf("Numeric RING = %q, want %q", got, "\r2\r")
+	}
+	modem.ProcessAtCommandSync("V1")
+
+	conn, _ := NewMockConnection()
+	modem.IncomingCallSync(conn)
+	modem.AnswerSync()
+	tty.ClearWrites()
+
+	// Queued in data mode, delivered after hangup
+	if err := modem.SendUnsolicitedSync("+CUSTOM: 1"); err != nil {
+		t.Errorf("SendUnsolicitedSync() in data mode error = %v", err)
+	}
+	if strings.Contains(tty.GetWrittenString(), "+CUSTOM") {
+		t.Errorf("Unsolicited code should not be written in data mode, got %q", tty.GetWrittenString())
+	}
+	modem.HangupSync()
+	if got := tty.GetWrittenString(); !strings.HasSuffix(got, "NO CARRIER\r\n\r\n+CUSTOM: 1\r\n") {
+		t.Errorf("Expected queued code after NO CARRIER, got %q", got)
+	}
+
+	// Dropped with the default policy
+	modem.Lock()
+	modem.urcPolicy = UnsolicitedDrop
+	modem.Unlock()
+	conn, _ = NewMockConnection()
+	modem.IncomingCallSync(conn)
+	modem.AnswerSync()
+	if err := modem.SendUnsolicitedSync("+CUSTOM: 2"); err != ErrModemBusy {
+		t.Errorf("SendUnsolicitedSync() with drop policy error = %v, want %v", err, ErrModemBusy)
+	}
+
+	modem.CloseSync()
+	if err := modem.SendUnsolicitedSync("RING"); err != ErrModemClosed {
+		t.Errorf("SendUnsolicitedSync() on a closed modem error = %v, want %v", err, ErrModemClosed)
+	}
+}
+
+// Test Close hangs up and Wait returns once background tasks exit
+func TestModem_CloseWait(t *testing.T) {
+	dte, tty := net.Pipe()
+	defer dte.Close()
+	go io.Copy(io.Discard, dte)
+
+	callerConn, remote := NewMockConnection()
+	modem, err := NewModem(&ModemConfig{
+		Id:  "caller",
+		TTY: tty,
+		OutgoingCall: func(m *Modem, number string) (io.ReadWriteCloser, error) {
+			return callerConn, nil
+		},
+		GuardTime: 100,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	modem.AddObserverSync(io.Discard, true)
+
+	modem.DialSync("1")
+	time.Sleep(50 * time.Millisecond)
+	if modem.StatusSync() != StatusConnected {
+		t.Fatalf("Modem should be connected, got %v", modem.StatusSync())
+	}
+
+	// Start an escape sequence so a guard time task is pending
+	dte.Write([]byte("+++"))
+
+	if err := modem.CloseSync(); err != nil {
+		t.Errorf("CloseSync() error = %v", err)
+	}
+	if err := modem.CloseSync(); err != nil {
+		t.Errorf("Second CloseSync() error = %v", err)
+	}
+	if _, err := remote.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected active connection to be closed by Close")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		modem.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return after Close")
+	}
+}
+
+// Test call handles follow the call until it ends
+func TestModem_CallHandle(t *testing.T) {
+	callerTTY := NewMockReadWriteCloser([]byte{})
+	callerConn, remote := NewMockConnection()
+
+	caller, err := NewModem(&ModemConfig{
+		Id:  "caller",
+		TTY: callerTTY,
+		OutgoingCall: func(m *Modem, number string) (io.ReadWriteCloser, error) {
+			return callerConn, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create caller modem: %v", err)
+	}
+	defer caller.CloseSync()
+
+	if caller.ActiveCallSync() != nil {
+		t.Error("Expected no active call while idle")
+	}
+
+	call, err := caller.DialSync("5551234")
+	if err != nil {
+		t.Fatalf("DialSync() error = %v", err)
+	}
+	if call.Incoming() || call.Number() != "5551234" || call.StartTime().IsZero() {
+		t.Errorf("Unexpected call handle: incoming = %v, number = %q, start = %v", call.Incoming(), call.Number(), call.StartTime())
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if caller.StatusSync() != StatusConnected {
+		t.Fatalf("Caller should be connected, got %v", caller.StatusSync())
+	}
+	if caller.ActiveCallSync() != call {
+		t.Error("ActiveCallSync() should return the dialed call")
+	}
+	select {
+	case <-call.Done():
+		t.Fatal("Call should not be done while connected")
+	default:
+	}
+
+	// Remote hangs up
+	remote.Close()
+	select {
+	case <-call.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Call not done after remote hangup")
+	}
+	if call.Context().Err() == nil {
+		t.Error("Expected call context to be cancelled")
+	}
+	if caller.ActiveCallSync() != nil {
+		t.Error("Expected no active call after hangup")
+	}
+
+	// Incoming calls carry the caller information
+	conn, _ := NewMockConnection()
+	caller.IncomingCallInfoSync(conn, CallInfo{Number: "777", Source: "test"})
+	call, err = caller.AnswerSync()
+	if err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	if !call.Incoming() || call.Number() != "777" || call.Info().Source != "test" {
+		t.Errorf("Unexpected incoming call handle: %+v", call.Info())
+	}
+}
+
+// Test modem pairs wired back to back
+func TestNewPair(t *testing.T) {
+	ttyA := NewMockReadWriteCloser([]byte{})
+	ttyB := NewMockReadWriteCloser([]byte{})
+
+	a, b, err := NewPair(&ModemConfig{Id: "A", TTY: ttyA}, &ModemConfig{Id: "B", TTY: ttyB})
+	if err != nil {
+		t.Fatalf("NewPair() error = %v", err)
+	}
+	defer a.CloseSync()
+	defer b.CloseSync()
+
+	ttyA.WriteInput([]byte("ATD1\r"))
+	time.Sleep(50 * time.Millisecond)
+
+	if b.StatusSync() != StatusRinging {
+		t.Fatalf("B should be ringing, got %v", b.StatusSync())
+	}
+	if a.StatusSync() != StatusDialing {
+		t.Errorf("A should wait for the answer, got %v", a.StatusSync())
+	}
+
+	ttyB.WriteInput([]byte("ATA\r"))
+	time.Sleep(50 * time.Millisecond)
+
+	if a.StatusSync() != StatusConnected || b.StatusSync() != StatusConnected {
+		t.Fatalf("Both modems should be connected, got %v and %v", a.StatusSync(), b.StatusSync())
+	}
+
+	ttyA.WriteInput([]byte("ping"))
+	ttyB.WriteInput([]byte("pong"))
+	time.Sleep(50 * time.Millisecond)
+
+	if !strings.Contains(ttyB.GetWrittenString(), "ping") {
+		t.Errorf("B should receive data from A, got %q", ttyB.GetWrittenString())
+	}
+	if !strings.Contains(ttyA.GetWrittenString(), "pong") {
+		t.Errorf("A should receive data from B, got %q", ttyA.GetWrittenString())
+	}
+
+	// Hanging up on one side drops the other
+	b.HangupSync()
+	time.Sleep(50 * time.Millisecond)
+	if a.StatusSync() != StatusIdle {
+		t.Errorf("A should be idle after B hangs up, got %v", a.StatusSync())
+	}
+
+	if _, _, err := NewPair(&ModemConfig{Id: "A", TTY: ttyA, AnswerChar: "X"}, &ModemConfig{Id: "B", TTY: ttyB, AnswerChar: "Y"}); err != ErrConfigRequired {
+		t.Errorf("NewPair() with different answer chars error = %v, want %v", err, ErrConfigRequired)
+	}
+}
+
+// Test swapping the TTY during a call
+func TestModem_SetTTY(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{
+		Id:  "answerer",
+		TTY: tty,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	conn, remote := NewMockConnection()
+	modem.IncomingCallSync(conn)
+	modem.AnswerSync()
+
+	// Detach: remote data is discarded, the call survives
+	prev, err := modem.SetTTYSync(nil)
+	if err != nil || prev != tty {
+		t.Fatalf("SetTTYSync(nil) = %v, %v", prev, err)
+	}
+	remote.Write([]byte("lost"))
+	time.Sleep(50 * time.Millisecond)
+	if modem.StatusSync() != StatusConnected {
+		t.Fatalf("Call should survive detaching the TTY, got %v", modem.StatusSync())
+	}
+
+	// Re-attach a new TTY
+	newTTY := NewMockReadWriteCloser([]byte{})
+	if prev, err := modem.SetTTYSync(newTTY); err != nil || prev != nil {
+		t.Fatalf("SetTTYSync() = %v, %v", prev, err)
+	}
+	remote.Write([]byte("hello again"))
+	newTTY.WriteInput([]byte("typed"))
+	time.Sleep(50 * time.Millisecond)
+
+	if !strings.Contains(newTTY.GetWrittenString(), "hello again") {
+		t.Errorf("New TTY should receive remote data, got %q", newTTY.GetWrittenString())
+	}
+	if strings.Contains(tty.GetWrittenString(), "hello again") {
+		t.Error("Old TTY should not receive data after being replaced")
+	}
+	buff := make([]byte, 16)
+	n, _ := remote.Read(buff)
+	if string(buff[:n]) != "typed" {
+		t.Errorf("Remote read %q, want %q", buff[:n], "typed")
+	}
+
+	// Input on the old TTY is ignored
+	tty.WriteInput([]byte("x"))
+	time.Sleep(20 * time.Millisecond)
+	if modem.StatusSync() != StatusConnected {
+		t.Errorf("Old TTY input should be ignored, got %v", modem.StatusSync())
+	}
+
+	modem.CloseSync()
+	if _, err := modem.SetTTYSync(newTTY); err != ErrModemClosed {
+		t.Errorf("SetTTYSync() on closed modem error = %v, want %v", err, ErrModemClosed)
+	}
+}
+
+// Test data mode filter chains
+func TestModem_Filters(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{
+		Id:               "answerer",
+		TTY:              tty,
+		GuardTime:        1,
+		DisablePostGuard: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	upper := FilterFunc(func(p []byte) []byte { return bytes.ToUpper(p) })
+	dropX := FilterFunc(func(p []byte) []byte { return bytes.ReplaceAll(p, []byte("X"), nil) })
+	modem.AddFilterSync(FilterToLine, upper)
+	modem.AddFilterSync(FilterToLine, dropX) // Runs after upper
+	id := modem.AddFilterSync(FilterToTTY, FilterFunc(func(p []byte) []byte {
+		return bytes.ReplaceAll(p, []byte("\xff\xff"), []byte("\xff"))
+	}))
+
+	conn, remote := NewMockConnection()
+	modem.IncomingCallSync(conn)
+	modem.AnswerSync()
+	tty.ClearWrites()
+
+	tty.WriteInput([]byte("box"))
+	remote.Write([]byte("a\xff\xffb"))
+	time.Sleep(50 * time.Millisecond)
+
+	buff := make([]byte, 16)
+	n, _ := remote.Read(buff)
+	if string(buff[:n]) != "BO" {
+		t.Errorf("Remote read %q, want %q", buff[:n], "BO")
+	}
+	if got := tty.GetWrittenString(); got != "a\xffb" {
+		t.Errorf("TTY got %q, want %q", got, "a\xffb")
+	}
+
+	// Escape detection sees unfiltered input, and removed filters no longer apply
+	modem.RemoveFilterSync(id)
+	time.Sleep(150 * time.Millisecond) // Pre-guard time of 50ms with margin
+	tty.WriteInput([]byte("+++"))
+	start := time.Now()
+	for modem.StatusSync() != StatusConnectedCmd && time.Since(start) < time.Second {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if modem.StatusSync() != StatusConnectedCmd {
+		t.Fatalf("Escape sequence should work with filters, got %v", modem.StatusSync())
+	}
+	remote.Write([]byte("\xff\xff"))
+	time.Sleep(50 * time.Millisecond)
+	modem.ProcessAtCommandSync("O")
+	time.Sleep(20 * time.Millisecond)
+	if got := tty.GetWrittenString(); !strings.HasSuffix(got, "\xff\xff") {
+		t.Errorf("Removed filter should not apply, got %q", got)
+	}
+}
+
+// Test a stalled DTE reader does not block a modem with a buffered TTY writer
+func TestModem_BufferedTTY(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	modem, err := NewModem(&ModemConfig{
+		Id:            "buffered",
+		TTY:           dce,
+		TTYBufferSize: 16,
+		TTYOverflow:   TTYOverflowDrop,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+
+	// Nobody reads the DTE side: output beyond the buffer is dropped
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			modem.TtyWriteStrSync("0123456789")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Writes blocked with a stalled TTY reader")
+	}
+	if dropped := modem.MetricsSync().TtyDroppedBytes; dropped == 0 {
+		t.Error("Expected dropped bytes with a stalled TTY reader")
+	}
+
+	// Buffered output is still delivered once the DTE reads
+	buf := make([]byte, 10)
+	dte.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(dte, buf); err != nil || string(buf) != "0123456789" {
+		t.Errorf("DTE read %q, %v, want %q", buf, err, "0123456789")
+	}
+
+	start := time.Now()
+	modem.CloseSync()
+	if elapsed := time.Since(start); elapsed > 2*ttyFlushTimeout {
+		t.Errorf("CloseSync() took %v with a stalled TTY reader", elapsed)
+	}
+}
+
+// Test backpressure of a buffered TTY writer
+func TestModem_BufferedTTYBlock(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	modem, err := NewModem(&ModemConfig{
+		Id:            "buffered",
+		TTY:           dce,
+		TTYBufferSize: 4,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	go modem.TtyWriteStrSync("hello world")
+	var got bytes.Buffer
+	buf := make([]byte, 32)
+	dte.SetReadDeadline(time.Now().Add(time.Second))
+	for got.Len() < len("hello world") {
+		n, err := dte.Read(buf)
+		if err != nil {
+			t.Fatalf("DTE read error: %v", err)
+		}
+		got.Write(buf[:n])
+	}
+	if got.String() != "hello world" {
+		t.Errorf("DTE read %q, want %q", got.String(), "hello world")
+	}
+	if dropped := modem.MetricsSync().TtyDroppedBytes; dropped != 0 {
+		t.Errorf("TtyDroppedBytes = %d, want 0", dropped)
+	}
+}
+
+// Test I/O error and dead modem notifications
+func TestModem_IOErrors(t *testing.T) {
+	dte, dce := net.Pipe()
+	var mu sync.Mutex
+	var ops []string
+	dead := make(chan error, 1)
+	modem, err := NewModem(&ModemConfig{
+		Id:  "failing",
+		TTY: dce,
+		OutgoingCall: func(m *Modem, number string) (io.ReadWriteCloser, error) {
+			return nil, net.ErrClosed
+		},
+		IOError: func(m *Modem, op IOOperation, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			ops = append(ops, op.String())
+		},
+		Dead: func(m *Modem, err error) {
+			dead <- err
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+	go io.Copy(io.Discard, dte)
+
+	if _, err := modem.DialSync("1234"); err != nil {
+		t.Fatalf("DialSync() error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// The DTE going away kills the modem
+	dte.Close()
+	select {
+	case err := <-dead:
+		if err == nil {
+			t.Error("Dead callback called without an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dead callback not called after the TTY failed")
+	}
+	if modem.StatusSync() != StatusClosed {
+		t.Errorf("status = %v, want %v", modem.StatusSync(), StatusClosed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ops) != 2 || ops[0] != "Dial" || ops[1] != "TTYRead" {
+		t.Errorf("IOError operations = %v, want [Dial TTYRead]", ops)
+	}
+}
+
+// failingTTY is a TTY whose writes fail once fail is set, while reads go on.
+type failingTTY struct {
+	*MockReadWriteCloser
+	fail atomic.Bool
+}
+
+func (f *failingTTY) Write(p []byte) (int, error) {
+	if f.fail.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	return f.MockReadWriteCloser.Write(p)
+}
+
+// Test a result code failing to reach the TTY kills the modem once its
+// transition is done
+func TestModem_ResultCodeWriteError(t *testing.T) {
+	tty := &failingTTY{MockReadWriteCloser: NewMockReadWriteCloser([]byte{})}
+	var mu sync.Mutex
+	var ops []string
+	deaths := 0
+	modem, err := NewModem(&ModemConfig{
+		Id:  "failing",
+		TTY: tty,
+		IOError: func(m *Modem, op IOOperation, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			ops = append(ops, op.String())
+		},
+		Dead: func(m *Modem, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			deaths++
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	// NO CARRIER cannot be written
+	tty.fail.Store(true)
+	modem.HangupSync()
+	if st := modem.StatusSync(); st != StatusClosed {
+		t.Errorf("status = %v, want %v", st, StatusClosed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if deaths != 1 || len(ops) != 1 || ops[0] != "TTYWrite" {
+		t.Errorf("Dead calls = %d, IOError operations = %v, want 1, [TTYWrite]", deaths, ops)
+	}
+}
+
+// Test dials are abandoned after the dial timeout and late connections closed
+func TestModem_DialTimeout(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	late, remote := NewMockConnection()
+	returned := make(chan struct{})
+	modem, err := NewModem(&ModemConfig{
+		Id:          "dialer",
+		TTY:         tty,
+		DialTimeout: 100 * time.Millisecond,
+		OutgoingCall: func(m *Modem, number string) (io.ReadWriteCloser, error) {
+			// Ignores cancellation and connects too late
+			time.Sleep(300 * time.Millisecond)
+			defer close(returned)
+			return late, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create modem: %v", err)
+	}
+	defer modem.CloseSync()
+
+	call, err := modem.DialSync("1234")
+	if err != nil {
+		t.Fatalf("DialSync() error = %v", err)
+	}
+	select {
+	case <-call.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Call context not cancelled after the dial timeout")
+	}
+	if modem.StatusSync() != StatusIdle {
+		t.Errorf("status after dial timeout = %v, want %v", modem.StatusSync(), StatusIdle)
+	}
+	if !strings.Contains(tty.GetWrittenString(), "NO ANSWER") {
+		t.Errorf("Expected NO ANSWER on the TTY, got %q", tty.GetWrittenString())
+	}
+
+	<-returned
+	time.Sleep(20 * time.Millisecond)
+	if _, err := remote.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the late connection to be closed")
+	}
+
+	// S7 also limits dials when shorter
+	modem.Lock()
+	modem.dialTimeout = 0
+	modem.setSReg(7, 5)
+	d := modem.dialDeadline()
+	modem.Unlock()
+	if d != 5*time.Second {
+		t.Errorf("dialDeadline() with S7=5 = %v, want 5s", d)
+	}
+}
+
+// Test dial errors reported as BUSY and NO ANSWER
+func TestModem_DialErrorResult(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		want  string
+		cause TransitionCause
+	}{
+		{fmt.Errorf("connect: %w", ErrRemoteBusy), "BUSY", CauseBusy},
+		{ErrNoAnswer, "NO ANSWER", CauseTimeout},
+		{errors.New("unreachable"), "NO CARRIER", CauseRemote},
+	} {
+		tty := NewMockReadWriteCloser([]byte{})
+		modem, err := NewModem(&ModemConfig{
+			Id:  "dialer",
+			TTY: tty,
+			OutgoingCall: func(m *Modem, number string) (io.ReadWriteCloser, error) {
+				return nil, tc.err
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create modem: %v", err)
+		}
+		id, changes := modem.SubscribeSync()
+		call, err := modem.DialSync("1234")
+		if err != nil {
+			t.Fatalf("DialSync() error = %v", err)
+		}
+		<-call.Done()
+		var last StateChange
+		for c := range changes {
+			if last = c; c.To == StatusIdle {
+				break
+			}
+		}
+		modem.UnsubscribeSync(id)
+		if last.Cause != tc.cause {
+			t.Errorf("%v: cause = %v, want %v", tc.err, last.Cause, tc.cause)
+		}
+		if !strings.Contains(tty.GetWrittenString(), tc.want) {
+			t.Errorf("%v: expected %s on the TTY, got %q", tc.err, tc.want, tty.GetWrittenString())
+		}
+		modem.CloseSync()
+	}
+}
+
+// Test a command typed right after the escape sequence, without the post guard time
+func TestModem_EscapeImmediateCommand(t *testing.T) {
+	callerTTY := NewMockReadWriteCloser([]byte{})
+	answererTTY := NewMockReadWriteCloser([]byte{})
+	callerConn, answererConn := NewMockConnection()
+
+	caller, err := NewModem(&ModemConfig{
+		Id:  "caller",
+		TTY: callerTTY,
+		OutgoingCall: func(_ *Modem, number string) (io.ReadWriteCloser, error) {
+			return callerConn, nil
+		},
+		GuardTime:  2,
+		AnswerChar: "C",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create caller modem: %v", err)
+	}
+	defer caller.CloseSync()
+
+	answerer, err := NewModem(&ModemConfig{
+		Id:         "answerer",
+		TTY:        answererTTY,
+		GuardTime:  2,
+		AnswerChar: "C",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create answerer modem: %v", err)
+	}
+	defer answerer.CloseSync()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := answerer.IncomingCallSync(answererConn); err != nil {
+		t.Fatalf("Failed to set up incoming call: %v", err)
+	}
+	callerTTY.WriteInput([]byte("ATDT12345\r"))
+	time.Sleep(30 * time.Millisecond)
+	answererTTY.WriteInput([]byte("ATA\r"))
+	time.Sleep(200 * time.Millisecond)
+	if caller.StatusSync() != StatusConnected {
+		t.Fatalf("Caller not connected: %v", caller.StatusSync())
+	}
+
+	// An A not followed by T is data, sent along with the escape sequence
+	answererTTY.ClearWrites()
+	callerTTY.WriteInput([]byte("+++AB"))
+	time.Sleep(200 * time.Millisecond)
+	if caller.StatusSync() != StatusConnected {
+		t.Errorf("Caller should stay online, got %v", caller.StatusSync())
+	}
+	if got := answererTTY.GetWrittenString(); !strings.Contains(got, "+++AB") {
+		t.Errorf("Answerer received %q, want it to contain %q", got, "+++AB")
+	}
+
+	// An A followed by nothing is sent once the guard time is over
+	answererTTY.ClearWrites()
+	callerTTY.WriteInput([]byte("+++A"))
+	time.Sleep(300 * time.Millisecond)
+	if caller.StatusSync() != StatusConnected {
+		t.Errorf("Caller should stay online, got %v", caller.StatusSync())
+	}
+	if got := answererTTY.GetWrittenString(); got != "+++A" {
+		t.Errorf("Answerer received %q, want %q", got, "+++A")
+	}
+
+	answererTTY.ClearWrites()
+	callerTTY.WriteInput([]byte("+++ATH0\r"))
+	time.Sleep(200 * time.Millisecond)
+	if caller.StatusSync() != StatusIdle {
+		t.Errorf("Caller should be idle after +++ATH0, got %v", caller.StatusSync())
+	}
+	if got := answererTTY.GetWrittenString(); strings.Contains(got, "ATH") {
+		t.Errorf("Answerer received the command %q", got)
+	}
+}
+
+// Test the connect handshake delays CONNECT on both ends of a call
+func TestModem_ConnectDelay(t *testing.T) {
+	callerTTY := NewMockReadWriteCloser([]byte{})
+	answererTTY := NewMockReadWriteCloser([]byte{})
+	callerConn, answererConn := NewMockConnection()
+
+	caller, err := NewModem(&ModemConfig{
+		Id:  "caller",
+		TTY: callerTTY,
+		OutgoingCall: func(_ *Modem, number string) (io.ReadWriteCloser, error) {
+			return callerConn, nil
+		},
+		AnswerChar:   "C",
+		ConnectDelay: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create caller modem: %v", err)
+	}
+	defer caller.CloseSync()
+
+	answerer, err := NewModem(&ModemConfig{
+		Id:           "answerer",
+		TTY:          answererTTY,
+		AnswerChar:   "C",
+		ConnectDelay: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create answerer modem: %v", err)
+	}
+	defer answerer.CloseSync()
+
+	if err := answerer.IncomingCallSync(answererConn); err != nil {
+		t.Fatalf("Failed to set up incoming call: %v", err)
+	}
+	callerTTY.WriteInput([]byte("ATDT12345\r"))
+	time.Sleep(30 * time.Millisecond)
+	answererTTY.WriteInput([]byte("ATA\r"))
+	time.Sleep(100 * time.Millisecond)
+
+	// The answerer trains before answering, then the caller trains before CONNECT
+	if answerer.StatusSync() != StatusRinging || caller.StatusSync() != StatusDialing {
+		t.Errorf("During the handshake got %v and %v, want Ringing and Dialing", answerer.StatusSync(), caller.StatusSync())
+	}
+	time.Sleep(200 * time.Millisecond)
+	if answerer.StatusSync() != StatusConnected || caller.StatusSync() != StatusDialing {
+		t.Errorf("After answering got %v and %v, want Connected and Dialing", answerer.StatusSync(), caller.StatusSync())
+	}
+	time.Sleep(200 * time.Millisecond)
+	if caller.StatusSync() != StatusConnected {
+		t.Errorf("Caller should be connected after the handshake, got %v", caller.StatusSync())
+	}
+	if got := answererTTY.GetWrittenString(); strings.Count(got, "RING") != 1 || !strings.Contains(got, "CONNECT") {
+		t.Errorf("Answerer TTY got %q, want one RING and CONNECT", got)
+	}
+
+	// A key typed during the handshake aborts the answer
+	caller.HangupSync()
+	time.Sleep(50 * time.Millisecond)
+	callerConn, answererConn = NewMockConnection()
+	if err := answerer.IncomingCallSync(answererConn); err != nil {
+		t.Fatalf("Failed to set up incoming call: %v", err)
+	}
+	answererTTY.ClearWrites()
+	answererTTY.WriteInput([]byte("ATA\r"))
+	time.Sleep(50 * time.Millisecond)
+	answererTTY.WriteInput([]byte("x"))
+	time.Sleep(50 * time.Millisecond)
+	if answerer.StatusSync() != StatusIdle {
+		t.Errorf("Answerer should be idle after aborting the handshake, got %v", answerer.StatusSync())
+	}
+	if got := answererTTY.GetWrittenString(); !strings.Contains(got, "NO CARRIER") {
+		t.Errorf("Expected NO CARRIER after aborting the handshake, got %q", got)
+	}
+	callerConn.Close()
+}
+
+// Test the data pump moves bulk data in both directions at once
+func TestModem_DataPump(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	modem, err := NewModem(&ModemConfig{Id: "pump", TTY: dce})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0Q1")
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	up := bytes.Repeat([]byte("upstream data\n"), 20000)
+	down := bytes.Repeat([]byte("downstream data\n"), 20000)
+	errs := make(chan error, 4)
+	go func() { _, err := dte.Write(up); errs <- err }()
+	go func() { _, err := host.Write(down); errs <- err }()
+	go func() {
+		got := make([]byte, len(up))
+		_, err := io.ReadFull(host, got)
+		if err == nil && !bytes.Equal(got, up) {
+			err = errors.New("upstream data corrupted")
+		}
+		errs <- err
+	}()
+	go func() {
+		got := make([]byte, len(down))
+		_, err := io.ReadFull(dte, got)
+		if err == nil && !bytes.Equal(got, down) {
+			err = errors.New("downstream data corrupted")
+		}
+		errs <- err
+	}()
+	for range 4 {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("data transfer timed out")
+		}
+	}
+
+	m := modem.MetricsSync()
+	if m.ConnTxBytes != len(up) || m.ConnRxBytes != len(down) {
+		t.Errorf("ConnTxBytes, ConnRxBytes = %d, %d, want %d, %d", m.ConnTxBytes, m.ConnRxBytes, len(up), len(down))
+	}
+}
+
+// Test call data is paced to the emulated line speed in both directions
+func TestModem_LineSpeed(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithId("slow"),
+		WithLineSpeed(2400), // 240 bytes per second
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0Q1")
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	data := strings.Repeat("x", 120)
+	start := time.Now()
+	host.Write([]byte(data))
+	time.Sleep(200 * time.Millisecond)
+	if n := strings.Count(tty.GetWrittenString(), "x"); n == 0 || n > 80 {
+		t.Errorf("TTY got %d bytes after 200ms at 2400 bps, want about 48", n)
+	}
+	for strings.Count(tty.GetWrittenString(), "x") < len(data) && time.Since(start) < 2*time.Second {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > time.Second {
+		t.Errorf("120 bytes at 2400 bps took %v, want about 500ms", elapsed)
+	}
+
+	// DTE input is paced too
+	received := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		buf := make([]byte, 60)
+		io.ReadFull(host, buf)
+		received <- time.Since(start)
+	}()
+	for range 60 {
+		tty.WriteInput([]byte("y"))
+	}
+	if elapsed := <-received; elapsed < 150*time.Millisecond || elapsed > 600*time.Millisecond {
+		t.Errorf("60 bytes at 2400 bps took %v, want about 250ms", elapsed)
+	}
+	if paced := modem.MetricsSync().CallPacingTime; paced < 400*time.Millisecond || paced > time.Second {
+		t.Errorf("CallPacingTime = %v, want about 700ms", paced)
+	}
+
+	if lineSpeed(50) != 300 || lineSpeed(1000000) != 56000 || lineSpeed(0) != 0 {
+		t.Errorf("lineSpeed() does not limit speeds to 300-56000 bps")
+	}
+
+	// A new speed applies to the next call
+	modem.SetLineSpeedSync(115200)
+	if speed := modem.LineSpeedSync(); speed != 56000 {
+		t.Errorf("LineSpeedSync() = %d, want 56000", speed)
+	}
+	modem.HangupSync()
+	host.Close()
+	host, line = NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	start = time.Now()
+	host.Write([]byte(data))
+	for strings.Count(tty.GetWrittenString(), "x") < 2*len(data) && time.Since(start) < 2*time.Second {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("120 bytes at 56000 bps took %v, want about 20ms", elapsed)
+	}
+}
+
+// Test call data is delayed by the line impairment in both directions
+func TestModem_Impairment(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithId("laggy"),
+		WithImpairment(LineImpairment{Latency: 200 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0Q1")
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	host.Write([]byte("ping"))
+	time.Sleep(100 * time.Millisecond)
+	if got := tty.GetWrittenString(); strings.Contains(got, "ping") {
+		t.Errorf("Data delivered before the latency: %q", got)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got := tty.GetWrittenString(); !strings.Contains(got, "ping") {
+		t.Errorf("Data not delivered after the latency: %q", got)
+	}
+
+	start := time.Now()
+	tty.WriteInput([]byte("pong"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(host, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("Host read %q, %v, want %q", buf, err, "pong")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("DTE data took %v, want about 200ms", elapsed)
+	}
+
+	// Data received before the remote hangs up is delivered before NO CARRIER
+	host.Write([]byte("bye"))
+	host.Close()
+	time.Sleep(400 * time.Millisecond)
+	if got := tty.GetWrittenString(); !strings.Contains(got, "bye") {
+		t.Errorf("Data lost on hangup: %q", got)
+	}
+	if modem.StatusSync() != StatusIdle {
+		t.Errorf("Status after remote hangup = %v, want %v", modem.StatusSync(), StatusIdle)
+	}
+}
+
+// Test jittered delays stay in range and keep data in order
+func TestLineDelay(t *testing.T) {
+	for _, dist := range []JitterDistribution{JitterUniform, JitterNormal} {
+		d := lineDelay{imp: LineImpairment{Latency: 100 * time.Millisecond, Jitter: 50 * time.Millisecond, Distribution: dist}}
+		now := time.Now()
+		prev := time.Time{}
+		for i := range 1000 {
+			due := d.due(now.Add(time.Duration(i) * time.Millisecond))
+			if due.Before(prev) {
+				t.Fatalf("%v: chunk %d due before the previous one", dist, i)
+			}
+			if dist == JitterUniform && due.Sub(now.Add(time.Duration(i)*time.Millisecond)) > 150*time.Millisecond {
+				t.Fatalf("%v: delay %v above Latency+Jitter", dist, due.Sub(now))
+			}
+			prev = due
+		}
+	}
+}
+
+// Test line noise corrupts call data in both directions
+func TestModem_LineNoise(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithId("noisy"),
+		WithImpairment(LineImpairment{BitErrorRate: 1}),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0Q1")
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	// Every bit flipped
+	inverted := func(s string) string {
+		b := []byte(s)
+		for i := range b {
+			b[i] ^= 0xff
+		}
+		return string(b)
+	}
+	host.Write([]byte("ping"))
+	time.Sleep(100 * time.Millisecond)
+	if got := tty.GetWrittenString(); !strings.HasSuffix(got, inverted("ping")) {
+		t.Errorf("TTY got %q, want inverted %q", got, "ping")
+	}
+	tty.WriteInput([]byte("pong"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(host, buf); err != nil || string(buf) != inverted("pong") {
+		t.Errorf("Host read %q, %v, want inverted %q", buf, err, "pong")
+	}
+
+	// Every byte is counted for retransmission
+	if m := modem.MetricsSync(); m.CallCorruptBytes != 8 || m.CallRetransmitBytes() != 8 {
+		t.Errorf("CallCorruptBytes, CallRetransmitBytes() = %d, %d, want 8, 8", m.CallCorruptBytes, m.CallRetransmitBytes())
+	}
+	modem.HangupSync()
+	if recs := modem.CallRecordsSync(); len(recs) != 1 || recs[0].CorruptBytes != 8 || recs[0].RetransmitBytes() != 8 {
+		t.Errorf("CallRecordsSync() = %+v, want one record with 8 corrupt bytes", recs)
+	}
+}
+
+// Test the noise model error rates
+func TestLineNoise(t *testing.T) {
+	data := make([]byte, 100000)
+	n := newLineNoise(LineImpairment{BitErrorRate: 0.01})
+	flipped := 0
+	for i := 0; i < len(data); i += 1000 {
+		out := n.apply(data[i : i+1000])
+		if len(out) != 1000 {
+			t.Fatalf("apply() changed the length to %d", len(out))
+		}
+		for _, b := range out {
+			flipped += bits.OnesCount8(b)
+		}
+	}
+	if flipped < 7000 || flipped > 9000 {
+		t.Errorf("Flipped %d bits, want about 8000", flipped)
+	}
+	if !bytes.Equal(data, make([]byte, len(data))) {
+		t.Error("apply() modified its input")
+	}
+
+	n = newLineNoise(LineImpairment{GarbageRate: 1})
+	if out := n.apply([]byte("abc")); len(out) != 6 || out[0] != 'a' || out[2] != 'b' || out[4] != 'c' {
+		t.Errorf("apply() = %q, want a garbage byte after each byte", out)
+	}
+	if c := n.corrupted(); c != 3 {
+		t.Errorf("corrupted() = %d, want 3", c)
+	}
+	if newLineNoise(LineImpairment{}) != nil {
+		t.Error("newLineNoise() of a clean line is not nil")
+	}
+}
+
+// Test spontaneous carrier loss drops the call with NO CARRIER
+func TestModem_CarrierLoss(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithId("flaky"),
+		WithImpairment(LineImpairment{CarrierLoss: 50 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+	_, changes := modem.SubscribeSync()
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	// The remote sees the connection closed
+	if _, err := io.ReadAll(host); err != nil {
+		t.Errorf("Host read error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if modem.StatusSync() != StatusIdle {
+		t.Errorf("Status after carrier loss = %v, want %v", modem.StatusSync(), StatusIdle)
+	}
+	if got := tty.GetWrittenString(); !strings.HasSuffix(got, "NO CARRIER\r\n") {
+		t.Errorf("TTY output = %q, want NO CARRIER", got)
+	}
+	var last StateChange
+	for len(changes) > 0 {
+		last = <-changes
+	}
+	if last.To != StatusIdle || last.Cause != CauseCarrierLoss {
+		t.Errorf("Last change = %v (%v), want %v (%v)", last.To, last.Cause, StatusIdle, CauseCarrierLoss)
+	}
+}
+
+// Test the impairment changed at runtime applies to the next call
+func TestModem_SetImpairment(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty, WithId("tuned"))
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	imp := LineImpairment{Latency: time.Millisecond, CarrierLoss: 50 * time.Millisecond}
+	modem.SetImpairmentSync(imp)
+	if got := modem.ImpairmentSync(); got != imp {
+		t.Errorf("ImpairmentSync() = %+v, want %+v", got, imp)
+	}
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	// The carrier of the new call drops
+	if _, err := io.ReadAll(host); err != nil {
+		t.Errorf("Host read error = %v", err)
+	}
+}
+
+// Test a stalled connection holds back the TTY without blocking the modem
+func TestModem_StalledConnection(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	modem, err := NewModem(&ModemConfig{Id: "stalled", TTY: dce})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0Q1")
+
+	// The remote end of a pipe never reads until told to
+	remote, conn := net.Pipe()
+	defer remote.Close()
+	if err := modem.IncomingCallSync(conn); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	up := bytes.Repeat([]byte("x"), 4*pumpQueueLen*ttyReadSize)
+	written := make(chan error, 1)
+	go func() { _, err := dte.Write(up); written <- err }()
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-written:
+		t.Fatal("TTY input not held back by the stalled connection")
+	default:
+	}
+
+	status := make(chan ModemStatus, 1)
+	go func() { status <- modem.StatusSync() }()
+	select {
+	case st := <-status:
+		if st != StatusConnected {
+			t.Errorf("StatusSync() = %v, want %v", st, StatusConnected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("StatusSync() blocked by the stalled connection")
+	}
+
+	got := make([]byte, len(up))
+	if _, err := io.ReadFull(remote, got); err != nil || !bytes.Equal(got, up) {
+		t.Fatalf("Remote read error = %v, data intact = %v", err, bytes.Equal(got, up))
+	}
+	if err := <-written; err != nil {
+		t.Errorf("TTY write error = %v", err)
+	}
+}
+
+// Test a TTY that does not drain holds back remote data without blocking the modem
+func TestModem_StalledTTY(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	modem, err := NewModem(&ModemConfig{Id: "stalled", TTY: dce})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0Q1")
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	// The DTE does not read, so the write of the remote data blocks
+	host.Write([]byte("hello"))
+	time.Sleep(100 * time.Millisecond)
+	metrics := make(chan *Metrics, 1)
+	go func() { metrics <- modem.MetricsSync() }()
+	select {
+	case m := <-metrics:
+		if m.CallRxBytes != 5 {
+			t.Errorf("CallRxBytes = %d, want 5", m.CallRxBytes)
+		}
+	case <-time.After(time.Second):
+		go io.Copy(io.Discard, dte)
+		t.Fatal("MetricsSync() blocked by the stalled TTY")
+	}
+
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(dte, got); err != nil || string(got) != "hello" {
+		t.Fatalf("DTE read %q, %v, want %q", got, err, "hello")
+	}
+}
+
+// Test data rates, result code counts and expvar publication
+func TestModem_Instrumentation(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithId("instrumented"),
+		WithExpvar(),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	modem.ProcessAtCommandSync("E0")
+	tty.WriteInput([]byte("AT\rAT\r"))
+	time.Sleep(50 * time.Millisecond)
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	tty.WriteInput(make([]byte, 500))
+	host.Write(make([]byte, 10000))
+	io.ReadFull(host, make([]byte, 500))
+	time.Sleep(50 * time.Millisecond)
+
+	m := modem.MetricsSync()
+	if m.TxRate < 500/rateWindow || m.RxRate < 10000/rateWindow {
+		t.Errorf("TxRate, RxRate = %.0f, %.0f, want at least %d, %d", m.TxRate, m.RxRate, 500/rateWindow, 10000/rateWindow)
+	}
+	if m.RetCodes[RetCodeOk] != 2 || m.RetCodes[RetCodeConnect] != 1 {
+		t.Errorf("RetCodes = %v, want two OK and one CONNECT", m.RetCodes)
+	}
+	if m.LineQueueLen != 0 {
+		t.Errorf("LineQueueLen = %d, want 0", m.LineQueueLen)
+	}
+
+	vars := expvar.Get("vmodem").(*expvar.Map)
+	var published Metrics
+	if err := json.Unmarshal([]byte(vars.Get("instrumented").String()), &published); err != nil {
+		t.Fatalf("expvar metrics error = %v", err)
+	}
+	if published.ConnTxBytes != 500 || published.Status != StatusConnected {
+		t.Errorf("expvar ConnTxBytes, Status = %d, %v, want 500, %v", published.ConnTxBytes, published.Status, StatusConnected)
+	}
+
+	modem.CloseSync()
+	time.Sleep(50 * time.Millisecond)
+	if vars.Get("instrumented") != nil {
+		t.Error("Metrics still published after Close")
+	}
+}
+
+// Test the rate meter averages over its window and forgets old data
+func TestRateMeter(t *testing.T) {
+	var r rateMeter
+	t0 := time.Unix(1000, 0)
+	for i := range rateWindow {
+		r.add(1000, t0.Add(time.Duration(i)*time.Second))
+	}
+	if got := r.rate(t0.Add(rateWindow*time.Second - time.Nanosecond)); got < 999 || got > 1001 {
+		t.Errorf("rate() = %.1f, want 1000", got)
+	}
+	if got := r.rate(t0.Add(2 * rateWindow * time.Second)); got != 0 {
+		t.Errorf("rate() after the window = %.1f, want 0", got)
+	}
+}
+
+// closeCounter counts the Close calls of a connection
+type closeCounter struct {
+	io.ReadWriteCloser
+	closes atomic.Int32
+}
+
+func (c *closeCounter) Close() error {
+	c.closes.Add(1)
+	return c.ReadWriteCloser.Close()
+}
+
+// Test the remote hanging up is noticed while ringing and while connected
+func TestModem_RemoteHangup(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{Id: "hangup", TTY: tty})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0")
+
+	// The caller gives up before the call is answered
+	host, line := NewLine()
+	conn := &closeCounter{ReadWriteCloser: line}
+	if err := modem.IncomingCallSync(conn); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	host.Close()
+	time.Sleep(50 * time.Millisecond)
+	if modem.StatusSync() != StatusIdle {
+		t.Errorf("Status after the caller hung up = %v, want %v", modem.StatusSync(), StatusIdle)
+	}
+	if n := conn.closes.Load(); n != 1 {
+		t.Errorf("Connection closed %d times, want 1", n)
+	}
+
+	// Data sent before the answer is delivered after CONNECT, and the remote
+	// hanging up ends the call with NO CARRIER
+	host, line = NewLine()
+	conn = &closeCounter{ReadWriteCloser: line}
+	if err := modem.IncomingCallSync(conn); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	host.Write([]byte("early"))
+	time.Sleep(50 * time.Millisecond)
+	tty.ClearWrites()
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	if got := tty.GetWrittenString(); got != "\r\nCONNECT\r\nearly" {
+		t.Errorf("TTY output after answer = %q, want CONNECT then the early data", got)
+	}
+	host.Close()
+	time.Sleep(50 * time.Millisecond)
+	if modem.StatusSync() != StatusIdle {
+		t.Errorf("Status after remote hangup = %v, want %v", modem.StatusSync(), StatusIdle)
+	}
+	if got := tty.GetWrittenString(); !strings.HasSuffix(got, "NO CARRIER\r\n") {
+		t.Errorf("TTY output = %q, want NO CARRIER", got)
+	}
+	modem.HangupSync()
+	if n := conn.closes.Load(); n != 1 {
+		t.Errorf("Connection closed %d times, want 1", n)
+	}
+}
+
+// Test XON/XOFF flow control and binary transparency
+func TestModem_Transparency(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithId("transfer"),
+		WithSReg(12, 2),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.AddFilterSync(FilterToLine, FilterFunc(func(p []byte) []byte { return bytes.ToUpper(p) }))
+
+	connect := func(line string) io.ReadWriteCloser {
+		t.Helper()
+		if r := modem.ProcessAtCommandSync(line); r != RetCodeOk {
+			t.Fatalf("AT%s = %v", line, r)
+		}
+		host, conn := NewLine()
+		if err := modem.IncomingCallSync(conn); err != nil {
+			t.Fatalf("IncomingCallSync() error = %v", err)
+		}
+		if _, err := modem.AnswerSync(); err != nil {
+			t.Fatalf("AnswerSync() error = %v", err)
+		}
+		return host
+	}
+	read := func(host io.Reader, n int) string {
+		t.Helper()
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(host, buf); err != nil {
+			t.Fatalf("Host read error = %v", err)
+		}
+		return string(buf)
+	}
+
+	// XON/XOFF: the characters are not sent and pause the TTY output
+	host := connect("E0&K4\\N3")
+	if modem.TransparentSync() {
+		t.Error("TransparentSync() = true with &K4")
+	}
+	tty.WriteInput([]byte("ab\x13cd"))
+	if got := read(host, 4); got != "ABCD" {
+		t.Errorf("Host got %q, want %q", got, "ABCD")
+	}
+	tty.ClearWrites()
+	host.Write([]byte("paused"))
+	time.Sleep(50 * time.Millisecond)
+	if got := tty.GetWrittenString(); got != "" {
+		t.Errorf("TTY got %q while paused", got)
+	}
+	tty.WriteInput([]byte{xon})
+	time.Sleep(50 * time.Millisecond)
+	if got := tty.GetWrittenString(); got != "paused" {
+		t.Errorf("TTY got %q after XON, want %q", got, "paused")
+	}
+	modem.HangupSync()
+	host.Close()
+
+	// Direct mode: everything passes through unfiltered, even the escape sequence
+	host = connect("&K3\\N1")
+	if !modem.TransparentSync() {
+		t.Error("TransparentSync() = false with \\N1")
+	}
+	time.Sleep(150 * time.Millisecond)
+	tty.WriteInput([]byte("+++"))
+	if got := read(host, 3); got != "+++" {
+		t.Errorf("Host got %q, want %q", got, "+++")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if modem.StatusSync() != StatusConnected {
+		t.Errorf("Status after +++ = %v, want %v", modem.StatusSync(), StatusConnected)
+	}
+	tty.WriteInput([]byte("z\x13\x11"))
+	if got := read(host, 3); got != "z\x13\x11" {
+		t.Errorf("Host got %q, want the raw bytes", got)
+	}
+
+	// Forced off, the escape sequence works again
+	modem.SetTransparencySync(TransparencyOff)
+	time.Sleep(150 * time.Millisecond)
+	tty.WriteInput([]byte("+++"))
+	time.Sleep(250 * time.Millisecond)
+	if modem.StatusSync() != StatusConnectedCmd {
+		t.Errorf("Status after +++ = %v, want %v", modem.StatusSync(), StatusConnectedCmd)
+	}
+	modem.HangupSync()
+	host.Close()
+}
+
+// Test command mode output is paced and call data is not
+func TestModem_CharDelay(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithId("vintage"),
+		WithCharDelay(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0")
+
+	start := time.Now()
+	tty.WriteInput([]byte("AT\r"))
+	for tty.GetWrittenString() != "\r\nOK\r\n" && time.Since(start) < time.Second {
+		time.Sleep(time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("Result code took %v, want about 50ms", elapsed)
+	}
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	tty.ClearWrites()
+	host.Write(make([]byte, 1000))
+	time.Sleep(100 * time.Millisecond)
+	if n := len(tty.GetWrittenString()); n != 1000 {
+		t.Errorf("TTY got %d bytes of call data, want 1000 unpaced", n)
+	}
+}
+
+// writeRecorder records the writes to a connection
+type writeRecorder struct {
+	io.ReadWriteCloser
+	mu     sync.Mutex
+	writes []string
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.writes = append(w.writes, string(p))
+	w.mu.Unlock()
+	return w.ReadWriteCloser.Write(p)
+}
+
+func (w *writeRecorder) Writes() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.writes)
+}
+
+// Test TTY input is coalesced into connection writes of the configured size
+func TestModem_WriteCoalescing(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	modem, err := NewModem(&ModemConfig{
+		Id:              "coalescing",
+		TTY:             dce,
+		WriteChunkSize:  4,
+		WriteFlushDelay: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0Q1")
+
+	conn, _ := NewMockConnection()
+	rec := &writeRecorder{ReadWriteCloser: conn}
+	if err := modem.IncomingCallSync(rec); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	// Keystrokes within the flush delay are written together, in chunks of 4
+	for _, k := range []string{"ab", "cd", "e"} {
+		dte.Write([]byte(k))
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond)
+	want := []string{"abcd", "e"}
+	if got := rec.Writes(); !slices.Equal(got, want) {
+		t.Errorf("Connection writes = %q, want %q", got, want)
+	}
+}
+
+// captureBuffer is a buffer safe for concurrent use
+type captureBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *captureBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+// Test both directions of calls are captured, annotated and raw
+func TestModem_Capture(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	modem, err := NewModem(&ModemConfig{Id: "cap", TTY: dce})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0Q1")
+
+	for _, format := range []CaptureFormat{CaptureAnnotated, CaptureRaw} {
+		out := &captureBuffer{}
+		modem.SetCaptureSync(NewCapture(out, format))
+		host, line := NewLine()
+		if err := modem.IncomingCallInfoSync(line, CallInfo{Number: "5551212"}); err != nil {
+			t.Fatalf("IncomingCallInfoSync() error = %v", err)
+		}
+		if _, err := modem.AnswerSync(); err != nil {
+			t.Fatalf("AnswerSync() error = %v", err)
+		}
+		dte.Write([]byte("ping\r"))
+		if _, err := io.ReadFull(host, make([]byte, 5)); err != nil {
+			t.Fatalf("reading the call: %v", err)
+		}
+		host.Write([]byte("pong\xff"))
+		if _, err := io.ReadFull(dte, make([]byte, 5)); err != nil {
+			t.Fatalf("reading the TTY: %v", err)
+		}
+		modem.HangupSync()
+		host.Close()
+
+		var sent, received []byte
+		switch format {
+		case CaptureAnnotated:
+			text := string(out.Bytes())
+			for _, l := range strings.Split(strings.TrimSpace(text), "\n") {
+				fields := strings.SplitN(l, " ", 4)
+				if len(fields) != 4 || fields[1] != "cap" {
+					t.Fatalf("capture line %q", l)
+				}
+				if fields[2] == "#" {
+					continue
+				}
+				data, err := strconv.Unquote(fields[3])
+				if err != nil {
+					t.Fatalf("capture line %q: %v", l, err)
+				}
+				if fields[2] == ">" {
+					sent = append(sent, data...)
+				} else {
+					received = append(received, data...)
+				}
+			}
+			if !strings.Contains(text, ` # incoming call number="5551212"`) || !strings.Contains(text, " # call ended cause=") {
+				t.Errorf("capture %q does not note the call", text)
+			}
+		case CaptureRaw:
+			r := bytes.NewReader(out.Bytes())
+			for {
+				rec, err := ReadCaptureRecord(r)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("ReadCaptureRecord() error = %v", err)
+				}
+				if rec.Modem != "cap" || time.Since(rec.Time) > time.Minute {
+					t.Errorf("record = %+v", rec)
+				}
+				if rec.Dir == FilterToLine {
+					sent = append(sent, rec.Data...)
+				} else {
+					received = append(received, rec.Data...)
+				}
+			}
+		}
+		if string(sent) != "ping\r" || string(received) != "pong\xff" {
+			t.Errorf("%v capture sent %q, received %q", format, sent, received)
+		}
+	}
+}
+
+// Test a tee capture records to all its captures in their own formats
+func TestTeeCapture(t *testing.T) {
+	text, raw := &captureBuffer{}, &captureBuffer{}
+	c := TeeCapture(NewCapture(text, CaptureAnnotated), NewCapture(raw, CaptureRaw))
+	c.note("cap", "incoming call")
+	if err := c.Record(CaptureRecord{Time: time.Now(), Modem: "cap", Dir: FilterToTTY, Data: []byte("pong")}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if s := string(text.Bytes()); !strings.Contains(s, " cap # incoming call\n") || !strings.Contains(s, ` cap < "pong"`) {
+		t.Errorf("annotated capture = %q", s)
+	}
+	rec, err := ReadCaptureRecord(bytes.NewReader(raw.Bytes()))
+	if err != nil || string(rec.Data) != "pong" || rec.Dir != FilterToTTY {
+		t.Errorf("raw capture = %+v, %v", rec, err)
+	}
+	if err := c.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+// Test dropping DTR acts per AT&D
+func TestModem_DTR(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{Id: "dtr", TTY: tty})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+	connect := func() {
+		t.Helper()
+		host, line := NewLine()
+		t.Cleanup(func() { host.Close() })
+		if err := modem.IncomingCallSync(line); err != nil {
+			t.Fatalf("IncomingCallSync() error = %v", err)
+		}
+		if _, err := modem.AnswerSync(); err != nil {
+			t.Fatalf("AnswerSync() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		cmd  string
+		want ModemStatus
+	}{
+		{"", StatusIdle},
+		{"&D0", StatusConnected},
+		{"&D1", StatusConnectedCmd},
+		{"&D2", StatusIdle},
+		{"&D3S0=5", StatusIdle},
+	}
+	for _, tt := range tests {
+		if tt.cmd != "" {
+			if r := modem.ProcessAtCommandSync(tt.cmd); r != RetCodeOk {
+				t.Fatalf("AT%s = %v", tt.cmd, r)
+			}
+		}
+		connect()
+		modem.SetDTRSync(false)
+		if st := modem.StatusSync(); st != tt.want {
+			t.Errorf("AT%s: status after dropping DTR = %v, want %v", tt.cmd, st, tt.want)
+		}
+		modem.SetDTRSync(true)
+		modem.HangupSync()
+	}
+	// &D3 reset the modem
+	modem.Lock()
+	dtrCtl, s0 := modem.dtrCtl, modem.sregs[0]
+	modem.Unlock()
+	if dtrCtl != dtrHangup || s0 != 0 {
+		t.Errorf("after &D3 drop &D = %d, S0 = %d, want defaults", dtrCtl, s0)
+	}
+	if r := modem.ProcessAtCommandSync("&D4"); r != RetCodeError {
+		t.Errorf("AT&D4 = %v, want ERROR", r)
+	}
+}
@@ -1,7 +1,13 @@
 package vmodem
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"log/slog"
+	"math/bits"
+	"net"
 	"strings"
 	"sync"
 	"testing"
@@ -88,6 +94,19 @@ func (m *MockReadWriteCloser) GetWrittenString() string {
 	return string(m.writes)
 }
 
+// WaitWritten waits up to a second for the data written to end with suffix,
+// and returns it.
+func (m *MockReadWriteCloser) WaitWritten(suffix string) string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		s := m.GetWrittenString()
+		if strings.HasSuffix(s, suffix) || time.Now().After(deadline) {
+			return s
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
 func (m *MockReadWriteCloser) IsClosed() bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -256,6 +275,148 @@ func TestModem_StateTransitions(t *testing.T) {
 	}
 }
 
+// Test closing the modem stops its tasks while a TTY read is pending
+func TestModem_WaitAfterClose(t *testing.T) {
+	// The mock TTY never returns from Read once closed
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{Id: "test-modem", TTY: tty})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	modem.CloseSync()
+
+	done := make(chan struct{})
+	go func() {
+		modem.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait() blocked by the pending TTY read")
+	}
+}
+
+// Test the status is read without waiting for the modem lock
+func TestModem_StatusLockFree(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{Id: "test-modem", TTY: tty})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	modem.Lock()
+	modem.SetStatus(StatusDialing)
+	status := make(chan ModemStatus, 1)
+	go func() { status <- modem.StatusSync() }()
+	select {
+	case st := <-status:
+		if st != StatusDialing {
+			t.Errorf("StatusSync() = %v, want %v", st, StatusDialing)
+		}
+	case <-time.After(time.Second):
+		t.Error("StatusSync() blocked by the modem lock")
+	}
+	modem.SetStatus(StatusIdle)
+	modem.Unlock()
+}
+
+// Test a status read without the lock may precede the side effects of its
+// transition, which are done once the lock is acquired
+func TestModem_StatusOrdering(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	var inCallback ModemStatus
+	var writtenInCallback string
+	modem, err := NewModem(&ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+		StatusTransition: func(m *Modem, from, to ModemStatus) {
+			if to == StatusConnected {
+				inCallback, writtenInCallback = m.Status(), tty.GetWrittenString()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	connected := make(chan string, 1)
+	go func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for modem.Status() != StatusConnected && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		modem.Lock()
+		connected <- tty.GetWrittenString()
+		modem.Unlock()
+	}()
+	conn, _ := NewLine()
+	if err := modem.IncomingCallSync(conn); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+
+	// The callback runs with the new status, after the result code
+	if inCallback != StatusConnected || !strings.Contains(writtenInCallback, "CONNECT") {
+		t.Errorf("callback saw status %v and output %q, want %v after CONNECT", inCallback, writtenInCallback, StatusConnected)
+	}
+	// A poller seeing Connected gets the lock once CONNECT is written
+	if got := <-connected; !strings.Contains(got, "CONNECT") {
+		t.Errorf("output after locking on Connected = %q, want CONNECT", got)
+	}
+}
+
+// Test invalid state transitions are rejected without panicking
+func TestModem_InvalidStateTransitions(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	config := &ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	tests := []struct {
+		name   string
+		status ModemStatus
+	}{
+		{"Idle to ConnectedCmd", StatusConnectedCmd},
+		{"Idle to Connected without connection", StatusConnected},
+		{"Idle to Ringing without connection", StatusRinging},
+		{"Idle to unknown status", ModemStatus(99)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := modem.SetStatusSync(tt.status); err != ErrInvalidStateTransition {
+				t.Errorf("SetStatusSync(%v) error = %v, want %v", tt.status, err, ErrInvalidStateTransition)
+			}
+			if modem.StatusSync() != StatusIdle {
+				t.Errorf("Expected StatusIdle after rejected transition, got %v", modem.StatusSync())
+			}
+		})
+	}
+
+	if err := modem.IncomingCallSync(nil); err != ErrInvalidStateTransition {
+		t.Errorf("IncomingCallSync(nil) error = %v, want %v", err, ErrInvalidStateTransition)
+	}
+
+	// Closed is terminal
+	modem.CloseSync()
+	if err := modem.SetStatusSync(StatusIdle); err != ErrInvalidStateTransition {
+		t.Errorf("SetStatusSync() on closed modem error = %v, want %v", err, ErrInvalidStateTransition)
+	}
+}
+
 // Test TTY operations
 func TestModem_TtyOperations(t *testing.T) {
 	tty := NewMockReadWriteCloser([]byte{})
@@ -684,3 +845,1518 @@ func TestModem_LineHook(t *testing.T) {
 		})
 	}
 }
+
+// Test command line length limit through TTY
+func TestModem_CommandLineOverflow(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	config := &ModemConfig{
+		Id:        "test-modem",
+		TTY:       tty,
+		MaxCmdLen: 10, // Raised to the V.250 minimum of 40
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	// Wait for ttyReadTask to start
+	time.Sleep(10 * time.Millisecond)
+
+	// A line at the limit is accepted
+	tty.WriteInput([]byte("AT" + strings.Repeat("E1", 20) + "\r"))
+	time.Sleep(50 * time.Millisecond)
+
+	response := tty.GetWrittenString()
+	if !strings.Contains(response, "OK") {
+		t.Errorf("Expected OK response for line at the limit, got %q", response)
+	}
+
+	// A line over the limit is rejected
+	tty.ClearWrites()
+	tty.WriteInput([]byte("AT" + strings.Repeat("E1", 21) + "\r"))
+	time.Sleep(50 * time.Millisecond)
+
+	response = tty.GetWrittenString()
+	if !strings.Contains(response, "ERROR") {
+		t.Errorf("Expected ERROR response for overlong line, got %q", response)
+	}
+}
+
+// Test parity tolerant command parsing through TTY
+func TestModem_CommandParity(t *testing.T) {
+	withParity := func(s string, odd int) string {
+		b := []byte(s)
+		for i := range b {
+			if bits.OnesCount8(b[i])%2 != odd {
+				b[i] |= 0x80
+			}
+		}
+		return string(b)
+	}
+	evenParity := func(s string) string { return withParity(s, 0) }
+	oddParity := func(s string) string { return withParity(s, 1) }
+
+	tests := []struct {
+		name     string
+		parity   Parity
+		input    string
+		expected string
+	}{
+		{"Strip 7E1", ParityStrip, evenParity("ATE1\r"), "ATE1\r\r\nOK\r\n"},
+		{"Even 7E1", ParityEven, evenParity("ATE1\r"), evenParity("ATE1\r\r\nOK\r\n")},
+		{"Odd 7O1", ParityOdd, oddParity("ATE1\r"), oddParity("ATE1\r\r\nOK\r\n")},
+		{"Even 7O1", ParityEven, oddParity("ATE1\r"), ""},
+		{"None 7E1", ParityNone, evenParity("ATE1\r"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tty := NewMockReadWriteCloser([]byte{})
+			config := &ModemConfig{
+				Id:            "test-modem",
+				TTY:           tty,
+				CommandParity: tt.parity,
+			}
+
+			modem, err := NewModem(config)
+			if err != nil {
+				t.Fatalf("NewModem() error = %v", err)
+			}
+			defer modem.CloseSync()
+
+			tty.WriteInput([]byte(tt.input))
+			time.Sleep(50 * time.Millisecond)
+
+			// The echo and the result are framed with the parity of the DTE
+			response := tty.GetWrittenString()
+			if tt.expected == "" {
+				if strings.Contains(response, "OK") || strings.Contains(response, evenParity("OK")) {
+					t.Errorf("Expected no OK response, got %q", response)
+				}
+			} else if !strings.Contains(response, tt.expected) {
+				t.Errorf("Expected response to contain %q, got %q", tt.expected, response)
+			}
+		})
+	}
+}
+
+// Test per-call duration and throughput calculations
+func TestMetrics_CallThroughput(t *testing.T) {
+	start := time.Now()
+	metrics := &Metrics{
+		CallStartTime: start,
+		CallEndTime:   start.Add(10 * time.Second),
+		CallTxBytes:   8000,
+		CallRxBytes:   4000,
+		CallStallTime: 2 * time.Second,
+	}
+
+	if d := metrics.CallDuration(); d != 10*time.Second {
+		t.Errorf("CallDuration() = %v, want %v", d, 10*time.Second)
+	}
+
+	txBps, rxBps := metrics.CallThroughput()
+	if txBps != 1000 || rxBps != 500 {
+		t.Errorf("CallThroughput() = %v, %v, want 1000, 500", txBps, rxBps)
+	}
+
+	metrics.CallCorruptBytes, metrics.CallDroppedBytes = 30, 12
+	if n := metrics.CallRetransmitBytes(); n != 42 {
+		t.Errorf("CallRetransmitBytes() = %d, want 42", n)
+	}
+
+	empty := &Metrics{}
+	if d := empty.CallDuration(); d != 0 {
+		t.Errorf("CallDuration() without calls = %v, want 0", d)
+	}
+	if txBps, rxBps := empty.CallThroughput(); txBps != 0 || rxBps != 0 {
+		t.Errorf("CallThroughput() without calls = %v, %v, want 0, 0", txBps, rxBps)
+	}
+}
+
+// Test unanswered incoming calls are abandoned after the ring timeout
+func TestModem_RingTimeout(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	missed := make(chan int, 1)
+	config := &ModemConfig{
+		Id:          "test-modem",
+		TTY:         tty,
+		RingTimeout: 100 * time.Millisecond,
+		MissedCall: func(m *Modem, rings int) {
+			missed <- rings
+		},
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	conn := NewMockReadWriteCloser([]byte{})
+	if err := modem.IncomingCallSync(conn); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+
+	select {
+	case rings := <-missed:
+		if rings != 1 {
+			t.Errorf("Missed call rings = %d, want 1", rings)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Missed call callback not called")
+	}
+
+	if modem.StatusSync() != StatusIdle {
+		t.Errorf("Expected modem to be idle after ring timeout, got %v", modem.StatusSync())
+	}
+
+	if !conn.IsClosed() {
+		t.Error("Expected incoming connection to be closed after ring timeout")
+	}
+}
+
+// Test localized verbose result codes
+func TestModem_Locale(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	config := &ModemConfig{
+		Id:     "test-modem",
+		TTY:    tty,
+		Locale: LocaleFrench,
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	tests := []struct {
+		name     string
+		locale   ResultLocale
+		command  string
+		expected string
+	}{
+		{"French error", LocaleFrench, "ATE5\r", "ERREUR"},
+		{"Partial locale falls back", ResultLocale{RetCodeOk: "BIEN"}, "ATE5\r", "ERROR"},
+		{"Partial locale", ResultLocale{RetCodeOk: "BIEN"}, "ATE1\r", "BIEN"},
+		{"Default locale", nil, "ATE5\r", "ERROR"},
+		{"Numeric codes unaffected", LocaleFrench, "ATV0\r", "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modem.SetLocaleSync(tt.locale)
+			tty.ClearWrites()
+			tty.WriteInput([]byte(tt.command))
+			time.Sleep(50 * time.Millisecond)
+
+			response := tty.GetWrittenString()
+			if !strings.Contains(response, tt.expected) {
+				t.Errorf("Expected response to contain %q, got %q", tt.expected, response)
+			}
+		})
+	}
+}
+
+// Test busy indication for incoming calls rejected by a busy modem
+func TestModem_IncomingCallBusy(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	var rejected io.ReadWriteCloser
+	config := &ModemConfig{
+		Id:      "test-modem",
+		TTY:     tty,
+		BusyStr: "BUSY\r\n",
+		BusyCall: func(m *Modem, conn io.ReadWriteCloser) {
+			rejected = conn
+		},
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	first := NewMockReadWriteCloser([]byte{})
+	if err := modem.IncomingCallSync(first); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+
+	second := NewMockReadWriteCloser([]byte{})
+	if err := modem.IncomingCallSync(second); err != ErrModemBusy {
+		t.Errorf("IncomingCallSync() error = %v, want %v", err, ErrModemBusy)
+	}
+
+	if written := second.GetWrittenString(); written != "BUSY\r\n" {
+		t.Errorf("Rejected connection received %q, want %q", written, "BUSY\r\n")
+	}
+
+	if rejected != second {
+		t.Error("Busy callback should receive the rejected connection")
+	}
+
+	if second.IsClosed() {
+		t.Error("Rejected connection should be left open for the caller")
+	}
+}
+
+// Test observers receive a copy of the TTY traffic
+func TestModem_Observers(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	config := &ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	output := NewMockReadWriteCloser([]byte{})
+	both := NewMockReadWriteCloser([]byte{})
+	modem.AddObserverSync(output, false)
+	id := modem.AddObserverSync(both, true)
+
+	tty.WriteInput([]byte("ATE0\r"))
+	time.Sleep(50 * time.Millisecond)
+
+	if observed := output.GetWrittenString(); observed != tty.GetWrittenString() {
+		t.Errorf("Output observer got %q, want %q", observed, tty.GetWrittenString())
+	}
+
+	// Input and output are interleaved as they happen, with echo on
+	if observed := both.GetWrittenString(); !strings.HasPrefix(observed, "AATTEE00\r\r") || !strings.Contains(observed, "OK") {
+		t.Errorf("Input observer got %q", observed)
+	}
+
+	// Removed observers stop receiving data
+	modem.RemoveObserverSync(id)
+	both.ClearWrites()
+	tty.WriteInput([]byte("ATE0\r"))
+	time.Sleep(50 * time.Millisecond)
+
+	if observed := both.GetWrittenString(); observed != "" {
+		t.Errorf("Removed observer got %q, want nothing", observed)
+	}
+}
+
+// Test two terminals sharing one modem
+func TestModem_SharedTTY(t *testing.T) {
+	tests := []struct {
+		name        string
+		arbitration InputArbitration
+		expected    string
+	}{
+		{"Merge accepts both terminals", ArbitrationMerge, "ERROR"},
+		{"Operator preempts primary", ArbitrationOperator, ""},
+		{"Exclusive keeps first terminal", ArbitrationExclusive, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := NewMockReadWriteCloser([]byte{})
+			secondary := NewMockReadWriteCloser([]byte{})
+			config := &ModemConfig{
+				Id:          "test-modem",
+				TTY:         primary,
+				SharedTTY:   secondary,
+				SharedInput: tt.arbitration,
+			}
+
+			modem, err := NewModem(config)
+			if err != nil {
+				t.Fatalf("NewModem() error = %v", err)
+			}
+			defer modem.CloseSync()
+
+			// Secondary terminal types a command, both see the answer
+			secondary.WriteInput([]byte("ATE0\r"))
+			time.Sleep(50 * time.Millisecond)
+
+			for name, tty := range map[string]*MockReadWriteCloser{"primary": primary, "secondary": secondary} {
+				if response := tty.GetWrittenString(); !strings.Contains(response, "OK") {
+					t.Errorf("Expected OK on %s terminal, got %q", name, response)
+				}
+			}
+
+			// Primary terminal types right after the secondary one
+			primary.ClearWrites()
+			primary.WriteInput([]byte("ATE5\r"))
+			time.Sleep(50 * time.Millisecond)
+
+			response := primary.GetWrittenString()
+			if tt.expected == "" {
+				if response != "" {
+					t.Errorf("Expected primary input to be discarded, got %q", response)
+				}
+			} else if !strings.Contains(response, tt.expected) {
+				t.Errorf("Expected response to contain %q, got %q", tt.expected, response)
+			}
+		})
+	}
+}
+
+// Test diagnostics are routed through the configured logger
+func TestModem_Logger(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	logOutput := NewMockReadWriteCloser([]byte{})
+	config := &ModemConfig{
+		Id:     "test-modem",
+		TTY:    tty,
+		Logger: slog.New(slog.NewTextHandler(logOutput, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	modem.SetStatusSync(StatusDialing)
+	modem.SetStatusSync(StatusIdle)
+
+	logs := logOutput.GetWrittenString()
+	if !strings.Contains(logs, "status transition") || !strings.Contains(logs, "modem=test-modem") {
+		t.Errorf("Expected status transition log tagged with the modem id, got %q", logs)
+	}
+	if !strings.Contains(logs, "from=Dialing to=Idle") {
+		t.Errorf("Expected transition details in log, got %q", logs)
+	}
+}
+
+// Test state change subscriptions
+func TestModem_Subscribe(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	config := &ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+
+	id, changes := modem.SubscribeSync()
+	_, other := modem.SubscribeSync()
+
+	conn := NewMockReadWriteCloser([]byte{})
+	modem.IncomingCallSync(conn)
+	modem.ProcessAtCommandSync("A")
+	modem.SetStatusSync(StatusIdle)
+
+	expected := []StateChange{
+		{From: StatusIdle, To: StatusRinging, Cause: CauseRemote},
+		{From: StatusRinging, To: StatusConnected, Cause: CauseCommand},
+		{From: StatusConnected, To: StatusIdle, Cause: CauseAPI},
+	}
+	for _, want := range expected {
+		select {
+		case got := <-changes:
+			if got.From != want.From || got.To != want.To || got.Cause != want.Cause {
+				t.Errorf("State change = %v -> %v (%v), want %v -> %v (%v)", got.From, got.To, got.Cause, want.From, want.To, want.Cause)
+			}
+			if got.Time.IsZero() {
+				t.Error("Expected state change to have a timestamp")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("State change %v -> %v not delivered", want.From, want.To)
+		}
+	}
+
+	modem.UnsubscribeSync(id)
+	if _, ok := <-changes; ok {
+		t.Error("Expected channel to be closed after Unsubscribe")
+	}
+
+	modem.CloseSync()
+	var last StateChange
+	for change := range other {
+		last = change
+	}
+	if last.To != StatusClosed {
+		t.Errorf("Expected last state change to be Closed, got %v", last.To)
+	}
+
+	_, late := modem.SubscribeSync()
+	if _, ok := <-late; ok {
+		t.Error("Expected subscription to a closed modem to be closed")
+	}
+}
+
+// Test S-register accessors and change notifications
+func TestModem_SRegAccessors(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	var mu sync.Mutex
+	var changes []string
+	config := &ModemConfig{
+		Id:        "test-modem",
+		TTY:       tty,
+		GuardTime: 20,
+		SRegChange: func(m *Modem, reg byte, prev byte, value byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, fmt.Sprintf("S%d:%d->%d", reg, prev, value))
+		},
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	if v := modem.SRegSync(12); v != 20 {
+		t.Errorf("SRegSync(12) = %d, want 20", v)
+	}
+
+	// Changes made by the host are not reported
+	modem.SetSRegSync(7, 30)
+	if v := modem.SRegSync(7); v != 30 {
+		t.Errorf("SRegSync(7) = %d, want 30", v)
+	}
+
+	modem.ProcessAtCommandSync("S0=2")
+	modem.ProcessAtCommandSync("S7=30") // Unchanged value
+	modem.ProcessAtCommandSync("Z")
+
+	if v := modem.SRegSync(0); v != 0 {
+		t.Errorf("SRegSync(0) after ATZ = %d, want 0", v)
+	}
+	if v := modem.SRegSync(7); v != 50 {
+		t.Errorf("SRegSync(7) after ATZ = %d, want 50", v)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// ATZ restores the default of every register
+	expected := []string{"S0:0->2", "S0:2->0", "S7:30->50"}
+	if strings.Join(changes, " ") != strings.Join(expected, " ") {
+		t.Errorf("S-register changes = %v, want %v", changes, expected)
+	}
+}
+
+// Test chained command hooks
+func TestModem_CommandHookChain(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	var calls []string
+	config := &ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+		CommandHook: func(m *Modem, cmd Command) RetCode {
+			calls = append(calls, "config")
+			if cmd.Name == "I" {
+				return RetCodeOk
+			}
+			return RetCodeSkip
+		},
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	first := modem.RegisterCommandHookSync(func(m *Modem, cmd Command) RetCode {
+		calls = append(calls, "first")
+		if cmd.Name == "X" {
+			return RetCodeError
+		}
+		return RetCodeSkip
+	})
+	modem.RegisterCommandHookSync(func(m *Modem, cmd Command) RetCode {
+		calls = append(calls, "second")
+		if cmd.Name == "X" {
+			return RetCodeOk
+		}
+		return RetCodeSkip
+	})
+
+	tests := []struct {
+		command  string
+		expected RetCode
+		calls    string
+	}{
+		{"I", RetCodeOk, "config"},
+		{"X", RetCodeError, "config first"},
+		{"E1", RetCodeOk, "config first second"},
+	}
+
+	for _, tt := range tests {
+		calls = nil
+		if r := modem.ProcessAtCommandSync(tt.command); r != tt.expected {
+			t.Errorf("ProcessAtCommandSync(%q) = %v, want %v", tt.command, r, tt.expected)
+		}
+		if strings.Join(calls, " ") != tt.calls {
+			t.Errorf("ProcessAtCommandSync(%q) called hooks %v, want %q", tt.command, calls, tt.calls)
+		}
+	}
+
+	// Once removed, the next hook in the chain handles the command
+	modem.UnregisterCommandHookSync(first)
+	calls = nil
+	if r := modem.ProcessAtCommandSync("X"); r != RetCodeOk {
+		t.Errorf("ProcessAtCommandSync(\"X\") after unregister = %v, want %v", r, RetCodeOk)
+	}
+	if strings.Join(calls, " ") != "config second" {
+		t.Errorf("Hooks called after unregister = %v", calls)
+	}
+}
+
+// Test replacing hooks and defaults at runtime
+func TestModem_RuntimeReconfiguration(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	config := &ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+		CommandHook: func(m *Modem, cmd Command) RetCode {
+			if cmd.Name == "I" {
+				return RetCodeError
+			}
+			return RetCodeSkip
+		},
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	modem.RegisterCommandHookSync(func(m *Modem, cmd Command) RetCode {
+		if cmd.Name == "I" {
+			return RetCodeConnect
+		}
+		return RetCodeSkip
+	})
+
+	// The replacement still runs before registered hooks
+	modem.SetCommandHookSync(func(m *Modem, cmd Command) RetCode {
+		if cmd.Name == "I" {
+			return RetCodeOk
+		}
+		return RetCodeSkip
+	})
+	if r := modem.ProcessAtCommandSync("I"); r != RetCodeOk {
+		t.Errorf("ATI with replaced hook = %v, want %v", r, RetCodeOk)
+	}
+	modem.SetCommandHookSync(nil)
+	if r := modem.ProcessAtCommandSync("I"); r != RetCodeConnect {
+		t.Errorf("ATI with removed hook = %v, want %v", r, RetCodeConnect)
+	}
+
+	// Dialing without a hook fails, and works once a hook is set
+	if r := modem.ProcessAtCommandSync("D1"); r != RetCodeNoCarrier {
+		t.Errorf("ATD without hook = %v, want %v", r, RetCodeNoCarrier)
+	}
+	dialed := make(chan string, 1)
+	modem.SetOutgoingCallSync(func(m *Modem, number string) (io.ReadWriteCloser, error) {
+		dialed <- number
+		return nil, ErrNoCarrier
+	})
+	modem.ProcessAtCommandSync("D1")
+	select {
+	case <-dialed:
+	case <-time.After(time.Second):
+		t.Error("Outgoing call hook set at runtime not called")
+	}
+
+	// Echo, verbose and quiet defaults survive ATZ
+	modem.SetEchoSync(false)
+	modem.SetVerboseSync(false)
+	modem.SetQuietSync(true)
+	modem.ProcessAtCommandSync("E1V1Q0")
+	modem.ProcessAtCommandSync("Z")
+	tty.ClearWrites()
+	// Neither echoed nor answered, then answered in numeric form once Q0
+	tty.WriteInput([]byte("AT\r"))
+	tty.WriteInput([]byte("ATQ0\r"))
+	if got := tty.WaitWritten("\r0\r"); got != "\r0\r" {
+		t.Errorf("After ATZ AT, ATQ0 wrote %q, want only %q", got, "\r0\r")
+	}
+	modem.SetQuietSync(false)
+
+	modem.SetConnectStrSync("CONNECT 9600")
+	modem.SetVerboseSync(true)
+	conn, _ := NewLine()
+	if err := modem.IncomingCallSync(conn); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	if got := tty.WaitWritten("CONNECT 9600\r\n"); !strings.HasSuffix(got, "CONNECT 9600\r\n") {
+		t.Errorf("Expected custom connect string, got %q", got)
+	}
+}
+
+// Test the lock-free controller front end
+func TestController(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	c := NewController(modem)
+
+	if r := c.ProcessAtCommand("S0=2"); r != RetCodeOk {
+		t.Errorf("ProcessAtCommand() = %v, want %v", r, RetCodeOk)
+	}
+	if v := c.SReg(0); v != 2 {
+		t.Errorf("SReg(0) = %d, want 2", v)
+	}
+
+	// Concurrent callers are serialized without touching the mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Do(func(m *Modem) { m.SetSReg(30, m.SReg(30)+1) })
+		}()
+	}
+	wg.Wait()
+	if v := c.SReg(30); v != 10 {
+		t.Errorf("SReg(30) = %d, want 10", v)
+	}
+
+	conn := NewMockReadWriteCloser([]byte{})
+	if err := c.IncomingCall(conn, CallInfo{Number: "123"}); err != nil {
+		t.Fatalf("IncomingCall() error = %v", err)
+	}
+	if _, err := c.Answer(); err != nil {
+		t.Errorf("Answer() error = %v", err)
+	}
+	if c.Status() != StatusConnected {
+		t.Errorf("Status() = %v, want %v", c.Status(), StatusConnected)
+	}
+	if err := c.Hangup(); err != nil {
+		t.Errorf("Hangup() error = %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if modem.StatusSync() != StatusClosed {
+		t.Errorf("Expected modem to be closed, got %v", modem.StatusSync())
+	}
+	if c.Status() != StatusClosed {
+		t.Errorf("Status() after Close = %v, want %v", c.Status(), StatusClosed)
+	}
+	if _, err := c.Dial("1"); err != ErrControllerStopped {
+		t.Errorf("Dial() after Close error = %v, want %v", err, ErrControllerStopped)
+	}
+}
+
+// Test the in-memory line and its use as a call connection
+func TestNewLine(t *testing.T) {
+	host, line := NewLine()
+
+	// Writes are buffered without a reader
+	if n, err := host.Write([]byte("hello")); n != 5 || err != nil {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+	buff := make([]byte, 16)
+	if n, err := line.Read(buff); string(buff[:n]) != "hello" || err != nil {
+		t.Errorf("Read() = %q, %v, want %q", buff[:n], err, "hello")
+	}
+
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	modem.AnswerSync()
+
+	host.Write([]byte("from host"))
+	tty.WriteInput([]byte("from dte"))
+	time.Sleep(50 * time.Millisecond)
+
+	if !strings.Contains(tty.GetWrittenString(), "from host") {
+		t.Errorf("Expected host data on the TTY, got %q", tty.GetWrittenString())
+	}
+	n, _ := host.Read(buff)
+	if string(buff[:n]) != "from dte"[:n] {
+		t.Errorf("Host read %q, want DTE data", buff[:n])
+	}
+
+	// Hanging up closes the line
+	modem.HangupSync()
+	if _, err := host.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("Write() after hangup error = %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+// Test data wrapping around the ring buffer of an in-memory line
+func TestNewLine_Wrap(t *testing.T) {
+	host, line := NewLine()
+	data := make([]byte, 3*lineBufferSize+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	go func() {
+		for p := data; len(p) > 0; p = p[min(len(p), 7777):] {
+			host.Write(p[:min(len(p), 7777)])
+		}
+		host.Close()
+	}()
+	got, err := io.ReadAll(line)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("ReadAll() = %d bytes, %v, want %d bytes of written data", len(got), err, len(data))
+	}
+}
+
+// Test custom result code texts
+func TestModem_ResultText(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	config := &ModemConfig{
+		Id:     "test-modem",
+		TTY:    tty,
+		Locale: LocaleFrench,
+		ResultText: ResultLocale{
+			RetCodeConnect: "CARRIER 2400\nPROTOCOL: LAPM\nCONNECT 2400",
+			RetCodeBusy:    "LINE BUSY",
+		},
+		NumericCodes: map[RetCode]string{
+			RetCodeConnect: "10",
+		},
+		OutgoingCall: func(m *Modem, number string) (io.ReadWriteCloser, error) {
+			return nil, ErrRemoteBusy
+		},
+	}
+
+	modem, err := NewModem(config)
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+	modem.ProcessAtCommandSync("E0")
+
+	// The result of each command typed on the TTY, or of answering a call
+	tests := []struct {
+		name     string
+		cmd      string
+		expected string
+	}{
+		{"multi-line connect", "V1", "\r\nCARRIER 2400\r\n\r\nPROTOCOL: LAPM\r\n\r\nCONNECT 2400\r\n"},
+		{"overridden text", "ATV1D1\r", "\r\nLINE BUSY\r\n"},
+		{"locale fallback", "ATV1Q5\r", "\r\nERREUR\r\n"},
+		{"overridden numeric", "V0", "\r10\r"},
+		{"default numeric", "ATV0D1\r", "\r7\r"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.HasPrefix(tt.cmd, "AT") {
+				tty.ClearWrites()
+				tty.WriteInput([]byte(tt.cmd))
+			} else {
+				modem.ProcessAtCommandSync(tt.cmd)
+				tty.ClearWrites()
+				conn, _ := NewLine()
+				if err := modem.IncomingCallSync(conn); err != nil {
+					t.Fatalf("IncomingCallSync() error = %v", err)
+				}
+				if _, err := modem.AnswerSync(); err != nil {
+					t.Fatalf("AnswerSync() error = %v", err)
+				}
+				defer modem.HangupSync()
+			}
+			if got := tty.WaitWritten(tt.expected); got != tt.expected {
+				t.Errorf("%s wrote %q, want %q", strings.TrimSpace(tt.cmd), got, tt.expected)
+			}
+		})
+	}
+}
+
+// Test information text framing
+func TestModem_WriteInfoText(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	tests := []struct {
+		name     string
+		setup    string
+		lines    []string
+		expected string
+	}{
+		{"verbose", "V1", []string{"VModem"}, "\r\nVModem\r\n"},
+		{"verbose multi-line", "V1", []string{"A", "B"}, "\r\nA\r\nB\r\n"},
+		{"numeric", "V0", []string{"VModem"}, "VModem\r\n"},
+		{"quiet", "V1Q1", []string{"VModem"}, "\r\nVModem\r\n"},
+		{"custom S3/S4", "V1Q0S3=10S4=13", []string{"VModem"}, "\n\rVModem\n\r"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modem.ProcessAtCommandSync(tt.setup)
+			tty.ClearWrites()
+			modem.WriteInfoTextSync(tt.lines...)
+			if got := tty.GetWrittenString(); got != tt.expected {
+				t.Errorf("WriteInfoTextSync(%q) wrote %q, want %q", tt.lines, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewModemWithOptions(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	modem, err := NewModemWithOptions(ctx, tty,
+		WithId("opt-modem"),
+		WithEcho(false),
+		WithQuiet(true),
+		WithVerbose(false),
+		WithSReg(0, 1),
+		WithConnectSpeed(33600),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+
+	if id := modem.Id(); id != "opt-modem" {
+		t.Errorf("Id() = %q, want %q", id, "opt-modem")
+	}
+	if v := modem.SRegSync(0); v != 1 {
+		t.Errorf("S0 = %d, want 1", v)
+	}
+	// Without echo, AT is not answered in quiet mode, and ATQ0 in numeric form
+	checkQuiet := func(when string) {
+		t.Helper()
+		tty.ClearWrites()
+		tty.WriteInput([]byte("AT\r"))
+		tty.WriteInput([]byte("ATQ0\r"))
+		if got := tty.WaitWritten("\r0\r"); got != "\r0\r" {
+			t.Errorf("%s AT, ATQ0 wrote %q, want only %q", when, got, "\r0\r")
+		}
+	}
+	checkQuiet("at start")
+
+	modem.ProcessAtCommandSync("V1")
+	conn, _ := NewLine()
+	if err := modem.IncomingCallSync(conn); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	if got := tty.WaitWritten("CONNECT 33600\r\n"); !strings.HasSuffix(got, "CONNECT 33600\r\n") {
+		t.Errorf("answer wrote %q, want CONNECT 33600", got)
+	}
+	modem.HangupSync()
+
+	// Options become the defaults restored by ATZ
+	modem.ProcessAtCommandSync("E1Q0")
+	modem.ProcessAtCommandSync("Z")
+	checkQuiet("after ATZ")
+
+	// Cancelling the context closes the modem
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if modem.StatusSync() != StatusClosed {
+		t.Errorf("status after cancel = %v, want %v", modem.StatusSync(), StatusClosed)
+	}
+
+	if _, err := NewModemWithOptions(context.Background(), nil); err != ErrConfigRequired {
+		t.Errorf("NewModemWithOptions(nil) error = %v, want %v", err, ErrConfigRequired)
+	}
+}
+
+// Test default S-register values and their configuration
+func TestModem_DefaultSRegs(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{
+		Id:    "test-modem",
+		TTY:   tty,
+		SRegs: map[byte]byte{0: 1, 8: 4},
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	expected := DefaultSRegs()
+	expected[0] = 1
+	expected[8] = 4
+	for reg, want := range expected {
+		if v := modem.SRegSync(reg); v != want {
+			t.Errorf("S%d = %d, want %d", reg, v, want)
+		}
+	}
+
+	modem.ProcessAtCommandSync("S0=0S8=9S40=7")
+	modem.ProcessAtCommandSync("&F")
+	for reg, want := range map[byte]byte{0: 1, 8: 4, 12: 20, 40: 0} {
+		if v := modem.SRegSync(reg); v != want {
+			t.Errorf("S%d after AT&F = %d, want %d", reg, v, want)
+		}
+	}
+
+	// A disabled escape character ignores +++
+	modem.SetSRegSync(2, 200)
+	modem.SetSRegSync(12, 0)
+	if err := modem.IncomingCallSync(NewMockReadWriteCloser([]byte{})); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	tty.WriteInput([]byte("+++"))
+	time.Sleep(50 * time.Millisecond)
+	if modem.StatusSync() != StatusConnected {
+		t.Errorf("status after +++ with S2=200 = %v, want %v", modem.StatusSync(), StatusConnected)
+	}
+}
+
+// Test remote data injection
+func TestModem_InjectRemoteData(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModem(&ModemConfig{
+		Id:               "test-modem",
+		TTY:              tty,
+		DisablePreGuard:  true,
+		DisablePostGuard: true,
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	if err := modem.InjectRemoteDataSync([]byte("x")); err != ErrNoCarrier {
+		t.Errorf("InjectRemoteDataSync() while idle error = %v, want %v", err, ErrNoCarrier)
+	}
+
+	if err := modem.IncomingCallSync(NewMockReadWriteCloser([]byte{})); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	modem.AddFilterSync(FilterToTTY, FilterFunc(func(b []byte) []byte {
+		return bytes.ToUpper(b)
+	}))
+
+	tty.ClearWrites()
+	if err := modem.InjectRemoteDataSync([]byte("hello")); err != nil {
+		t.Fatalf("InjectRemoteDataSync() error = %v", err)
+	}
+	if got := tty.GetWrittenString(); got != "HELLO" {
+		t.Errorf("TTY got %q, want %q", got, "HELLO")
+	}
+	if rx := modem.MetricsSync().CallRxBytes; rx != 5 {
+		t.Errorf("CallRxBytes = %d, want 5", rx)
+	}
+
+	// Data injected in online command mode is delivered on ATO
+	tty.WriteInput([]byte("+++"))
+	time.Sleep(50 * time.Millisecond)
+	if modem.StatusSync() != StatusConnectedCmd {
+		t.Fatalf("status after +++ = %v, want %v", modem.StatusSync(), StatusConnectedCmd)
+	}
+	modem.InjectRemoteDataSync([]byte("later"))
+	tty.ClearWrites()
+	modem.ProcessAtCommandSync("O")
+	if got := tty.GetWrittenString(); !strings.HasSuffix(got, "LATER") {
+		t.Errorf("TTY after ATO got %q, want it to end with %q", got, "LATER")
+	}
+}
+
+// Test hooks receive structured commands, and legacy hooks through the shim
+func TestModem_CommandHookCommand(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	var got []Command
+	modem, err := NewModem(&ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+		CommandHook: func(m *Modem, cmd Command) RetCode {
+			got = append(got, cmd)
+			return RetCodeSkip
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	modem.ProcessAtCommandSync("e0+CPBW=1,\"555\"")
+	if len(got) != 2 || got[0].Name != "E" || got[0].Raw != "e0" {
+		t.Fatalf("hook got %+v, want E0 and +CPBW", got)
+	}
+	if c := got[1]; c.Name != "+CPBW" || !c.Assign || strings.Join(c.Params, "|") != "1|555" {
+		t.Errorf("hook got %+v, want +CPBW with params [1 555]", c)
+	}
+
+	var legacy []string
+	modem.SetCommandHookSync(LegacyCommandHook(func(m *Modem, cmdChar string, cmdNum string, cmdAssign bool, cmdQuery bool, cmdAssignVal string) RetCode {
+		legacy = append(legacy, fmt.Sprintf("%s%s %v %v %s", cmdChar, cmdNum, cmdAssign, cmdQuery, cmdAssignVal))
+		return RetCodeSkip
+	}))
+	modem.ProcessAtCommandSync("S7=30+VCID=?")
+	expected := []string{"S7 true false 30", "+VCID true true "}
+	if strings.Join(legacy, ",") != strings.Join(expected, ",") {
+		t.Errorf("legacy hook got %q, want %q", legacy, expected)
+	}
+	if !strings.Contains(tty.GetWrittenString(), "+VCID: (0,1)") {
+		t.Errorf("Expected +VCID test response, got %q", tty.GetWrittenString())
+	}
+}
+
+// Test the per-command handler registry
+func TestModem_RegisterCommand(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithCommand("+csq", func(m *Modem, cmd Command) RetCode {
+			m.WriteInfoText("+CSQ: 31,0")
+			return RetCodeOk
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	if r := modem.ProcessAtCommandSync("+CSQ"); r != RetCodeOk || !strings.Contains(tty.GetWrittenString(), "+CSQ: 31,0") {
+		t.Errorf("AT+CSQ = %v, wrote %q", r, tty.GetWrittenString())
+	}
+
+	var stored []string
+	modem.RegisterCommandSync("&Z", func(m *Modem, cmd Command) RetCode {
+		stored = append(stored, cmd.Value)
+		return RetCodeOk
+	})
+	// Handlers run before the built-ins and fall back to them with RetCodeSkip
+	echo := 0
+	modem.RegisterCommandSync("E", func(m *Modem, cmd Command) RetCode {
+		echo++
+		return RetCodeSkip
+	})
+	if r := modem.ProcessAtCommandSync("&z=5551234"); r != RetCodeOk || len(stored) != 1 || stored[0] != "5551234" {
+		t.Errorf("AT&Z=5551234 = %v, stored %v", r, stored)
+	}
+	modem.ProcessAtCommandSync("E0")
+	if echo != 1 || modem.echo {
+		t.Errorf("E handler calls = %d, echo = %v, want 1, false", echo, modem.echo)
+	}
+
+	modem.UnregisterCommandSync("+CSQ")
+	tty.ClearWrites()
+	modem.ProcessAtCommandSync("+CSQ")
+	if strings.Contains(tty.GetWrittenString(), "+CSQ:") {
+		t.Errorf("Unregistered handler still called, wrote %q", tty.GetWrittenString())
+	}
+}
+
+// Test Rockwell (%) and USR (\) prefixed commands reach hooks and handlers
+func TestModem_PrefixedCommands(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	var hooked []string
+	modem, err := NewModem(&ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+		CommandHook: func(m *Modem, cmd Command) RetCode {
+			hooked = append(hooked, cmd.Name+cmd.Number)
+			return RetCodeSkip
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	errorControl := ""
+	modem.RegisterCommandSync(`\N`, func(m *Modem, cmd Command) RetCode {
+		errorControl = cmd.Number
+		return RetCodeOk
+	})
+	if r := modem.ProcessAtCommandSync(`&F%C0\N3E0`); r != RetCodeOk {
+		t.Errorf(`AT&F%%C0\N3E0 = %v, want %v`, r, RetCodeOk)
+	}
+	if errorControl != "3" {
+		t.Errorf(`\N handler got %q, want "3"`, errorControl)
+	}
+	expected := []string{"&F", "%C0", `\N3`, "E0"}
+	if strings.Join(hooked, " ") != strings.Join(expected, " ") {
+		t.Errorf("hook got %v, want %v", hooked, expected)
+	}
+}
+
+// Test extended commands chained with ';' run in order up to the end of the line
+func TestModem_ExtendedCommandChaining(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	var got []string
+	modem, err := NewModem(&ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+		CommandHook: func(m *Modem, cmd Command) RetCode {
+			if cmd.IsExtended() {
+				got = append(got, cmd.Name+"="+cmd.Value)
+				return RetCodeOk
+			}
+			return RetCodeSkip
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	modem.ProcessAtCommandSync("V0")
+	if ret := modem.ProcessAtCommandSync("+CMD1;+CMD2=\"a;b\";E0"); ret != RetCodeOk {
+		t.Errorf("ProcessAtCommand() = %v, want %v", ret, RetCodeOk)
+	}
+	expected := []string{"+CMD1=", "+CMD2=\"a;b\""}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("hook got %q, want %q", got, expected)
+	}
+	modem.Lock()
+	echo := modem.echo
+	modem.Unlock()
+	if echo {
+		t.Error("Expected E0 after the extended commands to disable echo")
+	}
+}
+
+// Test dial strings are cleaned up per Hayes conventions, keeping host names as typed
+func TestDialString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"T555-1234", "5551234", true},
+		{"p (555) 123-4567", "5551234567", true},
+		{" 1-800-FLOWERS", "1800FLOWERS", true},
+		{"T bbs.Example.com:6400", "bbs.Example.com:6400", true},
+		{"telehack.com", "telehack.com", true},
+		{"Ttelehack.com", "Ttelehack.com", true},
+		{"particles.org:23", "particles.org:23", true},
+		{"P5551234", "5551234", true},
+		{"T*192*168*1*100", "*192*168*1*100", true},
+		{"T[::1]:2323", "[::1]:2323", true},
+		{"my-bbs.example.com:23", "my-bbs.example.com:23", true},
+		{"retro_bbs:23", "retro_bbs:23", true},
+		{"[::1]:2323", "[::1]:2323", true},
+		{"*192*168*1*100", "*192*168*1*100", true},
+		{"555,,123;", "555,,123;", true},
+		{"host.example.com (23)", "", false},
+		{"555$1", "", false},
+		{"café.example.com", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := dialString(tt.input)
+			if got != tt.expected || ok != tt.ok {
+				t.Errorf("dialString(%q) = %q, %v, want %q, %v", tt.input, got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+// Test ATD hands the cleaned dial string to the outgoing call hook
+func TestModem_DialHostString(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	dialed := make(chan string, 1)
+	modem, err := NewModem(&ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+		OutgoingCall: func(m *Modem, number string) (io.ReadWriteCloser, error) {
+			dialed <- number
+			return nil, ErrNoCarrier
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	if r := modem.ProcessAtCommandSync("DT BBS.example.com:6400"); r != RetCodeSilent {
+		t.Fatalf("ATD = %v, want %v", r, RetCodeSilent)
+	}
+	select {
+	case number := <-dialed:
+		if number != "BBS.example.com:6400" {
+			t.Errorf("Dialed number = %q, want %q", number, "BBS.example.com:6400")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OutgoingCall hook not called")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if r := modem.ProcessAtCommandSync("D555{1}"); r != RetCodeError {
+		t.Errorf("ATD with invalid characters = %v, want %v", r, RetCodeError)
+	}
+}
+
+// Test unknown commands under each unknown command policy
+func TestModem_UnknownCommandPolicy(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	var unknown []string
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithId("test-modem"),
+		WithUnknownCommand(func(m *Modem, cmd Command) RetCode {
+			unknown = append(unknown, cmd.Name)
+			if cmd.Name == "%C" {
+				return RetCodeOk
+			}
+			return RetCodeSkip
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	tests := []struct {
+		policy   UnknownCommandPolicy
+		line     string
+		expected RetCode
+	}{
+		{UnknownCommandHook, "%C1", RetCodeOk},
+		{UnknownCommandHook, "L2", RetCodeError},
+		{UnknownCommandHook, "E0", RetCodeOk},
+		{UnknownCommandOk, "L2", RetCodeOk},
+		{UnknownCommandOk, "+CMEE=2", RetCodeOk},
+		{UnknownCommandError, "L2", RetCodeError},
+		{UnknownCommandError, "+CMEE=2", RetCodeError},
+		{UnknownCommandError, "E0V1", RetCodeOk},
+	}
+	for _, tt := range tests {
+		modem.SetUnknownCommandPolicySync(tt.policy)
+		if r := modem.ProcessAtCommandSync(tt.line); r != tt.expected {
+			t.Errorf("%v: AT%s = %v, want %v", tt.policy, tt.line, r, tt.expected)
+		}
+	}
+	if expected := []string{"%C", "L"}; strings.Join(unknown, ",") != strings.Join(expected, ",") {
+		t.Errorf("UnknownCommand hook got %q, want %q", unknown, expected)
+	}
+}
+
+// Test DTE register writes are validated against the register table
+func TestModem_SRegTable(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	rings := make(chan byte, 10)
+	modem, err := NewModem(&ModemConfig{
+		Id:  "test-modem",
+		TTY: tty,
+		Ring: func(m *Modem, n int, info CallInfo) {
+			rings <- m.SReg(1)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	tests := []struct {
+		line     string
+		expected RetCode
+	}{
+		{"S3=127", RetCodeOk},
+		{"S3=128", RetCodeError},
+		{"S4=200", RetCodeError},
+		{"S7=", RetCodeOk},
+		{"S1=5", RetCodeError},
+		{"S1?", RetCodeOk},
+		{"S200=255", RetCodeOk},
+		{"S200=256", RetCodeError},
+	}
+	for _, tt := range tests {
+		if r := modem.ProcessAtCommandSync(tt.line); r != tt.expected {
+			t.Errorf("AT%s = %v, want %v", tt.line, r, tt.expected)
+		}
+	}
+	if v := modem.SRegSync(3); v != 127 {
+		t.Errorf("S3 = %d, want 127", v)
+	}
+
+	if err := modem.IncomingCallSync(NewMockReadWriteCloser([]byte{})); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	select {
+	case v := <-rings:
+		if v != 1 {
+			t.Errorf("S1 on the first ring = %d, want 1", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Ring callback not called")
+	}
+
+	// AT&F restores writable registers and leaves status registers alone
+	modem.ProcessAtCommandSync("&F")
+	if v := modem.SRegSync(3); v != 13 {
+		t.Errorf("S3 after AT&F = %d, want 13", v)
+	}
+	if v := modem.SRegSync(1); v != 1 {
+		t.Errorf("S1 after AT&F = %d, want 1", v)
+	}
+
+	if d := SRegDescriptors()[1]; !d.ReadOnly {
+		t.Errorf("S1 descriptor = %+v, want read-only", d)
+	}
+}
+
+// Test result codes of command lines are written after their response delay
+func TestModem_ResponseDelay(t *testing.T) {
+	tty := NewMockReadWriteCloser([]byte{})
+	modem, err := NewModemWithOptions(context.Background(), tty,
+		WithId("test-modem"),
+		WithEcho(false),
+		WithResponseDelay(150*time.Millisecond),
+		WithResultDelay(RetCodeError, 0),
+	)
+	if err != nil {
+		t.Fatalf("NewModemWithOptions() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	tty.WriteInput([]byte("ATV1\r"))
+	time.Sleep(50 * time.Millisecond)
+	if got := tty.GetWrittenString(); strings.Contains(got, "OK") {
+		t.Errorf("OK written before the response delay: %q", got)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got := tty.GetWrittenString(); !strings.Contains(got, "OK") {
+		t.Errorf("Expected OK after the response delay, got %q", got)
+	}
+
+	tty.ClearWrites()
+	tty.WriteInput([]byte("ATE9\r"))
+	time.Sleep(50 * time.Millisecond)
+	if got := tty.GetWrittenString(); !strings.Contains(got, "ERROR") {
+		t.Errorf("Expected ERROR without delay, got %q", got)
+	}
+}
+
+// Test TTY input read in chunks: command lines and online data in a single read
+func TestModem_ChunkedInput(t *testing.T) {
+	dte, dce := net.Pipe()
+	defer dte.Close()
+	var mu sync.Mutex
+	var output bytes.Buffer
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := dte.Read(buf)
+			mu.Lock()
+			output.Write(buf[:n])
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	modem, err := NewModem(&ModemConfig{Id: "test-modem", TTY: dce, GuardTime: 2})
+	if err != nil {
+		t.Fatalf("NewModem() error = %v", err)
+	}
+	defer modem.CloseSync()
+
+	if _, err := dte.Write([]byte("ATE0\rATV0\rATS7?\r")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	got := output.String()
+	mu.Unlock()
+	if !strings.HasSuffix(got, "OK\r\n\r0\r050\r\n\r0\r") {
+		t.Errorf("TTY output = %q, want the results of the three commands", got)
+	}
+
+	host, line := NewLine()
+	defer host.Close()
+	if err := modem.IncomingCallSync(line); err != nil {
+		t.Fatalf("IncomingCallSync() error = %v", err)
+	}
+	if _, err := modem.AnswerSync(); err != nil {
+		t.Fatalf("AnswerSync() error = %v", err)
+	}
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	go dte.Write(payload)
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(host, received); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Error("Online data corrupted")
+	}
+
+	// Escape sequence and command in a single read after the guard time
+	time.Sleep(150 * time.Millisecond)
+	dte.Write([]byte("+++ATH\r"))
+	time.Sleep(50 * time.Millisecond)
+	if modem.StatusSync() != StatusIdle {
+		t.Errorf("Status after +++ATH = %v, want %v", modem.StatusSync(), StatusIdle)
+	}
+	if m := modem.MetricsSync(); m.ConnTxBytes != len(payload)+3 {
+		t.Errorf("ConnTxBytes = %d, want %d", m.ConnTxBytes, len(payload)+3)
+	}
+}
+
+// Test the escape sequence matcher across chunks and guard times
+func TestEscapeMatcher(t *testing.T) {
+	guard := time.Second
+	t0 := time.Now()
+	tests := []struct {
+		name     string
+		preGuard bool
+		chunks   []string
+		gaps     []time.Duration // Time before each chunk
+		wantN    int             // Bytes consumed by the last chunk
+		wantEsc  bool
+	}{
+		{"single chunk", true, []string{"+++"}, []time.Duration{2 * guard}, 3, true},
+		{"split chunks", true, []string{"+", "+", "+"}, []time.Duration{2 * guard, 0, 0}, 1, true},
+		{"data before", true, []string{"x+++"}, []time.Duration{2 * guard}, 4, false},
+		{"data within guard", true, []string{"x", "+++"}, []time.Duration{2 * guard, guard / 2}, 3, false},
+		{"slow pluses", true, []string{"+", "+", "+"}, []time.Duration{2 * guard, 2 * guard, 0}, 1, false},
+		{"no pre guard", false, []string{"data+++more"}, []time.Duration{0}, 7, true},
+		{"no pre guard no escape", false, []string{"data+"}, []time.Duration{0}, 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := escapeMatcher{char: '+', guard: guard, preGuard: tt.preGuard, lastData: t0}
+			now := t0
+			var n int
+			var esc bool
+			for i, c := range tt.chunks {
+				now = now.Add(tt.gaps[i])
+				n, esc = e.scan([]byte(c), now)
+			}
+			if n != tt.wantN || esc != tt.wantEsc {
+				t.Errorf("scan() = %d, %v, want %d, %v", n, esc, tt.wantN, tt.wantEsc)
+			}
+		})
+	}
+
+	e := escapeMatcher{char: '+', disabled: true}
+	if n, esc := e.scan([]byte("+++"), t0.Add(time.Hour)); n != 3 || esc {
+		t.Errorf("scan() with escape disabled = %d, %v, want 3, false", n, esc)
+	}
+}